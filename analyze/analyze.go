@@ -0,0 +1,185 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/exec/report"
+	"github.com/zombiezen/mcm/internal/catalogio"
+	"github.com/zombiezen/mcm/internal/depgraph"
+	"github.com/zombiezen/mcm/internal/version"
+)
+
+func init() {
+	flag.Usage = usage
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [-report=FILE] [-bottlenecks=N] [CATALOG]\n", filepath.Base(os.Args[0]))
+	flag.PrintDefaults()
+}
+
+func main() {
+	reportPath := flag.String("report", "", "weight resources by how long they took in this mcm-exec report file")
+	maxBottlenecks := flag.Int("bottlenecks", 5, "show at most `N` dependencies that serialize the catalog")
+	readOpts := catalogio.Flags(flag.CommandLine)
+	versionMode := flag.Bool("version", false, "display version info")
+	flag.Parse()
+	if *versionMode {
+		version.Show()
+		return
+	}
+
+	var path string
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		path = flag.Arg(0)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	cat, err := catalogio.ReadFile(path, readOpts)
+	if err != nil {
+		die(err)
+	}
+	var rep *report.Report
+	if *reportPath != "" {
+		rep, err = readReport(*reportPath, cat)
+		if err != nil {
+			die(err)
+		}
+	}
+	res, err := cat.Resources()
+	if err != nil {
+		die(err)
+	}
+	g, err := depgraph.New(res)
+	if err != nil {
+		die(err)
+	}
+	var a *depgraph.Analysis
+	format := formatCount
+	if rep == nil {
+		a = g.Analyze(nil)
+	} else {
+		durations := make(map[uint64]time.Duration, len(rep.Resources))
+		for _, rr := range rep.Resources {
+			durations[rr.ID] = rr.Duration
+		}
+		a = g.Analyze(func(id uint64) int64 { return int64(durations[id]) })
+		format = formatDuration
+	}
+	if *maxBottlenecks >= 0 && len(a.Bottlenecks) > *maxBottlenecks {
+		a.Bottlenecks = a.Bottlenecks[:*maxBottlenecks]
+	}
+	if err := writeAnalysis(os.Stdout, g, a, format); err != nil {
+		die(err)
+	}
+}
+
+// readReport finds the report for cat in an mcm-exec report file.
+func readReport(path string, cat catalog.Catalog) (*report.Report, error) {
+	h, err := catalog.Hash(cat)
+	if err != nil {
+		return nil, fmt.Errorf("hash catalog: %v", err)
+	}
+	catalogio.ResetReadLimit(cat)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rep, err := report.Find(f, h)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return rep, nil
+}
+
+func writeAnalysis(w io.Writer, g *depgraph.Graph, a *depgraph.Analysis, format func(int64) string) error {
+	bw := bufio.NewWriter(w)
+	n := 0
+	for _, l := range a.Levels {
+		n += len(l)
+	}
+	fmt.Fprintf(bw, "resources:     %d\n", n)
+	fmt.Fprintf(bw, "depth:         %d levels\n", a.Depth())
+	fmt.Fprintf(bw, "width:         %d resources\n", a.Width())
+	if len(a.Levels) > 0 {
+		bw.WriteString("level widths: ")
+		for i, l := range a.Levels {
+			if i > 0 {
+				bw.WriteString(" ")
+			}
+			fmt.Fprint(bw, len(l))
+		}
+		bw.WriteString("\n")
+	}
+	fmt.Fprintf(bw, "total cost:    %s\n", format(a.TotalCost))
+	fmt.Fprintf(bw, "critical cost: %s\n", format(a.CriticalCost))
+	if p := a.Parallelism(); p > 0 {
+		fmt.Fprintf(bw, "parallelism:   %.2f (more than -j %d will not help)\n", p, int(math.Ceil(p)))
+	}
+	if len(a.CriticalPath) > 0 {
+		fmt.Fprintf(bw, "\ncritical path (%s):\n", formatCount(int64(len(a.CriticalPath))))
+		for _, id := range a.CriticalPath {
+			fmt.Fprintf(bw, "  %s\n", formatResource(g.Resource(id)))
+		}
+	}
+	if len(a.Bottlenecks) > 0 {
+		bw.WriteString("\nserializing dependencies:\n")
+		for _, b := range a.Bottlenecks {
+			fmt.Fprintf(bw, "  %s -> %s: critical cost %s without it\n",
+				formatResource(g.Resource(b.Resource)),
+				formatResource(g.Resource(b.Dep)),
+				format(b.CriticalCost))
+		}
+	}
+	return bw.Flush()
+}
+
+func formatCount(n int64) string {
+	if n == 1 {
+		return "1 resource"
+	}
+	return fmt.Sprintf("%d resources", n)
+}
+
+func formatDuration(n int64) string {
+	return time.Duration(n).String()
+}
+
+func formatResource(r catalog.Resource) string {
+	c, _ := r.Comment()
+	if c == "" {
+		return fmt.Sprintf("id=%d", r.ID())
+	}
+	return fmt.Sprintf("%s (id=%d)", c, r.ID())
+}
+
+func die(err error) {
+	fmt.Fprintln(os.Stderr, "mcm-analyze:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,121 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/internal/catalogio"
+	"github.com/zombiezen/mcm/internal/version"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto/encoding/text"
+)
+
+func main() {
+	to := flag.String("to", "", "output format: text or binary (default is the opposite of the input)")
+	singleLiteral := flag.Bool("single-literal", false, "write each string in text output as one literal, so that capnp encode can read it")
+	var readLimit uint64
+	catalogio.ReadLimitFlag(flag.CommandLine, &readLimit)
+	versionMode := flag.Bool("version", false, "display version info")
+	flag.Parse()
+	if *versionMode {
+		version.Show()
+		return
+	}
+	if *to != "" && *to != catalogio.Binary && *to != catalogio.Text {
+		fmt.Fprintf(os.Stderr, "mcm-cat: unknown format %q\n", *to)
+		os.Exit(2)
+	}
+
+	var in []byte
+	var err error
+	switch flag.NArg() {
+	case 0:
+		in, err = ioutil.ReadAll(os.Stdin)
+	case 1:
+		in, err = ioutil.ReadFile(flag.Arg(0))
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		die(err)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	if err := convert(w, in, *to, *singleLiteral, readLimit); err != nil {
+		die(err)
+	}
+	if err := w.Flush(); err != nil {
+		die(err)
+	}
+}
+
+func die(err error) {
+	fmt.Fprintln(os.Stderr, "mcm-cat:", err)
+	os.Exit(1)
+}
+
+// convert reads a catalog in either format from in and writes it to w
+// in the format named by to.  If to is empty, then the catalog is
+// written in the format that it was not read in.  singleLiteral is
+// passed to text.Encoder.SetSingleLiteral.  readLimit is passed to
+// catalogio.Read.
+func convert(w io.Writer, in []byte, to string, singleLiteral bool, readLimit uint64) error {
+	from := detectFormat(in)
+	c, err := catalogio.Read(bytes.NewReader(in), &catalogio.Options{Format: from, ReadLimit: readLimit})
+	if err != nil {
+		return err
+	}
+	if to == "" {
+		if from == catalogio.Text {
+			to = catalogio.Binary
+		} else {
+			to = catalogio.Text
+		}
+	}
+	if to == catalogio.Text {
+		return writeText(w, c, singleLiteral)
+	}
+	return capnp.NewEncoder(w).Encode(c.Segment().Message())
+}
+
+// detectFormat reports whether in is a text or binary catalog.  A
+// binary catalog always starts with a small segment count, so it never
+// starts with the characters that begin a text catalog.
+func detectFormat(in []byte) string {
+	trimmed := bytes.TrimLeft(in, " \t\r\n")
+	if len(trimmed) > 0 && (trimmed[0] == '(' || trimmed[0] == '#') {
+		return catalogio.Text
+	}
+	return catalogio.Binary
+}
+
+func writeText(w io.Writer, c catalog.Catalog, singleLiteral bool) error {
+	enc := text.NewEncoder(w)
+	enc.SetIndent("  ")
+	enc.SetSingleLiteral(singleLiteral)
+	if err := enc.Encode(catalog.Catalog_TypeID, c.Struct); err != nil {
+		return fmt.Errorf("write catalog: %v", err)
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
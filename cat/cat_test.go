@@ -0,0 +1,115 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/internal/catalogio"
+	"github.com/zombiezen/mcm/internal/catpogs"
+)
+
+func TestRoundTrip(t *testing.T) {
+	c, err := (&catpogs.Catalog{
+		Resources: []*catpogs.Resource{
+			{
+				ID:      42,
+				Comment: "config",
+				Which:   catalog.Resource_Which_file,
+				File:    catpogs.PlainFile("/etc/foo.conf", []byte("# Generated\nfoo = \"bar\"\n\nbaz = 1\n")),
+			},
+			{
+				ID:      99,
+				Comment: "reload",
+				Deps:    []uint64{42},
+				Which:   catalog.Resource_Which_exec,
+				Exec: &catpogs.Exec{
+					Command: &catpogs.Command{
+						Which: catalog.Exec_Command_Which_argv,
+						Argv:  []string{"/bin/systemctl", "reload", "foo"},
+					},
+					Condition: catpogs.ExecCondition{
+						Which:         catalog.Exec_condition_Which_ifDepsChanged,
+						IfDepsChanged: []uint64{42},
+					},
+				},
+			},
+		},
+	}).ToCapnp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin, err := c.Segment().Message().Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txt := new(bytes.Buffer)
+	if err := convert(txt, bin, "", false, 0); err != nil {
+		t.Fatal("binary to text:", err)
+	}
+	if !strings.Contains(txt.String(), "\"# Generated\\n\"\n") {
+		t.Errorf("text output does not split content into lines:\n%s", txt)
+	}
+	bin2 := new(bytes.Buffer)
+	if err := convert(bin2, txt.Bytes(), "", false, 0); err != nil {
+		t.Fatalf("text to binary: %v\ninput:\n%s", err, txt)
+	}
+	txt2 := new(bytes.Buffer)
+	if err := convert(txt2, bin2.Bytes(), catalogio.Text, false, 0); err != nil {
+		t.Fatal("binary to text:", err)
+	}
+	if txt.String() != txt2.String() {
+		t.Errorf("round trip changed catalog. before:\n%s\nafter:\n%s", txt, txt2)
+	}
+	txt3 := new(bytes.Buffer)
+	if err := convert(txt3, txt.Bytes(), catalogio.Text, false, 0); err != nil {
+		t.Fatal("text to text:", err)
+	}
+	if txt.String() != txt3.String() {
+		t.Errorf("text output is not canonical. before:\n%s\nafter:\n%s", txt, txt3)
+	}
+
+	// The capnp tool does not accept adjacent string literals.
+	single := new(bytes.Buffer)
+	if err := convert(single, bin, catalogio.Text, true, 0); err != nil {
+		t.Fatal("binary to text with single literals:", err)
+	}
+	const wantContent = `content = "# Generated\nfoo = \"bar\"\n\nbaz = 1\n",`
+	if !strings.Contains(single.String(), wantContent) {
+		t.Errorf("text output with single literals does not contain %s:\n%s", wantContent, single)
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", catalogio.Binary},
+		{"\x00\x00\x00\x00", catalogio.Binary},
+		{"(resources = [])", catalogio.Text},
+		{"\n  (resources = [])", catalogio.Text},
+		{"# comment\n()", catalogio.Text},
+	}
+	for _, test := range tests {
+		if got := detectFormat([]byte(test.in)); got != test.want {
+			t.Errorf("detectFormat(%q) = %q; want %q", test.in, got, test.want)
+		}
+	}
+}
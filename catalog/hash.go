@@ -0,0 +1,39 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"crypto/sha256"
+
+	"github.com/zombiezen/mcm/third_party/golang/capnproto"
+)
+
+// Hash returns the SHA-256 hash of the canonical form of c.  Equivalent
+// catalogs have the same hash regardless of how their messages are
+// laid out, so the hash can be used to identify a catalog.  The
+// canonical form is streamed into the hash, so hashing does not copy
+// the catalog.
+//
+// Hashing traverses the whole catalog, so it counts against the
+// message's read limit.
+func Hash(c Catalog) ([sha256.Size]byte, error) {
+	h := sha256.New()
+	if err := capnp.WriteCanonical(h, c.Struct); err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum, nil
+}
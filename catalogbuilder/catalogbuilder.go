@@ -0,0 +1,343 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package catalogbuilder constructs catalogs from Go programs.
+//
+// Resources are named by strings, which are hashed into IDs exactly
+// like mcm-luacat's mcm.hash, so a catalog built with this package can
+// refer to resources from a Lua catalog and vice versa:
+//
+//	b := catalogbuilder.New()
+//	b.Resource("motd").File(catalogbuilder.PlainFile("/etc/motd", []byte("Hello\n")).Mode(0644))
+//	b.Resource("apt-get update").
+//		DependsOn("motd").
+//		Exec(catalogbuilder.Run(catalogbuilder.Argv("/usr/bin/apt-get", "update")).IfDepsChanged("motd"))
+//	if _, err := b.WriteTo(os.Stdout); err != nil {
+//		// ...
+//	}
+//
+// The mcm tools also read catalogs that are packed with WritePackedTo or
+// compressed by writing to a gzip.Writer.
+//
+// Catalogs built separately can be combined with mcm-merge.  A resource
+// exported by name from one catalog can be depended on by name from
+// another catalog that imports it:
+//
+//	base.Resource("nginx").Export("nginx").Exec(...)
+//	host.Import("nginx")
+//	host.Resource("site config").DependsOn("nginx").File(...)
+package catalogbuilder
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/internal/catpogs"
+)
+
+// idHashPrefix must match idHashPrefix in luacat/lib.c++.
+const idHashPrefix = "mcm-luacat ID: "
+
+// Hash returns the resource ID for a string.  It returns the same
+// value as mcm.hash in mcm-luacat.
+func Hash(s string) uint64 {
+	h := sha1.New()
+	io.WriteString(h, idHashPrefix)
+	io.WriteString(h, s)
+	sum := h.Sum(nil)
+	return 1 | binary.LittleEndian.Uint64(sum[:8])
+}
+
+// A Builder accumulates resources for a catalog.  The zero value is an
+// empty catalog.
+type Builder struct {
+	resources []*Resource
+	imports   []string
+}
+
+// New returns a new empty Builder.
+func New() *Builder {
+	return new(Builder)
+}
+
+// Resource adds a no-op resource to the catalog with the ID Hash(name)
+// and name as its comment, like mcm.resource in mcm-luacat.  Use the
+// returned Resource's methods to set its type and dependencies.
+func (b *Builder) Resource(name string) *Resource {
+	r := &Resource{name: ref{id: Hash(name), name: name}}
+	r.res.ID = r.name.id
+	r.res.Comment = name
+	r.res.Which = catalog.Resource_Which_noop
+	b.resources = append(b.resources, r)
+	return r
+}
+
+// ResourceID adds a no-op resource to the catalog with an explicit ID.
+func (b *Builder) ResourceID(id uint64) *Resource {
+	r := &Resource{name: ref{id: id}}
+	r.res.ID = id
+	r.res.Which = catalog.Resource_Which_noop
+	b.resources = append(b.resources, r)
+	return r
+}
+
+// Import declares that resources in the catalog depend on a resource
+// that another catalog exports under the given name.  Dependencies on
+// an imported name use the placeholder ID Hash(name), so a resource
+// can depend on it with DependsOn(name).  The catalog must be merged
+// with a catalog that exports the name before it can be applied.
+func (b *Builder) Import(names ...string) {
+	b.imports = append(b.imports, names...)
+}
+
+// Build validates the resources added so far and returns them as a
+// catalog in a new message.  It is an error for two resources to have
+// the same ID, for a resource to depend on a resource that is not in
+// the catalog, or for an exec's ifDepsChanged condition to name a
+// resource that is not one of its dependencies.
+func (b *Builder) Build() (catalog.Catalog, error) {
+	if err := b.validate(); err != nil {
+		return catalog.Catalog{}, err
+	}
+	c := &catpogs.Catalog{Resources: make([]*catpogs.Resource, len(b.resources))}
+	for i, r := range b.resources {
+		c.Resources[i] = r.toPogs()
+		for _, name := range r.exports {
+			c.Exports = append(c.Exports, catpogs.ResourceName{Name: name, ID: r.name.id})
+		}
+	}
+	for _, name := range b.imports {
+		c.Imports = append(c.Imports, catpogs.ResourceName{Name: name, ID: Hash(name)})
+	}
+	cat, err := c.ToCapnp()
+	if err != nil {
+		return catalog.Catalog{}, fmt.Errorf("catalogbuilder: %v", err)
+	}
+	return cat, nil
+}
+
+// WriteTo builds the catalog and writes it to w as a binary Cap'n
+// Proto message, the format that mcm-luacat produces and mcm-exec
+// reads.
+func (b *Builder) WriteTo(w io.Writer) (int64, error) {
+	c, err := b.Build()
+	if err != nil {
+		return 0, err
+	}
+	data, err := c.Segment().Message().Marshal()
+	if err != nil {
+		return 0, fmt.Errorf("catalogbuilder: %v", err)
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// WritePackedTo builds the catalog and writes it to w as a packed
+// binary Cap'n Proto message, which is much smaller than WriteTo's
+// output for most catalogs.  mcm-exec reads packed catalogs with
+// -input-format=packed.
+func (b *Builder) WritePackedTo(w io.Writer) (int64, error) {
+	c, err := b.Build()
+	if err != nil {
+		return 0, err
+	}
+	data, err := c.Segment().Message().MarshalPacked()
+	if err != nil {
+		return 0, fmt.Errorf("catalogbuilder: %v", err)
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+func (b *Builder) validate() error {
+	byID := make(map[uint64]*Resource, len(b.resources))
+	for _, r := range b.resources {
+		if prev := byID[r.name.id]; prev != nil {
+			return fmt.Errorf("catalogbuilder: resources %v and %v have the same ID", prev.name, r.name)
+		}
+		byID[r.name.id] = r
+	}
+	exported := make(map[string]bool)
+	for _, r := range b.resources {
+		for _, name := range r.exports {
+			if exported[name] {
+				return fmt.Errorf("catalogbuilder: export %q is used more than once", name)
+			}
+			exported[name] = true
+		}
+	}
+	imported := make(map[uint64]bool, len(b.imports))
+	for _, name := range b.imports {
+		id := Hash(name)
+		if r := byID[id]; r != nil {
+			return fmt.Errorf("catalogbuilder: import %q has the same ID as resource %v", name, r.name)
+		}
+		if imported[id] {
+			return fmt.Errorf("catalogbuilder: import %q is declared more than once", name)
+		}
+		imported[id] = true
+	}
+	for _, r := range b.resources {
+		if err := r.validate(byID, imported); err != nil {
+			return fmt.Errorf("catalogbuilder: resource %v: %v", r.name, err)
+		}
+	}
+	return nil
+}
+
+// A Resource is a resource in a Builder.
+type Resource struct {
+	name    ref
+	res     catpogs.Resource
+	deps    []ref
+	exports []string
+	file    *File
+	exec    *Exec
+}
+
+// Comment sets the resource's comment, replacing the name given to
+// Builder.Resource.
+func (r *Resource) Comment(s string) *Resource {
+	r.res.Comment = s
+	return r
+}
+
+// Export makes the resource available to other catalogs under the
+// given name when the catalogs are merged with mcm-merge.
+func (r *Resource) Export(name string) *Resource {
+	r.exports = append(r.exports, name)
+	return r
+}
+
+// DependsOn adds dependencies on the resources with the given names,
+// which may be names given to Builder.Import.
+func (r *Resource) DependsOn(names ...string) *Resource {
+	for _, name := range names {
+		r.deps = append(r.deps, ref{id: Hash(name), name: name})
+	}
+	return r
+}
+
+// DependsOnID adds dependencies on the resources with the given IDs.
+func (r *Resource) DependsOnID(ids ...uint64) *Resource {
+	for _, id := range ids {
+		r.deps = append(r.deps, ref{id: id})
+	}
+	return r
+}
+
+// Lock adds the given lock names to the resource.  mcm-exec never
+// applies two resources that share a lock name at the same time, even
+// if neither depends on the other.
+func (r *Resource) Lock(names ...string) *Resource {
+	r.res.Locks = append(r.res.Locks, names...)
+	return r
+}
+
+// Noop makes the resource a no-op, which is the default.  No-op
+// resources are useful for grouping dependencies.
+func (r *Resource) Noop() *Resource {
+	r.res.Which = catalog.Resource_Which_noop
+	r.file, r.exec = nil, nil
+	return r
+}
+
+// File makes the resource a file resource.
+func (r *Resource) File(f *File) *Resource {
+	r.res.Which = catalog.Resource_Which_file
+	r.file, r.exec = f, nil
+	return r
+}
+
+// Exec makes the resource an exec resource.
+func (r *Resource) Exec(e *Exec) *Resource {
+	r.res.Which = catalog.Resource_Which_exec
+	r.file, r.exec = nil, e
+	return r
+}
+
+func (r *Resource) validate(byID map[uint64]*Resource, imported map[uint64]bool) error {
+	deps := make(map[uint64]bool, len(r.deps))
+	for _, d := range r.deps {
+		if byID[d.id] == nil && !imported[d.id] {
+			return fmt.Errorf("depends on %v, which is not in the catalog", d)
+		}
+		deps[d.id] = true
+	}
+	for _, name := range r.res.Locks {
+		if name == "" {
+			return errors.New("empty lock name")
+		}
+	}
+	switch {
+	case r.file != nil:
+		if r.file.err != nil {
+			return r.file.err
+		}
+	case r.exec != nil:
+		if r.exec.cmd == nil {
+			return errors.New("exec has no command")
+		}
+		if r.exec.cond.Which == catalog.Exec_condition_Which_onlyIf && r.exec.onlyIf == nil ||
+			r.exec.cond.Which == catalog.Exec_condition_Which_unless && r.exec.unless == nil {
+			return fmt.Errorf("exec %v condition has no command", r.exec.cond.Which)
+		}
+		for _, d := range r.exec.depsChanged {
+			if !deps[d.id] {
+				return fmt.Errorf("ifDepsChanged names %v, which is not a dependency", d)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Resource) toPogs() *catpogs.Resource {
+	res := r.res
+	res.Deps = refIDs(r.deps)
+	switch {
+	case r.file != nil:
+		res.File = r.file.toPogs()
+	case r.exec != nil:
+		res.Exec = r.exec.toPogs()
+	}
+	return &res
+}
+
+// ref is a reference to a resource, by name or by ID.
+type ref struct {
+	id   uint64
+	name string
+}
+
+func (r ref) String() string {
+	if r.name == "" {
+		return fmt.Sprintf("%#x", r.id)
+	}
+	return fmt.Sprintf("%q", r.name)
+}
+
+func refIDs(refs []ref) []uint64 {
+	if len(refs) == 0 {
+		return nil
+	}
+	ids := make([]uint64, len(refs))
+	for i := range refs {
+		ids[i] = refs[i].id
+	}
+	return ids
+}
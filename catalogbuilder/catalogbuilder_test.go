@@ -0,0 +1,318 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalogbuilder
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto/encoding/text"
+)
+
+func TestHash(t *testing.T) {
+	tests := []struct {
+		s    string
+		want uint64
+	}{
+		// From luacat/testdata/depschanged.lua.
+		{"xyzzy!", 0xd96f419065c49db1},
+		{"bar", 0x20ef6c8ca69beae1},
+		{"", 0x53391a4c10115b5d},
+	}
+	for _, test := range tests {
+		if got := Hash(test.s); got != test.want {
+			t.Errorf("Hash(%q) = %#x; want %#x", test.s, got, test.want)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(b *Builder)
+		want  string
+	}{
+		{
+			name:  "empty",
+			build: func(b *Builder) {},
+			want:  `(resources = [], exports = [], imports = [])`,
+		},
+		{
+			name: "noop",
+			build: func(b *Builder) {
+				b.Resource("all")
+				b.ResourceID(42).Comment("answer").DependsOn("all").Noop()
+			},
+			want: `(resources = [` +
+				`(id = 9674939134875447263, comment = "all", dependencies = [], locks = [], noop = void), ` +
+				`(id = 42, comment = "answer", dependencies = [9674939134875447263], locks = [], noop = void)], exports = [], imports = [])`,
+		},
+		{
+			// Same as luacat/testdata/depschanged.lua.
+			name: "depschanged",
+			build: func(b *Builder) {
+				b.Resource("xyzzy!").File(PlainFile("/etc/motd", nil))
+				b.Resource("apt-get update").
+					DependsOn("xyzzy!").
+					Exec(Run(Argv("/usr/bin/apt-get", "update")).IfDepsChangedID(0xd96f419065c49db1))
+			},
+			want: `(resources = [` +
+				`(id = 15667813717083725233, comment = "xyzzy!", dependencies = [], locks = [], file = (path = "/etc/motd", plain = (content = "", mode = (bits = 65535, user = (id = -1), group = (id = -1)), contentSha256 = ""))), ` +
+				`(id = 4429374879372505379, comment = "apt-get update", dependencies = [15667813717083725233], locks = [], exec = (command = (argv = ["/usr/bin/apt-get", "update"], environment = [], workingDirectory = ""), condition = (ifDepsChanged = [15667813717083725233])))], exports = [], imports = [])`,
+		},
+		{
+			name: "files",
+			build: func(b *Builder) {
+				b.Resource("dir").File(Directory("/srv").Mode(0755).User("www").GroupID(33))
+				b.Resource("file").File(PlainFile("/srv/index.html", []byte("hi")).UserID(0).Group("www"))
+				b.Resource("link").File(Symlink("/var/www", "/srv"))
+				b.Resource("hard").File(Hardlink("/srv/home.html", "/srv/index.html"))
+				b.Resource("gone").File(Absent("/var/www/index.html"))
+			},
+			want: `(resources = [` +
+				`(id = 11912891704577708881, comment = "dir", dependencies = [], locks = [], file = (path = "/srv", directory = (mode = (bits = 493, user = (name = "www"), group = (id = 33))))), ` +
+				`(id = 3010081083001131681, comment = "file", dependencies = [], locks = [], file = (path = "/srv/index.html", plain = (content = "hi", mode = (bits = 65535, user = (id = 0), group = (name = "www")), contentSha256 = ""))), ` +
+				`(id = 8073562398833127279, comment = "link", dependencies = [], locks = [], file = (path = "/var/www", symlink = (target = "/srv"))), ` +
+				`(id = 6642821468976122583, comment = "hard", dependencies = [], locks = [], file = (path = "/srv/home.html", hardlink = (target = "/srv/index.html"))), ` +
+				`(id = 5761429794102890345, comment = "gone", dependencies = [], locks = [], file = (path = "/var/www/index.html", absent = void))], exports = [], imports = [])`,
+		},
+		{
+			name: "exec conditions",
+			build: func(b *Builder) {
+				b.Resource("a").Exec(Run(Bash("echo a")).OnlyIf(Argv("/bin/true")))
+				b.Resource("b").Exec(Run(Argv("/bin/b").Env("X", "1").Dir("/tmp")).Unless(Bash("false")))
+				b.Resource("c").Exec(Run(Argv("/bin/c")).IfFileAbsent("/tmp/c"))
+				b.Resource("d").Exec(Run(Argv("/bin/d")).IfFileAbsent("/tmp/d").IfDepsChanged())
+			},
+			want: `(resources = [` +
+				`(id = 3661779089568885339, comment = "a", dependencies = [], locks = [], exec = (command = (bash = "echo a", environment = [], workingDirectory = ""), condition = (onlyIf = (argv = ["/bin/true"], environment = [], workingDirectory = "")))), ` +
+				`(id = 12339958539482233169, comment = "b", dependencies = [], locks = [], exec = (command = (argv = ["/bin/b"], environment = [(name = "X", value = "1")], workingDirectory = "/tmp"), condition = (unless = (bash = "false", environment = [], workingDirectory = "")))), ` +
+				`(id = 11512322261415763845, comment = "c", dependencies = [], locks = [], exec = (command = (argv = ["/bin/c"], environment = [], workingDirectory = ""), condition = (fileAbsent = "/tmp/c"))), ` +
+				`(id = 18386993994401683143, comment = "d", dependencies = [], locks = [], exec = (command = (argv = ["/bin/d"], environment = [], workingDirectory = ""), condition = (ifDepsChanged = [])))], exports = [], imports = [])`,
+		},
+		{
+			name: "locks",
+			build: func(b *Builder) {
+				b.ResourceID(1).Comment("a").Lock("apt")
+				b.ResourceID(2).Comment("b").Lock("apt", "dpkg").Lock("net")
+			},
+			want: `(resources = [` +
+				`(id = 1, comment = "a", dependencies = [], locks = ["apt"], noop = void), ` +
+				`(id = 2, comment = "b", dependencies = [], locks = ["apt", "dpkg", "net"], noop = void)], exports = [], imports = [])`,
+		},
+		{
+			name: "exports and imports",
+			build: func(b *Builder) {
+				b.Import("nginx")
+				b.Resource("site").DependsOn("nginx").Export("site").Export("www")
+			},
+			want: `(resources = [` +
+				`(id = 13129494563913127413, comment = "site", dependencies = [18006237342002060303], locks = [], noop = void)], ` +
+				`exports = [(name = "site", id = 13129494563913127413), (name = "www", id = 13129494563913127413)], ` +
+				`imports = [(name = "nginx", id = 18006237342002060303)])`,
+		},
+	}
+	for _, test := range tests {
+		b := New()
+		test.build(b)
+		c, err := b.Build()
+		if err != nil {
+			t.Errorf("%s: Build: %v", test.name, err)
+			continue
+		}
+		got, err := text.Marshal(catalog.Catalog_TypeID, c.Struct)
+		if err != nil {
+			t.Errorf("%s: marshal: %v", test.name, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: Build() = %s; want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(b *Builder)
+		msg   string
+	}{
+		{
+			name: "duplicate ID",
+			build: func(b *Builder) {
+				b.Resource("foo")
+				b.ResourceID(Hash("foo"))
+			},
+			msg: "same ID",
+		},
+		{
+			name: "unknown dependency",
+			build: func(b *Builder) {
+				b.Resource("foo").DependsOn("bar")
+			},
+			msg: `"bar", which is not in the catalog`,
+		},
+		{
+			name: "ifDepsChanged outside deps",
+			build: func(b *Builder) {
+				b.Resource("bar")
+				b.Resource("baz")
+				b.Resource("foo").DependsOn("bar").Exec(Run(Argv("/bin/true")).IfDepsChanged("bar", "baz"))
+			},
+			msg: `"baz", which is not a dependency`,
+		},
+		{
+			name: "mode on symlink",
+			build: func(b *Builder) {
+				b.Resource("foo").File(Symlink("/foo", "/bar").Mode(0644))
+			},
+			msg: "cannot have a mode",
+		},
+		{
+			name: "duplicate export",
+			build: func(b *Builder) {
+				b.Resource("foo").Export("x")
+				b.Resource("bar").Export("x")
+			},
+			msg: `export "x" is used more than once`,
+		},
+		{
+			name: "import collides with resource",
+			build: func(b *Builder) {
+				b.Import("foo")
+				b.Resource("foo")
+			},
+			msg: `import "foo" has the same ID`,
+		},
+		{
+			name: "empty lock name",
+			build: func(b *Builder) {
+				b.Resource("foo").Lock("")
+			},
+			msg: "empty lock name",
+		},
+		{
+			name: "nil command",
+			build: func(b *Builder) {
+				b.Resource("foo").Exec(Run(nil))
+			},
+			msg: "no command",
+		},
+	}
+	for _, test := range tests {
+		b := New()
+		test.build(b)
+		_, err := b.Build()
+		if err == nil {
+			t.Errorf("%s: Build succeeded", test.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.msg) {
+			t.Errorf("%s: Build error = %v; want to contain %q", test.name, err, test.msg)
+		}
+		if _, err := b.WriteTo(new(bytes.Buffer)); err == nil {
+			t.Errorf("%s: WriteTo succeeded", test.name)
+		}
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	b := New()
+	b.Resource("foo").File(PlainFile("/foo", []byte("bar")))
+	var buf bytes.Buffer
+	n, err := b.WriteTo(&buf)
+	if err != nil {
+		t.Fatal("WriteTo:", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo returned %d; wrote %d bytes", n, buf.Len())
+	}
+	msg, err := capnp.Unmarshal(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := catalog.ReadRootCatalog(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.Resources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Len() != 1 || res.At(0).ID() != Hash("foo") {
+		t.Errorf("wrote %d resources; want 1 with ID Hash(\"foo\")", res.Len())
+	}
+}
+
+func TestWritePackedTo(t *testing.T) {
+	b := New()
+	b.Resource("foo").File(PlainFile("/foo", []byte("bar")))
+	var buf bytes.Buffer
+	n, err := b.WritePackedTo(&buf)
+	if err != nil {
+		t.Fatal("WritePackedTo:", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WritePackedTo returned %d; wrote %d bytes", n, buf.Len())
+	}
+	msg, err := capnp.UnmarshalPacked(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := catalog.ReadRootCatalog(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.Resources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Len() != 1 || res.At(0).ID() != Hash("foo") {
+		t.Errorf("wrote %d resources; want 1 with ID Hash(\"foo\")", res.Len())
+	}
+}
+
+func TestBlobFile(t *testing.T) {
+	digest := sha256.Sum256([]byte("Hello, World!\n"))
+	b := New()
+	b.Resource("blob").File(BlobFile("/etc/motd", digest).Mode(0644))
+	c, err := b.Build()
+	if err != nil {
+		t.Fatal("Build:", err)
+	}
+	res, err := c.Resources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := res.At(0).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Plain().HasContent() {
+		t.Error("blob file has inline content")
+	}
+	if got, err := f.Plain().ContentSHA256(); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(got, digest[:]) {
+		t.Errorf("contentSha256 = %x; want %x", got, digest[:])
+	}
+	if mode, _ := f.Plain().Mode(); mode.Bits() != 0644 {
+		t.Errorf("mode bits = %#o; want 0644", mode.Bits())
+	}
+}
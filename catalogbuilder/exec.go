@@ -0,0 +1,148 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalogbuilder
+
+import (
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/internal/catpogs"
+)
+
+// A Command is a program invocation.
+type Command struct {
+	c catpogs.Command
+}
+
+// Argv returns a command that runs a program directly.  argv[0] should
+// be an absolute path.
+func Argv(argv ...string) *Command {
+	c := new(Command)
+	c.c.Which = catalog.Exec_Command_Which_argv
+	c.c.Argv = append([]string(nil), argv...)
+	return c
+}
+
+// Bash returns a command that runs a bash script.
+func Bash(script string) *Command {
+	c := new(Command)
+	c.c.Which = catalog.Exec_Command_Which_bash
+	c.c.Bash = script
+	return c
+}
+
+// Env adds an environment variable to the command's environment.
+func (c *Command) Env(name, value string) *Command {
+	c.c.Env = append(c.c.Env, catpogs.EnvVar{Name: name, Value: value})
+	return c
+}
+
+// Dir sets the command's working directory.
+func (c *Command) Dir(dir string) *Command {
+	c.c.Dir = dir
+	return c
+}
+
+func (c *Command) toPogs() *catpogs.Command {
+	if c == nil {
+		return nil
+	}
+	cc := c.c
+	return &cc
+}
+
+// An Exec runs a command, optionally guarded by a condition.
+type Exec struct {
+	cmd         *Command
+	cond        catpogs.ExecCondition
+	onlyIf      *Command
+	unless      *Command
+	depsChanged []ref
+}
+
+// Run returns an Exec that always runs cmd.
+func Run(cmd *Command) *Exec {
+	e := &Exec{cmd: cmd}
+	e.cond.Which = catalog.Exec_condition_Which_always
+	return e
+}
+
+// OnlyIf changes the condition to run the command only if cond
+// succeeds.
+func (e *Exec) OnlyIf(cond *Command) *Exec {
+	e.setCondition(catalog.Exec_condition_Which_onlyIf)
+	e.onlyIf = cond
+	return e
+}
+
+// Unless changes the condition to run the command only if cond fails.
+func (e *Exec) Unless(cond *Command) *Exec {
+	e.setCondition(catalog.Exec_condition_Which_unless)
+	e.unless = cond
+	return e
+}
+
+// IfFileAbsent changes the condition to run the command only if path
+// does not exist.
+func (e *Exec) IfFileAbsent(path string) *Exec {
+	e.setCondition(catalog.Exec_condition_Which_fileAbsent)
+	e.cond.FileAbsent = path
+	return e
+}
+
+// IfDepsChanged changes the condition to run the command only if one
+// of the named resources changed.  Each must also be a dependency of
+// the resource.  Calling IfDepsChanged more than once adds to the list.
+func (e *Exec) IfDepsChanged(names ...string) *Exec {
+	e.setCondition(catalog.Exec_condition_Which_ifDepsChanged)
+	for _, name := range names {
+		e.depsChanged = append(e.depsChanged, ref{id: Hash(name), name: name})
+	}
+	return e
+}
+
+// IfDepsChangedID is like IfDepsChanged, but takes resource IDs.
+func (e *Exec) IfDepsChangedID(ids ...uint64) *Exec {
+	e.setCondition(catalog.Exec_condition_Which_ifDepsChanged)
+	for _, id := range ids {
+		e.depsChanged = append(e.depsChanged, ref{id: id})
+	}
+	return e
+}
+
+func (e *Exec) setCondition(w catalog.Exec_condition_Which) {
+	if e.cond.Which == w {
+		return
+	}
+	e.cond = catpogs.ExecCondition{Which: w}
+	e.onlyIf, e.unless, e.depsChanged = nil, nil, nil
+}
+
+func (e *Exec) toPogs() *catpogs.Exec {
+	ee := &catpogs.Exec{
+		Command:   e.cmd.toPogs(),
+		Condition: e.cond,
+	}
+	switch e.cond.Which {
+	case catalog.Exec_condition_Which_onlyIf:
+		ee.Condition.OnlyIf = e.onlyIf.toPogs()
+	case catalog.Exec_condition_Which_unless:
+		ee.Condition.Unless = e.unless.toPogs()
+	case catalog.Exec_condition_Which_ifDepsChanged:
+		ee.Condition.IfDepsChanged = refIDs(e.depsChanged)
+		if ee.Condition.IfDepsChanged == nil {
+			ee.Condition.IfDepsChanged = []uint64{}
+		}
+	}
+	return ee
+}
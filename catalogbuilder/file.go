@@ -0,0 +1,134 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalogbuilder
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/internal/catpogs"
+)
+
+// A File describes the desired state of a file.
+type File struct {
+	f    *catpogs.File
+	mode *catpogs.FileMode
+	err  error
+}
+
+// PlainFile returns a regular file with the given content.  If content
+// is nil, then the file's content is not managed.
+func PlainFile(path string, content []byte) *File {
+	return &File{f: catpogs.PlainFile(path, content)}
+}
+
+// BlobFile returns a regular file whose content is given only by its
+// SHA-256 digest.  The executor fetches the content from its blob store.
+func BlobFile(path string, digest [sha256.Size]byte) *File {
+	f := catpogs.PlainFile(path, nil)
+	f.Plain.ContentSHA256 = digest[:]
+	return &File{f: f}
+}
+
+// Directory returns a directory.
+func Directory(path string) *File {
+	return &File{f: catpogs.Directory(path, nil)}
+}
+
+// Symlink returns a symbolic link at path that points to target.
+func Symlink(path, target string) *File {
+	return &File{f: catpogs.SymlinkFile(target, path)}
+}
+
+// Hardlink returns a hard link at path to the existing file target.
+func Hardlink(path, target string) *File {
+	return &File{f: catpogs.HardlinkFile(target, path)}
+}
+
+// Absent returns a file that must not exist.
+func Absent(path string) *File {
+	return &File{f: &catpogs.File{Path: path, Which: catalog.File_Which_absent}}
+}
+
+// Mode sets the file's permission bits, in the same layout as
+// catalog.File.Mode.bits.  Only plain files and directories have modes.
+func (f *File) Mode(bits uint16) *File {
+	if m := f.fileMode(); m != nil {
+		m.Bits = bits
+	}
+	return f
+}
+
+// User sets the file's owner by name.
+func (f *File) User(name string) *File {
+	if m := f.fileMode(); m != nil {
+		m.User = catpogs.UserNameRef(name)
+	}
+	return f
+}
+
+// UserID sets the file's owner by numeric ID.
+func (f *File) UserID(uid int) *File {
+	if m := f.fileMode(); m != nil {
+		m.User = catpogs.UserIDRef(uid)
+	}
+	return f
+}
+
+// Group sets the file's group by name.
+func (f *File) Group(name string) *File {
+	if m := f.fileMode(); m != nil {
+		m.Group = catpogs.GroupNameRef(name)
+	}
+	return f
+}
+
+// GroupID sets the file's group by numeric ID.
+func (f *File) GroupID(gid int) *File {
+	if m := f.fileMode(); m != nil {
+		m.Group = catpogs.GroupIDRef(gid)
+	}
+	return f
+}
+
+// fileMode returns the file's mode, creating it if necessary.  If the
+// file cannot have a mode, fileMode records an error and returns nil.
+func (f *File) fileMode() *catpogs.FileMode {
+	if f.mode != nil {
+		return f.mode
+	}
+	switch f.f.Which {
+	case catalog.File_Which_plain, catalog.File_Which_directory:
+		f.mode = &catpogs.FileMode{Bits: catpogs.ModeUnset}
+		return f.mode
+	default:
+		if f.err == nil {
+			f.err = fmt.Errorf("file %s: %v cannot have a mode", f.f.Path, f.f.Which)
+		}
+		return nil
+	}
+}
+
+func (f *File) toPogs() *catpogs.File {
+	ff := *f.f
+	switch ff.Which {
+	case catalog.File_Which_plain:
+		ff.Plain.Mode = f.mode
+	case catalog.File_Which_directory:
+		ff.Directory.Mode = f.mode
+	}
+	return &ff
+}
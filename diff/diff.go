@@ -0,0 +1,227 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/zombiezen/mcm/internal/catalogio"
+	"github.com/zombiezen/mcm/internal/catdiff"
+	"github.com/zombiezen/mcm/internal/version"
+)
+
+func init() {
+	flag.Usage = usage
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [-json] OLD NEW\n", filepath.Base(os.Args[0]))
+	flag.PrintDefaults()
+}
+
+func main() {
+	jsonMode := flag.Bool("json", false, "write the differences as a JSON object")
+	readOpts := catalogio.Flags(flag.CommandLine)
+	versionMode := flag.Bool("version", false, "display version info")
+	flag.Parse()
+	if *versionMode {
+		version.Show()
+		return
+	}
+	if flag.NArg() != 2 {
+		usage()
+		os.Exit(2)
+	}
+	if flag.Arg(0) == "-" && flag.Arg(1) == "-" {
+		fmt.Fprintln(os.Stderr, "mcm-diff: only one catalog can be read from stdin")
+		os.Exit(2)
+	}
+
+	oldCat, err := catalogio.ReadFile(stdinPath(flag.Arg(0)), readOpts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "mcm-diff:", err)
+		os.Exit(2)
+	}
+	newCat, err := catalogio.ReadFile(stdinPath(flag.Arg(1)), readOpts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "mcm-diff:", err)
+		os.Exit(2)
+	}
+	d, err := catdiff.Compare(oldCat, newCat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "mcm-diff:", err)
+		os.Exit(2)
+	}
+	if *jsonMode {
+		err = writeJSON(os.Stdout, d)
+	} else {
+		err = writeText(os.Stdout, d)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "mcm-diff:", err)
+		os.Exit(2)
+	}
+	if !d.Empty() {
+		os.Exit(1)
+	}
+}
+
+// stdinPath converts the conventional "-" argument into the empty
+// path that catalogio.ReadFile reads from stdin.
+func stdinPath(path string) string {
+	if path == "-" {
+		return ""
+	}
+	return path
+}
+
+func writeText(w io.Writer, d *catdiff.Diff) error {
+	bw := bufio.NewWriter(w)
+	for _, r := range d.Removed {
+		fmt.Fprintf(bw, "- %v\n", r)
+	}
+	for _, r := range d.Added {
+		fmt.Fprintf(bw, "+ %v\n", r)
+	}
+	for _, rd := range d.Changed {
+		fmt.Fprintf(bw, "~ %v\n", rd.New)
+		for _, dep := range rd.RemovedDeps {
+			fmt.Fprintf(bw, "    - dependency %s\n", dep)
+		}
+		for _, dep := range rd.AddedDeps {
+			fmt.Fprintf(bw, "    + dependency %s\n", dep)
+		}
+		for _, fd := range rd.Fields {
+			switch {
+			case fd.Unified != "":
+				fmt.Fprintf(bw, "    %s:\n", fd.Name)
+				for _, line := range strings.SplitAfter(strings.TrimSuffix(fd.Unified, "\n"), "\n") {
+					fmt.Fprintf(bw, "        %s", line)
+				}
+				bw.WriteString("\n")
+			case isBinary(fd.Old) || isBinary(fd.New):
+				fmt.Fprintf(bw, "    %s: binary data differs (%s -> %s)\n", fd.Name, dataSize(fd.Old), dataSize(fd.New))
+			default:
+				fmt.Fprintf(bw, "    %s: %s -> %s\n", fd.Name, textValue(fd.Old), textValue(fd.New))
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+func isBinary(f *catdiff.Field) bool {
+	return f != nil && f.Binary
+}
+
+func dataSize(f *catdiff.Field) string {
+	if f == nil {
+		return "unset"
+	}
+	return fmt.Sprintf("%d bytes", len(f.Value))
+}
+
+func textValue(f *catdiff.Field) string {
+	if f == nil {
+		return "(unset)"
+	}
+	if f.Value == "" || strings.ContainsAny(f.Value, " \t\n\"") {
+		return strconv.Quote(f.Value)
+	}
+	return f.Value
+}
+
+// jsonDiff is the JSON form of a catdiff.Diff.
+type jsonDiff struct {
+	Added   []jsonResource `json:"added"`
+	Removed []jsonResource `json:"removed"`
+	Changed []jsonChange   `json:"changed"`
+}
+
+type jsonResource struct {
+	ID      uint64 `json:"id"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type jsonChange struct {
+	jsonResource
+	AddedDeps   []string    `json:"addedDependencies,omitempty"`
+	RemovedDeps []string    `json:"removedDependencies,omitempty"`
+	Fields      []jsonField `json:"fields,omitempty"`
+}
+
+// jsonField is a changed field.  Old and New are omitted if the field
+// is not set in that catalog, and both are omitted for binary data,
+// which can't be represented in JSON strings.
+type jsonField struct {
+	Name    string  `json:"name"`
+	Old     *string `json:"old,omitempty"`
+	New     *string `json:"new,omitempty"`
+	Binary  bool    `json:"binary,omitempty"`
+	Unified string  `json:"diff,omitempty"`
+}
+
+func writeJSON(w io.Writer, d *catdiff.Diff) error {
+	out := jsonDiff{
+		Added:   jsonResources(d.Added),
+		Removed: jsonResources(d.Removed),
+		Changed: make([]jsonChange, len(d.Changed)),
+	}
+	for i, rd := range d.Changed {
+		c := jsonChange{
+			jsonResource: jsonResource{ID: rd.New.ID, Comment: rd.New.Comment},
+			AddedDeps:    rd.AddedDeps,
+			RemovedDeps:  rd.RemovedDeps,
+			Fields:       make([]jsonField, len(rd.Fields)),
+		}
+		for j, fd := range rd.Fields {
+			f := jsonField{Name: fd.Name, Unified: fd.Unified}
+			if isBinary(fd.Old) || isBinary(fd.New) {
+				f.Binary = true
+			} else {
+				if fd.Old != nil {
+					f.Old = &fd.Old.Value
+				}
+				if fd.New != nil {
+					f.New = &fd.New.Value
+				}
+			}
+			c.Fields[j] = f
+		}
+		out.Changed[i] = c
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = w.Write(data)
+	return err
+}
+
+func jsonResources(list []*catdiff.Resource) []jsonResource {
+	out := make([]jsonResource, len(list))
+	for i, r := range list {
+		out[i] = jsonResource{ID: r.ID, Comment: r.Comment}
+	}
+	return out
+}
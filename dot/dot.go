@@ -21,11 +21,18 @@ import (
 	"os"
 
 	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/dot/dotlib"
+	"github.com/zombiezen/mcm/exec/report"
+	"github.com/zombiezen/mcm/internal/catalogio"
 	"github.com/zombiezen/mcm/internal/version"
-	"github.com/zombiezen/mcm/third_party/golang/capnproto"
 )
 
 func main() {
+	readOpts := catalogio.Flags(flag.CommandLine)
+	runsBefore := flag.Bool("runs-before", false, "draw edges from each resource to the resources that run after it, instead of to its dependencies")
+	clusterDepth := flag.Int("cluster-depth", 0, "group files by the first `N` components of their paths; 0 disables clustering")
+	format := flag.String("format", "dot", "output `format`: dot, json, mermaid, or graphml")
+	reportPath := flag.String("report", "", "color resources by their outcome in this mcm-exec report file")
 	versionMode := flag.Bool("version", false, "display version info")
 	flag.Parse()
 	if *versionMode {
@@ -33,62 +40,74 @@ func main() {
 		return
 	}
 
-	var cat catalog.Catalog
+	write := writers[*format]
+	if write == nil {
+		fmt.Fprintf(os.Stderr, "mcm-dot: unknown -format=%q\n", *format)
+		os.Exit(2)
+	}
+
+	var path string
 	switch flag.NArg() {
 	case 0:
-		var err error
-		cat, err = readCatalog(os.Stdin)
-		if err != nil {
-			die(err)
-		}
 	case 1:
-		// TODO(someday): read segments lazily
-		f, err := os.Open(flag.Arg(0))
-		if err != nil {
-			die(err)
-		}
-		cat, err = readCatalog(f)
-		if err != nil {
-			die(err)
-		}
-		if err = f.Close(); err != nil {
-			die(err)
-		}
+		path = flag.Arg(0)
 	default:
 		flag.Usage()
 		os.Exit(2)
 	}
+	cat, err := catalogio.ReadFile(path, readOpts)
+	if err != nil {
+		die(err)
+	}
 
-	fmt.Println("digraph catalog {")
-	resources, _ := cat.Resources()
-	for i := 0; i < resources.Len(); i++ {
-		r := resources.At(i)
-		id := r.ID()
-		if c, _ := r.Comment(); c != "" {
-			fmt.Printf("  %d [label=%q];\n", id, c)
-		}
-		deps, _ := r.Dependencies()
-		for j := 0; j < deps.Len(); j++ {
-			fmt.Printf("  %d -> %d;\n", id, deps.At(j))
+	var rep *report.Report
+	if *reportPath != "" {
+		rep, err = readReport(*reportPath, cat)
+		if err != nil {
+			die(err)
 		}
-		fmt.Println()
 	}
-	fmt.Println("}")
+	g, err := dotlib.NewGraph(cat, &dotlib.Options{
+		RunsBefore:   *runsBefore,
+		ClusterDepth: *clusterDepth,
+		Report:       rep,
+	})
+	if err != nil {
+		die(err)
+	}
+	if err := write(g, os.Stdout); err != nil {
+		die(err)
+	}
 }
 
-func die(err error) {
-	fmt.Fprintln(os.Stderr, "mcm-dot:", err)
-	os.Exit(1)
+// writers maps -format values to graph writers.
+var writers = map[string]func(*dotlib.Graph, io.Writer) error{
+	"dot":     (*dotlib.Graph).WriteDOT,
+	"json":    (*dotlib.Graph).WriteJSON,
+	"mermaid": (*dotlib.Graph).WriteMermaid,
+	"graphml": (*dotlib.Graph).WriteGraphML,
 }
 
-func readCatalog(r io.Reader) (catalog.Catalog, error) {
-	msg, err := capnp.NewDecoder(r).Decode()
+// readReport finds the report for cat in an mcm-exec report file.
+func readReport(path string, cat catalog.Catalog) (*report.Report, error) {
+	h, err := catalog.Hash(cat)
+	if err != nil {
+		return nil, fmt.Errorf("hash catalog: %v", err)
+	}
+	catalogio.ResetReadLimit(cat)
+	f, err := os.Open(path)
 	if err != nil {
-		return catalog.Catalog{}, fmt.Errorf("read catalog: %v", err)
+		return nil, err
 	}
-	c, err := catalog.ReadRootCatalog(msg)
+	defer f.Close()
+	rep, err := report.Find(f, h)
 	if err != nil {
-		return catalog.Catalog{}, fmt.Errorf("read catalog: %v", err)
+		return nil, fmt.Errorf("%s: %v", path, err)
 	}
-	return c, nil
+	return rep, nil
+}
+
+func die(err error) {
+	fmt.Fprintln(os.Stderr, "mcm-dot:", err)
+	os.Exit(1)
 }
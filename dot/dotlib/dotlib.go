@@ -0,0 +1,536 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dotlib draws a catalog's dependency graph for mcm-dot.
+package dotlib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/exec/report"
+)
+
+// Options controls how a catalog is drawn.
+type Options struct {
+	// RunsBefore draws each edge from a resource to the resources that
+	// depend on it, the order in which they are applied.  By default,
+	// edges point from a resource to its dependencies.
+	RunsBefore bool
+
+	// ClusterDepth, if positive, groups file resources into clusters
+	// by the first ClusterDepth components of their paths.  For
+	// example, with a depth of 1, /etc/hosts and /etc/ssh/sshd_config
+	// are both in the /etc cluster.
+	ClusterDepth int
+
+	// Report, if not nil, is the outcome of applying the catalog.  Nodes
+	// are colored by their status and edges that a failure propagated
+	// along are highlighted.
+	Report *report.Report
+}
+
+// Kind is the type of a resource, as it is drawn.
+type Kind int
+
+// Resource kinds.
+const (
+	Noop Kind = iota
+	PlainFile
+	Directory
+	Symlink
+	Hardlink
+	AbsentFile
+	Exec
+)
+
+var kindNames = [...]string{
+	Noop:       "noop",
+	PlainFile:  "plain",
+	Directory:  "directory",
+	Symlink:    "symlink",
+	Hardlink:   "hardlink",
+	AbsentFile: "absent",
+	Exec:       "exec",
+}
+
+// String returns the lowercase name of the kind.
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+	return kindNames[k]
+}
+
+// A Graph is the drawable form of a catalog.
+type Graph struct {
+	// Nodes are the catalog's resources in catalog order.
+	Nodes []*Node
+
+	// Edges are the dependencies between resources.  Each edge points
+	// in the direction given by Options.RunsBefore.
+	Edges []Edge
+}
+
+// A Node is a single resource.
+type Node struct {
+	ID    uint64
+	Kind  Kind
+	Label string
+
+	// Tooltip describes what the resource does: the path of a file or
+	// the command of an exec.
+	Tooltip string
+
+	// Cluster is the path prefix that the resource is grouped under,
+	// or empty if it's not in a cluster.
+	Cluster string
+
+	// Result is the outcome of applying the resource.  It is nil if
+	// the graph has no report or the report does not include the
+	// resource.
+	Result *report.Resource
+}
+
+// An Edge is a dependency between two resources.
+type Edge struct {
+	From, To uint64
+
+	// IfDepsChanged is true if the dependent resource is an exec that
+	// only runs if this dependency changed.
+	IfDepsChanged bool
+
+	// Failure is true if the dependent resource was skipped because of
+	// the failure of this dependency or of the same resource that this
+	// dependency was skipped for.
+	Failure bool
+}
+
+// NewGraph builds the graph for c.  opts may be nil, which is the same
+// as a zero Options.
+func NewGraph(c catalog.Catalog, opts *Options) (*Graph, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	res, err := c.Resources()
+	if err != nil {
+		return nil, fmt.Errorf("read resources: %v", err)
+	}
+	g := &Graph{Nodes: make([]*Node, 0, res.Len())}
+	var results map[uint64]*report.Resource
+	if opts.Report != nil {
+		results = make(map[uint64]*report.Resource, len(opts.Report.Resources))
+		for i := range opts.Report.Resources {
+			rr := &opts.Report.Resources[i]
+			results[rr.ID] = rr
+		}
+	}
+	for i := 0; i < res.Len(); i++ {
+		r := res.At(i)
+		n, err := newNode(r, opts)
+		if err != nil {
+			return nil, fmt.Errorf("resource id=%d: %v", r.ID(), err)
+		}
+		n.Result = results[n.ID]
+		g.Nodes = append(g.Nodes, n)
+		deps, err := r.Dependencies()
+		if err != nil {
+			return nil, fmt.Errorf("resource id=%d: dependencies: %v", r.ID(), err)
+		}
+		changed, err := ifDepsChanged(r)
+		if err != nil {
+			return nil, fmt.Errorf("resource id=%d: %v", r.ID(), err)
+		}
+		for j := 0; j < deps.Len(); j++ {
+			dep := deps.At(j)
+			e := newEdge(r.ID(), dep, changed[dep], opts)
+			e.Failure = isFailureEdge(results[r.ID()], results[dep], dep)
+			g.Edges = append(g.Edges, e)
+			delete(changed, dep)
+		}
+		// A condition on something other than a dependency is an
+		// error, but draw it anyway so that it can be spotted.
+		extra := make([]uint64, 0, len(changed))
+		for dep := range changed {
+			extra = append(extra, dep)
+		}
+		sort.Slice(extra, func(i, j int) bool { return extra[i] < extra[j] })
+		for _, dep := range extra {
+			g.Edges = append(g.Edges, newEdge(r.ID(), dep, true, opts))
+		}
+	}
+	return g, nil
+}
+
+func newEdge(id, dep uint64, changed bool, opts *Options) Edge {
+	if opts.RunsBefore {
+		return Edge{From: dep, To: id, IfDepsChanged: changed}
+	}
+	return Edge{From: id, To: dep, IfDepsChanged: changed}
+}
+
+// isFailureEdge reports whether the resource with report rr was skipped
+// because of the failure of dep (with report depReport) or of the same
+// resource that dep was skipped for.
+func isFailureEdge(rr, depReport *report.Resource, dep uint64) bool {
+	if rr == nil || depReport == nil || rr.Status != report.Skipped {
+		return false
+	}
+	switch depReport.Status {
+	case report.Failed:
+		return rr.FailedDep == dep
+	case report.Skipped:
+		return rr.FailedDep == depReport.FailedDep
+	default:
+		return false
+	}
+}
+
+// resultString returns a one-line summary of a resource's outcome.
+func resultString(rr *report.Resource) string {
+	switch rr.Status {
+	case report.Failed:
+		if rr.Err == nil {
+			return "failed"
+		}
+		return "failed: " + rr.Err.Error()
+	case report.Skipped:
+		return fmt.Sprintf("skipped: id=%d failed", rr.FailedDep)
+	default:
+		return rr.Status.String()
+	}
+}
+
+// formatDuration returns d rounded to a precision that is useful in a
+// label.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "0s"
+	case d >= time.Second:
+		return roundDuration(d, 10*time.Millisecond).String()
+	case d >= time.Millisecond:
+		return roundDuration(d, 100*time.Microsecond).String()
+	default:
+		return roundDuration(d, time.Microsecond).String()
+	}
+}
+
+// roundDuration rounds a non-negative d to the nearest multiple of m,
+// rounding halfway values up.  It behaves like Duration.Round, which
+// needs Go 1.9.
+func roundDuration(d, m time.Duration) time.Duration {
+	r := d % m
+	if r+r < m {
+		return d - r
+	}
+	return d + m - r
+}
+
+func newNode(r catalog.Resource, opts *Options) (*Node, error) {
+	n := &Node{ID: r.ID()}
+	n.Label, _ = r.Comment()
+	switch r.Which() {
+	case catalog.Resource_Which_noop:
+		n.Kind = Noop
+	case catalog.Resource_Which_file:
+		f, err := r.File()
+		if err != nil {
+			return nil, fmt.Errorf("file: %v", err)
+		}
+		p, _ := f.Path()
+		n.Tooltip = p
+		switch f.Which() {
+		case catalog.File_Which_plain:
+			n.Kind = PlainFile
+		case catalog.File_Which_directory:
+			n.Kind = Directory
+		case catalog.File_Which_symlink:
+			n.Kind = Symlink
+			target, _ := f.Symlink().Target()
+			n.Tooltip = p + " -> " + target
+		case catalog.File_Which_hardlink:
+			n.Kind = Hardlink
+			target, _ := f.Hardlink().Target()
+			n.Tooltip = p + " => " + target
+		case catalog.File_Which_absent:
+			n.Kind = AbsentFile
+		default:
+			return nil, fmt.Errorf("unknown file type %v", f.Which())
+		}
+		if n.Label == "" {
+			n.Label = p
+		}
+		if opts.ClusterDepth > 0 {
+			n.Cluster = pathPrefix(p, opts.ClusterDepth)
+		}
+	case catalog.Resource_Which_exec:
+		n.Kind = Exec
+		e, err := r.Exec()
+		if err != nil {
+			return nil, fmt.Errorf("exec: %v", err)
+		}
+		cmd, err := e.Command()
+		if err != nil {
+			return nil, fmt.Errorf("exec command: %v", err)
+		}
+		n.Tooltip = commandString(cmd)
+		if n.Label == "" {
+			n.Label = n.Tooltip
+		}
+	default:
+		return nil, fmt.Errorf("unknown resource type %v", r.Which())
+	}
+	if n.Label == "" {
+		n.Label = fmt.Sprintf("id=%d", n.ID)
+	}
+	return n, nil
+}
+
+// ifDepsChanged returns the set of IDs in r's ifDepsChanged condition.
+func ifDepsChanged(r catalog.Resource) (map[uint64]bool, error) {
+	if r.Which() != catalog.Resource_Which_exec {
+		return nil, nil
+	}
+	e, err := r.Exec()
+	if err != nil {
+		return nil, fmt.Errorf("exec: %v", err)
+	}
+	cond := e.Condition()
+	if cond.Which() != catalog.Exec_condition_Which_ifDepsChanged {
+		return nil, nil
+	}
+	ids, err := cond.IfDepsChanged()
+	if err != nil {
+		return nil, fmt.Errorf("exec condition: %v", err)
+	}
+	m := make(map[uint64]bool, ids.Len())
+	for i := 0; i < ids.Len(); i++ {
+		m[ids.At(i)] = true
+	}
+	return m, nil
+}
+
+// commandString returns a one-line summary of an exec's command.
+func commandString(cmd catalog.Exec_Command) string {
+	switch cmd.Which() {
+	case catalog.Exec_Command_Which_argv:
+		argv, _ := cmd.Argv()
+		args := make([]string, argv.Len())
+		for i := range args {
+			args[i], _ = argv.At(i)
+		}
+		return strings.Join(args, " ")
+	case catalog.Exec_Command_Which_bash:
+		script, _ := cmd.Bash()
+		return "bash: " + strings.TrimSpace(script)
+	default:
+		return ""
+	}
+}
+
+// pathPrefix returns the first depth components of the directory
+// containing p, or "/" if p is directly in the root.
+func pathPrefix(p string, depth int) string {
+	dir := path.Dir(path.Clean(p))
+	parts := strings.Split(strings.TrimPrefix(dir, "/"), "/")
+	if parts[0] == "" {
+		return "/"
+	}
+	if len(parts) > depth {
+		parts = parts[:depth]
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+// Node shapes and fill colors by kind.
+var (
+	dotShapes = [...]string{
+		Noop:       "ellipse",
+		PlainFile:  "note",
+		Directory:  "folder",
+		Symlink:    "cds",
+		Hardlink:   "cds",
+		AbsentFile: "note",
+		Exec:       "box",
+	}
+	dotKindColors = [...]string{
+		PlainFile: "#fff8c4",
+		Directory: "#f5deb3",
+		Symlink:   "#d8f0f8",
+		Hardlink:  "#c8e0f0",
+		Exec:      "#d4e8c8",
+	}
+)
+
+// Node fill colors by status when drawing a report.
+var dotStatusColors = [...]string{
+	report.Unchanged: "#e8f5e9",
+	report.Changed:   "#ffd27f",
+	report.Failed:    "#ef9a9a",
+	report.Skipped:   "#e0e0e0",
+}
+
+// displayLabel returns the text to show for n, which includes its
+// duration if it was applied.
+func displayLabel(n *Node) string {
+	if n.Result == nil || n.Result.Status == report.Skipped {
+		return n.Label
+	}
+	return n.Label + "\n" + formatDuration(n.Result.Duration)
+}
+
+// displayTooltip returns the text to show when hovering over n, which
+// starts with its outcome if it has one.
+func displayTooltip(n *Node) string {
+	switch {
+	case n.Result == nil:
+		return n.Tooltip
+	case n.Tooltip == "":
+		return resultString(n.Result)
+	default:
+		return resultString(n.Result) + "\n" + n.Tooltip
+	}
+}
+
+// clusters returns the names of the clusters in g in order of first
+// appearance and the nodes in each cluster.  Nodes that aren't in a
+// cluster are under the empty string.
+func (g *Graph) clusters() ([]string, map[string][]*Node) {
+	var names []string
+	byCluster := make(map[string][]*Node)
+	for _, n := range g.Nodes {
+		if _, ok := byCluster[n.Cluster]; !ok && n.Cluster != "" {
+			names = append(names, n.Cluster)
+		}
+		byCluster[n.Cluster] = append(byCluster[n.Cluster], n)
+	}
+	return names, byCluster
+}
+
+// WriteDOT writes g to w in GraphViz's DOT format.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("digraph catalog {\n")
+	clusters, byCluster := g.clusters()
+	for _, n := range byCluster[""] {
+		writeDOTNode(bw, "  ", n)
+	}
+	for i, c := range clusters {
+		fmt.Fprintf(bw, "  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(bw, "    label=%s;\n", dotQuote(c))
+		for _, n := range byCluster[c] {
+			writeDOTNode(bw, "    ", n)
+		}
+		bw.WriteString("  }\n")
+	}
+	if len(g.Edges) > 0 {
+		bw.WriteString("\n")
+	}
+	for _, e := range g.Edges {
+		var attrs []string
+		if e.IfDepsChanged {
+			attrs = append(attrs, "style=dashed")
+		}
+		if e.Failure {
+			attrs = append(attrs, "color=red", "penwidth=2")
+		}
+		if len(attrs) == 0 {
+			fmt.Fprintf(bw, "  %d -> %d;\n", e.From, e.To)
+		} else {
+			fmt.Fprintf(bw, "  %d -> %d [%s];\n", e.From, e.To, strings.Join(attrs, ", "))
+		}
+	}
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
+
+func writeDOTNode(w *bufio.Writer, indent string, n *Node) {
+	fmt.Fprintf(w, "%s%d [label=%s", indent, n.ID, dotQuote(displayLabel(n)))
+	if tip := displayTooltip(n); tip != "" {
+		fmt.Fprintf(w, ", tooltip=%s", dotQuote(tip))
+	}
+	w.WriteString(dotNodeAttrs(n))
+	w.WriteString("];\n")
+}
+
+// dotNodeAttrs returns the shape and style attributes of n, starting
+// with a comma.
+func dotNodeAttrs(n *Node) string {
+	var shape, fill string
+	if n.Kind >= 0 && int(n.Kind) < len(dotShapes) {
+		shape, fill = dotShapes[n.Kind], dotKindColors[n.Kind]
+	}
+	var style []string
+	var extra string
+	switch n.Kind {
+	case Exec:
+		style = append(style, "rounded")
+	case AbsentFile:
+		style = append(style, "dashed")
+		extra = ", color=gray40, fontcolor=gray40"
+	}
+	if n.Result != nil && n.Result.Status >= 0 && int(n.Result.Status) < len(dotStatusColors) {
+		fill = dotStatusColors[n.Result.Status]
+		switch n.Result.Status {
+		case report.Failed:
+			extra = ", color=red, penwidth=2"
+		case report.Skipped:
+			extra = ", color=gray40, fontcolor=gray40"
+		}
+	}
+	if fill != "" {
+		style = append(style, "filled")
+	}
+	buf := new(bytes.Buffer)
+	if shape != "" {
+		buf.WriteString(", shape=" + shape)
+	}
+	if len(style) > 0 {
+		buf.WriteString(", style=" + dotQuote(strings.Join(style, ",")))
+	}
+	if fill != "" {
+		buf.WriteString(", fillcolor=" + dotQuote(fill))
+	}
+	buf.WriteString(extra)
+	return buf.String()
+}
+
+// dotQuote returns s as a DOT quoted string.
+func dotQuote(s string) string {
+	buf := new(bytes.Buffer)
+	buf.WriteByte('"')
+	for _, c := range s {
+		switch c {
+		case '"', '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(c)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r', '\t':
+			buf.WriteByte(' ')
+		default:
+			buf.WriteRune(c)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
@@ -0,0 +1,242 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dotlib
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/exec/report"
+	"github.com/zombiezen/mcm/internal/catpogs"
+)
+
+func testCatalog(t *testing.T) catalog.Catalog {
+	c, err := (&catpogs.Catalog{
+		Resources: []*catpogs.Resource{
+			{ID: 1, Which: catalog.Resource_Which_file, File: catpogs.Directory("/etc/foo", nil)},
+			{ID: 2, Deps: []uint64{1}, Which: catalog.Resource_Which_file, File: catpogs.PlainFile("/etc/foo/foo.conf", []byte("foo"))},
+			{ID: 3, Which: catalog.Resource_Which_file, File: catpogs.SymlinkFile("/etc/foo/foo.conf", "/usr/local/etc/foo.conf")},
+			{ID: 4, Which: catalog.Resource_Which_file, File: &catpogs.File{Path: "/tmp/junk", Which: catalog.File_Which_absent}},
+			{
+				ID:    5,
+				Deps:  []uint64{2, 3},
+				Which: catalog.Resource_Which_exec,
+				Exec: &catpogs.Exec{
+					Command: &catpogs.Command{
+						Which: catalog.Exec_Command_Which_argv,
+						Argv:  []string{"/usr/sbin/service", "foo", "restart"},
+					},
+					Condition: catpogs.ExecCondition{
+						Which:         catalog.Exec_condition_Which_ifDepsChanged,
+						IfDepsChanged: []uint64{2},
+					},
+				},
+			},
+			{ID: 6, Comment: "all \"done\"", Deps: []uint64{5, 4}, Which: catalog.Resource_Which_noop},
+		},
+	}).ToCapnp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestNewGraph(t *testing.T) {
+	g, err := NewGraph(testCatalog(t), nil)
+	if err != nil {
+		t.Fatal("NewGraph:", err)
+	}
+	wantNodes := []Node{
+		{ID: 1, Kind: Directory, Label: "/etc/foo", Tooltip: "/etc/foo"},
+		{ID: 2, Kind: PlainFile, Label: "/etc/foo/foo.conf", Tooltip: "/etc/foo/foo.conf"},
+		{ID: 3, Kind: Symlink, Label: "/usr/local/etc/foo.conf", Tooltip: "/usr/local/etc/foo.conf -> /etc/foo/foo.conf"},
+		{ID: 4, Kind: AbsentFile, Label: "/tmp/junk", Tooltip: "/tmp/junk"},
+		{ID: 5, Kind: Exec, Label: "/usr/sbin/service foo restart", Tooltip: "/usr/sbin/service foo restart"},
+		{ID: 6, Kind: Noop, Label: "all \"done\""},
+	}
+	if len(g.Nodes) != len(wantNodes) {
+		t.Fatalf("len(g.Nodes) = %d; want %d", len(g.Nodes), len(wantNodes))
+	}
+	for i, n := range g.Nodes {
+		if *n != wantNodes[i] {
+			t.Errorf("g.Nodes[%d] = %+v; want %+v", i, *n, wantNodes[i])
+		}
+	}
+	wantEdges := []Edge{
+		{From: 2, To: 1},
+		{From: 5, To: 2, IfDepsChanged: true},
+		{From: 5, To: 3},
+		{From: 6, To: 5},
+		{From: 6, To: 4},
+	}
+	if !equalEdges(g.Edges, wantEdges) {
+		t.Errorf("g.Edges = %+v; want %+v", g.Edges, wantEdges)
+	}
+}
+
+func TestNewGraphOptions(t *testing.T) {
+	g, err := NewGraph(testCatalog(t), &Options{RunsBefore: true, ClusterDepth: 1})
+	if err != nil {
+		t.Fatal("NewGraph:", err)
+	}
+	wantClusters := map[uint64]string{1: "/etc", 2: "/etc", 3: "/usr", 4: "/tmp", 5: "", 6: ""}
+	for _, n := range g.Nodes {
+		if n.Cluster != wantClusters[n.ID] {
+			t.Errorf("node %d cluster = %q; want %q", n.ID, n.Cluster, wantClusters[n.ID])
+		}
+	}
+	wantEdges := []Edge{
+		{From: 1, To: 2},
+		{From: 2, To: 5, IfDepsChanged: true},
+		{From: 3, To: 5},
+		{From: 5, To: 6},
+		{From: 4, To: 6},
+	}
+	if !equalEdges(g.Edges, wantEdges) {
+		t.Errorf("g.Edges = %+v; want %+v", g.Edges, wantEdges)
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	g, err := NewGraph(testCatalog(t), &Options{ClusterDepth: 2})
+	if err != nil {
+		t.Fatal("NewGraph:", err)
+	}
+	buf := new(bytes.Buffer)
+	if err := g.WriteDOT(buf); err != nil {
+		t.Fatal("WriteDOT:", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"digraph catalog {\n",
+		`6 [label="all \"done\"", shape=ellipse];`,
+		`subgraph cluster_0 {`,
+		`label="/etc/foo";`,
+		`1 [label="/etc/foo", tooltip="/etc/foo", shape=folder`,
+		`label="/etc";`,
+		`4 [label="/tmp/junk", tooltip="/tmp/junk", shape=note, style="dashed", color=gray40`,
+		"5 -> 2 [style=dashed];\n",
+		"5 -> 3;\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q; output:\n%s", want, out)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	rep := &report.Report{
+		Resources: []report.Resource{
+			{ID: 1, Status: report.Unchanged, Duration: 1234 * time.Microsecond},
+			{ID: 3, Status: report.Changed, Duration: 2 * time.Millisecond},
+			{ID: 4, Status: report.Unchanged},
+			{ID: 2, Status: report.Failed, Err: errors.New("disk full"), Duration: 1500 * time.Millisecond},
+			{ID: 5, Status: report.Skipped, FailedDep: 2},
+			{ID: 6, Status: report.Skipped, FailedDep: 2},
+		},
+	}
+	g, err := NewGraph(testCatalog(t), &Options{Report: rep})
+	if err != nil {
+		t.Fatal("NewGraph:", err)
+	}
+	for _, n := range g.Nodes {
+		if n.Result == nil || n.Result.ID != n.ID {
+			t.Errorf("node %d Result = %+v; want report for id=%d", n.ID, n.Result, n.ID)
+		}
+	}
+	wantEdges := []Edge{
+		{From: 2, To: 1},
+		{From: 5, To: 2, IfDepsChanged: true, Failure: true},
+		{From: 5, To: 3},
+		{From: 6, To: 5, Failure: true},
+		{From: 6, To: 4},
+	}
+	if !equalEdges(g.Edges, wantEdges) {
+		t.Errorf("g.Edges = %+v; want %+v", g.Edges, wantEdges)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := g.WriteDOT(buf); err != nil {
+		t.Fatal("WriteDOT:", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`1 [label="/etc/foo\n1.2ms", tooltip="unchanged\n/etc/foo"`,
+		`2 [label="/etc/foo/foo.conf\n1.5s", tooltip="failed: disk full\n/etc/foo/foo.conf"`,
+		`5 [label="/usr/sbin/service foo restart", tooltip="skipped: id=2 failed\n/usr/sbin/service foo restart"`,
+		`6 [label="all \"done\"", tooltip="skipped: id=2 failed"`,
+		`fillcolor="#ef9a9a", color=red, penwidth=2];`,
+		"5 -> 2 [style=dashed, color=red, penwidth=2];\n",
+		"6 -> 5 [color=red, penwidth=2];\n",
+		"6 -> 4;\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q; output:\n%s", want, out)
+		}
+	}
+}
+
+func TestPathPrefix(t *testing.T) {
+	tests := []struct {
+		path  string
+		depth int
+		want  string
+	}{
+		{"/foo", 1, "/"},
+		{"/etc/hosts", 1, "/etc"},
+		{"/etc/ssh/sshd_config", 1, "/etc"},
+		{"/etc/ssh/sshd_config", 2, "/etc/ssh"},
+		{"/etc/ssh/sshd_config", 3, "/etc/ssh"},
+		{"/etc/ssh/", 1, "/etc"},
+	}
+	for _, test := range tests {
+		if got := pathPrefix(test.path, test.depth); got != test.want {
+			t.Errorf("pathPrefix(%q, %d) = %q; want %q", test.path, test.depth, got, test.want)
+		}
+	}
+}
+
+func TestDOTQuote(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"", `""`},
+		{"foo", `"foo"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`C:\`, `"C:\\"`},
+		{"a\nb", `"a\nb"`},
+	}
+	for _, test := range tests {
+		if got := dotQuote(test.s); got != test.want {
+			t.Errorf("dotQuote(%q) = %s; want %s", test.s, got, test.want)
+		}
+	}
+}
+
+func equalEdges(a, b []Edge) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dotlib
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+type graphML struct {
+	XMLName xml.Name     `xml:"http://graphml.graphdrawing.org/xmlns graphml"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// graphMLKeys declares the attributes of nodes and edges.  The names
+// match the JSON metadata fields.
+var graphMLKeys = []graphMLKey{
+	{ID: "label", For: "node", Name: "label", Type: "string"},
+	{ID: "kind", For: "node", Name: "kind", Type: "string"},
+	{ID: "tooltip", For: "node", Name: "tooltip", Type: "string"},
+	{ID: "cluster", For: "node", Name: "cluster", Type: "string"},
+	{ID: "status", For: "node", Name: "status", Type: "string"},
+	{ID: "error", For: "node", Name: "error", Type: "string"},
+	{ID: "duration", For: "node", Name: "duration", Type: "string"},
+	{ID: "failedDep", For: "node", Name: "failedDep", Type: "long"},
+	{ID: "ifDepsChanged", For: "edge", Name: "ifDepsChanged", Type: "boolean"},
+	{ID: "failure", For: "edge", Name: "failure", Type: "boolean"},
+}
+
+// WriteGraphML writes g to w in GraphML (http://graphml.graphdrawing.org/).
+// Node IDs are the resource IDs prefixed with "n", and the other node
+// fields are data attributes.
+func (g *Graph) WriteGraphML(w io.Writer) error {
+	doc := graphML{
+		Keys: graphMLKeys,
+		Graph: graphMLGraph{
+			ID:          "catalog",
+			EdgeDefault: "directed",
+			Nodes:       make([]graphMLNode, len(g.Nodes)),
+			Edges:       make([]graphMLEdge, len(g.Edges)),
+		},
+	}
+	for i, n := range g.Nodes {
+		md := newNodeMetadata(n)
+		data := []graphMLData{
+			{Key: "label", Value: n.Label},
+			{Key: "kind", Value: md.Kind},
+		}
+		add := func(key, value string) {
+			if value != "" {
+				data = append(data, graphMLData{Key: key, Value: value})
+			}
+		}
+		add("tooltip", md.Tooltip)
+		add("cluster", md.Cluster)
+		add("status", md.Status)
+		add("error", md.Error)
+		add("duration", md.Duration)
+		if md.FailedDep != 0 {
+			add("failedDep", strconv.FormatUint(md.FailedDep, 10))
+		}
+		doc.Graph.Nodes[i] = graphMLNode{ID: graphMLID(n.ID), Data: data}
+	}
+	for i, e := range g.Edges {
+		var data []graphMLData
+		if e.IfDepsChanged {
+			data = append(data, graphMLData{Key: "ifDepsChanged", Value: "true"})
+		}
+		if e.Failure {
+			data = append(data, graphMLData{Key: "failure", Value: "true"})
+		}
+		doc.Graph.Edges[i] = graphMLEdge{
+			Source: graphMLID(e.From),
+			Target: graphMLID(e.To),
+			Data:   data,
+		}
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func graphMLID(id uint64) string {
+	return fmt.Sprintf("n%d", id)
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dotlib
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+
+	"github.com/zombiezen/mcm/exec/report"
+)
+
+func TestWriteGraphML(t *testing.T) {
+	rep := &report.Report{
+		Resources: []report.Resource{
+			{ID: 2, Status: report.Failed},
+			{ID: 5, Status: report.Skipped, FailedDep: 2},
+		},
+	}
+	g, err := NewGraph(testCatalog(t), &Options{Report: rep})
+	if err != nil {
+		t.Fatal("NewGraph:", err)
+	}
+	buf := new(bytes.Buffer)
+	if err := g.WriteGraphML(buf); err != nil {
+		t.Fatal("WriteGraphML:", err)
+	}
+	var got graphML
+	if err := xml.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not valid XML: %v; output:\n%s", err, buf.Bytes())
+	}
+	if got.Graph.EdgeDefault != "directed" {
+		t.Errorf("edgedefault = %q; want \"directed\"", got.Graph.EdgeDefault)
+	}
+	if len(got.Graph.Nodes) != len(g.Nodes) || len(got.Graph.Edges) != len(g.Edges) {
+		t.Fatalf("got %d nodes and %d edges; want %d nodes and %d edges", len(got.Graph.Nodes), len(got.Graph.Edges), len(g.Nodes), len(g.Edges))
+	}
+	keys := make(map[string]bool)
+	for _, k := range got.Keys {
+		keys[k.ID] = true
+	}
+	for _, n := range got.Graph.Nodes {
+		for _, d := range n.Data {
+			if !keys[d.Key] {
+				t.Errorf("node %s has data with undeclared key %q", n.ID, d.Key)
+			}
+		}
+	}
+	node5 := got.Graph.Nodes[4]
+	want5 := map[string]string{
+		"label":     "/usr/sbin/service foo restart",
+		"kind":      "exec",
+		"tooltip":   "/usr/sbin/service foo restart",
+		"status":    "skipped",
+		"failedDep": "2",
+	}
+	if node5.ID != "n5" {
+		t.Errorf("nodes[4].id = %q; want \"n5\"", node5.ID)
+	}
+	if len(node5.Data) != len(want5) {
+		t.Errorf("nodes[4] data = %+v; want %v", node5.Data, want5)
+	}
+	for _, d := range node5.Data {
+		if want5[d.Key] != d.Value {
+			t.Errorf("nodes[4] data %s = %q; want %q", d.Key, d.Value, want5[d.Key])
+		}
+	}
+	edge := got.Graph.Edges[1]
+	if edge.Source != "n5" || edge.Target != "n2" || len(edge.Data) != 2 {
+		t.Errorf("edges[1] = %+v; want n5 -> n2 with ifDepsChanged and failure", edge)
+	}
+}
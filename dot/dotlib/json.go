@@ -0,0 +1,108 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dotlib
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// jsonGraph is the top level of the JSON Graph Format.
+type jsonGraph struct {
+	Graph struct {
+		Directed bool       `json:"directed"`
+		Nodes    []jsonNode `json:"nodes"`
+		Edges    []jsonEdge `json:"edges"`
+	} `json:"graph"`
+}
+
+type jsonNode struct {
+	ID       string       `json:"id"`
+	Label    string       `json:"label"`
+	Metadata nodeMetadata `json:"metadata"`
+}
+
+type jsonEdge struct {
+	Source   string       `json:"source"`
+	Target   string       `json:"target"`
+	Metadata edgeMetadata `json:"metadata"`
+}
+
+// nodeMetadata is the information about a node that every format
+// besides DOT records as attributes.
+type nodeMetadata struct {
+	Kind      string `json:"kind"`
+	Tooltip   string `json:"tooltip,omitempty"`
+	Cluster   string `json:"cluster,omitempty"`
+	Status    string `json:"status,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Duration  string `json:"duration,omitempty"`
+	FailedDep uint64 `json:"failedDep,omitempty"`
+}
+
+type edgeMetadata struct {
+	IfDepsChanged bool `json:"ifDepsChanged,omitempty"`
+	Failure       bool `json:"failure,omitempty"`
+}
+
+func newNodeMetadata(n *Node) nodeMetadata {
+	md := nodeMetadata{
+		Kind:    n.Kind.String(),
+		Tooltip: n.Tooltip,
+		Cluster: n.Cluster,
+	}
+	if rr := n.Result; rr != nil {
+		md.Status = rr.Status.String()
+		if rr.Err != nil {
+			md.Error = rr.Err.Error()
+		}
+		if rr.Duration != 0 {
+			md.Duration = rr.Duration.String()
+		}
+		md.FailedDep = rr.FailedDep
+	}
+	return md
+}
+
+// WriteJSON writes g to w in the JSON Graph Format
+// (http://jsongraphformat.info/).  Node IDs are the resource IDs in
+// decimal, and the other node fields are in each node's metadata.
+func (g *Graph) WriteJSON(w io.Writer) error {
+	var jg jsonGraph
+	jg.Graph.Directed = true
+	jg.Graph.Nodes = make([]jsonNode, len(g.Nodes))
+	for i, n := range g.Nodes {
+		jg.Graph.Nodes[i] = jsonNode{
+			ID:       strconv.FormatUint(n.ID, 10),
+			Label:    n.Label,
+			Metadata: newNodeMetadata(n),
+		}
+	}
+	jg.Graph.Edges = make([]jsonEdge, len(g.Edges))
+	for i, e := range g.Edges {
+		jg.Graph.Edges[i] = jsonEdge{
+			Source: strconv.FormatUint(e.From, 10),
+			Target: strconv.FormatUint(e.To, 10),
+			Metadata: edgeMetadata{
+				IfDepsChanged: e.IfDepsChanged,
+				Failure:       e.Failure,
+			},
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jg)
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dotlib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zombiezen/mcm/exec/report"
+)
+
+func TestWriteJSON(t *testing.T) {
+	rep := &report.Report{
+		Resources: []report.Resource{
+			{ID: 2, Status: report.Failed, Err: errors.New("disk full"), Duration: time.Second},
+			{ID: 5, Status: report.Skipped, FailedDep: 2},
+		},
+	}
+	g, err := NewGraph(testCatalog(t), &Options{ClusterDepth: 1, Report: rep})
+	if err != nil {
+		t.Fatal("NewGraph:", err)
+	}
+	buf := new(bytes.Buffer)
+	if err := g.WriteJSON(buf); err != nil {
+		t.Fatal("WriteJSON:", err)
+	}
+	var got jsonGraph
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not valid JSON: %v; output:\n%s", err, buf.Bytes())
+	}
+	if !got.Graph.Directed {
+		t.Error("graph.directed = false; want true")
+	}
+	wantNodes := map[string]jsonNode{
+		"1": {ID: "1", Label: "/etc/foo", Metadata: nodeMetadata{Kind: "directory", Tooltip: "/etc/foo", Cluster: "/etc"}},
+		"2": {ID: "2", Label: "/etc/foo/foo.conf", Metadata: nodeMetadata{Kind: "plain", Tooltip: "/etc/foo/foo.conf", Cluster: "/etc", Status: "failed", Error: "disk full", Duration: "1s"}},
+		"5": {ID: "5", Label: "/usr/sbin/service foo restart", Metadata: nodeMetadata{Kind: "exec", Tooltip: "/usr/sbin/service foo restart", Status: "skipped", FailedDep: 2}},
+		"6": {ID: "6", Label: "all \"done\"", Metadata: nodeMetadata{Kind: "noop"}},
+	}
+	if len(got.Graph.Nodes) != len(g.Nodes) {
+		t.Errorf("len(graph.nodes) = %d; want %d", len(got.Graph.Nodes), len(g.Nodes))
+	}
+	for _, n := range got.Graph.Nodes {
+		if want, ok := wantNodes[n.ID]; ok && n != want {
+			t.Errorf("node = %+v; want %+v", n, want)
+		}
+	}
+	wantEdge := jsonEdge{Source: "5", Target: "2", Metadata: edgeMetadata{IfDepsChanged: true, Failure: true}}
+	if len(got.Graph.Edges) != len(g.Edges) {
+		t.Errorf("len(graph.edges) = %d; want %d", len(got.Graph.Edges), len(g.Edges))
+	} else if got.Graph.Edges[1] != wantEdge {
+		t.Errorf("graph.edges[1] = %+v; want %+v", got.Graph.Edges[1], wantEdge)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dotlib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/zombiezen/mcm/exec/report"
+)
+
+// Mermaid node shapes by kind, as opening and closing brackets.
+var mermaidShapes = [...][2]string{
+	Noop:       {"([", "])"},
+	PlainFile:  {"[", "]"},
+	Directory:  {"[[", "]]"},
+	Symlink:    {"[/", "/]"},
+	Hardlink:   {"[/", "/]"},
+	AbsentFile: {"[", "]"},
+	Exec:       {"(", ")"},
+}
+
+// WriteMermaid writes g to w as a Mermaid flowchart
+// (https://mermaid.js.org/syntax/flowchart.html).  Mermaid has no
+// tooltips without scripts, so only labels, shapes, colors, and
+// clusters are drawn.
+func (g *Graph) WriteMermaid(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("flowchart TD\n")
+	clusters, byCluster := g.clusters()
+	for _, n := range byCluster[""] {
+		writeMermaidNode(bw, "  ", n)
+	}
+	for i, c := range clusters {
+		fmt.Fprintf(bw, "  subgraph cluster_%d [%s]\n", i, mermaidQuote(c))
+		for _, n := range byCluster[c] {
+			writeMermaidNode(bw, "    ", n)
+		}
+		bw.WriteString("  end\n")
+	}
+	var failures []string
+	for i, e := range g.Edges {
+		arrow := "-->"
+		if e.IfDepsChanged {
+			arrow = "-.->"
+		}
+		fmt.Fprintf(bw, "  n%d %s n%d\n", e.From, arrow, e.To)
+		if e.Failure {
+			failures = append(failures, fmt.Sprint(i))
+		}
+	}
+	if len(failures) > 0 {
+		fmt.Fprintf(bw, "  linkStyle %s stroke:red,stroke-width:2px\n", strings.Join(failures, ","))
+	}
+
+	// Style nodes with classes so that the definitions appear once.
+	classes := make(map[string][]string)
+	var classOrder []string
+	for _, n := range g.Nodes {
+		c := mermaidClass(n)
+		if c == "" {
+			continue
+		}
+		if _, ok := classes[c]; !ok {
+			classOrder = append(classOrder, c)
+		}
+		classes[c] = append(classes[c], fmt.Sprintf("n%d", n.ID))
+	}
+	for _, c := range classOrder {
+		fmt.Fprintf(bw, "  classDef %s %s\n", c, mermaidClassDefs[c])
+		fmt.Fprintf(bw, "  class %s %s\n", strings.Join(classes[c], ","), c)
+	}
+	return bw.Flush()
+}
+
+func writeMermaidNode(w *bufio.Writer, indent string, n *Node) {
+	shape := [2]string{"[", "]"}
+	if n.Kind >= 0 && int(n.Kind) < len(mermaidShapes) {
+		shape = mermaidShapes[n.Kind]
+	}
+	fmt.Fprintf(w, "%sn%d%s%s%s\n", indent, n.ID, shape[0], mermaidQuote(displayLabel(n)), shape[1])
+}
+
+// Mermaid class styles, which use the same colors as DOT.
+var mermaidClassDefs = map[string]string{
+	"plain":     "fill:#fff8c4",
+	"directory": "fill:#f5deb3",
+	"symlink":   "fill:#d8f0f8",
+	"hardlink":  "fill:#c8e0f0",
+	"absent":    "stroke:#666,stroke-dasharray:4,color:#666",
+	"exec":      "fill:#d4e8c8",
+	"unchanged": "fill:#e8f5e9",
+	"changed":   "fill:#ffd27f",
+	"failed":    "fill:#ef9a9a,stroke:red,stroke-width:2px",
+	"skipped":   "fill:#e0e0e0,stroke:#666,color:#666",
+}
+
+// mermaidClass returns the name of the class to style n with or empty
+// if n uses the default style.
+func mermaidClass(n *Node) string {
+	if n.Result != nil {
+		switch n.Result.Status {
+		case report.Unchanged, report.Changed, report.Failed, report.Skipped:
+			return n.Result.Status.String()
+		}
+	}
+	if n.Kind == Noop {
+		return ""
+	}
+	if _, ok := mermaidClassDefs[n.Kind.String()]; !ok {
+		return ""
+	}
+	return n.Kind.String()
+}
+
+// mermaidQuote returns s as a Mermaid quoted label.  Quotes are
+// written as entity codes and newlines as line breaks.
+func mermaidQuote(s string) string {
+	buf := new(bytes.Buffer)
+	buf.WriteByte('"')
+	for _, c := range s {
+		switch c {
+		case '"':
+			buf.WriteString("#quot;")
+		case '#':
+			buf.WriteString("#35;")
+		case '<':
+			buf.WriteString("#lt;")
+		case '>':
+			buf.WriteString("#gt;")
+		case '\n':
+			buf.WriteString("<br>")
+		case '\r', '\t':
+			buf.WriteByte(' ')
+		default:
+			buf.WriteRune(c)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
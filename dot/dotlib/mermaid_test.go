@@ -0,0 +1,75 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dotlib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/zombiezen/mcm/exec/report"
+)
+
+func TestWriteMermaid(t *testing.T) {
+	rep := &report.Report{
+		Resources: []report.Resource{
+			{ID: 2, Status: report.Failed},
+			{ID: 5, Status: report.Skipped, FailedDep: 2},
+			{ID: 6, Status: report.Skipped, FailedDep: 2},
+		},
+	}
+	g, err := NewGraph(testCatalog(t), &Options{ClusterDepth: 1, Report: rep})
+	if err != nil {
+		t.Fatal("NewGraph:", err)
+	}
+	buf := new(bytes.Buffer)
+	if err := g.WriteMermaid(buf); err != nil {
+		t.Fatal("WriteMermaid:", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"flowchart TD\n",
+		"  n5(\"/usr/sbin/service foo restart\")\n",
+		"  n6([\"all #quot;done#quot;\"])\n",
+		"  subgraph cluster_0 [\"/etc\"]\n    n1[[\"/etc/foo\"]]\n    n2[\"/etc/foo/foo.conf<br>0s\"]\n  end\n",
+		"  n3[/\"/usr/local/etc/foo.conf\"/]\n",
+		"  n5 -.-> n2\n",
+		"  n5 --> n3\n",
+		"  linkStyle 1,3 stroke:red,stroke-width:2px\n",
+		"  class n1 directory\n",
+		"  class n5,n6 skipped\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q; output:\n%s", want, out)
+		}
+	}
+}
+
+func TestMermaidQuote(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"", `""`},
+		{"foo", `"foo"`},
+		{`say "hi"`, `"say #quot;hi#quot;"`},
+		{"a\nb", `"a<br>b"`},
+		{"#1 <x>", `"#35;1 #lt;x#gt;"`},
+	}
+	for _, test := range tests {
+		if got := mermaidQuote(test.s); got != test.want {
+			t.Errorf("mermaidQuote(%q) = %s; want %s", test.s, got, test.want)
+		}
+	}
+}
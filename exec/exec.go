@@ -17,10 +17,13 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -29,9 +32,14 @@ import (
 
 	"github.com/zombiezen/mcm/catalog"
 	"github.com/zombiezen/mcm/exec/execlib"
+	"github.com/zombiezen/mcm/exec/report"
+	"github.com/zombiezen/mcm/internal/blobstore"
+	"github.com/zombiezen/mcm/internal/catalogio"
+	"github.com/zombiezen/mcm/internal/catsig"
+	"github.com/zombiezen/mcm/internal/digestcache"
 	"github.com/zombiezen/mcm/internal/system"
 	"github.com/zombiezen/mcm/internal/version"
-	"github.com/zombiezen/mcm/third_party/golang/capnproto"
+	"github.com/zombiezen/mcm/lint/lintlib"
 )
 
 func init() {
@@ -53,12 +61,36 @@ func main() {
 	logCommands := flag.Bool("s", false, "show commands run in the log")
 	flag.IntVar(&opts.ConcurrentJobs, "j", 1, "set the maximum number of resources to apply simultaneously")
 	flag.StringVar(&opts.Bash, "bash", execlib.DefaultBashPath, "path to bash shell")
+	allowPathConflicts := flag.Bool("allow-path-conflicts", false, "apply the catalog even if unordered resources manage the same path")
+	blobsPath := flag.String("blobs", "", "directory or tar file to fetch file content by digest from")
+	digestCachePath := flag.String("digest-cache", "", "file to remember verified file digests in between runs")
+	keyringPath := flag.String("keyring", "", "require the catalog to be signed by a key in this keyring file")
+	sigPath := flag.String("signature", "", "detached signature file for the catalog (requires -keyring)")
+	scheduleName := flag.String("schedule", "fifo", "order to apply ready resources in: fifo, longest-path, most-dependents, or durations")
+	previousReportPath := flag.String("previous-report", "", "report file from an earlier run to take durations from for -schedule=durations")
+	reportPath := flag.String("report", "", "write the outcome of each resource to this file as JSON")
+	keepGoing := flag.Bool("keep-going", false, "apply the remaining catalogs in the input after one fails")
+	readOpts := catalogio.Flags(flag.CommandLine)
 	versionMode := flag.Bool("version", false, "display version info")
 	flag.Parse()
 	if *versionMode {
 		version.Show()
 		return
 	}
+	if *sigPath != "" && *keyringPath == "" {
+		fmt.Fprintln(os.Stderr, "mcm-exec: -signature requires -keyring")
+		os.Exit(2)
+	}
+	schedule, ok := schedules[*scheduleName]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "mcm-exec: unknown -schedule=%q\n", *scheduleName)
+		os.Exit(2)
+	}
+	opts.Schedule = schedule
+	if (schedule == execlib.Durations) != (*previousReportPath != "") {
+		fmt.Fprintln(os.Stderr, "mcm-exec: -schedule=durations and -previous-report must be used together")
+		os.Exit(2)
+	}
 	var sys system.System = system.Local{}
 	if *simulate {
 		sys = simulatedSystem{}
@@ -71,35 +103,229 @@ func main() {
 	}
 
 	ctx := context.Background()
-	var cat catalog.Catalog
-	switch flag.NArg() {
-	case 0:
-		var err error
-		cat, err = readCatalog(os.Stdin)
+	if *blobsPath != "" {
+		blobs, err := blobstore.Open(*blobsPath)
 		if err != nil {
 			log.Fatal(ctx, err)
 		}
-	case 1:
-		// TODO(someday): read segments lazily
-		f, err := os.Open(flag.Arg(0))
+		defer blobs.Close()
+		opts.Blobs = blobs
+	}
+	if *digestCachePath != "" {
+		cache, err := digestcache.Load(*digestCachePath)
 		if err != nil {
 			log.Fatal(ctx, err)
 		}
-		cat, err = readCatalog(f)
+		opts.DigestCache = cache
+	}
+	// Read the previous report before creating the new one, in case
+	// they're the same file.
+	var previous map[[sha256.Size]byte]map[uint64]time.Duration
+	if *previousReportPath != "" {
+		var err error
+		previous, err = readDurations(*previousReportPath)
 		if err != nil {
 			log.Fatal(ctx, err)
 		}
-		if err = f.Close(); err != nil {
-			log.Error(ctx, err)
+	}
+	var reports *json.Encoder
+	var reportFile *os.File
+	if *reportPath != "" {
+		f, err := os.Create(*reportPath)
+		if err != nil {
+			log.Fatal(ctx, err)
 		}
+		reportFile = f
+		reports = json.NewEncoder(f)
+	}
+	var path string
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		path = flag.Arg(0)
 	default:
 		usage()
 		os.Exit(2)
 	}
-
-	if err := execlib.Apply(ctx, sys, cat, opts); err != nil {
+	var stream *catalogio.Stream
+	var err error
+	if *keyringPath != "" {
+		stream, err = openSignedStream(path, *sigPath, *keyringPath, readOpts)
+		if catsig.IsVerifyError(err) {
+			log.Error(ctx, err)
+			os.Exit(exitBadSignature)
+		}
+	} else {
+		stream, err = catalogio.OpenStream(path, readOpts)
+	}
+	if err != nil {
 		log.Fatal(ctx, err)
 	}
+	defer stream.Close()
+
+	failed := false
+	for n := 0; ; n++ {
+		cat, err := stream.Next()
+		if err == io.EOF {
+			if n == 0 {
+				log.Fatal(ctx, errors.New("no catalog in input"))
+			}
+			break
+		}
+		if err != nil {
+			log.Error(ctx, err)
+			failed = true
+			break
+		}
+		if reports != nil {
+			opts.Report = new(report.Report)
+		}
+		applyErr := applyCatalog(ctx, log, sys, cat, opts, previous, *allowPathConflicts)
+		// Apply fills in the hash, so a zero hash means that the
+		// catalog was rejected before any resources were applied.
+		if reports != nil && opts.Report.CatalogHash != ([sha256.Size]byte{}) {
+			if err := reports.Encode(opts.Report); err != nil {
+				log.Error(ctx, fmt.Errorf("write report: %v", err))
+				failed = true
+			}
+		}
+		if applyErr != nil {
+			log.Error(ctx, applyErr)
+			failed = true
+			if !*keepGoing {
+				break
+			}
+		}
+	}
+	if reportFile != nil {
+		if err := reportFile.Close(); err != nil {
+			log.Error(ctx, fmt.Errorf("write report: %v", err))
+			failed = true
+		}
+	}
+	if opts.DigestCache != nil && !*simulate {
+		// Save even if some resources failed, so that the files
+		// that were verified don't need to be read next time.
+		if err := opts.DigestCache.Save(*digestCachePath); err != nil {
+			log.Error(ctx, err)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// applyCatalog checks and applies a single catalog from the input.
+// If opts uses the Durations schedule, then the durations are taken
+// from the previous report for the catalog.
+func applyCatalog(ctx context.Context, log *logger, sys system.System, cat catalog.Catalog, opts *execlib.Options, previous map[[sha256.Size]byte]map[uint64]time.Duration, allowPathConflicts bool) error {
+	h, err := catalog.Hash(cat)
+	if err != nil {
+		return err
+	}
+	log.Infof(ctx, "catalog sha256:%x", h)
+	newOpts := new(execlib.Options)
+	*newOpts = *opts
+	newOpts.CatalogHash = h
+	if opts.Schedule == execlib.Durations {
+		newOpts.Durations = previous[h]
+		if newOpts.Durations == nil {
+			log.Infof(ctx, "no previous report for catalog; scheduling by longest path")
+			newOpts.Schedule = execlib.LongestPath
+		}
+	}
+	opts = newOpts
+	// Hashing, checking, and applying each read the whole catalog, so
+	// give each its own read budget.
+	catalogio.ResetReadLimit(cat)
+	if problems := checkCatalog(ctx, log, cat, allowPathConflicts); len(problems) > 0 {
+		for _, p := range problems {
+			log.Error(ctx, errors.New(p.String()))
+		}
+		return fmt.Errorf("catalog has %d problem(s); not applying", len(problems))
+	}
+	catalogio.ResetReadLimit(cat)
+	return execlib.Apply(ctx, sys, cat, opts)
+}
+
+// schedules maps -schedule values to execlib schedules.
+var schedules = map[string]execlib.Schedule{
+	execlib.FIFO.String():           execlib.FIFO,
+	execlib.LongestPath.String():    execlib.LongestPath,
+	execlib.MostDependents.String(): execlib.MostDependents,
+	execlib.Durations.String():      execlib.Durations,
+}
+
+// readDurations reads a report file written by -report and returns how
+// long each resource took, keyed by catalog hash.
+func readDurations(path string) (map[[sha256.Size]byte]map[uint64]time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m := make(map[[sha256.Size]byte]map[uint64]time.Duration)
+	dec := json.NewDecoder(f)
+	for {
+		rep := new(report.Report)
+		if err := dec.Decode(rep); err == io.EOF {
+			return m, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("read %s: %v", path, err)
+		}
+		durations := make(map[uint64]time.Duration, len(rep.Resources))
+		for _, rr := range rep.Resources {
+			durations[rr.ID] = rr.Duration
+		}
+		m[rep.CatalogHash] = durations
+	}
+}
+
+// exitBadSignature is the exit status when the catalog does not have a
+// valid signature from the keyring.
+const exitBadSignature = 3
+
+// openSignedStream reads the catalogs at path (or stdin, if empty) and
+// verifies their signature against the keyring before parsing them.
+// If sigPath is empty, then the input must have an embedded signature.
+func openSignedStream(path, sigPath, keyringPath string, opts *catalogio.Options) (*catalogio.Stream, error) {
+	kr, err := catsig.ReadKeyring(keyringPath)
+	if err != nil {
+		return nil, err
+	}
+	data, err := catalogio.ReadBytes(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	if sigPath == "" {
+		data, err = kr.Open(data)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		sig, err := ioutil.ReadFile(sigPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := kr.VerifyDetached(data, sig); err != nil {
+			return nil, err
+		}
+	}
+	return catalogio.NewStream(bytes.NewReader(data), opts), nil
+}
+
+// checkCatalog returns the errors that lintlib finds in cat.  If
+// allowPathConflicts is true, then path conflicts are logged instead.
+func checkCatalog(ctx context.Context, log *logger, cat catalog.Catalog, allowPathConflicts bool) []*lintlib.Problem {
+	var problems []*lintlib.Problem
+	for _, p := range lintlib.Check(cat, nil) {
+		if allowPathConflicts && p.Check == lintlib.CheckPathConflict {
+			log.Infof(ctx, "ignoring %v", p)
+			continue
+		}
+		problems = append(problems, p)
+	}
+	return problems
 }
 
 type sysLogger struct {
@@ -122,6 +348,11 @@ func (l sysLogger) Symlink(ctx context.Context, oldname, newname string) error {
 	return l.System.Symlink(ctx, oldname, newname)
 }
 
+func (l sysLogger) Link(ctx context.Context, oldname, newname string) error {
+	l.log.Infof(ctx, "ln %s %s", oldname, newname)
+	return l.System.Link(ctx, oldname, newname)
+}
+
 func (l sysLogger) Chmod(ctx context.Context, path string, mode os.FileMode) error {
 	m := uint32(mode & os.ModePerm)
 	if mode&os.ModeSticky != 0 {
@@ -174,6 +405,10 @@ func (simulatedSystem) Symlink(ctx context.Context, oldname, newname string) err
 	return nil
 }
 
+func (simulatedSystem) Link(ctx context.Context, oldname, newname string) error {
+	return nil
+}
+
 func (simulatedSystem) CreateFile(ctx context.Context, path string, mode os.FileMode) (system.FileWriter, error) {
 	if _, err := os.Lstat(path); err == nil {
 		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrExist}
@@ -204,6 +439,14 @@ func (simulatedSystem) OwnerInfo(mode os.FileInfo) (system.UID, system.GID, erro
 	return (system.Local{}).OwnerInfo(mode)
 }
 
+func (simulatedSystem) SameFile(fi1, fi2 os.FileInfo) bool {
+	return (system.Local{}).SameFile(fi1, fi2)
+}
+
+func (simulatedSystem) Inode(info os.FileInfo) (uint64, error) {
+	return (system.Local{}).Inode(info)
+}
+
 func (simulatedSystem) LookupUser(name string) (system.UID, error) {
 	return (system.Local{}).LookupUser(name)
 }
@@ -324,15 +567,3 @@ func (l *logger) Fatal(ctx context.Context, err error) {
 	l.Error(ctx, err)
 	os.Exit(1)
 }
-
-func readCatalog(r io.Reader) (catalog.Catalog, error) {
-	msg, err := capnp.NewDecoder(r).Decode()
-	if err != nil {
-		return catalog.Catalog{}, fmt.Errorf("read catalog: %v", err)
-	}
-	c, err := catalog.ReadRootCatalog(msg)
-	if err != nil {
-		return catalog.Catalog{}, fmt.Errorf("read catalog: %v", err)
-	}
-	return c, nil
-}
@@ -17,14 +17,20 @@ package execlib
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/internal/blobstore"
+	"github.com/zombiezen/mcm/internal/digestcache"
 	"github.com/zombiezen/mcm/internal/system"
 )
 
@@ -35,12 +41,15 @@ type job struct {
 	depsChanged map[uint64]bool
 
 	bashPath string
+	blobs    blobstore.Store
+	digests  *digestcache.Cache
 }
 
 type jobResult struct {
-	id      uint64
-	changed bool
-	err     error
+	id       uint64
+	changed  bool
+	err      error
+	duration time.Duration
 }
 
 func (j *job) run(ctx context.Context) jobResult {
@@ -94,6 +103,9 @@ func (j *job) file(ctx context.Context, f catalog.File) (changed bool, err error
 	if path == "" {
 		return false, errors.New("file path is empty")
 	}
+	if !filepath.IsAbs(path) {
+		return false, errorf("%s is not an absolute path", path)
+	}
 	switch f.Which() {
 	case catalog.File_Which_plain:
 		return j.plainFile(ctx, path, f.Plain())
@@ -101,6 +113,8 @@ func (j *job) file(ctx context.Context, f catalog.File) (changed bool, err error
 		return j.directory(ctx, path, f.Directory())
 	case catalog.File_Which_symlink:
 		return j.symlink(ctx, path, f.Symlink())
+	case catalog.File_Which_hardlink:
+		return j.hardlink(ctx, path, f.Hardlink())
 	case catalog.File_Which_absent:
 		err := j.sys.Remove(ctx, path)
 		if err != nil {
@@ -116,24 +130,50 @@ func (j *job) file(ctx context.Context, f catalog.File) (changed bool, err error
 }
 
 func (j *job) plainFile(ctx context.Context, path string, f catalog.File_plain) (changed bool, err error) {
-	if !f.HasContent() {
+	if !f.HasContent() && !f.HasContentSHA256() {
 		info, err := j.sys.Lstat(ctx, path)
 		if err != nil {
 			return false, err
 		}
 		if !info.Mode().IsRegular() {
-			// TODO(soon): what kind of node it?
-			return false, errorf("%s is not a regular file")
+			return false, errorf("%s is %s, not a regular file", path, describeFileType(info.Mode()))
 		}
 		mode, _ := f.Mode()
 		return j.fileModeWithInfo(ctx, path, info, mode)
 	}
 
-	content, err := f.Content()
+	var want fileContent
+	if f.HasContentSHA256() {
+		raw, err := f.ContentSHA256()
+		if err != nil {
+			return false, errorf("read content digest from catalog: %v", err)
+		}
+		d, err := blobstore.DigestFromBytes(raw)
+		if err != nil {
+			return false, errorf("content digest: %v", err)
+		}
+		want.digest = &d
+	}
+	if f.HasContent() {
+		want.data, err = f.Content()
+		if err != nil {
+			return false, errorf("read content from catalog: %v", err)
+		}
+		if want.digest != nil && blobstore.Sum(want.data) != *want.digest {
+			return false, errorf("content does not match digest %v", *want.digest)
+		}
+		if want.digest == nil && j.digests != nil {
+			d := blobstore.Sum(want.data)
+			want.digest = &d
+		}
+	}
+	// Like a shell redirect, replace the content of the file that an
+	// existing symlink points to instead of the symlink itself.
+	path, err = j.followSymlinks(ctx, path)
 	if err != nil {
-		return false, errorf("read content from catalog: %v", err)
+		return false, err
 	}
-	contentChanged, err := j.plainFileContent(ctx, path, content)
+	contentChanged, err := j.plainFileContent(ctx, path, want)
 	if err != nil {
 		return false, err
 	}
@@ -142,29 +182,134 @@ func (j *job) plainFile(ctx context.Context, path string, f catalog.File_plain)
 	if err != nil {
 		return false, err
 	}
+	if j.digests != nil {
+		// Record after changing the mode, since that may also
+		// change the file's key.
+		if info, err := j.sys.Lstat(ctx, path); err == nil {
+			if k, ok := j.digestKey(path, info); ok {
+				j.digests.Put(k, *want.digest)
+			}
+		}
+	}
 	return contentChanged || modeChanged, nil
 }
 
-func (j *job) plainFileContent(ctx context.Context, path string, content []byte) (changed bool, err error) {
-	w, err := j.sys.CreateFile(ctx, path, 0666) // rely on umask to restrict
-	if os.IsExist(err) {
-		f, err := j.sys.OpenFile(ctx, path)
+// maxSymlinks is the number of symlinks that followSymlinks will
+// follow before giving up.  It matches Linux's limit.
+const maxSymlinks = 40
+
+// followSymlinks returns the path that the symlink at path ultimately
+// points to, or path itself if it is not a symlink.  The returned path
+// may not exist.
+func (j *job) followSymlinks(ctx context.Context, path string) (string, error) {
+	for i := 0; i < maxSymlinks; i++ {
+		info, err := j.sys.Lstat(ctx, path)
+		if isNotExist(err) {
+			return path, nil
+		}
 		if err != nil {
-			return false, err
+			return "", errorf("determine state of %s: %v", path, err)
 		}
-		matches, err := hasContent(f, content)
+		if info.Mode()&os.ModeType != os.ModeSymlink {
+			return path, nil
+		}
+		target, err := j.sys.Readlink(ctx, path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			// Don't clean the joined path: a ".." after a symlinked
+			// directory is relative to the directory's target.
+			target = path[:strings.LastIndex(path, string(filepath.Separator))+1] + target
+		}
+		path = target
+	}
+	return "", errorf("%s: too many levels of symbolic links", path)
+}
+
+// digestKey returns the digest cache key for the file at path, if it
+// is a regular file.
+func (j *job) digestKey(path string, info os.FileInfo) (digestcache.Key, bool) {
+	if !info.Mode().IsRegular() {
+		return digestcache.Key{}, false
+	}
+	ino, err := j.sys.Inode(info)
+	if err != nil {
+		return digestcache.Key{}, false
+	}
+	return digestcache.Key{
+		Path:    path,
+		Inode:   ino,
+		ModTime: info.ModTime(),
+		Size:    info.Size(),
+	}, true
+}
+
+// fileContent is the content that a plain file should have.
+type fileContent struct {
+	data   []byte            // nil if the content must be fetched by digest
+	digest *blobstore.Digest // nil if not given and there is no digest cache
+}
+
+func (j *job) plainFileContent(ctx context.Context, path string, want fileContent) (changed bool, err error) {
+	if j.digests != nil && want.digest != nil {
+		if info, err := j.sys.Lstat(ctx, path); err == nil {
+			if k, ok := j.digestKey(path, info); ok {
+				if d, ok := j.digests.Get(k); ok && d == *want.digest {
+					return false, nil
+				}
+			}
+		}
+	}
+	if want.data == nil {
+		// Only fetch the blob if the file doesn't already match, so
+		// that unchanged hosts don't need the blob store.
+		matches, err := j.hasDigest(ctx, path, *want.digest)
 		if err != nil {
-			f.Close()
 			return false, err
 		}
 		if matches {
-			f.Close()
 			return false, nil
 		}
-		if _, err = f.Seek(0, io.SeekStart); err != nil {
-			f.Close()
+		if j.blobs == nil {
+			return false, errorf("content of %s is stored by digest, but no blob store given", path)
+		}
+		want.data, err = blobstore.Fetch(ctx, j.blobs, *want.digest)
+		if err != nil {
 			return false, err
 		}
+	}
+	content := want.data
+	w, err := j.sys.CreateFile(ctx, path, 0666) // rely on umask to restrict
+	if os.IsExist(err) {
+		// Opening a special file like a FIFO may block, so check first.
+		info, err := j.sys.Lstat(ctx, path)
+		if err != nil {
+			return false, errorf("determine state of %s: %v", path, err)
+		}
+		if m := info.Mode(); !m.IsRegular() {
+			return false, errorf("%s is %s, not a regular file", path, describeFileType(m))
+		}
+		f, err := j.sys.OpenFile(ctx, path)
+		if err != nil {
+			return false, err
+		}
+		// A file of a different size can't match, so skip reading it.
+		if info.Size() == int64(len(content)) {
+			matches, err := hasContent(f, content)
+			if err != nil {
+				f.Close()
+				return false, err
+			}
+			if matches {
+				f.Close()
+				return false, nil
+			}
+			if _, err = f.Seek(0, io.SeekStart); err != nil {
+				f.Close()
+				return false, err
+			}
+		}
 		if err = f.Truncate(0); err != nil {
 			f.Close()
 			return false, err
@@ -184,6 +329,33 @@ func (j *job) plainFileContent(ctx context.Context, path string, content []byte)
 	return true, nil
 }
 
+// hasDigest reports whether the regular file at path exists and has
+// content with the digest d.
+func (j *job) hasDigest(ctx context.Context, path string, d blobstore.Digest) (bool, error) {
+	info, err := j.sys.Lstat(ctx, path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errorf("determine state of %s: %v", path, err)
+	}
+	if m := info.Mode(); !m.IsRegular() {
+		return false, errorf("%s is %s, not a regular file", path, describeFileType(m))
+	}
+	f, err := j.sys.OpenFile(ctx, path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, errorf("read %s: %v", path, err)
+	}
+	var got blobstore.Digest
+	h.Sum(got[:0])
+	return got == d, nil
+}
+
 func hasContent(r io.Reader, content []byte) (bool, error) {
 	r = &errReader{r: r}
 	buf := make([]byte, 4096)
@@ -239,8 +411,7 @@ func (j *job) directory(ctx context.Context, path string, d catalog.File_directo
 		return false, errorf("determine state of %s: %v", path, err)
 	}
 	if !info.IsDir() {
-		// TODO(soon): what kind of node it?
-		return false, errorf("%s is not a directory", path)
+		return false, errorf("%s is %s, not a directory", path, describeFileType(info.Mode()))
 	}
 	mode, _ := d.Mode()
 	return j.fileModeWithInfo(ctx, path, info, mode)
@@ -264,8 +435,7 @@ func (j *job) symlink(ctx context.Context, path string, l catalog.File_symlink)
 		return false, errorf("determine state of %s: %v", path, err)
 	}
 	if info.Mode()&os.ModeType != os.ModeSymlink {
-		// TODO(soon): what kind of node is it?
-		return false, errorf("%s is not a symlink", path)
+		return false, errorf("%s is %s, not a symlink", path, describeFileType(info.Mode()))
 	}
 	actual, err := j.sys.Readlink(ctx, path)
 	if err != nil {
@@ -284,6 +454,79 @@ func (j *job) symlink(ctx context.Context, path string, l catalog.File_symlink)
 	return true, nil
 }
 
+func (j *job) hardlink(ctx context.Context, path string, l catalog.File_hardlink) (changed bool, err error) {
+	target, err := l.Target()
+	if err != nil {
+		return false, errorf("read target from catalog: %v", err)
+	}
+	if target == "" {
+		return false, errors.New("hard link target is empty")
+	}
+	if !filepath.IsAbs(target) {
+		return false, errorf("hard link target %q is not an absolute path", target)
+	}
+	targetInfo, err := j.sys.Lstat(ctx, target)
+	if err != nil {
+		return false, errorf("determine state of %s: %v", target, err)
+	}
+	if targetInfo.IsDir() {
+		return false, errorf("hard link target %s is a directory", target)
+	}
+	if targetInfo.Mode()&os.ModeType == os.ModeSymlink {
+		// Whether link(2) follows symlinks varies by platform.
+		return false, errorf("hard link target %s is a symlink", target)
+	}
+	err = j.sys.Link(ctx, target, path)
+	if err == nil {
+		return true, nil
+	}
+	if !os.IsExist(err) {
+		return false, err
+	}
+	// Ensure that what exists is a regular file before trying to relink.
+	info, err := j.sys.Lstat(ctx, path)
+	if err != nil {
+		return false, errorf("determine state of %s: %v", path, err)
+	}
+	if j.sys.SameFile(info, targetInfo) {
+		// Already the correct link.
+		return false, nil
+	}
+	if !info.Mode().IsRegular() {
+		return false, errorf("%s is %s, not a regular file", path, describeFileType(info.Mode()))
+	}
+	if err := j.sys.Remove(ctx, path); err != nil {
+		return false, errorf("relinking %s: %v", path, err)
+	}
+	if err := j.sys.Link(ctx, target, path); err != nil {
+		return false, errorf("relinking %s: %v", path, err)
+	}
+	return true, nil
+}
+
+// describeFileType returns a noun phrase for the type of file given by
+// mode, for use in error messages.
+func describeFileType(mode os.FileMode) string {
+	switch mode & os.ModeType {
+	case 0:
+		return "a regular file"
+	case os.ModeDir:
+		return "a directory"
+	case os.ModeSymlink:
+		return "a symlink"
+	case os.ModeNamedPipe:
+		return "a FIFO"
+	case os.ModeSocket:
+		return "a socket"
+	case os.ModeDevice:
+		return "a block device"
+	case os.ModeDevice | os.ModeCharDevice:
+		return "a character device"
+	default:
+		return "an unknown type of file"
+	}
+}
+
 func (j *job) fileMode(ctx context.Context, path string, mode catalog.File_Mode) (changed bool, err error) {
 	// TODO(someday): avoid the extra capnp read, since WithInfo also accesses these fields.
 	bits := mode.Bits()
@@ -452,9 +695,12 @@ func (j *job) evalExecCondition(ctx context.Context, cond catalog.Exec_condition
 		return !success, nil
 	case catalog.Exec_condition_Which_fileAbsent:
 		path, _ := cond.FileAbsent()
+		if !filepath.IsAbs(path) {
+			return false, errorf("file absent path %q is not an absolute path", path)
+		}
 		_, err := j.sys.Lstat(ctx, path)
 		if err != nil {
-			if os.IsNotExist(err) {
+			if isNotExist(err) {
 				return true, nil
 			}
 			return false, err
@@ -577,3 +823,17 @@ func buildCommand(cmd catalog.Exec_Command, bashPath string) (*system.Cmd, error
 
 	return c, nil
 }
+
+// isNotExist reports whether err indicates that a path does not exist.
+// Unlike os.IsNotExist, it also reports true if one of the path's
+// parents is not a directory or is part of a symlink loop, since such a
+// path can't exist either.
+func isNotExist(err error) bool {
+	if os.IsNotExist(err) {
+		return true
+	}
+	if e, ok := err.(*os.PathError); ok {
+		return e.Err == syscall.ENOTDIR || e.Err == syscall.ELOOP
+	}
+	return false
+}
@@ -15,26 +15,49 @@
 package execlib
 
 import (
+	"container/heap"
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/exec/report"
+	"github.com/zombiezen/mcm/internal/blobstore"
+	"github.com/zombiezen/mcm/internal/catalogio"
 	"github.com/zombiezen/mcm/internal/depgraph"
+	"github.com/zombiezen/mcm/internal/digestcache"
 	"github.com/zombiezen/mcm/internal/system"
 )
 
 // Apply changes a system match the resources in a catalog.
 // Passing nil options is the same as passing the zero value.
 func Apply(ctx context.Context, sys system.System, c catalog.Catalog, opts *Options) error {
+	if opts != nil && opts.Report != nil {
+		h := opts.CatalogHash
+		if h == ([sha256.Size]byte{}) {
+			var err error
+			h, err = catalog.Hash(c)
+			if err != nil {
+				return errorf("hash catalog: %v", err)
+			}
+			catalogio.ResetReadLimit(c)
+		}
+		opts.Report.CatalogHash = h
+	}
 	res, _ := c.Resources()
 	g, err := depgraph.New(res)
 	if err != nil {
 		return toError(err)
 	}
-	if err = apply(ctx, cacheUserLookups(sys), g, opts.normalize()); err != nil {
+	locks, err := resourceLocks(res)
+	if err != nil {
+		return toError(err)
+	}
+	if err = apply(ctx, cacheUserLookups(sys), g, locks, opts.normalize()); err != nil {
 		return toError(err)
 	}
 	return nil
@@ -51,8 +74,36 @@ type Options struct {
 	Bash string
 
 	// ConcurrentJobs is the number of resources to apply simultaneously.
-	// If non-positive, then it assumes 1.
+	// If non-positive, then it assumes 1.  Resources that share a lock
+	// name are never applied simultaneously.
 	ConcurrentJobs int
+
+	// Report will receive the catalog's hash and the outcome of each
+	// resource if non-nil.
+	Report *report.Report
+
+	// CatalogHash is the catalog's hash, as returned by catalog.Hash,
+	// for callers that have already computed it.  If it is zero and
+	// Report is non-nil, then Apply hashes the catalog itself.
+	CatalogHash [sha256.Size]byte
+
+	// Blobs is the store that file content given only by digest is
+	// fetched from.  If nil, then applying such a file fails.
+	Blobs blobstore.Store
+
+	// Schedule is the order in which ready resources are applied.
+	// The default is FIFO.
+	Schedule Schedule
+
+	// Durations is how long each resource took in a previous run, for
+	// the Durations schedule.  Resources that aren't in the map are
+	// treated as taking no time.
+	Durations map[uint64]time.Duration
+
+	// DigestCache, if non-nil, records the digests of files that Apply
+	// writes or verifies, and lets Apply skip reading a file whose
+	// path, inode, modification time, and size match a recorded entry.
+	DigestCache *digestcache.Cache
 }
 
 // normalize will return a Options struct that is equivalent to opts.
@@ -99,7 +150,7 @@ type applyState struct {
 	changedResources map[uint64]bool
 }
 
-func apply(ctx context.Context, sys system.System, g *depgraph.Graph, opts *Options) error {
+func apply(ctx context.Context, sys system.System, g *depgraph.Graph, locks map[uint64][]string, opts *Options) error {
 	ch, results, done := startWorkers(ctx, opts.Log, opts.ConcurrentJobs)
 	defer done()
 
@@ -107,21 +158,24 @@ func apply(ctx context.Context, sys system.System, g *depgraph.Graph, opts *Opti
 		graph:            g,
 		changedResources: make(map[uint64]bool),
 	}
-	working := make(workingSet, opts.ConcurrentJobs)
+	working := newWorkingSet(opts.ConcurrentJobs, locks, priorities(g, opts))
+	working.push(g.Ready())
 	var nextJob *job
 	for !g.Done() {
 		if working.hasIdle() && nextJob == nil {
 			// Find next work, if any.
-			ready := g.Ready()
-			if len(ready) == 0 {
+			id := working.next()
+			if id == 0 && working.empty() {
 				return errors.New("graph not done, but has nothing to do")
 			}
-			if id := working.next(ready); id != 0 {
+			if id != 0 {
 				res := g.Resource(id)
 				nextJob = &job{
 					sys:         sys,
 					log:         opts.Log,
 					bashPath:    opts.Bash,
+					blobs:       opts.Blobs,
+					digests:     opts.DigestCache,
 					resource:    res,
 					depsChanged: mapChangedDeps(state.changedResources, res),
 				}
@@ -131,7 +185,7 @@ func apply(ctx context.Context, sys system.System, g *depgraph.Graph, opts *Opti
 			select {
 			case r := <-results:
 				working.remove(r.id)
-				update(ctx, opts.Log, state, r)
+				update(ctx, opts, state, working, r)
 			case <-ctx.Done():
 				return ctx.Err()
 			}
@@ -143,7 +197,7 @@ func apply(ctx context.Context, sys system.System, g *depgraph.Graph, opts *Opti
 			nextJob = nil
 		case r := <-results:
 			working.remove(r.id)
-			update(ctx, opts.Log, state, r)
+			update(ctx, opts, state, working, r)
 		case <-ctx.Done():
 			return ctx.Err()
 		}
@@ -155,10 +209,11 @@ func apply(ctx context.Context, sys system.System, g *depgraph.Graph, opts *Opti
 	return nil
 }
 
-func update(ctx context.Context, log Logger, state *applyState, r jobResult) {
+func update(ctx context.Context, opts *Options, state *applyState, working *workingSet, r jobResult) {
 	if r.err != nil {
 		state.hasFailures = true
-		log.Error(ctx, r.err)
+		opts.Log.Error(ctx, r.err)
+		addReport(opts.Report, report.Resource{ID: r.id, Status: report.Failed, Err: r.err, Duration: r.duration})
 		skipped := state.graph.MarkFailure(r.id)
 		if len(skipped) == 0 {
 			return
@@ -166,13 +221,27 @@ func update(ctx context.Context, log Logger, state *applyState, r jobResult) {
 		skipnames := make([]string, len(skipped))
 		for i := range skipnames {
 			skipnames[i] = formatResource(state.graph.Resource(skipped[i]))
+			addReport(opts.Report, report.Resource{ID: skipped[i], Status: report.Skipped, FailedDep: r.id})
 		}
 		res := state.graph.Resource(r.id)
-		log.Infof(ctx, "skipping due to failure of %s: %s", formatResource(res), strings.Join(skipnames, ", "))
+		opts.Log.Infof(ctx, "skipping due to failure of %s: %s", formatResource(res), strings.Join(skipnames, ", "))
 		return
 	}
-	state.graph.Mark(r.id)
+	working.push(state.graph.Mark(r.id))
 	state.changedResources[r.id] = r.changed
+	if r.changed {
+		addReport(opts.Report, report.Resource{ID: r.id, Status: report.Changed, Duration: r.duration})
+	} else {
+		addReport(opts.Report, report.Resource{ID: r.id, Status: report.Unchanged, Duration: r.duration})
+	}
+}
+
+// addReport appends rr to r if r is non-nil.
+func addReport(r *report.Report, rr report.Resource) {
+	if r == nil {
+		return
+	}
+	r.Resources = append(r.Resources, rr)
 }
 
 func mapChangedDeps(all map[uint64]bool, r catalog.Resource) map[uint64]bool {
@@ -186,52 +255,194 @@ func mapChangedDeps(all map[uint64]bool, r catalog.Resource) map[uint64]bool {
 	return m
 }
 
-// workingSet is the list of resource IDs being processed at a point in time.
-type workingSet []uint64
+// resourceLocks returns the lock names of each resource in res that
+// has any, or nil if no resource does.
+func resourceLocks(res catalog.Resource_List) (map[uint64][]string, error) {
+	var locks map[uint64][]string
+	for i := 0; i < res.Len(); i++ {
+		r := res.At(i)
+		list, err := r.Locks()
+		if err != nil {
+			return nil, errorWithResource(r, fmt.Errorf("read locks: %v", err))
+		}
+		if list.Len() == 0 {
+			continue
+		}
+		names := make([]string, list.Len())
+		for j := range names {
+			names[j], err = list.At(j)
+			if err != nil {
+				return nil, errorWithResource(r, fmt.Errorf("read locks: %v", err))
+			}
+		}
+		if locks == nil {
+			locks = make(map[uint64][]string)
+		}
+		locks[r.ID()] = names
+	}
+	return locks, nil
+}
+
+// workingSet is the set of resource IDs being processed at a point in
+// time, along with the locks that they hold and the ready resources
+// waiting for a worker.
+type workingSet struct {
+	ids   map[uint64]struct{}
+	max   int
+	locks map[uint64][]string // resource ID -> lock names
+	held  map[string]struct{}
+
+	// queue holds ready resources that could be applied next.
+	// Resources that need a held lock are set aside in waiting under
+	// that lock's name until it is released.
+	prio    map[uint64]int64
+	queue   readyQueue
+	waiting map[string]*readyQueue
+	nready  int
+}
+
+func newWorkingSet(n int, locks map[uint64][]string, prio map[uint64]int64) *workingSet {
+	return &workingSet{
+		ids:     make(map[uint64]struct{}, n),
+		max:     n,
+		locks:   locks,
+		held:    make(map[string]struct{}),
+		prio:    prio,
+		waiting: make(map[string]*readyQueue),
+	}
+}
 
 // hasIdle reports whether there are idle workers.
-func (ws workingSet) hasIdle() bool {
-	return ws.find(0) != -1
+func (ws *workingSet) hasIdle() bool {
+	return len(ws.ids) < ws.max
+}
+
+// empty reports whether no resources are being processed.
+func (ws *workingSet) empty() bool {
+	return len(ws.ids) == 0
 }
 
-func (ws workingSet) add(id uint64) {
-	i := ws.find(0)
-	if i == -1 {
+func (ws *workingSet) add(id uint64) {
+	if !ws.hasIdle() {
 		panic("workingSet.add on full set")
 	}
-	ws[i] = id
+	if name := ws.heldLock(id); name != "" {
+		panic("workingSet.add with held lock")
+	}
+	ws.ids[id] = struct{}{}
+	for _, name := range ws.locks[id] {
+		ws.held[name] = struct{}{}
+	}
 }
 
-func (ws workingSet) remove(id uint64) {
-	i := ws.find(id)
-	if i == -1 {
+func (ws *workingSet) remove(id uint64) {
+	if !ws.has(id) {
 		panic("workingSet.remove could not find ID")
 	}
-	ws[i] = 0
+	delete(ws.ids, id)
+	for _, name := range ws.locks[id] {
+		delete(ws.held, name)
+		ws.wake(name)
+	}
+}
+
+func (ws *workingSet) has(id uint64) bool {
+	_, ok := ws.ids[id]
+	return ok
 }
 
-// find returns the index of an ID in the set or -1 if not found.
-func (ws workingSet) find(id uint64) int {
-	for i := range ws {
-		if ws[i] == id {
-			return i
+// heldLock returns the name of a lock that the resource with the given
+// ID needs and that is held by a resource in ws, or the empty string if
+// there is none.
+func (ws *workingSet) heldLock(id uint64) string {
+	for _, name := range ws.locks[id] {
+		if _, ok := ws.held[name]; ok {
+			return name
 		}
 	}
-	return -1
+	return ""
 }
 
-// next returns a resource ID that is in ready but not in ws or zero if ws is a superset of ready.
-func (ws workingSet) next(ready []uint64) uint64 {
-	// While this is technically O(len(ws) * len(ready)),
-	// len(ws) is constant over the course of an Apply.
-	for _, id := range ready {
-		if ws.find(id) == -1 {
-			return id
+// push adds resources that just became ready to the queue.
+func (ws *workingSet) push(ids []uint64) {
+	for _, id := range ids {
+		heap.Push(&ws.queue, readyItem{id: id, prio: ws.prio[id], seq: ws.nready})
+		ws.nready++
+	}
+}
+
+// next removes and returns the queued resource ID with the highest
+// priority, setting aside resources that need a held lock.  It returns
+// zero if there is no such resource.  Ties, or all resources if there
+// are no priorities, go to the resource that became ready first.
+func (ws *workingSet) next() uint64 {
+	for ws.queue.Len() > 0 {
+		item := heap.Pop(&ws.queue).(readyItem)
+		name := ws.heldLock(item.id)
+		if name == "" {
+			return item.id
+		}
+		w := ws.waiting[name]
+		if w == nil {
+			w = new(readyQueue)
+			ws.waiting[name] = w
+		}
+		heap.Push(w, item)
+		// If item was woken by the release of another of its locks,
+		// that lock is free but may still have resources waiting for
+		// it.  Wake the next one in item's place.
+		for _, other := range ws.locks[item.id] {
+			ws.wake(other)
 		}
 	}
 	return 0
 }
 
+// wake moves the resource with the highest priority that is waiting for
+// the named lock back to the queue if the lock is not held.  Only one
+// resource can hold the lock at a time, so the others stay set aside
+// until the woken one either takes the lock or is set aside again.
+func (ws *workingSet) wake(name string) {
+	if _, ok := ws.held[name]; ok {
+		return
+	}
+	w := ws.waiting[name]
+	if w == nil || w.Len() == 0 {
+		return
+	}
+	heap.Push(&ws.queue, heap.Pop(w))
+}
+
+// A readyItem is a ready resource in a readyQueue.  seq is the order in
+// which it became ready.
+type readyItem struct {
+	id   uint64
+	prio int64
+	seq  int
+}
+
+// readyQueue is a heap of ready resources with the highest priority
+// first, ties going to the resource that became ready first.
+type readyQueue []readyItem
+
+func (q readyQueue) Len() int { return len(q) }
+
+func (q readyQueue) Less(i, j int) bool {
+	if q[i].prio != q[j].prio {
+		return q[i].prio > q[j].prio
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q readyQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *readyQueue) Push(x interface{}) { *q = append(*q, x.(readyItem)) }
+
+func (q *readyQueue) Pop() interface{} {
+	x := (*q)[len(*q)-1]
+	*q = (*q)[:len(*q)-1]
+	return x
+}
+
 func startWorkers(ctx context.Context, log Logger, n int) (chan<- *job, <-chan jobResult, func()) {
 	ch := make(chan *job)
 	results := make(chan jobResult)
@@ -258,7 +469,9 @@ func worker(ctx context.Context, log Logger, results chan<- jobResult, ch <-chan
 				return
 			}
 			log.Infof(ctx, "applying: %s", formatResource(j.resource))
+			start := time.Now()
 			r := j.run(ctx)
+			r.duration = time.Since(start)
 			select {
 			case results <- r:
 			case <-ctx.Done():
@@ -17,15 +17,25 @@ package execlib_test
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
+	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/zombiezen/mcm/catalog"
 	. "github.com/zombiezen/mcm/exec/execlib"
+	"github.com/zombiezen/mcm/exec/report"
 	"github.com/zombiezen/mcm/internal/applytests"
+	"github.com/zombiezen/mcm/internal/blobstore"
 	"github.com/zombiezen/mcm/internal/catpogs"
+	"github.com/zombiezen/mcm/internal/digestcache"
 	"github.com/zombiezen/mcm/internal/system"
 	"github.com/zombiezen/mcm/internal/system/fakesystem"
 )
@@ -38,6 +48,14 @@ func TestApplier2Jobs(t *testing.T) {
 	applytests.Run(t, (&fixtureFactory{concurrentJobs: 2}).newFixture)
 }
 
+func TestApplierSchedules(t *testing.T) {
+	for _, s := range []Schedule{LongestPath, MostDependents, Durations} {
+		t.Run(s.String(), func(t *testing.T) {
+			applytests.Run(t, (&fixtureFactory{concurrentJobs: 2, schedule: s}).newFixture)
+		})
+	}
+}
+
 func TestExecBash(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -101,8 +119,510 @@ func TestExecBash(t *testing.T) {
 	}
 }
 
+func TestSpecialFileErrors(t *testing.T) {
+	tests := []struct {
+		mode os.FileMode
+		want string
+	}{
+		{os.ModeNamedPipe | 0644, "is a FIFO"},
+		{os.ModeSocket | 0755, "is a socket"},
+		{os.ModeDevice | 0600, "is a block device"},
+		{os.ModeDevice | os.ModeCharDevice | 0666, "is a character device"},
+	}
+	files := []struct {
+		name string
+		file func(path string) *catpogs.File
+	}{
+		{"plain", func(path string) *catpogs.File { return catpogs.PlainFile(path, []byte("Hello")) }},
+		{"no content", func(path string) *catpogs.File { return catpogs.PlainFile(path, nil) }},
+		{"directory", func(path string) *catpogs.File { return catpogs.Directory(path, nil) }},
+		{"symlink", func(path string) *catpogs.File { return catpogs.SymlinkFile("/foo", path) }},
+	}
+	for _, test := range tests {
+		for _, f := range files {
+			ctx, cancel := context.WithCancel(context.Background())
+			sys := new(fakesystem.System)
+			path := filepath.Join(fakesystem.Root, "node")
+			if err := sys.Mknod(path, test.mode); err != nil {
+				t.Fatalf("Mknod(%q, %v): %v", path, test.mode, err)
+			}
+			cat, err := (&catpogs.Catalog{
+				Resources: []*catpogs.Resource{
+					{
+						ID:      42,
+						Comment: f.name,
+						Which:   catalog.Resource_Which_file,
+						File:    f.file(path),
+					},
+				},
+			}).ToCapnp()
+			if err != nil {
+				t.Fatal("catpogs.Catalog.ToCapnp():", err)
+			}
+			log := new(recordLogger)
+			Apply(ctx, sys, cat, &Options{Log: log})
+			if len(log.errors) != 1 {
+				t.Errorf("%s over %v: got %d errors; want 1", f.name, test.mode, len(log.errors))
+			} else if msg := log.errors[0].Error(); !strings.Contains(msg, test.want) {
+				t.Errorf("%s over %v: error = %q; want to contain %q", f.name, test.mode, msg, test.want)
+			}
+			cancel()
+		}
+	}
+}
+
+func TestBlobContent(t *testing.T) {
+	content := []byte("Hello, World!\n")
+	digest := blobstore.Sum(content)
+	other := blobstore.Sum([]byte("Goodbye\n"))
+	tests := []struct {
+		name    string
+		initial []byte // nil for no file
+		data    []byte
+		digest  blobstore.Digest
+		blobs   map[blobstore.Digest][]byte // nil for no store
+
+		want      []byte // nil if an error is expected
+		wantFetch bool
+	}{
+		{
+			name:      "create",
+			digest:    digest,
+			blobs:     map[blobstore.Digest][]byte{digest: content},
+			want:      content,
+			wantFetch: true,
+		},
+		{
+			name:      "replace",
+			initial:   []byte("Goodbye\n"),
+			digest:    digest,
+			blobs:     map[blobstore.Digest][]byte{digest: content},
+			want:      content,
+			wantFetch: true,
+		},
+		{
+			name:    "unchanged without store",
+			initial: content,
+			digest:  digest,
+			want:    content,
+		},
+		{
+			name:      "missing blob",
+			digest:    digest,
+			blobs:     map[blobstore.Digest][]byte{},
+			wantFetch: true,
+		},
+		{
+			name:   "no store",
+			digest: digest,
+		},
+		{
+			name:      "corrupt blob",
+			initial:   []byte("Hello\n"),
+			digest:    other,
+			blobs:     map[blobstore.Digest][]byte{other: content},
+			wantFetch: true,
+		},
+		{
+			name:   "inline content mismatch",
+			data:   content,
+			digest: other,
+		},
+	}
+	for _, test := range tests {
+		ctx := context.Background()
+		sys := new(fakesystem.System)
+		path := filepath.Join(fakesystem.Root, "foo.txt")
+		if test.initial != nil {
+			if err := system.WriteFile(ctx, sys, path, test.initial, 0666); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+		f := catpogs.PlainFile(path, test.data)
+		f.Plain.ContentSHA256 = test.digest[:]
+		cat, err := (&catpogs.Catalog{
+			Resources: []*catpogs.Resource{
+				{
+					ID:      42,
+					Comment: "file",
+					Which:   catalog.Resource_Which_file,
+					File:    f,
+				},
+			},
+		}).ToCapnp()
+		if err != nil {
+			t.Fatal("catpogs.Catalog.ToCapnp():", err)
+		}
+		opts := &Options{Log: new(recordLogger)}
+		store := &blobStore{blobs: test.blobs}
+		if test.blobs != nil {
+			opts.Blobs = store
+		}
+		err = Apply(ctx, sys, cat, opts)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("%s: Apply did not return an error", test.name)
+			}
+			if got, _ := system.ReadFile(ctx, sys, path); !bytes.Equal(got, test.initial) {
+				t.Errorf("%s: content after failure = %q; want %q", test.name, got, test.initial)
+			}
+		} else {
+			if err != nil {
+				t.Errorf("%s: Apply: %v", test.name, err)
+			}
+			if got, err := system.ReadFile(ctx, sys, path); err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			} else if !bytes.Equal(got, test.want) {
+				t.Errorf("%s: content = %q; want %q", test.name, got, test.want)
+			}
+		}
+		if store.opened != test.wantFetch {
+			t.Errorf("%s: fetched blob = %t; want %t", test.name, store.opened, test.wantFetch)
+		}
+	}
+}
+
+func TestDigestCache(t *testing.T) {
+	ctx := context.Background()
+	sys := new(fakesystem.System)
+	path := filepath.Join(fakesystem.Root, "foo.txt")
+	if err := system.WriteFile(ctx, sys, path, []byte("old\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte("new\n")
+	cat, err := (&catpogs.Catalog{
+		Resources: []*catpogs.Resource{
+			{ID: 42, Which: catalog.Resource_Which_file, File: catpogs.PlainFile(path, want)},
+		},
+	}).ToCapnp()
+	if err != nil {
+		t.Fatal("catpogs.Catalog.ToCapnp():", err)
+	}
+	key := func() digestcache.Key {
+		info, err := sys.Lstat(ctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ino, err := sys.Inode(info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return digestcache.Key{Path: path, Inode: ino, ModTime: info.ModTime(), Size: info.Size()}
+	}
+
+	// An entry that matches the file's key is trusted without reading the file.
+	cache := new(digestcache.Cache)
+	cache.Put(key(), blobstore.Sum(want))
+	if err := Apply(ctx, sys, cat, &Options{DigestCache: cache}); err != nil {
+		t.Fatal("Apply with stale cache:", err)
+	}
+	if got, _ := system.ReadFile(ctx, sys, path); string(got) != "old\n" {
+		t.Errorf("after Apply with cache hit, content = %q; want \"old\\n\"", got)
+	}
+
+	// fakesystem counts opening a file as modifying it, so check the
+	// cache entry before reading the content back.
+	cache = new(digestcache.Cache)
+	if err := Apply(ctx, sys, cat, &Options{DigestCache: cache}); err != nil {
+		t.Fatal("Apply:", err)
+	}
+	if d, ok := cache.Get(key()); !ok || d != blobstore.Sum(want) {
+		t.Errorf("cache entry after Apply = %v, %t; want %v, true", d, ok, blobstore.Sum(want))
+	}
+	if got, _ := system.ReadFile(ctx, sys, path); !bytes.Equal(got, want) {
+		t.Errorf("after Apply, content = %q; want %q", got, want)
+	}
+}
+
+func TestReport(t *testing.T) {
+	ctx := context.Background()
+	sys := new(fakesystem.System)
+	path := filepath.Join(fakesystem.Root, "foo.txt")
+	cat, err := (&catpogs.Catalog{
+		Resources: []*catpogs.Resource{
+			{ID: 42, Which: catalog.Resource_Which_file, File: catpogs.PlainFile(path, []byte("Hello\n"))},
+			{ID: 43, Which: catalog.Resource_Which_noop, Deps: []uint64{42}},
+		},
+	}).ToCapnp()
+	if err != nil {
+		t.Fatal("catpogs.Catalog.ToCapnp():", err)
+	}
+	want, err := catalog.Hash(cat)
+	if err != nil {
+		t.Fatal("catalog.Hash:", err)
+	}
+	rep := new(report.Report)
+	if err := Apply(ctx, sys, cat, &Options{Report: rep}); err != nil {
+		t.Fatal("Apply:", err)
+	}
+	if rep.CatalogHash != want {
+		t.Errorf("report.CatalogHash = %x; want %x", rep.CatalogHash, want)
+	}
+	if len(rep.Resources) != 2 ||
+		rep.Resources[0].ID != 42 || rep.Resources[0].Status != report.Changed ||
+		rep.Resources[1].ID != 43 || rep.Resources[1].Status != report.Changed {
+		t.Errorf("report.Resources = %+v; want [42 changed, 43 changed]", rep.Resources)
+	}
+
+	// A hash passed in the options is used as is.
+	rep = new(report.Report)
+	given := [sha256.Size]byte{1, 2, 3}
+	if err := Apply(ctx, sys, cat, &Options{Report: rep, CatalogHash: given}); err != nil {
+		t.Fatal("Apply:", err)
+	}
+	if rep.CatalogHash != given {
+		t.Errorf("with Options.CatalogHash = %x, report.CatalogHash = %x", given, rep.CatalogHash)
+	}
+}
+
+func TestReportFailure(t *testing.T) {
+	ctx := context.Background()
+	sys := new(fakesystem.System)
+	falsePath := filepath.Join(fakesystem.Root, "false")
+	err := sys.Mkprogram(falsePath, func(ctx context.Context, pc *fakesystem.ProgramContext) int {
+		return 1
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cat, err := (&catpogs.Catalog{
+		Resources: []*catpogs.Resource{
+			{
+				ID:    1,
+				Which: catalog.Resource_Which_exec,
+				Exec: &catpogs.Exec{
+					Command: &catpogs.Command{Which: catalog.Exec_Command_Which_argv, Argv: []string{falsePath}},
+				},
+			},
+			{ID: 2, Which: catalog.Resource_Which_noop, Deps: []uint64{1}},
+			{ID: 3, Which: catalog.Resource_Which_noop, Deps: []uint64{2}},
+			{ID: 4, Which: catalog.Resource_Which_noop},
+		},
+	}).ToCapnp()
+	if err != nil {
+		t.Fatal("catpogs.Catalog.ToCapnp():", err)
+	}
+	rep := new(report.Report)
+	if err := Apply(ctx, sys, cat, &Options{Report: rep}); err == nil {
+		t.Error("Apply did not return an error")
+	}
+	got := make(map[uint64]report.Resource)
+	for _, rr := range rep.Resources {
+		got[rr.ID] = rr
+	}
+	if rr := got[1]; rr.Status != report.Failed || rr.Err == nil || rr.FailedDep != 0 {
+		t.Errorf("report for id=1 = %+v; want failed with error", rr)
+	}
+	for _, id := range []uint64{2, 3} {
+		if rr := got[id]; rr.Status != report.Skipped || rr.FailedDep != 1 || rr.Duration != 0 {
+			t.Errorf("report for id=%d = %+v; want skipped with FailedDep=1", id, rr)
+		}
+	}
+	if rr := got[4]; rr.Status != report.Unchanged {
+		t.Errorf("report for id=4 = %+v; want unchanged", rr)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	// 1 and 2 are ready at the start, but 2 has a chain of dependents.
+	// 5 has more dependents than 2, but a shorter chain.
+	cat, err := (&catpogs.Catalog{
+		Resources: []*catpogs.Resource{
+			{ID: 1, Which: catalog.Resource_Which_noop},
+			{ID: 2, Which: catalog.Resource_Which_noop},
+			{ID: 3, Which: catalog.Resource_Which_noop, Deps: []uint64{2}},
+			{ID: 4, Which: catalog.Resource_Which_noop, Deps: []uint64{3}},
+			{ID: 5, Which: catalog.Resource_Which_noop},
+			{ID: 6, Which: catalog.Resource_Which_noop, Deps: []uint64{5}},
+			{ID: 7, Which: catalog.Resource_Which_noop, Deps: []uint64{5}},
+			{ID: 8, Which: catalog.Resource_Which_noop, Deps: []uint64{5}},
+		},
+	}).ToCapnp()
+	if err != nil {
+		t.Fatal("catpogs.Catalog.ToCapnp():", err)
+	}
+	tests := []struct {
+		schedule  Schedule
+		durations map[uint64]time.Duration
+		first     uint64
+	}{
+		{schedule: FIFO, first: 1},
+		{schedule: LongestPath, first: 2},
+		{schedule: MostDependents, first: 5},
+		{schedule: Durations, durations: map[uint64]time.Duration{1: time.Minute}, first: 1},
+		{schedule: Durations, durations: map[uint64]time.Duration{7: time.Minute}, first: 5},
+	}
+	for _, test := range tests {
+		rep := new(report.Report)
+		opts := &Options{
+			Schedule:  test.schedule,
+			Durations: test.durations,
+			Report:    rep,
+		}
+		if err := Apply(context.Background(), new(fakesystem.System), cat, opts); err != nil {
+			t.Errorf("Apply with %v schedule: %v", test.schedule, err)
+			continue
+		}
+		if len(rep.Resources) == 0 || rep.Resources[0].ID != test.first {
+			t.Errorf("Apply with %v schedule (durations = %v) applied %+v; want id=%d first", test.schedule, test.durations, rep.Resources, test.first)
+		}
+	}
+}
+
+func TestLocks(t *testing.T) {
+	ctx := context.Background()
+	sys := new(fakesystem.System)
+	runPath := filepath.Join(fakesystem.Root, "run")
+	var mu sync.Mutex
+	running := make(map[string]int)
+	maxRunning := make(map[string]int)
+	err := sys.Mkprogram(runPath, func(ctx context.Context, pc *fakesystem.ProgramContext) int {
+		locks := pc.Args[1:]
+		mu.Lock()
+		for _, name := range locks {
+			running[name]++
+			if running[name] > maxRunning[name] {
+				maxRunning[name] = running[name]
+			}
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		for _, name := range locks {
+			running[name]--
+		}
+		mu.Unlock()
+		return 0
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(id uint64, locks ...string) *catpogs.Resource {
+		return &catpogs.Resource{
+			ID:    id,
+			Locks: locks,
+			Which: catalog.Resource_Which_exec,
+			Exec: &catpogs.Exec{
+				Command: &catpogs.Command{Which: catalog.Exec_Command_Which_argv, Argv: append([]string{runPath}, locks...)},
+			},
+		}
+	}
+	cat, err := (&catpogs.Catalog{
+		Resources: []*catpogs.Resource{
+			run(1, "apt"),
+			run(2, "apt", "net"),
+			run(3, "apt"),
+			run(4),
+			run(5),
+			run(6, "net"),
+			run(7, "net", "apt"),
+			run(8, "net"),
+		},
+	}).ToCapnp()
+	if err != nil {
+		t.Fatal("catpogs.Catalog.ToCapnp():", err)
+	}
+	for _, schedule := range []Schedule{FIFO, MostDependents} {
+		for k := range maxRunning {
+			delete(maxRunning, k)
+		}
+		rep := new(report.Report)
+		if err := Apply(ctx, sys, cat, &Options{ConcurrentJobs: 5, Report: rep, Schedule: schedule}); err != nil {
+			t.Fatalf("Apply with %v schedule: %v", schedule, err)
+		}
+		if len(rep.Resources) != 8 {
+			t.Errorf("with %v schedule, applied %d resources; want 8", schedule, len(rep.Resources))
+		}
+		for _, name := range []string{"apt", "net"} {
+			if n := maxRunning[name]; n != 1 {
+				t.Errorf("with %v schedule, %d resources holding the %s lock ran at once; want 1", schedule, n, name)
+			}
+		}
+	}
+}
+
+// BenchmarkApply measures scheduling overhead on large catalogs of
+// noops shaped like generated configuration: a few directories, each
+// with many files, and a service per directory that depends on all of
+// its files.
+func BenchmarkApply(b *testing.B) {
+	schedules := []Schedule{FIFO, LongestPath, MostDependents, Durations}
+	for _, n := range []int{10000, 50000, 100000} {
+		cat := wideCatalog(b, n)
+		durations := make(map[uint64]time.Duration, n)
+		for id := uint64(1); id <= uint64(n); id++ {
+			durations[id] = time.Duration(id%10) * time.Millisecond
+		}
+		for _, schedule := range schedules {
+			for _, jobs := range []int{1, 8} {
+				opts := &Options{
+					ConcurrentJobs: jobs,
+					Schedule:       schedule,
+					Durations:      durations,
+				}
+				b.Run(fmt.Sprintf("%v/%d/j=%d", schedule, n, jobs), func(b *testing.B) {
+					for i := 0; i < b.N; i++ {
+						// Each Apply reads the whole catalog, which would
+						// soon exhaust the message's traversal limit.
+						cat.Segment().Message().ReadLimiter().Reset(math.MaxUint64)
+						if err := Apply(context.Background(), new(fakesystem.System), cat, opts); err != nil {
+							b.Fatal(err)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+func wideCatalog(tb testing.TB, n int) catalog.Catalog {
+	const dirs = 100
+	res := make([]*catpogs.Resource, 0, n)
+	for i := 1; i <= dirs; i++ {
+		res = append(res, &catpogs.Resource{ID: uint64(i), Which: catalog.Resource_Which_noop})
+	}
+	services := make([]*catpogs.Resource, dirs)
+	for i := range services {
+		services[i] = &catpogs.Resource{ID: uint64(n - dirs + 1 + i), Which: catalog.Resource_Which_noop}
+	}
+	for id := dirs + 1; id <= n-dirs; id++ {
+		res = append(res, &catpogs.Resource{
+			ID:    uint64(id),
+			Deps:  []uint64{uint64(id%dirs + 1)},
+			Which: catalog.Resource_Which_noop,
+		})
+		services[id%dirs].Deps = append(services[id%dirs].Deps, uint64(id))
+	}
+	cat, err := (&catpogs.Catalog{Resources: append(res, services...)}).ToCapnp()
+	if err != nil {
+		tb.Fatal("catpogs.Catalog.ToCapnp():", err)
+	}
+	return cat
+}
+
+// blobStore is an in-memory blobstore.Store that records whether it
+// was read from.  Blobs are not checked against their digests.
+type blobStore struct {
+	blobs map[blobstore.Digest][]byte
+
+	mu     sync.Mutex
+	opened bool
+}
+
+func (bs *blobStore) Open(ctx context.Context, d blobstore.Digest) (io.ReadCloser, error) {
+	bs.mu.Lock()
+	bs.opened = true
+	bs.mu.Unlock()
+	data, ok := bs.blobs[d]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: d.String(), Err: os.ErrNotExist}
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
 type fixtureFactory struct {
 	concurrentJobs int
+	schedule       Schedule
 }
 
 type fixture struct {
@@ -110,6 +630,7 @@ type fixture struct {
 	log            applytests.Logger
 	info           *applytests.SystemInfo
 	concurrentJobs int
+	schedule       Schedule
 }
 
 func (ff *fixtureFactory) newFixture(ctx context.Context, log applytests.Logger, name string) (applytests.Fixture, error) {
@@ -163,14 +684,19 @@ func (ff *fixtureFactory) newFixture(ctx context.Context, log applytests.Logger,
 		log:            log,
 		info:           info,
 		concurrentJobs: ff.concurrentJobs,
+		schedule:       ff.schedule,
 	}, nil
 }
 
 func (f *fixture) Apply(ctx context.Context, c catalog.Catalog) error {
-	return Apply(ctx, f.sys, c, &Options{
+	before := f.sys.Snapshot()
+	err := Apply(ctx, f.sys, c, &Options{
 		Log:            testLogger{t: f.log},
 		ConcurrentJobs: f.concurrentJobs,
+		Schedule:       f.schedule,
 	})
+	f.log.Logf("filesystem changes:\n%v", fakesystem.Diff(before, f.sys.Snapshot()))
+	return err
 }
 
 func (f *fixture) System() system.System {
@@ -185,6 +711,20 @@ func (f *fixture) Close() error {
 	return nil
 }
 
+type recordLogger struct {
+	mu     sync.Mutex
+	errors []error
+}
+
+func (rl *recordLogger) Infof(ctx context.Context, format string, args ...interface{}) {
+}
+
+func (rl *recordLogger) Error(ctx context.Context, err error) {
+	rl.mu.Lock()
+	rl.errors = append(rl.errors, err)
+	rl.mu.Unlock()
+}
+
 type testLogger struct {
 	t applytests.Logger
 }
@@ -0,0 +1,85 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package execlib
+
+import (
+	"fmt"
+
+	"github.com/zombiezen/mcm/internal/depgraph"
+)
+
+// Schedule is a policy for choosing which ready resource to apply next
+// when there are more ready resources than idle workers.
+type Schedule int
+
+// Schedules.
+const (
+	// FIFO applies resources in the order that they become ready.
+	FIFO Schedule = iota
+
+	// LongestPath applies the resource with the longest chain of
+	// resources depending on it first, since that chain limits how
+	// soon the catalog can finish.
+	LongestPath
+
+	// MostDependents applies the resource that the most resources
+	// depend on, directly or indirectly, first.  A resource that depends
+	// on another through more than one chain of dependencies is counted
+	// once per chain.
+	MostDependents
+
+	// Durations is like LongestPath, but weighs each resource by how
+	// long it took in a previous run, as given in Options.Durations.
+	Durations
+)
+
+// String returns the name of the schedule as given to mcm-exec's
+// -schedule flag.
+func (s Schedule) String() string {
+	switch s {
+	case FIFO:
+		return "fifo"
+	case LongestPath:
+		return "longest-path"
+	case MostDependents:
+		return "most-dependents"
+	case Durations:
+		return "durations"
+	default:
+		return fmt.Sprintf("Schedule(%d)", int(s))
+	}
+}
+
+// priorities returns the priority of each resource in g under opts's
+// schedule, or nil if resources should be applied in FIFO order.
+func priorities(g *depgraph.Graph, opts *Options) map[uint64]int64 {
+	switch opts.Schedule {
+	case LongestPath:
+		return g.RemainingCosts(nil)
+	case MostDependents:
+		counts := g.DescendantCounts()
+		prio := make(map[uint64]int64, len(counts))
+		for id, n := range counts {
+			prio[id] = int64(n)
+		}
+		return prio
+	case Durations:
+		return g.RemainingCosts(func(id uint64) int64 {
+			return int64(opts.Durations[id])
+		})
+	default:
+		return nil
+	}
+}
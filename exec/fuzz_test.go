@@ -0,0 +1,111 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zombiezen/mcm/exec/execlib"
+	"github.com/zombiezen/mcm/exec/report"
+	"github.com/zombiezen/mcm/internal/catalogio"
+	"github.com/zombiezen/mcm/internal/fuzzcorpus"
+	"github.com/zombiezen/mcm/internal/system/fakesystem"
+)
+
+// FuzzApply reads arbitrary bytes as a catalog and applies it to a fake
+// system.  Apply must finish without panicking and must only report
+// errors as *execlib.Error values.
+func FuzzApply(f *testing.F) {
+	fuzzcorpus.Add(f, "..")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c, err := catalogio.Read(bytes.NewReader(data), nil)
+		if err != nil {
+			return
+		}
+		ids := make(map[uint64]bool)
+		if res, err := c.Resources(); err == nil {
+			for i, n := 0, res.Len(); i < n; i++ {
+				ids[res.At(i).ID()] = true
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		log := new(recordLogger)
+		rep := new(report.Report)
+		err = execlib.Apply(ctx, new(fakesystem.System), c, &execlib.Options{
+			Log:    log,
+			Report: rep,
+		})
+		if ctx.Err() != nil {
+			t.Fatal("Apply did not finish")
+		}
+		if err != nil {
+			checkError(t, "Apply", err)
+		}
+		for _, err := range log.errors {
+			checkError(t, "logged", err)
+			if id := err.(*execlib.Error).ResourceID; !ids[id] {
+				t.Errorf("logged error %q has resource ID %d, which is not in the catalog", err, id)
+			}
+		}
+		seen := make(map[uint64]bool)
+		for _, rr := range rep.Resources {
+			if !ids[rr.ID] {
+				t.Errorf("report has resource ID %d, which is not in the catalog", rr.ID)
+			}
+			if seen[rr.ID] {
+				t.Errorf("report has resource ID %d multiple times", rr.ID)
+			}
+			seen[rr.ID] = true
+		}
+	})
+}
+
+func checkError(t *testing.T, what string, err error) {
+	e, ok := err.(*execlib.Error)
+	if !ok {
+		t.Errorf("%s error %q is a %T; want *execlib.Error", what, err, err)
+		return
+	}
+	if e.Err == nil {
+		t.Errorf("%s error has nil Err", what)
+		return
+	}
+	if e.Error() == "" {
+		t.Errorf("%s error has empty message", what)
+	}
+}
+
+type recordLogger struct {
+	mu     sync.Mutex
+	errors []error
+}
+
+func (rl *recordLogger) Infof(ctx context.Context, format string, args ...interface{}) {
+}
+
+func (rl *recordLogger) Error(ctx context.Context, err error) {
+	rl.mu.Lock()
+	rl.errors = append(rl.errors, err)
+	rl.mu.Unlock()
+}
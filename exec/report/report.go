@@ -0,0 +1,191 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package report provides the record of an mcm-exec run and its JSON
+// encoding.
+package report
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// A Report is a record of the outcome of each resource in an
+// application of a catalog.
+type Report struct {
+	// CatalogHash is the hash of the applied catalog, as returned by
+	// catalog.Hash.
+	CatalogHash [sha256.Size]byte
+
+	// Resources is the list of resource outcomes in the order that
+	// they finished.
+	Resources []Resource
+}
+
+// Resource is the outcome of applying a single resource.
+type Resource struct {
+	ID     uint64
+	Status Status
+
+	// Err is the reason that the resource failed.  It is nil unless
+	// Status is Failed.
+	Err error
+
+	// Duration is how long the resource took to apply.  It is zero if
+	// Status is Skipped.
+	Duration time.Duration
+
+	// FailedDep is the ID of the failed resource that caused this
+	// resource to be skipped.  It may be an indirect dependency.  It is
+	// zero unless Status is Skipped.
+	FailedDep uint64
+}
+
+// Status is the final state of a resource.
+type Status int
+
+// Resource statuses.
+const (
+	// Unchanged indicates that the resource was applied, but the
+	// system already matched the resource.
+	Unchanged Status = iota
+
+	// Changed indicates that the resource made a change to the system.
+	Changed
+
+	// Failed indicates that the resource could not be applied.
+	Failed
+
+	// Skipped indicates that the resource was not applied because one
+	// of its dependencies failed.
+	Skipped
+)
+
+// String returns the lowercase name of the status.
+func (s Status) String() string {
+	switch s {
+	case Unchanged:
+		return "unchanged"
+	case Changed:
+		return "changed"
+	case Failed:
+		return "failed"
+	case Skipped:
+		return "skipped"
+	default:
+		return fmt.Sprintf("Status(%d)", int(s))
+	}
+}
+
+// parseStatus is the inverse of Status.String.
+func parseStatus(s string) (Status, error) {
+	for _, status := range []Status{Unchanged, Changed, Failed, Skipped} {
+		if s == status.String() {
+			return status, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown resource status %q", s)
+}
+
+// jsonReport is the JSON encoding of a Report.
+type jsonReport struct {
+	CatalogHash string         `json:"catalogSha256"`
+	Resources   []jsonResource `json:"resources"`
+}
+
+type jsonResource struct {
+	ID        uint64 `json:"id"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	Duration  string `json:"duration,omitempty"`
+	FailedDep uint64 `json:"failedDep,omitempty"`
+}
+
+// MarshalJSON encodes the report as a JSON object, as written by
+// mcm-exec -report.  Errors are encoded as their messages.
+func (r *Report) MarshalJSON() ([]byte, error) {
+	jr := jsonReport{
+		CatalogHash: hex.EncodeToString(r.CatalogHash[:]),
+		Resources:   make([]jsonResource, len(r.Resources)),
+	}
+	for i, rr := range r.Resources {
+		jr.Resources[i] = jsonResource{
+			ID:        rr.ID,
+			Status:    rr.Status.String(),
+			FailedDep: rr.FailedDep,
+		}
+		if rr.Err != nil {
+			jr.Resources[i].Error = rr.Err.Error()
+		}
+		if rr.Duration != 0 {
+			jr.Resources[i].Duration = rr.Duration.String()
+		}
+	}
+	return json.Marshal(jr)
+}
+
+// UnmarshalJSON decodes a report encoded by MarshalJSON.  Errors are
+// decoded as opaque errors with the same message.
+func (r *Report) UnmarshalJSON(data []byte) error {
+	var jr jsonReport
+	if err := json.Unmarshal(data, &jr); err != nil {
+		return err
+	}
+	h, err := hex.DecodeString(jr.CatalogHash)
+	if err != nil || len(h) != sha256.Size {
+		return fmt.Errorf("decode report: invalid catalog hash %q", jr.CatalogHash)
+	}
+	res := make([]Resource, len(jr.Resources))
+	for i, jrr := range jr.Resources {
+		res[i] = Resource{ID: jrr.ID, FailedDep: jrr.FailedDep}
+		res[i].Status, err = parseStatus(jrr.Status)
+		if err != nil {
+			return fmt.Errorf("decode report: resource id=%d: %v", jrr.ID, err)
+		}
+		if jrr.Error != "" {
+			res[i].Err = errors.New(jrr.Error)
+		}
+		if jrr.Duration != "" {
+			res[i].Duration, err = time.ParseDuration(jrr.Duration)
+			if err != nil {
+				return fmt.Errorf("decode report: resource id=%d: %v", jrr.ID, err)
+			}
+		}
+	}
+	copy(r.CatalogHash[:], h)
+	r.Resources = res
+	return nil
+}
+
+// Find reads JSON-encoded reports from r, as written by mcm-exec
+// -report, until it finds the one for the catalog with hash h.
+func Find(r io.Reader, h [sha256.Size]byte) (*Report, error) {
+	dec := json.NewDecoder(r)
+	for {
+		report := new(Report)
+		if err := dec.Decode(report); err == io.EOF {
+			return nil, fmt.Errorf("no report for catalog sha256:%x", h)
+		} else if err != nil {
+			return nil, fmt.Errorf("read report: %v", err)
+		}
+		if report.CatalogHash == h {
+			return report, nil
+		}
+	}
+}
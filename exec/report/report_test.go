@@ -0,0 +1,88 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJSON(t *testing.T) {
+	report := &Report{
+		Resources: []Resource{
+			{ID: 1, Status: Failed, Err: errors.New("bork"), Duration: 1500 * time.Millisecond},
+			{ID: 2, Status: Skipped, FailedDep: 1},
+			{ID: 3, Status: Changed, Duration: time.Microsecond},
+			{ID: 4, Status: Unchanged},
+		},
+	}
+	report.CatalogHash[0] = 0xab
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal("json.Marshal:", err)
+	}
+	got := new(Report)
+	if err := json.Unmarshal(data, got); err != nil {
+		t.Fatalf("json.Unmarshal(%s): %v", data, err)
+	}
+	if got.CatalogHash != report.CatalogHash {
+		t.Errorf("CatalogHash = %x; want %x", got.CatalogHash, report.CatalogHash)
+	}
+	if len(got.Resources) != len(report.Resources) {
+		t.Fatalf("Resources = %+v; want %+v", got.Resources, report.Resources)
+	}
+	for i, rr := range got.Resources {
+		want := report.Resources[i]
+		if rr.ID != want.ID || rr.Status != want.Status || rr.Duration != want.Duration || rr.FailedDep != want.FailedDep {
+			t.Errorf("Resources[%d] = %+v; want %+v", i, rr, want)
+		}
+		if (rr.Err == nil) != (want.Err == nil) || rr.Err != nil && rr.Err.Error() != want.Err.Error() {
+			t.Errorf("Resources[%d].Err = %v; want %v", i, rr.Err, want.Err)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	var r1, r2 Report
+	r1.CatalogHash[0] = 1
+	r1.Resources = []Resource{{ID: 1, Status: Changed}}
+	r2.CatalogHash[0] = 2
+	r2.Resources = []Resource{{ID: 2, Status: Unchanged}}
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	if err := enc.Encode(&r1); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode(&r2); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	got, err := Find(bytes.NewReader(data), r2.CatalogHash)
+	if err != nil {
+		t.Fatal("Find(..., r2.CatalogHash):", err)
+	}
+	if got.CatalogHash != r2.CatalogHash || len(got.Resources) != 1 || got.Resources[0].ID != 2 {
+		t.Errorf("Find(..., r2.CatalogHash) = %+v; want %+v", got, r2)
+	}
+	var missing [sha256.Size]byte
+	if _, err := Find(bytes.NewReader(data), missing); err == nil {
+		t.Error("Find(..., missing) did not return an error")
+	}
+}
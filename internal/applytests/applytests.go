@@ -19,6 +19,7 @@ package applytests
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -66,12 +67,16 @@ type SystemInfo struct {
 func Run(t *testing.T, ff FixtureFunc) {
 	t.Run("Empty", func(t *testing.T) { emptyTest(t, ff) })
 	t.Run("File", func(t *testing.T) { fileTest(t, ff) })
+	t.Run("FileThroughSymlink", func(t *testing.T) { fileThroughSymlinkTest(t, ff) })
+	t.Run("RelativePath", func(t *testing.T) { relativePathTest(t, ff) })
 	t.Run("Directory", func(t *testing.T) { dirTest(t, ff) })
 	t.Run("FileMode", func(t *testing.T) { fileModeTest(t, ff) })
 	t.Run("Noop", func(t *testing.T) { noopTest(t, ff) })
 	t.Run("NoContentFile", func(t *testing.T) { noContentFileTest(t, ff) })
+	t.Run("ContentDigestMismatch", func(t *testing.T) { contentDigestMismatchTest(t, ff) })
 	t.Run("Link", func(t *testing.T) { linkTest(t, ff) })
 	t.Run("Relink", func(t *testing.T) { relinkTest(t, ff) })
+	t.Run("HardLink", func(t *testing.T) { hardLinkTest(t, ff) })
 	t.Run("SkipFail", func(t *testing.T) { skipFailTest(t, ff) })
 	t.Run("Exec", func(t *testing.T) { execTest(t, ff) })
 	t.Run("ExecOnlyIf", func(t *testing.T) { execOnlyIfTest(t, ff) })
@@ -107,6 +112,67 @@ func emptyTest(t *testing.T, ff FixtureFunc) {
 	}
 }
 
+func relativePathTest(t *testing.T, ff FixtureFunc) {
+	t.Run("File", func(t *testing.T) {
+		ctx, f, done := startTest(t, ff, "relativePath")
+		defer done()
+
+		c, err := (&catpogs.Catalog{
+			Resources: []*catpogs.Resource{
+				{
+					ID:      42,
+					Comment: "file",
+					Which:   catalog.Resource_Which_file,
+					File:    catpogs.PlainFile("foo.txt", []byte("Hello!\n")),
+				},
+			},
+		}).ToCapnp()
+		if err != nil {
+			t.Fatalf("build catalog: %v", err)
+		}
+		if err = f.Apply(ctx, c); err == nil {
+			t.Error("run catalog did not fail as expected")
+		}
+	})
+	t.Run("ExecFileAbsent", func(t *testing.T) {
+		ctx, f, done := startTest(t, ff, "relativePathFileAbsent")
+		defer done()
+
+		info := f.SystemInfo()
+		fpath := filepath.Join(info.Root, "canary")
+		c, err := (&catpogs.Catalog{
+			Resources: []*catpogs.Resource{
+				{
+					ID:      42,
+					Comment: "touch canary",
+					Which:   catalog.Resource_Which_exec,
+					Exec: &catpogs.Exec{
+						Command: &catpogs.Command{
+							Which: catalog.Exec_Command_Which_argv,
+							Argv:  []string{info.TouchPath, fpath},
+						},
+						Condition: catpogs.ExecCondition{
+							Which:      catalog.Exec_condition_Which_fileAbsent,
+							FileAbsent: "canary",
+						},
+					},
+				},
+			},
+		}).ToCapnp()
+		if err != nil {
+			t.Fatalf("build catalog: %v", err)
+		}
+		if err = f.Apply(ctx, c); err == nil {
+			t.Error("run catalog did not fail as expected")
+		}
+		if exists, err := fileExists(ctx, f.System(), fpath); err != nil {
+			t.Error("fileExists:", err)
+		} else if exists {
+			t.Errorf("%q exists; want the command not to run", fpath)
+		}
+	})
+}
+
 func fileTest(t *testing.T, ff FixtureFunc) {
 	ctx, f, done := startTest(t, ff, "file")
 	defer done()
@@ -140,6 +206,61 @@ func fileTest(t *testing.T, ff FixtureFunc) {
 	}
 }
 
+func fileThroughSymlinkTest(t *testing.T, ff FixtureFunc) {
+	ctx, f, done := startTest(t, ff, "fileThroughSymlink")
+	defer done()
+
+	sys := f.System()
+	root := f.SystemInfo().Root
+	fpath := filepath.Join(root, "foo.txt")
+	lpath := filepath.Join(root, "link.txt")
+	const fileContent = "Hello!\n"
+	fileRes := catpogs.PlainFile(lpath, []byte(fileContent))
+	fileRes.Plain.Mode = &catpogs.FileMode{Bits: 0640}
+	c, err := (&catpogs.Catalog{
+		Resources: []*catpogs.Resource{
+			{
+				ID:      42,
+				Comment: "file",
+				Which:   catalog.Resource_Which_file,
+				File:    fileRes,
+			},
+		},
+	}).ToCapnp()
+	if err != nil {
+		t.Fatalf("build catalog: %v", err)
+	}
+	if err := system.WriteFile(ctx, sys, fpath, []byte("Goodbye\n"), 0666); err != nil {
+		t.Fatal("WriteFile:", err)
+	}
+	if err := sys.Symlink(ctx, "foo.txt", lpath); err != nil {
+		t.Fatal("Symlink:", err)
+	}
+	err = f.Apply(ctx, c)
+	if err != nil {
+		t.Errorf("run catalog: %v", err)
+	}
+	if target, err := sys.Readlink(ctx, lpath); err != nil {
+		t.Errorf("Readlink(%q): %v", lpath, err)
+	} else if target != "foo.txt" {
+		t.Errorf("Readlink(%q) = %q; want \"foo.txt\"", lpath, target)
+	}
+	gotContent, err := system.ReadFile(ctx, sys, fpath)
+	if err != nil {
+		t.Errorf("read %s: %v", fpath, err)
+	}
+	if !bytes.Equal(gotContent, []byte(fileContent)) {
+		t.Errorf("content of %s = %q; want %q", fpath, gotContent, fileContent)
+	}
+	info, err := sys.Lstat(ctx, fpath)
+	if err != nil {
+		t.Fatalf("Lstat(%q): %v", fpath, err)
+	}
+	if got := info.Mode() & os.ModePerm; got != 0640 {
+		t.Errorf("Lstat(%q).Mode()&os.ModePerm = %v; want %v", fpath, got, os.FileMode(0640))
+	}
+}
+
 func dirTest(t *testing.T, ff FixtureFunc) {
 	ctx, f, done := startTest(t, ff, "directory")
 	defer done()
@@ -448,6 +569,37 @@ func noContentFileTest(t *testing.T, ff FixtureFunc) {
 	})
 }
 
+func contentDigestMismatchTest(t *testing.T, ff FixtureFunc) {
+	ctx, f, done := startTest(t, ff, "contentDigestMismatch")
+	defer done()
+	fpath := filepath.Join(f.SystemInfo().Root, "foo.txt")
+	file := catpogs.PlainFile(fpath, []byte("Hello!\n"))
+	wrongDigest := sha256.Sum256([]byte("Goodbye!\n"))
+	file.Plain.ContentSHA256 = wrongDigest[:]
+	c, err := (&catpogs.Catalog{
+		Resources: []*catpogs.Resource{
+			{
+				ID:      42,
+				Comment: "file",
+				Which:   catalog.Resource_Which_file,
+				File:    file,
+			},
+		},
+	}).ToCapnp()
+	if err != nil {
+		t.Fatalf("build catalog: %v", err)
+	}
+	err = f.Apply(ctx, c)
+	if err == nil {
+		t.Error("run catalog did not fail as expected")
+	}
+	if exists, err := fileExists(ctx, f.System(), fpath); err != nil {
+		t.Error("fileExists:", err)
+	} else if exists {
+		t.Errorf("file %q exists; applier should not have created", fpath)
+	}
+}
+
 func linkTest(t *testing.T, ff FixtureFunc) {
 	ctx, f, done := startTest(t, ff, "link")
 	defer done()
@@ -547,6 +699,191 @@ func relinkTest(t *testing.T, ff FixtureFunc) {
 	}
 }
 
+func hardLinkTest(t *testing.T, ff FixtureFunc) {
+	t.Run("New", func(t *testing.T) {
+		ctx, f, done := startTest(t, ff, "hardLinkNew")
+		defer done()
+		root := f.SystemInfo().Root
+		fpath := filepath.Join(root, "foo")
+		lpath := filepath.Join(root, "link")
+		c, err := (&catpogs.Catalog{
+			Resources: []*catpogs.Resource{
+				{
+					ID:      42,
+					Comment: "file",
+					Which:   catalog.Resource_Which_file,
+					File:    catpogs.PlainFile(fpath, []byte("Hello")),
+				},
+				{
+					ID:      100,
+					Deps:    []uint64{42},
+					Comment: "link",
+					Which:   catalog.Resource_Which_file,
+					File:    catpogs.HardlinkFile(fpath, lpath),
+				},
+			},
+		}).ToCapnp()
+		if err != nil {
+			t.Fatalf("build catalog: %v", err)
+		}
+		err = f.Apply(ctx, c)
+		if err != nil {
+			t.Errorf("run catalog: %v", err)
+		}
+		checkHardLink(ctx, t, f.System(), fpath, lpath)
+	})
+	t.Run("Replace", func(t *testing.T) {
+		ctx, f, done := startTest(t, ff, "hardLinkReplace")
+		defer done()
+		root := f.SystemInfo().Root
+		fpath := filepath.Join(root, "foo")
+		lpath := filepath.Join(root, "link")
+		c, err := (&catpogs.Catalog{
+			Resources: []*catpogs.Resource{
+				{
+					ID:      42,
+					Comment: "link",
+					Which:   catalog.Resource_Which_file,
+					File:    catpogs.HardlinkFile(fpath, lpath),
+				},
+			},
+		}).ToCapnp()
+		if err != nil {
+			t.Fatalf("build catalog: %v", err)
+		}
+		sys := f.System()
+		if err := system.WriteFile(ctx, sys, fpath, []byte("File 1"), 0666); err != nil {
+			t.Fatal("WriteFile 1:", err)
+		}
+		if err := system.WriteFile(ctx, sys, lpath, []byte("File 2"), 0666); err != nil {
+			t.Fatal("WriteFile 2:", err)
+		}
+		err = f.Apply(ctx, c)
+		if err != nil {
+			t.Errorf("run catalog: %v", err)
+		}
+		checkHardLink(ctx, t, sys, fpath, lpath)
+	})
+	t.Run("SymlinkTarget", func(t *testing.T) {
+		ctx, f, done := startTest(t, ff, "hardLinkSymlinkTarget")
+		defer done()
+		root := f.SystemInfo().Root
+		fpath := filepath.Join(root, "foo")
+		spath := filepath.Join(root, "symlink")
+		lpath := filepath.Join(root, "link")
+		c, err := (&catpogs.Catalog{
+			Resources: []*catpogs.Resource{
+				{
+					ID:      42,
+					Comment: "link",
+					Which:   catalog.Resource_Which_file,
+					File:    catpogs.HardlinkFile(spath, lpath),
+				},
+			},
+		}).ToCapnp()
+		if err != nil {
+			t.Fatalf("build catalog: %v", err)
+		}
+		sys := f.System()
+		if err := system.WriteFile(ctx, sys, fpath, []byte("File 1"), 0666); err != nil {
+			t.Fatal("WriteFile:", err)
+		}
+		if err := sys.Symlink(ctx, fpath, spath); err != nil {
+			t.Fatal("Symlink:", err)
+		}
+		err = f.Apply(ctx, c)
+		if err == nil {
+			t.Error("run catalog did not fail as expected")
+		}
+		if _, err := sys.Lstat(ctx, lpath); !os.IsNotExist(err) {
+			t.Errorf("Lstat(%q) = _, %v; want not exist", lpath, err)
+		}
+	})
+	t.Run("DanglingSymlinkTarget", func(t *testing.T) {
+		ctx, f, done := startTest(t, ff, "hardLinkDanglingSymlinkTarget")
+		defer done()
+		root := f.SystemInfo().Root
+		spath := filepath.Join(root, "symlink")
+		lpath := filepath.Join(root, "link")
+		c, err := (&catpogs.Catalog{
+			Resources: []*catpogs.Resource{
+				{
+					ID:      42,
+					Comment: "link",
+					Which:   catalog.Resource_Which_file,
+					File:    catpogs.HardlinkFile(spath, lpath),
+				},
+			},
+		}).ToCapnp()
+		if err != nil {
+			t.Fatalf("build catalog: %v", err)
+		}
+		sys := f.System()
+		if err := sys.Symlink(ctx, filepath.Join(root, "nonexistent"), spath); err != nil {
+			t.Fatal("Symlink:", err)
+		}
+		err = f.Apply(ctx, c)
+		if err == nil {
+			t.Error("run catalog did not fail as expected")
+		}
+		if _, err := sys.Lstat(ctx, lpath); !os.IsNotExist(err) {
+			t.Errorf("Lstat(%q) = _, %v; want not exist", lpath, err)
+		}
+	})
+	t.Run("NotRegular", func(t *testing.T) {
+		ctx, f, done := startTest(t, ff, "hardLinkNotRegular")
+		defer done()
+		root := f.SystemInfo().Root
+		fpath := filepath.Join(root, "foo")
+		lpath := filepath.Join(root, "link")
+		c, err := (&catpogs.Catalog{
+			Resources: []*catpogs.Resource{
+				{
+					ID:      42,
+					Comment: "link",
+					Which:   catalog.Resource_Which_file,
+					File:    catpogs.HardlinkFile(fpath, lpath),
+				},
+			},
+		}).ToCapnp()
+		if err != nil {
+			t.Fatalf("build catalog: %v", err)
+		}
+		sys := f.System()
+		if err := system.WriteFile(ctx, sys, fpath, []byte("File 1"), 0666); err != nil {
+			t.Fatal("WriteFile:", err)
+		}
+		if err := sys.Mkdir(ctx, lpath, 0777); err != nil {
+			t.Fatal("Mkdir:", err)
+		}
+		err = f.Apply(ctx, c)
+		if err == nil {
+			t.Error("run catalog did not fail as expected")
+		}
+		if info, err := sys.Lstat(ctx, lpath); err != nil {
+			t.Errorf("Lstat(%q): %v", lpath, err)
+		} else if !info.IsDir() {
+			t.Errorf("Lstat(%q).Mode() = %v; want directory", lpath, info.Mode())
+		}
+	})
+}
+
+func checkHardLink(ctx context.Context, t *testing.T, fs system.FS, fpath, lpath string) {
+	finfo, err := fs.Lstat(ctx, fpath)
+	if err != nil {
+		t.Errorf("Lstat(%q): %v", fpath, err)
+		return
+	}
+	linfo, err := fs.Lstat(ctx, lpath)
+	if err != nil {
+		t.Errorf("Lstat(%q): %v", lpath, err)
+		return
+	}
+	if !fs.SameFile(finfo, linfo) {
+		t.Errorf("%s and %s are not the same file", fpath, lpath)
+	}
+}
+
 func skipFailTest(t *testing.T, ff FixtureFunc) {
 	ctx, f, done := startTest(t, ff, "skipFail")
 	defer done()
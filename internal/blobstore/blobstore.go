@@ -0,0 +1,146 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package blobstore fetches file content by SHA-256 digest.
+//
+// A catalog may give a plain file's content as a digest instead of
+// inline bytes, so that large or widely shared files are stored once
+// outside the catalog.  A store is either a directory or a tar file
+// whose regular files are named by the lowercase hex digest of their
+// content, like:
+//
+//	blobs/
+//	  2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824
+//	  ...
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Digest is a SHA-256 digest of a blob's content.
+type Digest [sha256.Size]byte
+
+// Sum returns the digest of data.
+func Sum(data []byte) Digest {
+	return Digest(sha256.Sum256(data))
+}
+
+// DigestFromBytes converts a raw digest, like catalog.File.plain.contentSha256,
+// to a Digest.
+func DigestFromBytes(b []byte) (Digest, error) {
+	var d Digest
+	if len(b) != len(d) {
+		return Digest{}, fmt.Errorf("digest is %d bytes; want %d", len(b), len(d))
+	}
+	copy(d[:], b)
+	return d, nil
+}
+
+// String returns the digest in lowercase hex.
+func (d Digest) String() string {
+	return hex.EncodeToString(d[:])
+}
+
+// A Store retrieves blobs by digest.  A Store must be safe to call from
+// multiple goroutines.
+type Store interface {
+	// Open returns the content of the blob with the given digest.
+	// If the store does not have the blob, then the error satisfies
+	// os.IsNotExist.  Open does not verify the content: see Fetch.
+	Open(ctx context.Context, d Digest) (io.ReadCloser, error)
+}
+
+// Fetch reads the blob with the given digest from s and checks that
+// the content matches the digest.  If it doesn't, then Fetch returns a
+// *MismatchError.
+func Fetch(ctx context.Context, s Store, d Digest) ([]byte, error) {
+	r, err := s.Open(ctx, d)
+	if err != nil {
+		return nil, fmt.Errorf("fetch blob %v: %v", d, err)
+	}
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return nil, fmt.Errorf("fetch blob %v: %v", d, err)
+	}
+	if got := Sum(data); got != d {
+		return nil, &MismatchError{Want: d, Got: got}
+	}
+	return data, nil
+}
+
+// MismatchError is returned by Fetch when a store's blob does not
+// have the content its digest names.
+type MismatchError struct {
+	Want Digest
+	Got  Digest
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("fetch blob %v: content has digest %v", e.Want, e.Got)
+}
+
+// Dir is a Store that reads blobs from files in a directory.
+type Dir string
+
+// Open opens the file in dir named by d.
+func (dir Dir) Open(ctx context.Context, d Digest) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(dir), d.String()))
+}
+
+// StoreCloser is a Store that holds resources until it is closed.
+type StoreCloser interface {
+	Store
+	io.Closer
+}
+
+// Open opens the store at path: a Dir if path is a directory, or a
+// tar bundle otherwise.
+func Open(path string) (StoreCloser, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("open blob store: %v", err)
+	}
+	if info.IsDir() {
+		return nopCloser{Dir(path)}, nil
+	}
+	return OpenTar(path)
+}
+
+type nopCloser struct {
+	Store
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// isDigestName reports whether name is a digest in lowercase hex.
+func isDigestName(name string) bool {
+	if len(name) != hex.EncodedLen(sha256.Size) {
+		return false
+	}
+	return bytes.IndexFunc([]byte(name), func(c rune) bool {
+		return !('0' <= c && c <= '9' || 'a' <= c && c <= 'f')
+	}) == -1
+}
@@ -0,0 +1,219 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blobstore
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStores(t *testing.T) {
+	hello := []byte("Hello, World!\n")
+	bye := []byte("Goodbye\n")
+	bogus := Sum([]byte("something else"))
+	missing := Sum([]byte("missing"))
+	files := map[string][]byte{
+		Sum(hello).String(): hello,
+		bogus.String():      bye,
+		"README":            []byte("not a blob\n"),
+	}
+
+	dir, err := ioutil.TempDir("", "mcm_blobstore_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	blobDir := filepath.Join(dir, "blobs")
+	if err := os.Mkdir(blobDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(blobDir, name), data, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tarPath := filepath.Join(dir, "blobs.tar")
+	if err := writeTar(tarPath, files); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{blobDir, tarPath} {
+		s, err := Open(path)
+		if err != nil {
+			t.Errorf("Open(%q): %v", path, err)
+			continue
+		}
+		ctx := context.Background()
+		if got, err := Fetch(ctx, s, Sum(hello)); err != nil {
+			t.Errorf("%s: Fetch(hello): %v", path, err)
+		} else if string(got) != string(hello) {
+			t.Errorf("%s: Fetch(hello) = %q; want %q", path, got, hello)
+		}
+		if _, err := Fetch(ctx, s, bogus); err == nil {
+			t.Errorf("%s: Fetch of corrupt blob did not return an error", path)
+		} else if e, ok := err.(*MismatchError); !ok || e.Want != bogus || e.Got != Sum(bye) {
+			t.Errorf("%s: Fetch of corrupt blob error = %v; want MismatchError", path, err)
+		}
+		if _, err := s.Open(ctx, missing); !os.IsNotExist(err) {
+			t.Errorf("%s: Open(missing) error = %v; want not exist", path, err)
+		}
+		if err := s.Close(); err != nil {
+			t.Errorf("%s: Close: %v", path, err)
+		}
+	}
+}
+
+func writeTar(path string, files map[string][]byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(f)
+	err = tw.WriteHeader(&tar.Header{Name: "blobs/", Typeflag: tar.TypeDir, Mode: 0777})
+	for name, data := range files {
+		if err != nil {
+			break
+		}
+		hdr := &tar.Header{
+			Name:     "blobs/" + name,
+			Typeflag: tar.TypeReg,
+			Mode:     0666,
+			Size:     int64(len(data)),
+		}
+		if err = tw.WriteHeader(hdr); err == nil {
+			_, err = tw.Write(data)
+		}
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func TestTarExtendedHeaders(t *testing.T) {
+	blobs := [][]byte{
+		[]byte("Hello, World!\n"),
+		[]byte("Goodbye\n"),
+		bytes.Repeat([]byte("0123456789abcdef"), 100),
+	}
+	longDir := strings.Repeat("very-long-directory-name/", 8)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	// A non-ASCII name can only be stored in a PAX header.
+	if err := writeTarFile(tw, "blobs/\u00fcber/"+Sum(blobs[0]).String(), blobs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// tar.Writer can't write GNU long name headers, so write one by hand.
+	longName := longDir + Sum(blobs[1]).String()
+	buf.Write(gnuLongNameHeader(longName))
+	buf.WriteString(longName)
+	buf.Write(make([]byte, -len(longName)&511))
+	tw = tar.NewWriter(&buf)
+	if err := writeTarFile(tw, "placeholder", blobs[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTarFile(tw, longDir+"x/"+Sum(blobs[2]).String(), blobs[2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "mcm_blobstore_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tarPath := filepath.Join(dir, "blobs.tar")
+	if err := ioutil.WriteFile(tarPath, buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenTar(tarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+	for i, want := range blobs {
+		got, err := Fetch(ctx, s, Sum(want))
+		if err != nil {
+			t.Errorf("Fetch(blobs[%d]): %v", i, err)
+		} else if !bytes.Equal(got, want) {
+			t.Errorf("Fetch(blobs[%d]) = %q; want %q", i, got, want)
+		}
+	}
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0666,
+		Size:     int64(len(data)),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// gnuLongNameHeader returns a GNU tar header block that sets the name
+// of the next entry to name.
+func gnuLongNameHeader(name string) []byte {
+	b := make([]byte, 512)
+	copy(b[0:100], "././@LongLink")
+	copy(b[100:108], "0000644\x00")
+	copy(b[108:116], "0000000\x00")
+	copy(b[116:124], "0000000\x00")
+	copy(b[124:136], fmt.Sprintf("%011o\x00", len(name)))
+	copy(b[136:148], "00000000000\x00")
+	b[156] = tar.TypeGNULongName
+	copy(b[257:265], "ustar  \x00")
+	copy(b[148:156], "        ")
+	var sum int
+	for _, c := range b {
+		sum += int(c)
+	}
+	copy(b[148:156], fmt.Sprintf("%06o\x00 ", sum))
+	return b
+}
+
+func TestDigestFromBytes(t *testing.T) {
+	want := Sum([]byte("abc"))
+	if got, err := DigestFromBytes(want[:]); err != nil || got != want {
+		t.Errorf("DigestFromBytes(%x) = %v, %v; want %v, <nil>", want[:], got, err, want)
+	}
+	if _, err := DigestFromBytes(want[:5]); err == nil {
+		t.Error("DigestFromBytes of short slice did not return an error")
+	}
+	const wantString = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if got := want.String(); got != wantString {
+		t.Errorf("String() = %q; want %q", got, wantString)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blobstore
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// Tar is a Store that reads blobs from a tar file.  Entries are matched
+// by their base name, so a bundle may be made with a directory prefix,
+// like "tar -cf blobs.tar blobs/".  Entries that are not regular files
+// or not named by a digest are ignored.
+type Tar struct {
+	f       *os.File
+	entries map[Digest]tarEntry
+}
+
+type tarEntry struct {
+	offset int64
+	size   int64
+}
+
+// OpenTar opens the tar file at path and indexes its entries.
+func OpenTar(path string) (*Tar, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open blob store: %v", err)
+	}
+	entries, err := indexTar(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("open blob store %s: %v", path, err)
+	}
+	return &Tar{f: f, entries: entries}, nil
+}
+
+func indexTar(f *os.File) (map[Digest]tarEntry, error) {
+	entries := make(map[Digest]tarEntry)
+	cr := &countingReader{r: f}
+	tr := tar.NewReader(cr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		name := path.Base(hdr.Name)
+		if !isDigestName(name) {
+			continue
+		}
+		var d Digest
+		hex.Decode(d[:], []byte(name))
+		if _, dup := entries[d]; dup {
+			continue
+		}
+		// Next has consumed the entry's headers (including any PAX or
+		// GNU long name headers before it) and nothing more, so the
+		// entry's content starts where the reader left off.  Fetch
+		// checks blobs against their digests, so were that ever not
+		// the case, the store would fail rather than return bad data.
+		entries[d] = tarEntry{offset: cr.n, size: hdr.Size}
+	}
+}
+
+// countingReader tracks the offset of the next byte that will be read
+// from r.  It implements io.Seeker so that tar.Reader can skip over
+// content without reading it.
+type countingReader struct {
+	r io.ReadSeeker
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func (cr *countingReader) Seek(offset int64, whence int) (int64, error) {
+	n, err := cr.r.Seek(offset, whence)
+	if err != nil {
+		return n, err
+	}
+	cr.n = n
+	return n, nil
+}
+
+// Open returns a reader for the entry named by d.
+func (t *Tar) Open(ctx context.Context, d Digest) (io.ReadCloser, error) {
+	ent, ok := t.entries[d]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: t.f.Name() + ":" + d.String(), Err: os.ErrNotExist}
+	}
+	return ioutil.NopCloser(io.NewSectionReader(t.f, ent.offset, ent.size)), nil
+}
+
+// Close closes the tar file.
+func (t *Tar) Close() error {
+	return t.f.Close()
+}
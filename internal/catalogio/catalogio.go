@@ -0,0 +1,285 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package catalogio reads and writes catalogs for the mcm command-line
+// tools.
+package catalogio
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/internal/catjson"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto/encoding/text"
+	"github.com/zombiezen/mcm/third_party/golang/zstd"
+)
+
+// Input formats.
+const (
+	Binary = "binary" // Cap'n Proto binary message
+	Packed = "packed" // Cap'n Proto packed binary message
+	Text   = "text"   // Cap'n Proto text format, as written by mcm-cat
+	JSON   = "json"   // see package catjson
+	YAML   = "yaml"   // see package catjson
+)
+
+var formats = []string{Binary, Packed, Text, JSON, YAML}
+
+// DefaultReadLimit is the read limit used when Options.ReadLimit is
+// zero.
+const DefaultReadLimit = 64 << 20 // 64 MiB
+
+// Options controls how a catalog is read.  The zero value reads a
+// binary catalog with the default read limit.
+type Options struct {
+	// Format is one of the input formats.  The empty string means
+	// Binary.  A catalog in any format may be compressed with gzip or
+	// zstd, which is detected from its first bytes.  Packed catalogs
+	// are not detected, since the packed encoding has no header that
+	// reliably tells it apart from an unpacked message.
+	Format string
+
+	// ReadLimit bounds the memory that a malformed or hostile catalog
+	// can make a tool use.  It is the maximum number of bytes of the
+	// catalog that can be traversed, as well as the maximum size of a
+	// catalog that must be read into memory, like a text catalog or a
+	// binary catalog read from a pipe.  Compressed catalogs are limited
+	// by their decompressed size.  Uncompressed binary catalog files are
+	// mapped into memory instead, so their size is not limited.  Zero
+	// means DefaultReadLimit.
+	ReadLimit uint64
+}
+
+func (opts *Options) format() string {
+	if opts == nil || opts.Format == "" {
+		return Binary
+	}
+	return opts.Format
+}
+
+func (opts *Options) readLimit() uint64 {
+	if opts == nil || opts.ReadLimit == 0 {
+		return DefaultReadLimit
+	}
+	return opts.ReadLimit
+}
+
+// Flags defines -input-format and -read-limit flags on fs and returns
+// the Options that they set.
+func Flags(fs *flag.FlagSet) *Options {
+	opts := new(Options)
+	fs.StringVar(&opts.Format, "input-format", Binary, "catalog format: "+strings.Join(formats, ", ")+
+		"; gzip and zstd compression are detected, but packed must be given explicitly")
+	ReadLimitFlag(fs, &opts.ReadLimit)
+	return opts
+}
+
+// ReadLimitFlag defines a -read-limit flag on fs that sets *limit.
+// The flag accepts a number of bytes with an optional K, M, or G
+// suffix.
+func ReadLimitFlag(fs *flag.FlagSet, limit *uint64) {
+	fs.Var((*byteSize)(limit), "read-limit", "maximum bytes of the catalog to read, like 64M (default 64M)")
+}
+
+// Read reads a single catalog from r.  opts may be nil, which is the
+// same as a zero Options.  It is an error for r to hold more than one
+// catalog; use a Stream to read them all.
+func Read(r io.Reader, opts *Options) (catalog.Catalog, error) {
+	return readOne(NewStream(r, opts))
+}
+
+// ReadFile reads a single catalog from the named file, or from stdin
+// if path is empty.  opts may be nil, which is the same as a zero
+// Options.  It is an error for the file to hold more than one catalog;
+// use OpenStream to read them all.
+//
+// An uncompressed binary catalog in a regular file is not read all at
+// once: its segments are loaded as the catalog is traversed, so memory use
+// depends on how much of the catalog is used rather than on its size.
+// The file stays open (or mapped) for the rest of the process, since
+// data from the catalog can refer to it.
+func ReadFile(path string, opts *Options) (catalog.Catalog, error) {
+	s, err := OpenStream(path, opts)
+	if err != nil {
+		return catalog.Catalog{}, err
+	}
+	defer s.Close()
+	return readOne(s)
+}
+
+func readOne(s *Stream) (catalog.Catalog, error) {
+	c, err := s.Next()
+	if err == io.EOF {
+		return catalog.Catalog{}, errors.New("read catalog: no catalog in input")
+	}
+	if err != nil {
+		return catalog.Catalog{}, err
+	}
+	if _, err := s.Next(); err == nil {
+		return catalog.Catalog{}, errors.New("read catalog: input has more than one catalog")
+	} else if err != io.EOF {
+		return catalog.Catalog{}, err
+	}
+	return c, nil
+}
+
+// readDocument reads a catalog in one of the text-based formats from r.
+func readDocument(r io.Reader, format string, limit uint64) (catalog.Catalog, error) {
+	data, err := readAll(r, limit)
+	if err != nil {
+		return catalog.Catalog{}, err
+	}
+	var c catalog.Catalog
+	switch format {
+	case Text:
+		c, err = readText(data)
+	case JSON:
+		c, err = catjson.Unmarshal(data)
+	case YAML:
+		c, err = catjson.UnmarshalYAML(data)
+	default:
+		return catalog.Catalog{}, fmt.Errorf("read catalog: unknown format %q", format)
+	}
+	if err != nil {
+		return catalog.Catalog{}, fmt.Errorf("read catalog: %v", err)
+	}
+	msg := c.Segment().Message()
+	msg.TraverseLimit = limit
+	msg.ReadLimiter().Reset(limit)
+	return c, nil
+}
+
+func readBinary(dec *capnp.Decoder, limit uint64) (catalog.Catalog, error) {
+	dec.MaxMessageSize = limit
+	msg, err := dec.Decode()
+	if err == io.EOF {
+		return catalog.Catalog{}, io.EOF
+	}
+	if err != nil {
+		return catalog.Catalog{}, fmt.Errorf("read catalog: %v", err)
+	}
+	msg.TraverseLimit = limit
+	c, err := catalog.ReadRootCatalog(msg)
+	if err != nil {
+		return catalog.Catalog{}, fmt.Errorf("read catalog: %v", err)
+	}
+	return c, nil
+}
+
+// ReadBytes reads the named file, or stdin if path is empty, without
+// parsing it.  It fails if the file is larger than the read limit.
+// This is for verifying the exact bytes of a catalog before passing
+// them to Read.
+func ReadBytes(path string, opts *Options) ([]byte, error) {
+	if path == "" {
+		return readAll(os.Stdin, opts.readLimit())
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readAll(f, opts.readLimit())
+}
+
+// ResetReadLimit gives the message that c belongs to a fresh read
+// budget: its TraverseLimit, or DefaultReadLimit if it has none.
+// Hashing or checking a catalog traverses all of it, so call
+// ResetReadLimit after doing so and before applying or drawing the
+// catalog, or else a catalog that is within the limit can fail to be
+// read the second time.
+func ResetReadLimit(c catalog.Catalog) {
+	seg := c.Segment()
+	if seg == nil {
+		return
+	}
+	msg := seg.Message()
+	limit := msg.TraverseLimit
+	if limit == 0 {
+		limit = DefaultReadLimit
+	}
+	msg.ReadLimiter().Reset(limit)
+}
+
+// Magic numbers at the start of compressed catalogs.  Neither can
+// start a valid catalog in any of the input formats.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompress returns a reader for the decompressed content of r if r
+// starts with a gzip or zstd header, or a reader for r's content if
+// it does not.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("read catalog: %v", err)
+		}
+		return zr, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		return zstd.NewReader(br), nil
+	default:
+		return br, nil
+	}
+}
+
+// isCompressedFile reports whether f starts with a gzip or zstd
+// header.  It does not change f's offset.
+func isCompressedFile(f *os.File) bool {
+	magic := make([]byte, len(zstdMagic))
+	n, _ := f.ReadAt(magic, 0)
+	magic = magic[:n]
+	return bytes.HasPrefix(magic, gzipMagic) || bytes.HasPrefix(magic, zstdMagic)
+}
+
+func readAll(r io.Reader, limit uint64) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, fmt.Errorf("read catalog: %v", err)
+	}
+	if uint64(len(data)) > limit {
+		return nil, fmt.Errorf("read catalog: catalog larger than read limit of %v", byteSize(limit))
+	}
+	return data, nil
+}
+
+func readText(data []byte) (catalog.Catalog, error) {
+	_, seg, err := capnp.NewMessage(capnp.SingleSegment(nil))
+	if err != nil {
+		return catalog.Catalog{}, err
+	}
+	c, err := catalog.NewRootCatalog(seg)
+	if err != nil {
+		return catalog.Catalog{}, err
+	}
+	if err := text.Unmarshal(catalog.Catalog_TypeID, c.Struct, data); err != nil {
+		return catalog.Catalog{}, err
+	}
+	return c, nil
+}
@@ -0,0 +1,401 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalogio
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/internal/catpogs"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto/encoding/text"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto/pogs"
+)
+
+// symlinkCatalogText is the text format of symlinkCatalog.
+const symlinkCatalogText = `(resources = [(id = 42, comment = "hi", dependencies = [], locks = [], file = (path = "/foo", symlink = (target = "/bar")))], exports = [], imports = [])`
+
+func symlinkCatalog(t *testing.T) catalog.Catalog {
+	c, err := (&catpogs.Catalog{
+		Resources: []*catpogs.Resource{{
+			ID:      42,
+			Comment: "hi",
+			Which:   catalog.Resource_Which_file,
+			File:    catpogs.SymlinkFile("/bar", "/foo"),
+		}},
+	}).ToCapnp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestRead(t *testing.T) {
+	const want = symlinkCatalogText
+	c := symlinkCatalog(t)
+	bin, err := c.Segment().Message().Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantHash, err := catalog.Hash(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		format string
+		data   []byte
+	}{
+		{Binary, bin},
+		{Text, []byte(`(resources = [(id = 42, comment = "hi", file = (path = "/foo", symlink = (target = "/bar")))])`)},
+		{JSON, []byte(`{"resources": [{"id": 42, "comment": "hi", "file": {"path": "/foo", "symlink": {"target": "/bar"}}}]}`)},
+		{YAML, []byte("resources:\n- id: 42\n  comment: hi\n  file:\n    path: /foo\n    symlink: {target: /bar}\n")},
+	}
+	for _, test := range tests {
+		c, err := Read(bytes.NewReader(test.data), &Options{Format: test.format})
+		if err != nil {
+			t.Errorf("Read(..., %q): %v", test.format, err)
+			continue
+		}
+		got, err := text.Marshal(catalog.Catalog_TypeID, c.Struct)
+		if err != nil {
+			t.Errorf("Read(..., %q): marshal result: %v", test.format, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("Read(..., %q) = %s; want %s", test.format, got, want)
+		}
+		if h, err := catalog.Hash(c); err != nil {
+			t.Errorf("catalog.Hash(Read(..., %q)): %v", test.format, err)
+		} else if h != wantHash {
+			t.Errorf("catalog.Hash(Read(..., %q)) = %x; want %x", test.format, h, wantHash)
+		}
+	}
+}
+
+func TestReadCompressed(t *testing.T) {
+	c := symlinkCatalog(t)
+	bin, err := c.Segment().Message().Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed, err := c.Segment().Message().MarshalPacked()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		format string
+		data   []byte
+	}{
+		{"packed", Packed, packed},
+		{"gzip", Binary, gzipBytes(t, bin)},
+		{"gzip packed", Packed, gzipBytes(t, packed)},
+		{"gzip text", Text, gzipBytes(t, []byte(symlinkCatalogText))},
+		{
+			// symlinkCatalog compressed with the zstd command.
+			name:   "zstd",
+			format: Binary,
+			data: []byte{
+				0x28, 0xb5, 0x2f, 0xfd, 0x24, 0x98, 0xd5, 0x01, 0x00, 0xc2, 0xc5, 0x0a,
+				0x11, 0xb0, 0xa9, 0x35, 0xe0, 0x18, 0x31, 0x7e, 0x98, 0x43, 0x69, 0x36,
+				0x49, 0xf0, 0x2c, 0x91, 0x44, 0xa6, 0x9f, 0x10, 0xc5, 0xfd, 0x39, 0xc6,
+				0x99, 0x07, 0xff, 0xfa, 0x91, 0xa3, 0xa5, 0xe1, 0xe5, 0x4f, 0x7f, 0xb3,
+				0x02, 0xb7, 0xf7, 0xb2, 0xfe, 0x0b, 0x1f, 0x04, 0x00, 0xae, 0x07, 0xad,
+				0x14, 0xa3, 0xa5, 0xba, 0xcc, 0x03, 0x62, 0x89, 0x05, 0x71, 0xa8,
+			},
+		},
+	}
+	for _, test := range tests {
+		c, err := Read(bytes.NewReader(test.data), &Options{Format: test.format})
+		if err != nil {
+			t.Errorf("%s: Read: %v", test.name, err)
+			continue
+		}
+		got, err := text.Marshal(catalog.Catalog_TypeID, c.Struct)
+		if err != nil {
+			t.Errorf("%s: marshal result: %v", test.name, err)
+			continue
+		}
+		if got != symlinkCatalogText {
+			t.Errorf("%s: Read(...) = %s; want %s", test.name, got, symlinkCatalogText)
+		}
+	}
+}
+
+func TestReadCompressedLimit(t *testing.T) {
+	// Compression must not get around the read limit.
+	data := gzipBytes(t, make([]byte, 1<<20))
+	if _, err := Read(bytes.NewReader(data), &Options{Format: Text, ReadLimit: 64 << 10}); err == nil {
+		t.Error("Read of large compressed catalog succeeded")
+	}
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadErrors(t *testing.T) {
+	if _, err := Read(strings.NewReader("{}"), &Options{Format: "xml"}); err == nil {
+		t.Error("Read with unknown format succeeded")
+	}
+	if _, err := Read(strings.NewReader("{}"), nil); err == nil {
+		t.Error("Read of JSON as binary succeeded")
+	}
+	big := strings.NewReader(strings.Repeat(" ", 1024) + "{}")
+	if _, err := Read(big, &Options{Format: JSON, ReadLimit: 1024}); err == nil {
+		t.Error("Read of oversized catalog succeeded")
+	}
+}
+
+// bigCatalog returns a multi-segment message with n plain files of
+// size bytes each.  size must be less than 4096, since the capnp
+// package's multi-segment arena corrupts larger data.
+func bigCatalog(t *testing.T, n, size int) []byte {
+	_, seg, err := capnp.NewMessage(capnp.MultiSegment(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := catalog.NewRootCatalog(seg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc := new(catpogs.Catalog)
+	for i := 0; i < n; i++ {
+		content := bytes.Repeat([]byte{byte('a' + i)}, size)
+		pc.Resources = append(pc.Resources, &catpogs.Resource{
+			ID:      uint64(i + 1),
+			Comment: "file",
+			Which:   catalog.Resource_Which_file,
+			File:    catpogs.PlainFile("/big", content),
+		})
+	}
+	if err := pogs.Insert(catalog.Catalog_TypeID, root.Struct, pc); err != nil {
+		t.Fatal(err)
+	}
+	data, err := seg.Message().Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestReadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalogio_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := bigCatalog(t, 8, 4000)
+	path := filepath.Join(dir, "big.catalog")
+	if err := ioutil.WriteFile(path, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := ReadFile(path, &Options{ReadLimit: 1 << 20})
+	if err != nil {
+		t.Fatal("ReadFile:", err)
+	}
+	res, err := c.Resources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Len() != 8 {
+		t.Fatalf("len(resources) = %d; want 8", res.Len())
+	}
+	for i := 0; i < res.Len(); i++ {
+		f, err := res.At(i).File()
+		if err != nil {
+			t.Fatalf("resources[%d].file: %v", i, err)
+		}
+		content, err := f.Plain().Content()
+		if err != nil {
+			t.Fatalf("resources[%d].file.plain.content: %v", i, err)
+		}
+		if want := bytes.Repeat([]byte{byte('a' + i)}, 4000); !bytes.Equal(content, want) {
+			t.Errorf("resources[%d].file.plain.content differs", i)
+		}
+	}
+
+	// A small read limit stops traversal.
+	c, err = ReadFile(path, &Options{ReadLimit: 16 << 10})
+	if err != nil {
+		t.Fatal("ReadFile:", err)
+	}
+	if err := readAllContent(c); err == nil {
+		t.Error("reading content past the read limit succeeded")
+	}
+
+	// A truncated message is an error.
+	truncPath := filepath.Join(dir, "truncated.catalog")
+	if err := ioutil.WriteFile(truncPath, data[:len(data)-8], 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFile(truncPath, nil); err == nil {
+		t.Error("ReadFile of truncated catalog succeeded")
+	}
+}
+
+func TestReadBytes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalogio_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := bigCatalog(t, 8, 4000)
+	path := filepath.Join(dir, "big.catalog")
+	if err := ioutil.WriteFile(path, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadBytes(path, nil)
+	if err != nil {
+		t.Fatal("ReadBytes:", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("ReadBytes returned different bytes than the file")
+	}
+	if _, err := ReadBytes(path, &Options{ReadLimit: uint64(len(data) - 1)}); err == nil {
+		t.Error("ReadBytes of file larger than read limit succeeded")
+	}
+}
+
+func readAllContent(c catalog.Catalog) error {
+	res, err := c.Resources()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < res.Len(); i++ {
+		f, err := res.At(i).File()
+		if err != nil {
+			return err
+		}
+		if _, err := f.Plain().Content(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestResetReadLimit(t *testing.T) {
+	t.Run("Default", func(t *testing.T) {
+		c := symlinkCatalog(t)
+		c.Segment().Message().ReadLimiter().Reset(0)
+		if _, err := c.Resources(); err == nil {
+			t.Fatal("c.Resources() with an exhausted read limit succeeded")
+		}
+		ResetReadLimit(c)
+		if _, err := c.Resources(); err != nil {
+			t.Error("after ResetReadLimit, c.Resources():", err)
+		}
+	})
+	t.Run("TraverseLimit", func(t *testing.T) {
+		c := symlinkCatalog(t)
+		msg := c.Segment().Message()
+		msg.TraverseLimit = 1
+		msg.ReadLimiter().Reset(0)
+		ResetReadLimit(c)
+		if _, err := c.Resources(); err == nil {
+			t.Error("after ResetReadLimit, c.Resources() succeeded; want TraverseLimit of 1 byte to be used")
+		}
+	})
+	t.Run("Zero", func(t *testing.T) {
+		// Must not panic.
+		ResetReadLimit(catalog.Catalog{})
+	})
+}
+
+// countingReaderAt counts the bytes read through it.
+type countingReaderAt struct {
+	r *bytes.Reader
+	n int
+}
+
+func (cr *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := cr.r.ReadAt(p, off)
+	cr.n += n
+	return n, err
+}
+
+func TestSegmentArena(t *testing.T) {
+	data := bigCatalog(t, 8, 4000)
+	r := &countingReaderAt{r: bytes.NewReader(data)}
+	arena, err := newSegmentArena(r, int64(len(data)))
+	if err != nil {
+		t.Fatal("newSegmentArena:", err)
+	}
+	if arena.NumSegments() < 2 {
+		t.Fatalf("message has %d segments; want several", arena.NumSegments())
+	}
+	c, err := catalog.ReadRootCatalog(&capnp.Message{Arena: arena})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.Resources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := res.At(0).Comment(); err != nil {
+		t.Fatal(err)
+	}
+	if r.n > len(data)/2 {
+		t.Errorf("reading the first resource read %d of %d bytes", r.n, len(data))
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		want uint64
+	}{
+		{"0", 0},
+		{"1000", 1000},
+		{"64K", 64 << 10},
+		{"64M", 64 << 20},
+		{"2G", 2 << 30},
+	}
+	for _, test := range tests {
+		var b byteSize
+		if err := b.Set(test.s); err != nil {
+			t.Errorf("Set(%q): %v", test.s, err)
+			continue
+		}
+		if uint64(b) != test.want {
+			t.Errorf("Set(%q) = %d; want %d", test.s, uint64(b), test.want)
+		}
+		if got := b.String(); got != test.s {
+			t.Errorf("byteSize(%d).String() = %q; want %q", test.want, got, test.s)
+		}
+	}
+	for _, s := range []string{"", "K", "-1", "1.5M", "99999999999G"} {
+		var b byteSize
+		if err := b.Set(s); err == nil {
+			t.Errorf("Set(%q) = nil; want error", s)
+		}
+	}
+}
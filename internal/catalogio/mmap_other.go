@@ -0,0 +1,32 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package catalogio
+
+import (
+	"errors"
+	"os"
+)
+
+// mapFile always fails on this platform, so catalogs are read from the
+// file on demand instead.
+func mapFile(f *os.File, size int64) ([]byte, error) {
+	return nil, errors.New("memory mapping not supported")
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
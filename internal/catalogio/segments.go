@@ -0,0 +1,155 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalogio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/zombiezen/mcm/third_party/golang/capnproto"
+)
+
+// maxSegments is the largest number of segments that a message can
+// have.  It matches the capnp package's limit for streams.
+const maxSegments = 512
+
+// segmentArena is a read-only capnp.Arena for a message in the stream
+// framing format that loads each segment the first time it is used.
+type segmentArena struct {
+	r    io.ReaderAt
+	segs []segmentRange
+	end  int64 // offset just past the message
+
+	// mapped is the data that r reads if r reads from a memory mapping.
+	// Segments are then sliced from it instead of copied.
+	mapped []byte
+}
+
+type segmentRange struct {
+	off, size int64
+}
+
+// newSegmentArena reads the segment table of the message at the
+// beginning of r, which has size bytes.  Data after the message is
+// ignored.
+func newSegmentArena(r io.ReaderAt, size int64) (*segmentArena, error) {
+	var buf [4]byte
+	if err := readFullAt(r, buf[:], 0); err != nil {
+		return nil, fmt.Errorf("read segment table: %v", err)
+	}
+	n := int64(binary.LittleEndian.Uint32(buf[:])) + 1
+	if n > maxSegments {
+		return nil, fmt.Errorf("message has %d segments; maximum is %d", n, maxSegments)
+	}
+	hdrSize := (4 + 4*n + 7) &^ 7
+	hdr := make([]byte, hdrSize)
+	if err := readFullAt(r, hdr, 0); err != nil {
+		return nil, fmt.Errorf("read segment table: %v", err)
+	}
+	a := &segmentArena{r: r, segs: make([]segmentRange, n)}
+	off := hdrSize
+	for i := range a.segs {
+		sz := int64(binary.LittleEndian.Uint32(hdr[4+4*i:])) * 8
+		a.segs[i] = segmentRange{off: off, size: sz}
+		off += sz
+	}
+	if off > size {
+		return nil, fmt.Errorf("message is %d bytes, but file is %d bytes", off, size)
+	}
+	a.end = off
+	return a, nil
+}
+
+func (a *segmentArena) NumSegments() int64 {
+	return int64(len(a.segs))
+}
+
+func (a *segmentArena) Data(id capnp.SegmentID) ([]byte, error) {
+	if int64(id) >= int64(len(a.segs)) {
+		return nil, fmt.Errorf("segment %d out of bounds", id)
+	}
+	s := a.segs[id]
+	if a.mapped != nil {
+		return a.mapped[s.off : s.off+s.size : s.off+s.size], nil
+	}
+	data := make([]byte, s.size)
+	if err := readFullAt(a.r, data, s.off); err != nil {
+		return nil, fmt.Errorf("read segment %d: %v", id, err)
+	}
+	return data, nil
+}
+
+func (a *segmentArena) Allocate(capnp.Size, map[capnp.SegmentID]*capnp.Segment) (capnp.SegmentID, []byte, error) {
+	return 0, nil, errors.New("catalog is read-only")
+}
+
+// readFullAt reads exactly len(p) bytes from r at off.
+func readFullAt(r io.ReaderAt, p []byte, off int64) error {
+	n, err := r.ReadAt(p, off)
+	if n == len(p) {
+		return nil
+	}
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// byteSize is a flag.Value for a number of bytes with an optional
+// K, M, or G suffix.
+type byteSize uint64
+
+func (b *byteSize) String() string {
+	switch {
+	case *b == 0:
+		return "0"
+	case *b%(1<<30) == 0:
+		return strconv.FormatUint(uint64(*b)>>30, 10) + "G"
+	case *b%(1<<20) == 0:
+		return strconv.FormatUint(uint64(*b)>>20, 10) + "M"
+	case *b%(1<<10) == 0:
+		return strconv.FormatUint(uint64(*b)>>10, 10) + "K"
+	default:
+		return strconv.FormatUint(uint64(*b), 10)
+	}
+}
+
+func (b *byteSize) Set(s string) error {
+	var shift uint
+	switch {
+	case strings.HasSuffix(s, "K"):
+		shift = 10
+	case strings.HasSuffix(s, "M"):
+		shift = 20
+	case strings.HasSuffix(s, "G"):
+		shift = 30
+	}
+	if shift > 0 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return errors.New("invalid size")
+	}
+	if n > (1<<64-1)>>shift {
+		return errors.New("size too large")
+	}
+	*b = byteSize(n << shift)
+	return nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalogio
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto"
+)
+
+// A Stream reads a sequence of catalogs from one input.  Binary and
+// packed input holds any number of catalogs, one message after
+// another, so catalogs can be concatenated with cat.  Input in the
+// other formats holds exactly one catalog.
+type Stream struct {
+	opts *Options
+	err  error // returned by all calls to Next once set
+
+	// r is the input until the first call to Next.
+	r   io.Reader
+	dec *capnp.Decoder
+	f   *os.File // closed by Close
+
+	// Uncompressed binary regular files are read by offset.
+	ra        io.ReaderAt
+	mapped    []byte // non-nil if ra reads from a memory mapping
+	off, size int64
+	used      bool // whether a catalog refers to ra
+}
+
+// NewStream returns a stream that reads catalogs from r.  opts may be
+// nil, which is the same as a zero Options.  The read limit applies to
+// each catalog separately.
+func NewStream(r io.Reader, opts *Options) *Stream {
+	return &Stream{opts: opts, r: r}
+}
+
+// OpenStream opens a stream that reads catalogs from the named file, or
+// from stdin if path is empty.  opts may be nil, which is the same as a
+// zero Options.  Like ReadFile, segments of catalogs in an uncompressed
+// binary file are loaded as they are used.
+func OpenStream(path string, opts *Options) (*Stream, error) {
+	if path == "" {
+		return NewStream(os.Stdin, opts), nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if opts.format() == Binary && !isCompressedFile(f) {
+		if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+			s := &Stream{opts: opts, size: info.Size()}
+			if data, err := mapFile(f, info.Size()); err == nil {
+				s.ra = bytes.NewReader(data)
+				s.mapped = data
+				// The mapping outlives the file descriptor.
+				f.Close()
+			} else {
+				// Fall back to reading segments from the file.
+				s.ra = f
+				s.f = f
+			}
+			return s, nil
+		}
+	}
+	s := NewStream(f, opts)
+	s.f = f
+	return s, nil
+}
+
+// Next reads the next catalog from the stream.  It returns io.EOF if
+// there are no more catalogs.
+func (s *Stream) Next() (catalog.Catalog, error) {
+	if s.err != nil {
+		return catalog.Catalog{}, s.err
+	}
+	c, err := s.next()
+	if err != nil {
+		s.err = err
+		return catalog.Catalog{}, err
+	}
+	return c, nil
+}
+
+func (s *Stream) next() (catalog.Catalog, error) {
+	limit := s.opts.readLimit()
+	if s.ra != nil {
+		return s.nextFromFile(limit)
+	}
+	if s.r != nil {
+		r, err := decompress(s.r)
+		s.r = nil
+		if err != nil {
+			return catalog.Catalog{}, err
+		}
+		switch s.opts.format() {
+		case Binary:
+			s.dec = capnp.NewDecoder(r)
+		case Packed:
+			s.dec = capnp.NewPackedDecoder(r)
+		default:
+			return readDocument(r, s.opts.format(), limit)
+		}
+	}
+	if s.dec == nil {
+		return catalog.Catalog{}, io.EOF
+	}
+	return readBinary(s.dec, limit)
+}
+
+func (s *Stream) nextFromFile(limit uint64) (catalog.Catalog, error) {
+	if s.off >= s.size {
+		return catalog.Catalog{}, io.EOF
+	}
+	n := s.size - s.off
+	arena, err := newSegmentArena(io.NewSectionReader(s.ra, s.off, n), n)
+	if err != nil {
+		return catalog.Catalog{}, fmt.Errorf("read catalog: %v", err)
+	}
+	if s.mapped != nil {
+		arena.mapped = s.mapped[s.off:]
+	}
+	s.off += arena.end
+	s.used = true
+	msg := &capnp.Message{Arena: arena, TraverseLimit: limit}
+	c, err := catalog.ReadRootCatalog(msg)
+	if err != nil {
+		return catalog.Catalog{}, fmt.Errorf("read catalog: %v", err)
+	}
+	return c, nil
+}
+
+// Close releases the stream's file, unless catalogs read from it still
+// refer to it.  It does not close stdin or a reader passed to NewStream.
+func (s *Stream) Close() error {
+	if s.used {
+		return nil
+	}
+	if s.mapped != nil {
+		data := s.mapped
+		s.mapped = nil
+		return unmapFile(data)
+	}
+	if s.f == nil {
+		return nil
+	}
+	f := s.f
+	s.f = nil
+	return f.Close()
+}
@@ -0,0 +1,137 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalogio
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/internal/catpogs"
+)
+
+func TestStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalogio_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var bin, packed []byte
+	for _, id := range []uint64{1, 2, 3} {
+		c, err := (&catpogs.Catalog{
+			Resources: []*catpogs.Resource{{ID: id, Which: catalog.Resource_Which_noop}},
+		}).ToCapnp()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := Marshal(c, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bin = append(bin, data...)
+		data, err = Marshal(c, &WriteOptions{Packed: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		packed = append(packed, data...)
+	}
+	binPath := filepath.Join(dir, "stream.catalog")
+	if err := ioutil.WriteFile(binPath, bin, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		open func() (*Stream, error)
+	}{
+		{"binary", func() (*Stream, error) {
+			return NewStream(bytes.NewReader(bin), nil), nil
+		}},
+		{"packed", func() (*Stream, error) {
+			return NewStream(bytes.NewReader(packed), &Options{Format: Packed}), nil
+		}},
+		{"gzip", func() (*Stream, error) {
+			return NewStream(bytes.NewReader(gzipBytes(t, bin)), nil), nil
+		}},
+		{"file", func() (*Stream, error) {
+			return OpenStream(binPath, nil)
+		}},
+	}
+	for _, test := range tests {
+		s, err := test.open()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		var ids []uint64
+		for {
+			c, err := s.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Errorf("%s: Next: %v", test.name, err)
+				break
+			}
+			res, err := c.Resources()
+			if err != nil || res.Len() != 1 {
+				t.Errorf("%s: catalog %d: resources = %d, %v; want 1 resource", test.name, len(ids)+1, res.Len(), err)
+				break
+			}
+			ids = append(ids, res.At(0).ID())
+		}
+		if len(ids) != 3 || ids[0] != 1 || ids[1] != 2 || ids[2] != 3 {
+			t.Errorf("%s: read catalogs with IDs %v; want [1 2 3]", test.name, ids)
+		}
+		if err := s.Close(); err != nil {
+			t.Errorf("%s: Close: %v", test.name, err)
+		}
+	}
+
+	if _, err := Read(bytes.NewReader(bin), nil); err == nil {
+		t.Error("Read of several catalogs succeeded")
+	}
+	if _, err := ReadFile(binPath, nil); err == nil {
+		t.Error("ReadFile of several catalogs succeeded")
+	}
+	if _, err := Read(bytes.NewReader(nil), nil); err == nil {
+		t.Error("Read of empty input succeeded")
+	}
+}
+
+func TestStreamText(t *testing.T) {
+	s := NewStream(bytes.NewReader([]byte(symlinkCatalogText)), &Options{Format: Text})
+	if _, err := s.Next(); err != nil {
+		t.Fatal("Next:", err)
+	}
+	if _, err := s.Next(); err != io.EOF {
+		t.Errorf("second Next = %v; want io.EOF", err)
+	}
+}
+
+func TestStreamTruncated(t *testing.T) {
+	data := append(bigCatalog(t, 1, 100), bigCatalog(t, 1, 100)...)
+	s := NewStream(bytes.NewReader(data[:len(data)-8]), nil)
+	if _, err := s.Next(); err != nil {
+		t.Fatal("Next:", err)
+	}
+	if _, err := s.Next(); err == nil || err == io.EOF {
+		t.Errorf("Next on truncated catalog = %v; want error", err)
+	}
+}
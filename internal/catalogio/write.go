@@ -0,0 +1,74 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalogio
+
+import (
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"fmt"
+
+	"github.com/zombiezen/mcm/catalog"
+)
+
+// WriteOptions controls how a catalog is encoded.  The zero value
+// writes an uncompressed, unpacked binary catalog.
+type WriteOptions struct {
+	// Packed selects the Cap'n Proto packed encoding, which removes
+	// the zero bytes that make up much of a typical catalog.
+	Packed bool
+
+	// Gzip compresses the catalog with gzip.  There is no zstd option
+	// because only a zstd decompressor is vendored.
+	Gzip bool
+}
+
+// WriteFlags defines -packed and -gzip flags on fs and returns the
+// WriteOptions that they set.
+func WriteFlags(fs *flag.FlagSet) *WriteOptions {
+	opts := new(WriteOptions)
+	fs.BoolVar(&opts.Packed, "packed", false, "write the catalog in the packed encoding")
+	fs.BoolVar(&opts.Gzip, "gzip", false, "compress the catalog with gzip (for zstd, pipe the output through the zstd command)")
+	return opts
+}
+
+// Marshal encodes c as a binary catalog.  opts may be nil, which is the
+// same as a zero WriteOptions.  A packed catalog must be read with the
+// Packed format, but Read detects compression on its own.
+func Marshal(c catalog.Catalog, opts *WriteOptions) ([]byte, error) {
+	msg := c.Segment().Message()
+	var data []byte
+	var err error
+	if opts != nil && opts.Packed {
+		data, err = msg.MarshalPacked()
+	} else {
+		data, err = msg.Marshal()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("write catalog: %v", err)
+	}
+	if opts == nil || !opts.Gzip {
+		return data, nil
+	}
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, fmt.Errorf("write catalog: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("write catalog: %v", err)
+	}
+	return buf.Bytes(), nil
+}
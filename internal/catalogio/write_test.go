@@ -0,0 +1,67 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalogio
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto/encoding/text"
+)
+
+func TestMarshal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalogio_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := symlinkCatalog(t)
+	tests := []struct {
+		opts   *WriteOptions
+		format string
+	}{
+		{nil, Binary},
+		{&WriteOptions{Packed: true}, Packed},
+		{&WriteOptions{Gzip: true}, Binary},
+		{&WriteOptions{Packed: true, Gzip: true}, Packed},
+	}
+	for _, test := range tests {
+		data, err := Marshal(c, test.opts)
+		if err != nil {
+			t.Errorf("Marshal(c, %+v): %v", test.opts, err)
+			continue
+		}
+		path := filepath.Join(dir, "catalog")
+		if err := ioutil.WriteFile(path, data, 0666); err != nil {
+			t.Fatal(err)
+		}
+		c2, err := ReadFile(path, &Options{Format: test.format})
+		if err != nil {
+			t.Errorf("ReadFile(Marshal(c, %+v)): %v", test.opts, err)
+			continue
+		}
+		got, err := text.Marshal(catalog.Catalog_TypeID, c2.Struct)
+		if err != nil {
+			t.Errorf("ReadFile(Marshal(c, %+v)): marshal result: %v", test.opts, err)
+			continue
+		}
+		if got != symlinkCatalogText {
+			t.Errorf("ReadFile(Marshal(c, %+v)) = %s; want %s", test.opts, got, symlinkCatalogText)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package catdiff computes semantic differences between catalogs.
+//
+// Resources are matched by ID.  Each resource is flattened into an
+// ordered list of named fields (like "file.plain.mode.bits"), and
+// matching resources are compared field by field, so the differences
+// are independent of how the catalogs were encoded.
+package catdiff
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/zombiezen/mcm/catalog"
+)
+
+// A Diff is the set of differences between two catalogs.
+type Diff struct {
+	Added   []*Resource // in new catalog, but not old
+	Removed []*Resource // in old catalog, but not new
+	Changed []*ResourceDiff
+}
+
+// Empty reports whether the catalogs are semantically equal.
+func (d *Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// A Resource is a flattened catalog resource.
+type Resource struct {
+	ID      uint64
+	Comment string
+	Deps    []uint64
+	Fields  []Field
+}
+
+// String returns the resource's comment and ID.
+func (r *Resource) String() string {
+	return describe(r.ID, r.Comment)
+}
+
+func describe(id uint64, comment string) string {
+	if comment == "" {
+		return fmt.Sprintf("id=%d", id)
+	}
+	return fmt.Sprintf("%s (id=%d)", comment, id)
+}
+
+// A Field is a named value in a flattened resource.
+type Field struct {
+	Name  string
+	Value string
+	// Text is true if Value is multi-line text, like file content or a
+	// bash script, that should be compared line by line.
+	Text bool
+	// Binary is true if Value is binary data.
+	Binary bool
+}
+
+// A ResourceDiff describes how a resource with the same ID differs
+// between two catalogs.
+type ResourceDiff struct {
+	Old, New *Resource
+
+	// AddedDeps and RemovedDeps are the dependency edges that were
+	// added and removed, described by comment and ID from the catalog
+	// that has them.
+	AddedDeps   []string
+	RemovedDeps []string
+
+	Fields []FieldDiff
+}
+
+// A FieldDiff is a field whose value changed.  A missing field is
+// represented by Old or New being nil.
+type FieldDiff struct {
+	Name     string
+	Old, New *Field
+	// Unified is a unified diff of the field's lines if the field is
+	// text in either resource and binary in neither.
+	Unified string
+}
+
+// Compare returns the differences between the old and new catalogs.
+// Added and removed resources are in the order they appear in their
+// catalogs; changed resources are in the order of the new catalog.
+func Compare(oldCat, newCat catalog.Catalog) (*Diff, error) {
+	oldRes, err := flattenCatalog(oldCat)
+	if err != nil {
+		return nil, fmt.Errorf("old catalog: %v", err)
+	}
+	newRes, err := flattenCatalog(newCat)
+	if err != nil {
+		return nil, fmt.Errorf("new catalog: %v", err)
+	}
+	oldByID := indexResources(oldRes)
+	newByID := indexResources(newRes)
+	d := new(Diff)
+	for _, r := range oldRes {
+		if newByID[r.ID] == nil {
+			d.Removed = append(d.Removed, r)
+		}
+	}
+	for _, r := range newRes {
+		o := oldByID[r.ID]
+		if o == nil {
+			d.Added = append(d.Added, r)
+			continue
+		}
+		if rd := compareResource(o, r, oldByID, newByID); rd != nil {
+			d.Changed = append(d.Changed, rd)
+		}
+	}
+	return d, nil
+}
+
+// CompareResources returns the differences between two resources, or
+// nil if they are semantically equal.  Dependencies are described
+// using their IDs only.
+func CompareResources(oldRes, newRes catalog.Resource) (*ResourceDiff, error) {
+	o, err := Flatten(oldRes)
+	if err != nil {
+		return nil, err
+	}
+	n, err := Flatten(newRes)
+	if err != nil {
+		return nil, err
+	}
+	return compareResource(o, n, nil, nil), nil
+}
+
+func flattenCatalog(c catalog.Catalog) ([]*Resource, error) {
+	list, err := c.Resources()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*Resource, list.Len())
+	seen := make(map[uint64]bool, len(res))
+	for i := range res {
+		res[i], err = Flatten(list.At(i))
+		if err != nil {
+			return nil, err
+		}
+		if seen[res[i].ID] {
+			return nil, fmt.Errorf("multiple resources with ID=%d", res[i].ID)
+		}
+		seen[res[i].ID] = true
+	}
+	return res, nil
+}
+
+func indexResources(res []*Resource) map[uint64]*Resource {
+	m := make(map[uint64]*Resource, len(res))
+	for _, r := range res {
+		m[r.ID] = r
+	}
+	return m
+}
+
+func compareResource(o, n *Resource, oldByID, newByID map[uint64]*Resource) *ResourceDiff {
+	rd := &ResourceDiff{Old: o, New: n}
+	oldDeps := depSet(o.Deps)
+	newDeps := depSet(n.Deps)
+	for _, id := range sortedIDs(newDeps) {
+		if !oldDeps[id] {
+			rd.AddedDeps = append(rd.AddedDeps, describeDep(id, newByID))
+		}
+	}
+	for _, id := range sortedIDs(oldDeps) {
+		if !newDeps[id] {
+			rd.RemovedDeps = append(rd.RemovedDeps, describeDep(id, oldByID))
+		}
+	}
+
+	oldFields := fieldMap(o.Fields)
+	newFields := fieldMap(n.Fields)
+	var names []string
+	for _, f := range o.Fields {
+		names = append(names, f.Name)
+	}
+	for _, f := range n.Fields {
+		if oldFields[f.Name] == nil {
+			names = append(names, f.Name)
+		}
+	}
+	for _, name := range names {
+		of, nf := oldFields[name], newFields[name]
+		if of != nil && nf != nil && *of == *nf {
+			continue
+		}
+		fd := FieldDiff{Name: name, Old: of, New: nf}
+		if of != nil && nf != nil && (of.Text || nf.Text) && !of.Binary && !nf.Binary {
+			fd.Unified = Unified(of.Value, nf.Value, 3)
+		}
+		rd.Fields = append(rd.Fields, fd)
+	}
+	if len(rd.AddedDeps) == 0 && len(rd.RemovedDeps) == 0 && len(rd.Fields) == 0 {
+		return nil
+	}
+	return rd
+}
+
+func depSet(deps []uint64) map[uint64]bool {
+	m := make(map[uint64]bool, len(deps))
+	for _, id := range deps {
+		m[id] = true
+	}
+	return m
+}
+
+func sortedIDs(m map[uint64]bool) []uint64 {
+	ids := make([]uint64, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func describeDep(id uint64, byID map[uint64]*Resource) string {
+	if r := byID[id]; r != nil {
+		return r.String()
+	}
+	return describe(id, "")
+}
+
+func fieldMap(fields []Field) map[string]*Field {
+	m := make(map[string]*Field, len(fields))
+	for i := range fields {
+		m[fields[i].Name] = &fields[i]
+	}
+	return m
+}
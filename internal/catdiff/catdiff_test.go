@@ -0,0 +1,256 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catdiff
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/internal/catpogs"
+)
+
+func TestCompare(t *testing.T) {
+	mode := func(bits uint16) *catpogs.FileMode {
+		return &catpogs.FileMode{Bits: bits, User: catpogs.UserIDRef(-1), Group: catpogs.GroupNameRef("adm")}
+	}
+	oldPogs := &catpogs.Catalog{Resources: []*catpogs.Resource{
+		{ID: 1, Comment: "same", Which: catalog.Resource_Which_noop},
+		{ID: 2, Comment: "removed", Which: catalog.Resource_Which_noop},
+		{ID: 3, Comment: "file", Deps: []uint64{1, 2}, Which: catalog.Resource_Which_file, File: func() *catpogs.File {
+			f := catpogs.PlainFile("/etc/foo", []byte("a\nb\nc\n"))
+			f.Plain.Mode = mode(0644)
+			return f
+		}()},
+		{ID: 4, Comment: "exec", Which: catalog.Resource_Which_exec, Exec: &catpogs.Exec{
+			Command: &catpogs.Command{
+				Which: catalog.Exec_Command_Which_argv,
+				Argv:  []string{"/bin/echo", "hi"},
+				Env:   []catpogs.EnvVar{{Name: "A", Value: "1"}},
+			},
+		}},
+	}}
+	newPogs := &catpogs.Catalog{Resources: []*catpogs.Resource{
+		{ID: 5, Comment: "added", Which: catalog.Resource_Which_noop},
+		{ID: 4, Comment: "exec", Locks: []string{"echo"}, Which: catalog.Resource_Which_exec, Exec: &catpogs.Exec{
+			Command: &catpogs.Command{
+				Which: catalog.Exec_Command_Which_argv,
+				Argv:  []string{"/bin/echo", "hello"},
+				Env:   []catpogs.EnvVar{{Name: "B", Value: "2"}},
+			},
+			Condition: catpogs.ExecCondition{Which: catalog.Exec_condition_Which_fileAbsent, FileAbsent: "/tmp/x"},
+		}},
+		{ID: 3, Comment: "file", Deps: []uint64{5, 1}, Which: catalog.Resource_Which_file, File: func() *catpogs.File {
+			f := catpogs.PlainFile("/etc/foo", []byte("a\nB\nc\n"))
+			f.Plain.Mode = mode(0600)
+			return f
+		}()},
+		{ID: 1, Comment: "same", Which: catalog.Resource_Which_noop},
+	}}
+	oldCat, err := oldPogs.ToCapnp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCat, err := newPogs.ToCapnp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := Compare(oldCat, newCat)
+	if err != nil {
+		t.Fatal("Compare:", err)
+	}
+	if len(d.Added) != 1 || d.Added[0].ID != 5 {
+		t.Errorf("Added = %v; want [added (id=5)]", d.Added)
+	}
+	if len(d.Removed) != 1 || d.Removed[0].ID != 2 {
+		t.Errorf("Removed = %v; want [removed (id=2)]", d.Removed)
+	}
+	if len(d.Changed) != 2 {
+		t.Fatalf("len(Changed) = %d; want 2", len(d.Changed))
+	}
+
+	exec := d.Changed[0]
+	if exec.New.ID != 4 {
+		t.Errorf("Changed[0].New.ID = %d; want 4", exec.New.ID)
+	}
+	if got, want := fieldNames(exec.Fields), "exec.command.argv exec.command.environment.A exec.condition locks exec.command.environment.B exec.condition.fileAbsent"; got != want {
+		t.Errorf("exec changed fields = %s; want %s", got, want)
+	}
+	if len(exec.AddedDeps) != 0 || len(exec.RemovedDeps) != 0 {
+		t.Errorf("exec deps changed: +%v -%v", exec.AddedDeps, exec.RemovedDeps)
+	}
+
+	file := d.Changed[1]
+	if file.New.ID != 3 {
+		t.Errorf("Changed[1].New.ID = %d; want 3", file.New.ID)
+	}
+	if got, want := fieldNames(file.Fields), "file.plain.content file.plain.mode.bits"; got != want {
+		t.Errorf("file changed fields = %s; want %s", got, want)
+	}
+	if got, want := strings.Join(file.AddedDeps, ","), "added (id=5)"; got != want {
+		t.Errorf("file added deps = %s; want %s", got, want)
+	}
+	if got, want := strings.Join(file.RemovedDeps, ","), "removed (id=2)"; got != want {
+		t.Errorf("file removed deps = %s; want %s", got, want)
+	}
+	const wantContent = "@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"
+	if got := file.Fields[0].Unified; got != wantContent {
+		t.Errorf("content diff = %q; want %q", got, wantContent)
+	}
+	if got := file.Fields[1]; got.Old.Value != "0644" || got.New.Value != "0600" {
+		t.Errorf("mode diff = %s -> %s; want 0644 -> 0600", got.Old.Value, got.New.Value)
+	}
+}
+
+func TestCompareEqual(t *testing.T) {
+	// Same catalog, different resource, dependency, and lock order.
+	a, err := (&catpogs.Catalog{Resources: []*catpogs.Resource{
+		{ID: 1, Which: catalog.Resource_Which_noop},
+		{ID: 2, Deps: []uint64{1, 3}, Locks: []string{"a", "b"}, Which: catalog.Resource_Which_file, File: catpogs.PlainFile("/foo", nil)},
+		{ID: 3, Which: catalog.Resource_Which_noop},
+	}}).ToCapnp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := (&catpogs.Catalog{Resources: []*catpogs.Resource{
+		{ID: 3, Which: catalog.Resource_Which_noop},
+		{ID: 2, Deps: []uint64{3, 1}, Locks: []string{"b", "a"}, Which: catalog.Resource_Which_file, File: catpogs.PlainFile("/foo", nil)},
+		{ID: 1, Which: catalog.Resource_Which_noop},
+	}}).ToCapnp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := Compare(a, b)
+	if err != nil {
+		t.Fatal("Compare:", err)
+	}
+	if !d.Empty() {
+		t.Errorf("Compare(a, b) = %+v; want empty", d)
+	}
+}
+
+func fieldNames(fields []FieldDiff) string {
+	names := make([]string, len(fields))
+	for i := range fields {
+		names[i] = fields[i].Name
+	}
+	return strings.Join(names, " ")
+}
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		old, new string
+		want     string
+	}{
+		{"", "", ""},
+		{"a\n", "a\n", ""},
+		{"", "a\n", "@@ -0,0 +1 @@\n+a\n"},
+		{"a\n", "", "@@ -1 +0,0 @@\n-a\n"},
+		{"a\nb\n", "a\nb", "@@ -1,2 +1,2 @@\n a\n-b\n+b\n\\ No newline at end of text\n"},
+		{
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			"1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n",
+			"@@ -1,6 +1,6 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+		{
+			"1\n2\n3\n4\n5\n6\n7\n8\n",
+			"1\n2\nthree\n4\n5\n6\n7\neight\n",
+			"@@ -1,8 +1,8 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n 7\n-8\n+eight\n",
+		},
+	}
+	for _, test := range tests {
+		if got := Unified(test.old, test.new, 3); got != test.want {
+			t.Errorf("Unified(%q, %q, 3) = %q; want %q", test.old, test.new, got, test.want)
+		}
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		a := randomLines(rng, rng.Intn(20))
+		b := randomLines(rng, rng.Intn(20))
+		if rng.Intn(2) == 0 {
+			// Make b an edit of a, which is the common case.
+			b = append(append(append([]string(nil), a[:len(a)/3]...), b...), a[len(a)/2:]...)
+		}
+		ops := diffLines(a, b)
+		var gotA, gotB []string
+		edits := 0
+		for _, op := range ops {
+			if op.kind != '+' {
+				gotA = append(gotA, op.line)
+			}
+			if op.kind != '-' {
+				gotB = append(gotB, op.line)
+			}
+			if op.kind != ' ' {
+				edits++
+			}
+		}
+		if strings.Join(gotA, "") != strings.Join(a, "") || strings.Join(gotB, "") != strings.Join(b, "") {
+			t.Errorf("diffLines(%q, %q) = %v; does not reproduce inputs", a, b, ops)
+			continue
+		}
+		if want := len(a) + len(b) - 2*lcsLen(a, b); edits != want {
+			t.Errorf("diffLines(%q, %q) has %d edits; want %d", a, b, edits, want)
+		}
+	}
+}
+
+func TestUnifiedLargeRewrite(t *testing.T) {
+	const n = 2000
+	var a, b bytes.Buffer
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&a, "old %d\n", i)
+		fmt.Fprintf(&b, "new %d\n", i)
+	}
+	got := Unified(a.String(), b.String(), 3)
+	if want := fmt.Sprintf("@@ -1,%d +1,%d @@\n", n, n); !strings.HasPrefix(got, want) {
+		t.Errorf("Unified(...) starts with %q; want %q", got[:len(want)], want)
+	}
+}
+
+func randomLines(rng *rand.Rand, n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = string('a'+rune(rng.Intn(4))) + "\n"
+	}
+	return lines
+}
+
+// lcsLen returns the length of the longest common subsequence of a and
+// b by dynamic programming.
+func lcsLen(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for i := range a {
+		for j := range b {
+			switch {
+			case a[i] == b[j]:
+				curr[j+1] = prev[j] + 1
+			case prev[j+1] > curr[j]:
+				curr[j+1] = prev[j+1]
+			default:
+				curr[j+1] = curr[j]
+			}
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
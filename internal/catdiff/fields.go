@@ -0,0 +1,277 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catdiff
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/zombiezen/mcm/catalog"
+)
+
+// Flatten converts a resource into its list of fields.  Dependencies
+// are kept separately, since their order does not matter.
+func Flatten(r catalog.Resource) (*Resource, error) {
+	res := &Resource{ID: r.ID()}
+	var err error
+	res.Comment, err = r.Comment()
+	if err != nil {
+		return nil, fmt.Errorf("resource ID=%d: read comment: %v", res.ID, err)
+	}
+	deps, err := r.Dependencies()
+	if err != nil {
+		return nil, fmt.Errorf("resource ID=%d: read dependencies: %v", res.ID, err)
+	}
+	res.Deps = make([]uint64, deps.Len())
+	for i := range res.Deps {
+		res.Deps[i] = deps.At(i)
+	}
+	f := &flattener{}
+	f.add("comment", res.Comment)
+	f.locks(r)
+	f.add("type", r.Which().String())
+	switch r.Which() {
+	case catalog.Resource_Which_noop:
+	case catalog.Resource_Which_file:
+		file, err := r.File()
+		if err != nil {
+			return nil, fmt.Errorf("resource ID=%d: read file: %v", res.ID, err)
+		}
+		f.file(file)
+	case catalog.Resource_Which_exec:
+		exec, err := r.Exec()
+		if err != nil {
+			return nil, fmt.Errorf("resource ID=%d: read exec: %v", res.ID, err)
+		}
+		f.exec(exec)
+	}
+	if f.err != nil {
+		return nil, fmt.Errorf("resource ID=%d: %v", res.ID, f.err)
+	}
+	res.Fields = f.fields
+	return res, nil
+}
+
+// flattener accumulates fields, keeping the first error it encounters
+// so that the walk functions don't need to check each read.
+type flattener struct {
+	fields []Field
+	err    error
+}
+
+func (f *flattener) add(name, value string) {
+	f.fields = append(f.fields, Field{Name: name, Value: value})
+}
+
+func (f *flattener) addData(name string, value []byte) {
+	switch {
+	case !utf8.Valid(value) || strings.IndexByte(string(value), 0) != -1:
+		f.fields = append(f.fields, Field{Name: name, Value: string(value), Binary: true})
+	case strings.Contains(string(value), "\n"):
+		f.fields = append(f.fields, Field{Name: name, Value: string(value), Text: true})
+	default:
+		f.add(name, string(value))
+	}
+}
+
+func (f *flattener) check(what string, err error) bool {
+	if err != nil && f.err == nil {
+		f.err = fmt.Errorf("read %s: %v", what, err)
+	}
+	return err == nil
+}
+
+// locks adds the resource's lock names as a single field.  The names
+// are sorted, since their order does not matter.
+func (f *flattener) locks(r catalog.Resource) {
+	locks, err := r.Locks()
+	if !f.check("locks", err) || locks.Len() == 0 {
+		return
+	}
+	s := make([]string, locks.Len())
+	for i := range s {
+		name, err := locks.At(i)
+		if !f.check("locks", err) {
+			return
+		}
+		s[i] = name
+	}
+	sort.Strings(s)
+	for i := range s {
+		s[i] = strconv.Quote(s[i])
+	}
+	f.add("locks", "["+strings.Join(s, ", ")+"]")
+}
+
+func (f *flattener) file(file catalog.File) {
+	path, err := file.Path()
+	if !f.check("file path", err) {
+		return
+	}
+	f.add("file.path", path)
+	f.add("file.type", file.Which().String())
+	switch file.Which() {
+	case catalog.File_Which_plain:
+		if file.Plain().HasContent() {
+			content, err := file.Plain().Content()
+			if f.check("file content", err) {
+				f.addData("file.plain.content", content)
+			}
+		}
+		if file.Plain().HasContentSHA256() {
+			digest, err := file.Plain().ContentSHA256()
+			if f.check("file content digest", err) {
+				f.add("file.plain.contentSha256", hex.EncodeToString(digest))
+			}
+		}
+		mode, err := file.Plain().Mode()
+		if f.check("file mode", err) {
+			f.mode("file.plain.mode", mode)
+		}
+	case catalog.File_Which_directory:
+		mode, err := file.Directory().Mode()
+		if f.check("file mode", err) {
+			f.mode("file.directory.mode", mode)
+		}
+	case catalog.File_Which_symlink:
+		target, err := file.Symlink().Target()
+		if f.check("symlink target", err) {
+			f.add("file.symlink.target", target)
+		}
+	case catalog.File_Which_hardlink:
+		target, err := file.Hardlink().Target()
+		if f.check("hard link target", err) {
+			f.add("file.hardlink.target", target)
+		}
+	}
+}
+
+func (f *flattener) mode(prefix string, mode catalog.File_Mode) {
+	if bits := mode.Bits(); bits != catalog.File_Mode_unset {
+		f.add(prefix+".bits", fmt.Sprintf("%#04o", bits))
+	}
+	user, err := mode.User()
+	if !f.check("mode user", err) {
+		return
+	}
+	switch user.Which() {
+	case catalog.UserRef_Which_ID:
+		if user.ID() != -1 {
+			f.add(prefix+".user", "id "+strconv.Itoa(int(user.ID())))
+		}
+	case catalog.UserRef_Which_name:
+		name, err := user.Name()
+		if f.check("mode user", err) {
+			f.add(prefix+".user", "name "+name)
+		}
+	}
+	group, err := mode.Group()
+	if !f.check("mode group", err) {
+		return
+	}
+	switch group.Which() {
+	case catalog.GroupRef_Which_ID:
+		if group.ID() != -1 {
+			f.add(prefix+".group", "id "+strconv.Itoa(int(group.ID())))
+		}
+	case catalog.GroupRef_Which_name:
+		name, err := group.Name()
+		if f.check("mode group", err) {
+			f.add(prefix+".group", "name "+name)
+		}
+	}
+}
+
+func (f *flattener) exec(exec catalog.Exec) {
+	cmd, err := exec.Command()
+	if f.check("command", err) {
+		f.command("exec.command", cmd)
+	}
+	cond := exec.Condition()
+	f.add("exec.condition", cond.Which().String())
+	switch cond.Which() {
+	case catalog.Exec_condition_Which_onlyIf:
+		cmd, err := cond.OnlyIf()
+		if f.check("onlyIf", err) {
+			f.command("exec.condition.onlyIf", cmd)
+		}
+	case catalog.Exec_condition_Which_unless:
+		cmd, err := cond.Unless()
+		if f.check("unless", err) {
+			f.command("exec.condition.unless", cmd)
+		}
+	case catalog.Exec_condition_Which_fileAbsent:
+		path, err := cond.FileAbsent()
+		if f.check("fileAbsent", err) {
+			f.add("exec.condition.fileAbsent", path)
+		}
+	case catalog.Exec_condition_Which_ifDepsChanged:
+		ids, err := cond.IfDepsChanged()
+		if f.check("ifDepsChanged", err) {
+			s := make([]string, ids.Len())
+			for i := range s {
+				s[i] = strconv.FormatUint(ids.At(i), 10)
+			}
+			f.add("exec.condition.ifDepsChanged", "["+strings.Join(s, ", ")+"]")
+		}
+	}
+}
+
+func (f *flattener) command(prefix string, cmd catalog.Exec_Command) {
+	switch cmd.Which() {
+	case catalog.Exec_Command_Which_argv:
+		argv, err := cmd.Argv()
+		if !f.check(prefix+" argv", err) {
+			return
+		}
+		s := make([]string, argv.Len())
+		for i := range s {
+			arg, err := argv.At(i)
+			if !f.check(prefix+" argv", err) {
+				return
+			}
+			s[i] = strconv.Quote(arg)
+		}
+		f.add(prefix+".argv", "["+strings.Join(s, ", ")+"]")
+	case catalog.Exec_Command_Which_bash:
+		script, err := cmd.BashBytes()
+		if f.check(prefix+" bash", err) {
+			f.addData(prefix+".bash", script)
+		}
+	}
+	env, err := cmd.Environment()
+	if !f.check(prefix+" environment", err) {
+		return
+	}
+	for i := 0; i < env.Len(); i++ {
+		name, err := env.At(i).Name()
+		if !f.check(prefix+" environment", err) {
+			return
+		}
+		value, err := env.At(i).Value()
+		if !f.check(prefix+" environment", err) {
+			return
+		}
+		f.add(prefix+".environment."+name, value)
+	}
+	dir, err := cmd.WorkingDirectory()
+	if f.check(prefix+" working directory", err) && dir != "" {
+		f.add(prefix+".workingDirectory", dir)
+	}
+}
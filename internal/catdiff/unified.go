@@ -0,0 +1,226 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catdiff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Unified returns a unified diff of the lines of a and b with the
+// given number of context lines, without the "---" and "+++" header.
+// It returns the empty string if a and b are equal.
+func Unified(a, b string, context int) string {
+	if a == b {
+		return ""
+	}
+	ops := diffLines(splitLines(a), splitLines(b))
+	var buf bytes.Buffer
+	for start := 0; start < len(ops); {
+		// Find the next change.
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		// Extend the hunk until there are more than 2*context equal
+		// lines in a row.
+		end := start
+		for i := start; i < len(ops); i++ {
+			if ops[i].kind != ' ' {
+				end = i + 1
+			} else if i-end >= 2*context {
+				break
+			}
+		}
+		lo, hi := start-context, end+context
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(ops) {
+			hi = len(ops)
+		}
+		writeHunk(&buf, ops, lo, hi)
+		start = hi
+	}
+	return buf.String()
+}
+
+// splitLines splits s into lines, keeping the line terminators so
+// that a missing final newline shows up in the diff.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+type lineOp struct {
+	kind       byte // ' ', '-', or '+'
+	line       string
+	aPos, bPos int // line index in old and new before this op
+}
+
+func writeHunk(buf *bytes.Buffer, ops []lineOp, lo, hi int) {
+	var na, nb int
+	for _, op := range ops[lo:hi] {
+		if op.kind != '+' {
+			na++
+		}
+		if op.kind != '-' {
+			nb++
+		}
+	}
+	fmt.Fprintf(buf, "@@ -%s +%s @@\n", hunkRange(ops[lo].aPos, na), hunkRange(ops[lo].bPos, nb))
+	for _, op := range ops[lo:hi] {
+		buf.WriteByte(op.kind)
+		buf.WriteString(op.line)
+		if !strings.HasSuffix(op.line, "\n") {
+			buf.WriteString("\n\\ No newline at end of text\n")
+		}
+	}
+}
+
+func hunkRange(start, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if n == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
+
+// diffLines returns the shortest edit script from a to b using the
+// linear-space variant of Myers' O(ND) algorithm, which finds the
+// middle snake of an optimal path and recurses on either side of it.
+// Memory is O(N+M) regardless of how different a and b are.
+func diffLines(a, b []string) []lineOp {
+	max := len(a) + len(b)
+	d := &differ{
+		a:  a,
+		b:  b,
+		vf: make([]int, 2*max+3),
+		vb: make([]int, 2*max+3),
+	}
+	d.compare(0, len(a), 0, len(b))
+	return d.ops
+}
+
+type differ struct {
+	a, b []string
+	ops  []lineOp
+
+	// vf and vb hold the furthest reaching x on each diagonal for the
+	// forward and reverse searches, offset by len(a)+len(b)+1.
+	vf, vb []int
+}
+
+// compare appends the edits from a[a0:a1] to b[b0:b1].
+func (d *differ) compare(a0, a1, b0, b1 int) {
+	for a0 < a1 && b0 < b1 && d.a[a0] == d.b[b0] {
+		d.ops = append(d.ops, lineOp{kind: ' ', line: d.a[a0], aPos: a0, bPos: b0})
+		a0++
+		b0++
+	}
+	suffix := 0
+	for a0 < a1 && b0 < b1 && d.a[a1-1] == d.b[b1-1] {
+		a1--
+		b1--
+		suffix++
+	}
+	switch {
+	case a0 == a1:
+		for y := b0; y < b1; y++ {
+			d.ops = append(d.ops, lineOp{kind: '+', line: d.b[y], aPos: a0, bPos: y})
+		}
+	case b0 == b1:
+		for x := a0; x < a1; x++ {
+			d.ops = append(d.ops, lineOp{kind: '-', line: d.a[x], aPos: x, bPos: b0})
+		}
+	default:
+		// With the common prefix and suffix removed, at least two edits
+		// are needed, so each half is strictly smaller.
+		x, y, u, v := d.middleSnake(a0, a1, b0, b1)
+		d.compare(a0, x, b0, y)
+		for ; x < u; x, y = x+1, y+1 {
+			d.ops = append(d.ops, lineOp{kind: ' ', line: d.a[x], aPos: x, bPos: y})
+		}
+		d.compare(u, a1, v, b1)
+	}
+	for i := 0; i < suffix; i++ {
+		d.ops = append(d.ops, lineOp{kind: ' ', line: d.a[a1+i], aPos: a1 + i, bPos: b1 + i})
+	}
+}
+
+// middleSnake finds the snake from (x, y) to (u, v) in the middle of a
+// shortest edit script from a[a0:a1] to b[b0:b1] by searching forward
+// from the start and backward from the end until the paths overlap.
+func (d *differ) middleSnake(a0, a1, b0, b1 int) (x, y, u, v int) {
+	n, m := a1-a0, b1-b0
+	delta := n - m
+	odd := delta%2 != 0
+	offset := len(d.a) + len(d.b) + 1
+	vf, vb := d.vf, d.vb
+	vf[offset+1] = 0
+	vb[offset+1] = 0
+	for D := 0; D <= (n+m+1)/2; D++ {
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || k != D && vf[offset+k-1] < vf[offset+k+1] {
+				x = vf[offset+k+1]
+			} else {
+				x = vf[offset+k-1] + 1
+			}
+			y := x - k
+			x0, y0 := x, y
+			for x < n && y < m && d.a[a0+x] == d.b[b0+y] {
+				x++
+				y++
+			}
+			vf[offset+k] = x
+			if rk := delta - k; odd && -(D-1) <= rk && rk <= D-1 && x+vb[offset+rk] >= n {
+				return a0 + x0, b0 + y0, a0 + x, b0 + y
+			}
+		}
+		// The reverse search runs on diagonals of the reversed
+		// sequences, where diagonal rk is forward diagonal delta-rk.
+		for rk := -D; rk <= D; rk += 2 {
+			var x int
+			if rk == -D || rk != D && vb[offset+rk-1] < vb[offset+rk+1] {
+				x = vb[offset+rk+1]
+			} else {
+				x = vb[offset+rk-1] + 1
+			}
+			y := x - rk
+			x0, y0 := x, y
+			for x < n && y < m && d.a[a1-1-x] == d.b[b1-1-y] {
+				x++
+				y++
+			}
+			vb[offset+rk] = x
+			if k := delta - rk; !odd && -D <= k && k <= D && x+vf[offset+k] >= n {
+				return a1 - x, b1 - y, a1 - x0, b1 - y0
+			}
+		}
+	}
+	panic("unreachable")
+}
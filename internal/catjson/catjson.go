@@ -0,0 +1,800 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package catjson converts catalogs to and from JSON and YAML.
+//
+// The mapping follows catalog.capnp field for field, using the
+// schema's field names as object keys.  A union is an object with
+// exactly one of the union's member keys; if none are present, then
+// the first member is used, as in Cap'n Proto.  Void union members
+// (noop, absent, always) are written as an empty object.  Resource IDs
+// are JSON numbers, but decimal strings are also accepted for
+// generators whose JSON numbers are 64-bit floats.  File content is
+// given as a UTF-8 string in "content" or as base64 in "contentBase64";
+// omitting both leaves the content unmanaged unless "contentSha256"
+// gives the content's digest in hex.  Mode bits are a JSON
+// number or a string of octal digits like "0644", and are written as
+// the latter.
+//
+// For example:
+//
+//	{
+//	  "resources": [
+//	    {
+//	      "id": 1,
+//	      "comment": "hello",
+//	      "file": {
+//	        "path": "/etc/hello.txt",
+//	        "plain": {"content": "Hello, World!\n", "mode": {"bits": "0644"}}
+//	      }
+//	    },
+//	    {
+//	      "id": 2,
+//	      "dependencies": [1],
+//	      "locks": ["apt"],
+//	      "exec": {
+//	        "command": {"argv": ["/usr/bin/apt-get", "update"]},
+//	        "condition": {"ifDepsChanged": [1]}
+//	      }
+//	    }
+//	  ]
+//	}
+//
+// YAML input is converted to JSON before decoding, so the same mapping
+// applies.  Only a subset of YAML is supported: see UnmarshalYAML.
+package catjson
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto/pogs"
+)
+
+// Unmarshal decodes a JSON catalog into a new message.  Unknown keys
+// are an error.
+func Unmarshal(data []byte) (catalog.Catalog, error) {
+	c, err := unmarshal(data)
+	if err != nil {
+		return catalog.Catalog{}, fmt.Errorf("decode json catalog: %v", err)
+	}
+	return c, nil
+}
+
+func unmarshal(data []byte) (catalog.Catalog, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var raw interface{}
+	if err := dec.Decode(&raw); err != nil {
+		return catalog.Catalog{}, err
+	}
+	if dec.More() {
+		return catalog.Catalog{}, errors.New("data after catalog")
+	}
+	if err := checkFields(raw, reflect.TypeOf(jsonCatalog{})); err != nil {
+		return catalog.Catalog{}, err
+	}
+	jc := new(jsonCatalog)
+	if err := json.Unmarshal(data, jc); err != nil {
+		return catalog.Catalog{}, err
+	}
+	pc, err := jc.toPogs()
+	if err != nil {
+		return catalog.Catalog{}, err
+	}
+	_, seg, err := capnp.NewMessage(capnp.SingleSegment(nil))
+	if err != nil {
+		return catalog.Catalog{}, err
+	}
+	root, err := catalog.NewRootCatalog(seg)
+	if err != nil {
+		return catalog.Catalog{}, err
+	}
+	if err := pogs.Insert(catalog.Catalog_TypeID, root.Struct, pc); err != nil {
+		return catalog.Catalog{}, err
+	}
+	return root, nil
+}
+
+// checkFields returns an error if any object in v has a key that does
+// not name a field of the corresponding struct in t.  Like
+// encoding/json, keys are matched case-insensitively.  Values of the
+// wrong JSON type are left for json.Unmarshal to report.
+func checkFields(v interface{}, t reflect.Type) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for k, fv := range obj {
+			f, ok := jsonField(t, k)
+			if !ok {
+				return fmt.Errorf("json: unknown field %q", k)
+			}
+			if err := checkFields(fv, f.Type); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		list, ok := v.([]interface{})
+		if !ok {
+			return nil
+		}
+		for _, elem := range list {
+			if err := checkFields(elem, t.Elem()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// jsonField finds the field of struct type t that a JSON object key
+// decodes into.
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Tag.Get("json")
+		if j := strings.IndexByte(name, ','); j != -1 {
+			name = name[:j]
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if name != "-" && strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// Marshal encodes a catalog as indented JSON.
+func Marshal(c catalog.Catalog) ([]byte, error) {
+	pc := new(pogsCatalog)
+	if err := pogs.Extract(pc, catalog.Catalog_TypeID, c.Struct); err != nil {
+		return nil, fmt.Errorf("encode json catalog: %v", err)
+	}
+	jc, err := pc.toJSON()
+	if err != nil {
+		return nil, fmt.Errorf("encode json catalog: %v", err)
+	}
+	data, err := json.MarshalIndent(jc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encode json catalog: %v", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// pogs mirrors of the catalog schema.
+
+type pogsCatalog struct {
+	Resources []*pogsResource
+	Exports   []pogsResourceName
+	Imports   []pogsResourceName
+}
+
+type pogsResourceName struct {
+	Name string
+	ID   uint64 `capnp:"id"`
+}
+
+type pogsResource struct {
+	ID      uint64 `capnp:"id"`
+	Comment string
+	Deps    []uint64 `capnp:"dependencies"`
+	Locks   []string
+
+	Which catalog.Resource_Which
+	File  *pogsFile
+	Exec  *pogsExec
+}
+
+type pogsFile struct {
+	Path string
+
+	Which catalog.File_Which
+	Plain struct {
+		Content       []byte
+		Mode          *pogsMode
+		ContentSHA256 []byte `capnp:"contentSha256"`
+	}
+	Directory struct {
+		Mode *pogsMode
+	}
+	Symlink struct {
+		Target string
+	}
+	Hardlink struct {
+		Target string
+	}
+}
+
+type pogsMode struct {
+	Bits  uint16
+	User  *pogsUserRef
+	Group *pogsGroupRef
+}
+
+type pogsUserRef struct {
+	Which catalog.UserRef_Which
+	ID    int32 `capnp:"id"`
+	Name  string
+}
+
+type pogsGroupRef struct {
+	Which catalog.GroupRef_Which
+	ID    int32 `capnp:"id"`
+	Name  string
+}
+
+type pogsExec struct {
+	Command   *pogsCommand
+	Condition struct {
+		Which         catalog.Exec_condition_Which
+		OnlyIf        *pogsCommand
+		Unless        *pogsCommand
+		FileAbsent    string
+		IfDepsChanged []uint64
+	}
+}
+
+type pogsCommand struct {
+	Which catalog.Exec_Command_Which
+	Argv  []string
+	Bash  string
+
+	Env []pogsEnvVar `capnp:"environment"`
+	Dir string       `capnp:"workingDirectory"`
+}
+
+type pogsEnvVar struct {
+	Name, Value string
+}
+
+// JSON mapping.
+
+type void struct{}
+
+type jsonCatalog struct {
+	Resources []*jsonResource    `json:"resources"`
+	Exports   []jsonResourceName `json:"exports,omitempty"`
+	Imports   []jsonResourceName `json:"imports,omitempty"`
+}
+
+type jsonResourceName struct {
+	Name string     `json:"name"`
+	ID   resourceID `json:"id"`
+}
+
+type jsonResource struct {
+	ID           resourceID   `json:"id"`
+	Comment      string       `json:"comment,omitempty"`
+	Dependencies []resourceID `json:"dependencies,omitempty"`
+	Locks        []string     `json:"locks,omitempty"`
+
+	Noop *void     `json:"noop,omitempty"`
+	File *jsonFile `json:"file,omitempty"`
+	Exec *jsonExec `json:"exec,omitempty"`
+}
+
+type jsonFile struct {
+	Path string `json:"path"`
+
+	Plain     *jsonPlain     `json:"plain,omitempty"`
+	Directory *jsonDirectory `json:"directory,omitempty"`
+	Symlink   *jsonLink      `json:"symlink,omitempty"`
+	Hardlink  *jsonLink      `json:"hardlink,omitempty"`
+	Absent    *void          `json:"absent,omitempty"`
+}
+
+type jsonPlain struct {
+	Content       *string   `json:"content,omitempty"`
+	ContentBase64 *string   `json:"contentBase64,omitempty"`
+	ContentSHA256 string    `json:"contentSha256,omitempty"`
+	Mode          *jsonMode `json:"mode,omitempty"`
+}
+
+type jsonDirectory struct {
+	Mode *jsonMode `json:"mode,omitempty"`
+}
+
+// jsonLink is the mapping of both the symlink and hardlink groups.
+type jsonLink struct {
+	Target string `json:"target"`
+}
+
+type jsonMode struct {
+	Bits  *modeBits    `json:"bits,omitempty"`
+	User  *jsonNameRef `json:"user,omitempty"`
+	Group *jsonNameRef `json:"group,omitempty"`
+}
+
+// jsonNameRef is the mapping of both UserRef and GroupRef.
+type jsonNameRef struct {
+	ID   *int32  `json:"id,omitempty"`
+	Name *string `json:"name,omitempty"`
+}
+
+type jsonExec struct {
+	Command   *jsonCommand   `json:"command"`
+	Condition *jsonCondition `json:"condition,omitempty"`
+}
+
+type jsonCondition struct {
+	Always        *void         `json:"always,omitempty"`
+	OnlyIf        *jsonCommand  `json:"onlyIf,omitempty"`
+	Unless        *jsonCommand  `json:"unless,omitempty"`
+	FileAbsent    *string       `json:"fileAbsent,omitempty"`
+	IfDepsChanged *[]resourceID `json:"ifDepsChanged,omitempty"`
+}
+
+type jsonCommand struct {
+	Argv []string `json:"argv,omitempty"`
+	Bash *string  `json:"bash,omitempty"`
+
+	Environment      []jsonEnvVar `json:"environment,omitempty"`
+	WorkingDirectory string       `json:"workingDirectory,omitempty"`
+}
+
+type jsonEnvVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// resourceID is a uint64 that can be decoded from a JSON number or a
+// decimal string.
+type resourceID uint64
+
+func (id resourceID) MarshalJSON() ([]byte, error) {
+	return strconv.AppendUint(nil, uint64(id), 10), nil
+}
+
+func (id *resourceID) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid resource ID %s", data)
+	}
+	*id = resourceID(v)
+	return nil
+}
+
+// modeBits is a uint16 that is encoded as an octal string.  It can be
+// decoded from a JSON number or an octal string.
+type modeBits uint16
+
+func (b modeBits) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`"0%03o"`, uint16(b))), nil
+}
+
+func (b *modeBits) UnmarshalJSON(data []byte) error {
+	base, s := 10, string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		base = 8
+	}
+	v, err := strconv.ParseUint(s, base, 16)
+	if err != nil || v == uint64(catalog.File_Mode_unset) {
+		return fmt.Errorf("invalid mode bits %s", data)
+	}
+	*b = modeBits(v)
+	return nil
+}
+
+func (jc *jsonCatalog) toPogs() (*pogsCatalog, error) {
+	pc := &pogsCatalog{Resources: make([]*pogsResource, 0, len(jc.Resources))}
+	for i, jr := range jc.Resources {
+		if jr == nil {
+			return nil, fmt.Errorf("resources[%d] is null", i)
+		}
+		pr, err := jr.toPogs()
+		if err != nil {
+			return nil, fmt.Errorf("resource ID=%d: %v", jr.ID, err)
+		}
+		pc.Resources = append(pc.Resources, pr)
+	}
+	pc.Exports = namesToPogs(jc.Exports)
+	pc.Imports = namesToPogs(jc.Imports)
+	return pc, nil
+}
+
+func (jr *jsonResource) toPogs() (*pogsResource, error) {
+	pr := &pogsResource{
+		ID:      uint64(jr.ID),
+		Comment: jr.Comment,
+		Deps:    idsToPogs(jr.Dependencies),
+		Locks:   jr.Locks,
+	}
+	if err := checkUnion(jr.Noop != nil, jr.File != nil, jr.Exec != nil); err != nil {
+		return nil, err
+	}
+	switch {
+	case jr.File != nil:
+		pr.Which = catalog.Resource_Which_file
+		f, err := jr.File.toPogs()
+		if err != nil {
+			return nil, fmt.Errorf("file: %v", err)
+		}
+		pr.File = f
+	case jr.Exec != nil:
+		pr.Which = catalog.Resource_Which_exec
+		e, err := jr.Exec.toPogs()
+		if err != nil {
+			return nil, fmt.Errorf("exec: %v", err)
+		}
+		pr.Exec = e
+	default:
+		pr.Which = catalog.Resource_Which_noop
+	}
+	return pr, nil
+}
+
+func (jf *jsonFile) toPogs() (*pogsFile, error) {
+	pf := &pogsFile{Path: jf.Path}
+	err := checkUnion(jf.Plain != nil, jf.Directory != nil, jf.Symlink != nil, jf.Hardlink != nil, jf.Absent != nil)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case jf.Directory != nil:
+		pf.Which = catalog.File_Which_directory
+		if pf.Directory.Mode, err = jf.Directory.Mode.toPogs(); err != nil {
+			return nil, fmt.Errorf("directory: %v", err)
+		}
+	case jf.Symlink != nil:
+		pf.Which = catalog.File_Which_symlink
+		pf.Symlink.Target = jf.Symlink.Target
+	case jf.Hardlink != nil:
+		pf.Which = catalog.File_Which_hardlink
+		pf.Hardlink.Target = jf.Hardlink.Target
+	case jf.Absent != nil:
+		pf.Which = catalog.File_Which_absent
+	default:
+		pf.Which = catalog.File_Which_plain
+		if jf.Plain == nil {
+			break
+		}
+		switch {
+		case jf.Plain.Content != nil && jf.Plain.ContentBase64 != nil:
+			return nil, errors.New("plain: both content and contentBase64 set")
+		case jf.Plain.Content != nil:
+			pf.Plain.Content = []byte(*jf.Plain.Content)
+		case jf.Plain.ContentBase64 != nil:
+			pf.Plain.Content, err = base64.StdEncoding.DecodeString(*jf.Plain.ContentBase64)
+			if err != nil {
+				return nil, fmt.Errorf("plain: contentBase64: %v", err)
+			}
+		}
+		if jf.Plain.ContentSHA256 != "" {
+			pf.Plain.ContentSHA256, err = hex.DecodeString(jf.Plain.ContentSHA256)
+			if err != nil {
+				return nil, fmt.Errorf("plain: contentSha256: %v", err)
+			}
+		}
+		if pf.Plain.Mode, err = jf.Plain.Mode.toPogs(); err != nil {
+			return nil, fmt.Errorf("plain: %v", err)
+		}
+	}
+	return pf, nil
+}
+
+func (jm *jsonMode) toPogs() (*pogsMode, error) {
+	if jm == nil {
+		return nil, nil
+	}
+	pm := &pogsMode{Bits: catalog.File_Mode_unset}
+	if jm.Bits != nil {
+		pm.Bits = uint16(*jm.Bits)
+	}
+	if jm.User != nil {
+		if err := checkUnion(jm.User.ID != nil, jm.User.Name != nil); err != nil {
+			return nil, fmt.Errorf("mode: user: %v", err)
+		}
+		pm.User = &pogsUserRef{Which: catalog.UserRef_Which_ID, ID: -1}
+		if jm.User.Name != nil {
+			pm.User.Which, pm.User.Name = catalog.UserRef_Which_name, *jm.User.Name
+		} else if jm.User.ID != nil {
+			pm.User.ID = *jm.User.ID
+		}
+	}
+	if jm.Group != nil {
+		if err := checkUnion(jm.Group.ID != nil, jm.Group.Name != nil); err != nil {
+			return nil, fmt.Errorf("mode: group: %v", err)
+		}
+		pm.Group = &pogsGroupRef{Which: catalog.GroupRef_Which_ID, ID: -1}
+		if jm.Group.Name != nil {
+			pm.Group.Which, pm.Group.Name = catalog.GroupRef_Which_name, *jm.Group.Name
+		} else if jm.Group.ID != nil {
+			pm.Group.ID = *jm.Group.ID
+		}
+	}
+	return pm, nil
+}
+
+func (je *jsonExec) toPogs() (*pogsExec, error) {
+	pe := new(pogsExec)
+	if je.Command == nil {
+		return nil, errors.New("missing command")
+	}
+	var err error
+	if pe.Command, err = je.Command.toPogs(); err != nil {
+		return nil, fmt.Errorf("command: %v", err)
+	}
+	cond := je.Condition
+	pe.Condition.Which = catalog.Exec_condition_Which_always
+	if cond == nil {
+		return pe, nil
+	}
+	err = checkUnion(cond.Always != nil, cond.OnlyIf != nil, cond.Unless != nil, cond.FileAbsent != nil, cond.IfDepsChanged != nil)
+	if err != nil {
+		return nil, fmt.Errorf("condition: %v", err)
+	}
+	switch {
+	case cond.OnlyIf != nil:
+		pe.Condition.Which = catalog.Exec_condition_Which_onlyIf
+		if pe.Condition.OnlyIf, err = cond.OnlyIf.toPogs(); err != nil {
+			return nil, fmt.Errorf("condition: onlyIf: %v", err)
+		}
+	case cond.Unless != nil:
+		pe.Condition.Which = catalog.Exec_condition_Which_unless
+		if pe.Condition.Unless, err = cond.Unless.toPogs(); err != nil {
+			return nil, fmt.Errorf("condition: unless: %v", err)
+		}
+	case cond.FileAbsent != nil:
+		pe.Condition.Which = catalog.Exec_condition_Which_fileAbsent
+		pe.Condition.FileAbsent = *cond.FileAbsent
+	case cond.IfDepsChanged != nil:
+		pe.Condition.Which = catalog.Exec_condition_Which_ifDepsChanged
+		pe.Condition.IfDepsChanged = idsToPogs(*cond.IfDepsChanged)
+	}
+	return pe, nil
+}
+
+func (jc *jsonCommand) toPogs() (*pogsCommand, error) {
+	if err := checkUnion(jc.Argv != nil, jc.Bash != nil); err != nil {
+		return nil, err
+	}
+	pc := &pogsCommand{
+		Which: catalog.Exec_Command_Which_argv,
+		Argv:  jc.Argv,
+		Dir:   jc.WorkingDirectory,
+	}
+	if jc.Bash != nil {
+		pc.Which, pc.Bash = catalog.Exec_Command_Which_bash, *jc.Bash
+	}
+	for _, ev := range jc.Environment {
+		pc.Env = append(pc.Env, pogsEnvVar{Name: ev.Name, Value: ev.Value})
+	}
+	return pc, nil
+}
+
+// checkUnion returns an error if more than one union member is set.
+func checkUnion(set ...bool) error {
+	n := 0
+	for _, b := range set {
+		if b {
+			n++
+		}
+	}
+	if n > 1 {
+		return errors.New("more than one union member set")
+	}
+	return nil
+}
+
+func idsToPogs(ids []resourceID) []uint64 {
+	if ids == nil {
+		return nil
+	}
+	p := make([]uint64, len(ids))
+	for i := range ids {
+		p[i] = uint64(ids[i])
+	}
+	return p
+}
+
+func namesToPogs(names []jsonResourceName) []pogsResourceName {
+	if len(names) == 0 {
+		return nil
+	}
+	p := make([]pogsResourceName, len(names))
+	for i, n := range names {
+		p[i] = pogsResourceName{Name: n.Name, ID: uint64(n.ID)}
+	}
+	return p
+}
+
+func namesToJSON(names []pogsResourceName) []jsonResourceName {
+	if len(names) == 0 {
+		return nil
+	}
+	j := make([]jsonResourceName, len(names))
+	for i, n := range names {
+		j[i] = jsonResourceName{Name: n.Name, ID: resourceID(n.ID)}
+	}
+	return j
+}
+
+func idsToJSON(ids []uint64) []resourceID {
+	if len(ids) == 0 {
+		return nil
+	}
+	j := make([]resourceID, len(ids))
+	for i := range ids {
+		j[i] = resourceID(ids[i])
+	}
+	return j
+}
+
+func (pc *pogsCatalog) toJSON() (*jsonCatalog, error) {
+	jc := &jsonCatalog{Resources: make([]*jsonResource, 0, len(pc.Resources))}
+	for _, pr := range pc.Resources {
+		jr, err := pr.toJSON()
+		if err != nil {
+			return nil, fmt.Errorf("resource ID=%d: %v", pr.ID, err)
+		}
+		jc.Resources = append(jc.Resources, jr)
+	}
+	jc.Exports = namesToJSON(pc.Exports)
+	jc.Imports = namesToJSON(pc.Imports)
+	return jc, nil
+}
+
+func (pr *pogsResource) toJSON() (*jsonResource, error) {
+	jr := &jsonResource{
+		ID:           resourceID(pr.ID),
+		Comment:      pr.Comment,
+		Dependencies: idsToJSON(pr.Deps),
+		Locks:        pr.Locks,
+	}
+	switch pr.Which {
+	case catalog.Resource_Which_noop:
+		jr.Noop = new(void)
+	case catalog.Resource_Which_file:
+		jr.File = pr.File.toJSON()
+	case catalog.Resource_Which_exec:
+		jr.Exec = pr.Exec.toJSON()
+	default:
+		return nil, fmt.Errorf("unknown type %v", pr.Which)
+	}
+	return jr, nil
+}
+
+func (pf *pogsFile) toJSON() *jsonFile {
+	if pf == nil {
+		pf = new(pogsFile)
+	}
+	jf := &jsonFile{Path: pf.Path}
+	switch pf.Which {
+	case catalog.File_Which_plain:
+		jf.Plain = new(jsonPlain)
+		if c := pf.Plain.Content; c != nil {
+			s := string(c)
+			if utf8.Valid(c) {
+				jf.Plain.Content = &s
+			} else {
+				s = base64.StdEncoding.EncodeToString(c)
+				jf.Plain.ContentBase64 = &s
+			}
+		}
+		if d := pf.Plain.ContentSHA256; d != nil {
+			jf.Plain.ContentSHA256 = hex.EncodeToString(d)
+		}
+		jf.Plain.Mode = pf.Plain.Mode.toJSON()
+	case catalog.File_Which_directory:
+		jf.Directory = &jsonDirectory{Mode: pf.Directory.Mode.toJSON()}
+	case catalog.File_Which_symlink:
+		jf.Symlink = &jsonLink{Target: pf.Symlink.Target}
+	case catalog.File_Which_hardlink:
+		jf.Hardlink = &jsonLink{Target: pf.Hardlink.Target}
+	case catalog.File_Which_absent:
+		jf.Absent = new(void)
+	}
+	return jf
+}
+
+func (pm *pogsMode) toJSON() *jsonMode {
+	if pm == nil {
+		return nil
+	}
+	jm := new(jsonMode)
+	if pm.Bits != catalog.File_Mode_unset {
+		b := modeBits(pm.Bits)
+		jm.Bits = &b
+	}
+	if u := pm.User; u != nil {
+		if u.Which == catalog.UserRef_Which_name {
+			jm.User = &jsonNameRef{Name: &u.Name}
+		} else if u.ID != -1 {
+			jm.User = &jsonNameRef{ID: &u.ID}
+		}
+	}
+	if g := pm.Group; g != nil {
+		if g.Which == catalog.GroupRef_Which_name {
+			jm.Group = &jsonNameRef{Name: &g.Name}
+		} else if g.ID != -1 {
+			jm.Group = &jsonNameRef{ID: &g.ID}
+		}
+	}
+	if jm.Bits == nil && jm.User == nil && jm.Group == nil {
+		return nil
+	}
+	return jm
+}
+
+func (pe *pogsExec) toJSON() *jsonExec {
+	if pe == nil {
+		pe = new(pogsExec)
+	}
+	je := &jsonExec{Command: pe.Command.toJSON()}
+	if pe.Condition.Which == catalog.Exec_condition_Which_always {
+		return je
+	}
+	je.Condition = new(jsonCondition)
+	switch pe.Condition.Which {
+	case catalog.Exec_condition_Which_onlyIf:
+		je.Condition.OnlyIf = pe.Condition.OnlyIf.toJSON()
+	case catalog.Exec_condition_Which_unless:
+		je.Condition.Unless = pe.Condition.Unless.toJSON()
+	case catalog.Exec_condition_Which_fileAbsent:
+		je.Condition.FileAbsent = &pe.Condition.FileAbsent
+	case catalog.Exec_condition_Which_ifDepsChanged:
+		ids := idsToJSON(pe.Condition.IfDepsChanged)
+		if ids == nil {
+			ids = []resourceID{}
+		}
+		je.Condition.IfDepsChanged = &ids
+	}
+	return je
+}
+
+func (pc *pogsCommand) toJSON() *jsonCommand {
+	if pc == nil {
+		pc = new(pogsCommand)
+	}
+	jc := &jsonCommand{WorkingDirectory: pc.Dir}
+	if pc.Which == catalog.Exec_Command_Which_bash {
+		jc.Bash = &pc.Bash
+	} else {
+		jc.Argv = pc.Argv
+		if jc.Argv == nil {
+			jc.Argv = []string{}
+		}
+	}
+	for _, ev := range pc.Env {
+		jc.Environment = append(jc.Environment, jsonEnvVar{Name: ev.Name, Value: ev.Value})
+	}
+	return jc
+}
@@ -0,0 +1,198 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catjson
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/internal/catpogs"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto/encoding/text"
+)
+
+func textOf(t *testing.T, c catalog.Catalog) string {
+	s, err := text.Marshal(catalog.Catalog_TypeID, c.Struct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func testCatalog() *catpogs.Catalog {
+	mode := &catpogs.FileMode{
+		Bits:  0644,
+		User:  catpogs.UserNameRef("root"),
+		Group: catpogs.GroupIDRef(0),
+	}
+	plain := catpogs.PlainFile("/etc/foo.conf", []byte("foo = 1\n"))
+	plain.Plain.Mode = mode
+	blob := catpogs.PlainFile("/etc/blob", nil)
+	blob.Plain.ContentSHA256 = bytes.Repeat([]byte{0xab}, 32)
+	return &catpogs.Catalog{
+		Resources: []*catpogs.Resource{
+			{ID: 1, Comment: "noop", Which: catalog.Resource_Which_noop},
+			{ID: 2, Which: catalog.Resource_Which_file, File: plain},
+			{ID: 3, Which: catalog.Resource_Which_file, File: catpogs.PlainFile("/etc/empty", []byte{})},
+			{ID: 4, Which: catalog.Resource_Which_file, File: catpogs.PlainFile("/etc/unmanaged", nil)},
+			{ID: 5, Which: catalog.Resource_Which_file, File: catpogs.PlainFile("/etc/binary", []byte{0xff, 0, 1})},
+			{ID: 6, Which: catalog.Resource_Which_file, File: catpogs.Directory("/srv", &catpogs.FileMode{Bits: 01755, User: catpogs.UserIDRef(1000)})},
+			{ID: 7, Which: catalog.Resource_Which_file, File: catpogs.SymlinkFile("/srv", "/data")},
+			{ID: 8, Which: catalog.Resource_Which_file, File: catpogs.HardlinkFile("/etc/foo.conf", "/etc/bar.conf")},
+			{ID: 9, Which: catalog.Resource_Which_file, File: &catpogs.File{Path: "/tmp/gone", Which: catalog.File_Which_absent}},
+			{ID: 10, Which: catalog.Resource_Which_file, File: blob},
+			{
+				ID:    18446744073709551615,
+				Deps:  []uint64{2, 3},
+				Locks: []string{"systemd"},
+				Which: catalog.Resource_Which_exec,
+				Exec: &catpogs.Exec{
+					Command: &catpogs.Command{
+						Which: catalog.Exec_Command_Which_argv,
+						Argv:  []string{"/bin/systemctl", "reload", "foo"},
+						Env:   []catpogs.EnvVar{{Name: "LANG", Value: "C"}},
+						Dir:   "/",
+					},
+					Condition: catpogs.ExecCondition{
+						Which:         catalog.Exec_condition_Which_ifDepsChanged,
+						IfDepsChanged: []uint64{2},
+					},
+				},
+			},
+			{
+				ID:    11,
+				Which: catalog.Resource_Which_exec,
+				Exec: &catpogs.Exec{
+					Command: &catpogs.Command{Which: catalog.Exec_Command_Which_bash, Bash: "echo hi\n"},
+					Condition: catpogs.ExecCondition{
+						Which:  catalog.Exec_condition_Which_unless,
+						Unless: &catpogs.Command{Which: catalog.Exec_Command_Which_argv, Argv: []string{"/bin/true"}},
+					},
+				},
+			},
+			{
+				ID:    12,
+				Which: catalog.Resource_Which_exec,
+				Exec: &catpogs.Exec{
+					Command: &catpogs.Command{Which: catalog.Exec_Command_Which_argv, Argv: []string{"/bin/true"}},
+					Condition: catpogs.ExecCondition{
+						Which:      catalog.Exec_condition_Which_fileAbsent,
+						FileAbsent: "/tmp/gone",
+					},
+				},
+			},
+		},
+		Exports: []catpogs.ResourceName{{Name: "foo.conf", ID: 2}},
+		Imports: []catpogs.ResourceName{{Name: "base", ID: 100}},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	c, err := testCatalog().ToCapnp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := textOf(t, c)
+	data, err := Marshal(c)
+	if err != nil {
+		t.Fatal("Marshal:", err)
+	}
+	c2, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v; JSON:\n%s", err, data)
+	}
+	if got := textOf(t, c2); got != want {
+		t.Errorf("round trip through JSON:\n%s\ngot  %s\nwant %s", data, got, want)
+	}
+	for _, s := range []string{`"bits": "0644"`, `"bits": "01755"`, `"contentBase64": "/wAB"`, `"content": ""`, `"contentSha256": "` + strings.Repeat("ab", 32) + `"`, `"absent": {}`, `"noop": {}`, `"id": 18446744073709551615`, `"name": "base"`} {
+		if !strings.Contains(string(data), s) {
+			t.Errorf("JSON does not contain %s:\n%s", s, data)
+		}
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	tests := []struct {
+		json string
+		want string
+	}{
+		{`{}`, `(resources = [], exports = [], imports = [])`},
+		{
+			`{"resources": [{"id": 1}]}`,
+			`(resources = [(id = 1, comment = "", dependencies = [], locks = [], noop = void)], exports = [], imports = [])`,
+		},
+		{
+			`{"resources": [{"id": "18446744073709551615", "dependencies": ["1", 2], "noop": {}}]}`,
+			`(resources = [(id = 18446744073709551615, comment = "", dependencies = [1, 2], locks = [], noop = void)], exports = [], imports = [])`,
+		},
+		{
+			`{"resources": [{"id": 1, "file": {"path": "/foo", "plain": {"mode": {"bits": 420}}}}]}`,
+			`(resources = [(id = 1, comment = "", dependencies = [], locks = [], file = (path = "/foo", plain = (content = "", mode = (bits = 420, user = (id = -1), group = (id = -1)), contentSha256 = "")))], exports = [], imports = [])`,
+		},
+		{
+			`{"resources": [{"id": 1, "file": {"path": "/foo", "plain": {"contentBase64": "aGk="}}}]}`,
+			`(resources = [(id = 1, comment = "", dependencies = [], locks = [], file = (path = "/foo", plain = (content = "hi", mode = (bits = 65535, user = (id = -1), group = (id = -1)), contentSha256 = "")))], exports = [], imports = [])`,
+		},
+		{
+			`{"resources": [{"id": 1, "exec": {"command": {"bash": "true"}}}]}`,
+			`(resources = [(id = 1, comment = "", dependencies = [], locks = [], exec = (command = (bash = "true", environment = [], workingDirectory = ""), condition = (always = void)))], exports = [], imports = [])`,
+		},
+		{
+			`{"resources": [{"id": 1, "exec": {"command": {"argv": ["a"]}, "condition": {"ifDepsChanged": []}}}]}`,
+			`(resources = [(id = 1, comment = "", dependencies = [], locks = [], exec = (command = (argv = ["a"], environment = [], workingDirectory = ""), condition = (ifDepsChanged = [])))], exports = [], imports = [])`,
+		},
+	}
+	for _, test := range tests {
+		c, err := Unmarshal([]byte(test.json))
+		if err != nil {
+			t.Errorf("Unmarshal(%s): %v", test.json, err)
+			continue
+		}
+		if got := textOf(t, c); got != test.want {
+			t.Errorf("Unmarshal(%s) = %s; want %s", test.json, got, test.want)
+		}
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	tests := []string{
+		``,
+		`[]`,
+		`{"resources": []} {}`,
+		`{"resources": [{"id": 1, "bogus": true}]}`,
+		`{"resources": [{"id": -1}]}`,
+		`{"resources": [{"id": 1.5}]}`,
+		`{"resources": [{"id": 1, "noop": {}, "file": {"path": "/foo"}}]}`,
+		`{"resources": [{"id": 1, "file": {"path": "/foo", "symlink": {"target": "/bar"}, "absent": {}}}]}`,
+		`{"resources": [{"id": 1, "file": {"path": "/foo", "plain": {"content": "", "contentBase64": ""}}}]}`,
+		`{"resources": [{"id": 1, "file": {"path": "/foo", "plain": {"contentBase64": "!"}}}]}`,
+		`{"resources": [{"id": 1, "file": {"path": "/foo", "plain": {"contentSha256": "xyz"}}}]}`,
+		`{"resources": [{"id": 1, "file": {"path": "/foo", "plain": {"mode": {"bits": "0999"}}}}]}`,
+		`{"resources": [{"id": 1, "file": {"path": "/foo", "plain": {"mode": {"bits": 65535}}}}]}`,
+		`{"resources": [{"id": 1, "file": {"path": "/foo", "plain": {"mode": {"user": {"id": 0, "name": "root"}}}}}]}`,
+		`{"resources": [{"id": 1, "exec": {}}]}`,
+		`{"resources": [{"id": 1, "exec": {"command": {"argv": [], "bash": ""}}}]}`,
+		`{"resources": [{"id": 1, "exec": {"command": {"argv": []}, "condition": {"always": {}, "fileAbsent": "/foo"}}}]}`,
+		`{"resources": [null]}`,
+		`{"resources": [{"id": 1, "file": {"path": "/foo", "plain": {"mode": {"bitz": "0644"}}}}]}`,
+		`{"resources": [{"id": 1, "exec": {"command": {"argv": [], "environment": [{"name": "A", "vale": "b"}]}}}]}`,
+	}
+	for _, test := range tests {
+		if _, err := Unmarshal([]byte(test)); err == nil {
+			t.Errorf("Unmarshal(%s) succeeded; want error", test)
+		}
+	}
+}
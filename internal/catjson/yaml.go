@@ -0,0 +1,645 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/zombiezen/mcm/catalog"
+)
+
+// UnmarshalYAML decodes a YAML catalog into a new message.
+//
+// The supported subset of YAML covers what is needed to write a catalog
+// by hand: block mappings and sequences, flow collections on a single
+// line, plain and quoted scalars on a single line, literal (|) and
+// folded (>) block scalars, and comments.  Anchors, aliases, tags,
+// directives, complex keys, and multiple documents are not supported;
+// docs/catalog-formats.md lists the whole subset.  Plain scalars are
+// resolved using the YAML 1.2 core schema (including 0o644 and 0x1a4),
+// and, as in YAML 1.1, an integer with a leading zero like 0644 is
+// octal, so mode bits can be written the same way as for chmod.  Any
+// other YAML is rejected with an error rather than guessed at.
+func UnmarshalYAML(data []byte) (catalog.Catalog, error) {
+	v, err := parseYAML(string(data))
+	if err != nil {
+		return catalog.Catalog{}, fmt.Errorf("decode yaml catalog: %v", err)
+	}
+	if v == nil {
+		v = map[string]interface{}{}
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return catalog.Catalog{}, fmt.Errorf("decode yaml catalog: %v", err)
+	}
+	c, err := unmarshal(j)
+	if err != nil {
+		return catalog.Catalog{}, fmt.Errorf("decode yaml catalog: %v", err)
+	}
+	return c, nil
+}
+
+type yamlParser struct {
+	lines []string
+	i     int
+}
+
+// parseYAML parses a YAML document into JSON-compatible values:
+// map[string]interface{}, []interface{}, string, bool, json.Number,
+// and nil.
+func parseYAML(src string) (interface{}, error) {
+	if !utf8.ValidString(src) {
+		return nil, errors.New("not valid UTF-8")
+	}
+	src = strings.TrimPrefix(src, "\ufeff")
+	p := &yamlParser{lines: strings.Split(strings.Replace(src, "\r\n", "\n", -1), "\n")}
+	if p.skipBlank() && isDocMarker(p.lines[p.i], "---") {
+		p.i++
+	}
+	v, err := p.parseBlock(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.skipBlank() {
+		if isDocMarker(p.lines[p.i], "...") {
+			p.i++
+			if !p.skipBlank() {
+				return v, nil
+			}
+		}
+		return nil, p.errorf("unexpected content; multiple documents are not supported")
+	}
+	return v, nil
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.i+1, fmt.Sprintf(format, args...))
+}
+
+// skipBlank advances past blank and comment lines and reports whether
+// there is a line left.
+func (p *yamlParser) skipBlank() bool {
+	for ; p.i < len(p.lines); p.i++ {
+		t := strings.TrimLeft(p.lines[p.i], " ")
+		if t != "" && t[0] != '#' {
+			return true
+		}
+	}
+	return false
+}
+
+// current returns the indentation and content of the current line, with
+// any trailing comment removed.
+func (p *yamlParser) current() (int, string, error) {
+	line := p.lines[p.i]
+	t := strings.TrimLeft(line, " ")
+	if strings.HasPrefix(t, "\t") {
+		return 0, "", p.errorf("tabs are not allowed in indentation")
+	}
+	return len(line) - len(t), stripComment(t), nil
+}
+
+// stripComment removes a trailing comment from a line, ignoring '#'
+// inside quoted scalars.
+func stripComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote == '\'' && c == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.IndexByte(" [{,:-", s[i-1]) != -1 {
+				quote = c
+			}
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return strings.TrimRight(s[:i], " \t")
+		}
+	}
+	return strings.TrimRight(s, " \t")
+}
+
+// isDocMarker reports whether line is the document marker m ("---" or
+// "..."), which ends any block collection.
+func isDocMarker(line, m string) bool {
+	return strings.HasPrefix(line, m) && stripComment(line[len(m):]) == ""
+}
+
+func isSeqItem(t string) bool {
+	return t == "-" || strings.HasPrefix(t, "- ")
+}
+
+func isComplexKey(t string) bool {
+	return t == "?" || strings.HasPrefix(t, "? ")
+}
+
+// unsupportedIndicator returns the error for a node that starts with
+// the indicator c, or nil if the parser supports c there.
+func unsupportedIndicator(c byte) error {
+	switch c {
+	case '&':
+		return errors.New("anchors ('&') are not supported")
+	case '*':
+		return errors.New("aliases ('*') are not supported")
+	case '!':
+		return errors.New("tags ('!') are not supported")
+	case '%':
+		return errors.New("directives ('%') are not supported")
+	case '|', '>', '@', '`':
+		return fmt.Errorf("unsupported YAML syntax %q", c)
+	default:
+		return nil
+	}
+}
+
+// parseBlock parses the node starting at the next non-blank line, if
+// it is indented at least minIndent.
+func (p *yamlParser) parseBlock(minIndent int) (interface{}, error) {
+	if !p.skipBlank() {
+		return nil, nil
+	}
+	indent, t, err := p.current()
+	if err != nil {
+		return nil, err
+	}
+	if indent < minIndent || isDocMarker(p.lines[p.i], "---") || isDocMarker(p.lines[p.i], "...") {
+		return nil, nil
+	}
+	if isComplexKey(t) {
+		return nil, p.errorf("complex mapping keys ('?') are not supported")
+	}
+	if isSeqItem(t) {
+		return p.parseSeq(indent)
+	}
+	if _, _, ok, err := splitKey(t); err != nil {
+		return nil, p.errorf("%v", err)
+	} else if ok {
+		return p.parseMap(indent)
+	}
+	v, err := parseInline(t)
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	p.i++
+	return v, nil
+}
+
+func (p *yamlParser) parseSeq(indent int) (interface{}, error) {
+	seq := []interface{}{}
+	for p.skipBlank() {
+		if isDocMarker(p.lines[p.i], "---") || isDocMarker(p.lines[p.i], "...") {
+			break
+		}
+		ind, t, err := p.current()
+		if err != nil {
+			return nil, err
+		}
+		if ind < indent || ind == indent && !isSeqItem(t) {
+			break
+		}
+		if ind > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		rest := strings.TrimLeft(t[1:], " ")
+		var item interface{}
+		switch {
+		case rest == "":
+			p.i++
+			item, err = p.parseBlock(indent + 1)
+		case rest[0] == '|' || rest[0] == '>':
+			item, err = p.parseBlockScalar(indent, rest)
+		default:
+			// Reparse the rest of the line as if it were indented to
+			// where it starts, so that compact nested collections work.
+			p.lines[p.i] = strings.Repeat(" ", indent+len(t)-len(rest)) + rest
+			item, err = p.parseBlock(indent + 1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, item)
+	}
+	return seq, nil
+}
+
+func (p *yamlParser) parseMap(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for p.skipBlank() {
+		if isDocMarker(p.lines[p.i], "---") || isDocMarker(p.lines[p.i], "...") {
+			break
+		}
+		ind, t, err := p.current()
+		if err != nil {
+			return nil, err
+		}
+		if ind < indent || ind == indent && isSeqItem(t) {
+			break
+		}
+		if ind > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		if isComplexKey(t) {
+			return nil, p.errorf("complex mapping keys ('?') are not supported")
+		}
+		key, rest, ok, err := splitKey(t)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		if !ok {
+			return nil, p.errorf("expected \"key: value\"")
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		var v interface{}
+		switch {
+		case rest == "":
+			p.i++
+			if !p.skipBlank() {
+				break
+			}
+			next, nt, err := p.current()
+			if err != nil {
+				return nil, err
+			}
+			if next > indent {
+				v, err = p.parseBlock(indent + 1)
+			} else if next == indent && isSeqItem(nt) {
+				v, err = p.parseSeq(indent)
+			}
+			if err != nil {
+				return nil, err
+			}
+		case rest[0] == '|' || rest[0] == '>':
+			v, err = p.parseBlockScalar(indent, rest)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			v, err = parseInline(rest)
+			if err != nil {
+				return nil, p.errorf("%v", err)
+			}
+			p.i++
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+// splitKey splits a "key: value" line.  ok is false if the line is not
+// a mapping entry.
+func splitKey(t string) (key, rest string, ok bool, err error) {
+	if t == "" || t[0] == '[' || t[0] == '{' {
+		return "", "", false, nil
+	}
+	if t[0] == '"' || t[0] == '\'' {
+		s, n, err := parseQuoted(t)
+		if err != nil {
+			return "", "", false, err
+		}
+		after := strings.TrimLeft(t[n:], " ")
+		if !strings.HasPrefix(after, ":") {
+			return "", "", false, nil
+		}
+		if len(after) > 1 && after[1] != ' ' {
+			return "", "", false, nil
+		}
+		return s, strings.TrimLeft(after[1:], " "), true, nil
+	}
+	for i := 0; i < len(t); i++ {
+		if t[i] == ':' && (i+1 == len(t) || t[i+1] == ' ') {
+			if err := unsupportedIndicator(t[0]); err != nil {
+				return "", "", false, err
+			}
+			return strings.TrimRight(t[:i], " "), strings.TrimLeft(t[i+1:], " "), true, nil
+		}
+	}
+	return "", "", false, nil
+}
+
+// parseBlockScalar parses a literal or folded block scalar whose header
+// is on the current line.  parentIndent is the indentation of the
+// mapping key or sequence item that owns the scalar.
+func (p *yamlParser) parseBlockScalar(parentIndent int, header string) (interface{}, error) {
+	folded := header[0] == '>'
+	chomp := byte(0)
+	indent := 0
+	for _, c := range []byte(header[1:]) {
+		switch {
+		case (c == '-' || c == '+') && chomp == 0:
+			chomp = c
+		case c >= '1' && c <= '9' && indent == 0:
+			indent = parentIndent + int(c-'0')
+		default:
+			return nil, p.errorf("invalid block scalar header %q", header)
+		}
+	}
+	p.i++
+	var lines []string
+	for ; p.i < len(p.lines); p.i++ {
+		line := p.lines[p.i]
+		t := strings.TrimLeft(line, " ")
+		ind := len(line) - len(t)
+		if t == "" {
+			lines = append(lines, "")
+			continue
+		}
+		if indent == 0 {
+			if ind <= parentIndent {
+				break
+			}
+			indent = ind
+		}
+		if ind < indent {
+			break
+		}
+		lines = append(lines, line[indent:])
+	}
+	// Trailing blank lines belong to the scalar only for chomping.
+	trailing := 0
+	for len(lines) > 0 && strings.TrimLeft(lines[len(lines)-1], " ") == "" {
+		lines = lines[:len(lines)-1]
+		trailing++
+	}
+	var buf bytes.Buffer
+	for i, line := range lines {
+		switch {
+		case i == 0:
+		case !folded:
+			buf.WriteByte('\n')
+		case line == "" || lines[i-1] == "":
+			// A blank line in folded text becomes a newline; the line
+			// after it starts without a joining space.
+			if line == "" {
+				buf.WriteByte('\n')
+			}
+		case strings.HasPrefix(line, " ") || strings.HasPrefix(lines[i-1], " "):
+			buf.WriteByte('\n')
+		default:
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(line)
+	}
+	s := buf.String()
+	if len(lines) > 0 {
+		switch chomp {
+		case 0:
+			s += "\n"
+		case '+':
+			s += strings.Repeat("\n", 1+trailing)
+		}
+	} else if chomp == '+' {
+		s = strings.Repeat("\n", trailing)
+	}
+	return s, nil
+}
+
+// parseInline parses a scalar or flow collection that is entirely on
+// one line.
+func parseInline(t string) (interface{}, error) {
+	v, n, err := parseFlow(t, false)
+	if err != nil {
+		return nil, err
+	}
+	if rest := strings.TrimSpace(t[n:]); rest != "" {
+		return nil, fmt.Errorf("unexpected %q after value", rest)
+	}
+	return v, nil
+}
+
+// parseFlow parses a value at the start of t, returning the number of
+// bytes consumed.  If inFlow is true, then the value is inside a flow
+// collection, so plain scalars end at ',', ']', and '}'.
+func parseFlow(t string, inFlow bool) (interface{}, int, error) {
+	n := len(t) - len(strings.TrimLeft(t, " "))
+	t = t[n:]
+	if t == "" {
+		return nil, n, nil
+	}
+	switch t[0] {
+	case '"', '\'':
+		s, m, err := parseQuoted(t)
+		return s, n + m, err
+	case '[':
+		seq := []interface{}{}
+		i := 1
+		for {
+			i += len(t[i:]) - len(strings.TrimLeft(t[i:], " "))
+			if i == len(t) {
+				return nil, 0, errMultilineFlow
+			}
+			if t[i] == ']' {
+				return seq, n + i + 1, nil
+			}
+			v, m, err := parseFlow(t[i:], true)
+			if err != nil {
+				return nil, 0, err
+			}
+			seq = append(seq, v)
+			i += m
+			i += len(t[i:]) - len(strings.TrimLeft(t[i:], " "))
+			switch {
+			case i < len(t) && t[i] == ',':
+				i++
+			case i < len(t) && t[i] == ']':
+				return seq, n + i + 1, nil
+			case i == len(t):
+				return nil, 0, errMultilineFlow
+			default:
+				return nil, 0, fmt.Errorf("expected ',' or ']' in flow sequence, found %q", t[i:])
+			}
+		}
+	case '{':
+		m := make(map[string]interface{})
+		i := 1
+		for {
+			i += len(t[i:]) - len(strings.TrimLeft(t[i:], " "))
+			if i == len(t) {
+				return nil, 0, errMultilineFlow
+			}
+			if t[i] == '}' {
+				return m, n + i + 1, nil
+			}
+			k, kn, err := parseFlow(t[i:], true)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("flow mapping key %v is not a string", k)
+			}
+			i += kn
+			if !strings.HasPrefix(t[i:], ":") {
+				return nil, 0, errors.New("expected ':' in flow mapping")
+			}
+			i++
+			v, vn, err := parseFlow(t[i:], true)
+			if err != nil {
+				return nil, 0, err
+			}
+			if _, dup := m[key]; dup {
+				return nil, 0, fmt.Errorf("duplicate key %q", key)
+			}
+			m[key] = v
+			i += vn
+			i += len(t[i:]) - len(strings.TrimLeft(t[i:], " "))
+			switch {
+			case i < len(t) && t[i] == ',':
+				i++
+			case i < len(t) && t[i] == '}':
+				return m, n + i + 1, nil
+			case i == len(t):
+				return nil, 0, errMultilineFlow
+			default:
+				return nil, 0, fmt.Errorf("expected ',' or '}' in flow mapping, found %q", t[i:])
+			}
+		}
+	default:
+		if err := unsupportedIndicator(t[0]); err != nil {
+			return nil, 0, err
+		}
+	}
+	end := len(t)
+	for i := 0; i < len(t); i++ {
+		if inFlow && strings.IndexByte(",]}", t[i]) != -1 {
+			end = i
+			break
+		}
+		if inFlow && t[i] == ':' && (i+1 == len(t) || strings.IndexByte(" ,]}", t[i+1]) != -1) {
+			end = i
+			break
+		}
+	}
+	plain := strings.TrimRight(t[:end], " ")
+	if !inFlow && (strings.Contains(plain, ": ") || strings.HasSuffix(plain, ":")) {
+		return nil, 0, fmt.Errorf("unexpected ':' in plain scalar %q; quote it", plain)
+	}
+	return resolvePlain(plain), n + end, nil
+}
+
+var errMultilineFlow = errors.New("flow collections must be on one line")
+
+var (
+	yamlInt   = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	yamlOctal = regexp.MustCompile(`^0o?[0-7]+$`)
+	yamlHex   = regexp.MustCompile(`^0x[0-9a-fA-F]+$`)
+	yamlFloat = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+)
+
+// resolvePlain resolves a plain scalar to its value.
+func resolvePlain(s string) interface{} {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if s != "0" && yamlOctal.MatchString(s) {
+		return radixNumber(strings.TrimPrefix(s[1:], "o"), 8, s)
+	}
+	if yamlHex.MatchString(s) {
+		return radixNumber(s[2:], 16, s)
+	}
+	digits := strings.TrimLeft(s, "+-")
+	if len(digits) > 1 && digits[0] == '0' && yamlInt.MatchString(s) {
+		// Not octal, like 0800, so not an integer in either schema.
+		return s
+	}
+	if yamlInt.MatchString(s) || yamlFloat.MatchString(s) {
+		return json.Number(strings.TrimPrefix(s, "+"))
+	}
+	return s
+}
+
+// radixNumber converts digits in the given base to a decimal number.
+// If the value does not fit in 64 bits, then it returns orig as a
+// string.
+func radixNumber(digits string, base int, orig string) interface{} {
+	v, err := strconv.ParseUint(digits, base, 64)
+	if err != nil {
+		return orig
+	}
+	return json.Number(strconv.FormatUint(v, 10))
+}
+
+// parseQuoted parses a single- or double-quoted scalar at the start of
+// t, returning the number of bytes consumed.
+func parseQuoted(t string) (string, int, error) {
+	q := t[0]
+	var buf bytes.Buffer
+	for i := 1; i < len(t); i++ {
+		c := t[i]
+		switch {
+		case c == q && q == '\'' && i+1 < len(t) && t[i+1] == '\'':
+			buf.WriteByte('\'')
+			i++
+		case c == q:
+			return buf.String(), i + 1, nil
+		case c == '\\' && q == '"':
+			i++
+			if i >= len(t) {
+				return "", 0, errors.New("unterminated string")
+			}
+			n, err := unescape(&buf, t[i:])
+			if err != nil {
+				return "", 0, err
+			}
+			i += n - 1
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return "", 0, errors.New("unterminated string; multi-line quoted scalars are not supported")
+}
+
+// unescape decodes the escape sequence at the start of t (after the
+// backslash) into buf and returns its length.
+func unescape(buf *bytes.Buffer, t string) (int, error) {
+	simple := map[byte]string{
+		'0': "\x00", 'a': "\a", 'b': "\b", 't': "\t", 'n': "\n", 'v': "\v",
+		'f': "\f", 'r': "\r", 'e': "\x1b", ' ': " ", '"': "\"", '/': "/",
+		'\\': "\\", 'N': "\u0085", '_': "\u00a0", 'L': "\u2028", 'P': "\u2029",
+	}
+	if s, ok := simple[t[0]]; ok {
+		buf.WriteString(s)
+		return 1, nil
+	}
+	width := map[byte]int{'x': 2, 'u': 4, 'U': 8}[t[0]]
+	if width == 0 || len(t) < 1+width {
+		return 0, fmt.Errorf("invalid escape sequence \\%c", t[0])
+	}
+	v, err := strconv.ParseUint(t[1:1+width], 16, 32)
+	if err != nil || !utf8.ValidRune(rune(v)) {
+		return 0, fmt.Errorf("invalid escape sequence \\%s", t[:1+width])
+	}
+	buf.WriteRune(rune(v))
+	return 1 + width, nil
+}
@@ -0,0 +1,206 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catjson
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want string // as JSON
+	}{
+		{"", `null`},
+		{"# just a comment\n", `null`},
+		{"---\nfoo: bar\n...\n", `{"foo":"bar"}`},
+		{"foo: bar # comment\nbaz: 'it''s # not a comment'\n", `{"baz":"it's # not a comment","foo":"bar"}`},
+		{"a: 1\nb: -2.5\nc: true\nd: ~\ne: 0644\nf: 18446744073709551615\ng:\n", `{"a":1,"b":-2.5,"c":true,"d":null,"e":420,"f":18446744073709551615,"g":null}`},
+		{"a: 0644\nb: 0o755\nc: 0x1ff\nd: 0800\ne: '0644'\nf: 0\ng: -0644\n", `{"a":420,"b":493,"c":511,"d":"0800","e":"0644","f":0,"g":"-0644"}`},
+		{`s: "tab\there \"quoted\" é"`, `{"s":"tab\there \"quoted\" é"}`},
+		{"list:\n- a\n- b\n", `{"list":["a","b"]}`},
+		{"list:\n  - a\n  -   b\n", `{"list":["a","b"]}`},
+		{"- x: 1\n  y: 2\n- x: 3\n", `[{"x":1,"y":2},{"x":3}]`},
+		{"- - a\n  - b\n- - c\n", `[["a","b"],["c"]]`},
+		{"outer:\n  inner:\n    leaf: v\n  other: w\n", `{"outer":{"inner":{"leaf":"v"},"other":"w"}}`},
+		{"flow: [1, \"two\", [3], {a: b, c: [d]}]\nempty: {}\n", `{"empty":{},"flow":[1,"two",[3],{"a":"b","c":["d"]}]}`},
+		{"path: /etc/foo:bar\nurl: http://example.com/\n", `{"path":"/etc/foo:bar","url":"http://example.com/"}`},
+		{"\"quoted key\": 1\n", `{"quoted key":1}`},
+		{"a: 'b: c'\nd: \"e:\"\n", `{"a":"b: c","d":"e:"}`},
+		{"---\n...\n", `null`},
+		{"lit: |\n  line 1\n    indented\n\n  line 3\nnext: x\n", `{"lit":"line 1\n  indented\n\nline 3\n","next":"x"}`},
+		{"lit: |-\n  no newline\n", `{"lit":"no newline"}`},
+		{"lit: |+\n  keep\n\n\nnext: x\n", `{"lit":"keep\n\n\n","next":"x"}`},
+		{"fold: >\n  one\n  two\n\n  three\n", `{"fold":"one two\nthree\n"}`},
+		{"- |\n  item\n- b\n", `["item\n","b"]`},
+		{"lit: |\n  # not a comment\n", `{"lit":"# not a comment\n"}`},
+	}
+	for _, test := range tests {
+		v, err := parseYAML(test.yaml)
+		if err != nil {
+			t.Errorf("parseYAML(%q): %v", test.yaml, err)
+			continue
+		}
+		got, err := json.Marshal(v)
+		if err != nil {
+			t.Errorf("parseYAML(%q) = %#v; cannot marshal: %v", test.yaml, v, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("parseYAML(%q) = %s; want %s", test.yaml, got, test.want)
+		}
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want string // substring of the error
+	}{
+		{"a: 1\na: 2\n", `line 2: duplicate key "a"`},
+		{"a: 1\n  b: 2\n", "line 2: unexpected indentation"},
+		{"a: &anchor 1\n", "line 1: anchors ('&') are not supported"},
+		{"&anchor a: 1\n", "line 1: anchors ('&') are not supported"},
+		{"- &anchor\n  a: 1\n", "line 1: anchors ('&') are not supported"},
+		{"a: *alias\n", "line 1: aliases ('*') are not supported"},
+		{"a: [1, *alias]\n", "line 1: aliases ('*') are not supported"},
+		{"<<: *alias\n", "line 1: aliases ('*') are not supported"},
+		{"a: !!str 1\n", "line 1: tags ('!') are not supported"},
+		{"%YAML 1.2\n---\na: 1\n", "line 1: directives ('%') are not supported"},
+		{"? a\n: 1\n", "line 1: complex mapping keys ('?') are not supported"},
+		{"a: 1\n? b\n", "line 2: complex mapping keys ('?') are not supported"},
+		{"a: {b: 1,\n  c: 2}\n", "line 1: flow collections must be on one line"},
+		{"a: [1, 2\n", "line 1: flow collections must be on one line"},
+		{"a: {b: 1} x\n", `unexpected "x" after value`},
+		{"a: [1 2] ]\n", `unexpected "]" after value`},
+		{"a: [b: c]\n", "expected ',' or ']' in flow sequence"},
+		{"a: b: c\n", `line 1: unexpected ':' in plain scalar "b: c"`},
+		{"a: 1\n---\nb: 2\n", "line 2: unexpected content; multiple documents are not supported"},
+		{"---\na: 1\n---\n", "line 3: unexpected content; multiple documents are not supported"},
+		{"a: 1\n...\n---\nb: 2\n", "line 3: unexpected content; multiple documents are not supported"},
+		{"a: \"unterminated\n", "multi-line quoted scalars are not supported"},
+		{"\ta: 1\n", "tabs are not allowed in indentation"},
+		{"a: \"bad \\q escape\"\n", `invalid escape sequence \q`},
+		{"- a\nb: 1\n", "line 2: unexpected content"},
+	}
+	for _, test := range tests {
+		v, err := parseYAML(test.yaml)
+		if err == nil {
+			t.Errorf("parseYAML(%q) = %#v; want error", test.yaml, v)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("parseYAML(%q) error = %v; want it to contain %q", test.yaml, err, test.want)
+		}
+	}
+}
+
+func TestUnmarshalYAML(t *testing.T) {
+	const yaml = `# Example catalog
+resources:
+  - id: 1
+    comment: hello
+    file:
+      path: /etc/hello.txt
+      plain:
+        content: |
+          Hello, World!
+        mode: {bits: 0644}
+  - id: 2
+    dependencies: [1]
+    exec:
+      command:
+        argv: [/usr/bin/apt-get, update]
+      condition:
+        ifDepsChanged: [1]
+`
+	const json = `{
+  "resources": [
+    {
+      "id": 1,
+      "comment": "hello",
+      "file": {
+        "path": "/etc/hello.txt",
+        "plain": {"content": "Hello, World!\n", "mode": {"bits": "0644"}}
+      }
+    },
+    {
+      "id": 2,
+      "dependencies": [1],
+      "exec": {
+        "command": {"argv": ["/usr/bin/apt-get", "update"]},
+        "condition": {"ifDepsChanged": [1]}
+      }
+    }
+  ]
+}`
+	yc, err := UnmarshalYAML([]byte(yaml))
+	if err != nil {
+		t.Fatal("UnmarshalYAML:", err)
+	}
+	jc, err := Unmarshal([]byte(json))
+	if err != nil {
+		t.Fatal("Unmarshal:", err)
+	}
+	if got, want := textOf(t, yc), textOf(t, jc); got != want {
+		t.Errorf("UnmarshalYAML(...) = %s; want %s", got, want)
+	}
+}
+
+func TestUnmarshalYAMLModeBits(t *testing.T) {
+	tests := []struct {
+		bits string
+		want uint16
+	}{
+		{"0644", 0644},
+		{"0o755", 0755},
+		{"'1777'", 01777},
+		{"420", 0644},
+	}
+	for _, test := range tests {
+		yaml := "resources:\n" +
+			"  - id: 1\n" +
+			"    file:\n" +
+			"      path: /foo\n" +
+			"      directory:\n" +
+			"        mode:\n" +
+			"          bits: " + test.bits + "\n"
+		c, err := UnmarshalYAML([]byte(yaml))
+		if err != nil {
+			t.Errorf("bits: %s: %v", test.bits, err)
+			continue
+		}
+		res, err := c.Resources()
+		if err != nil {
+			t.Errorf("bits: %s: %v", test.bits, err)
+			continue
+		}
+		f, err := res.At(0).File()
+		if err != nil {
+			t.Errorf("bits: %s: %v", test.bits, err)
+			continue
+		}
+		mode, err := f.Directory().Mode()
+		if err != nil {
+			t.Errorf("bits: %s: %v", test.bits, err)
+			continue
+		}
+		if got := mode.Bits(); got != test.want {
+			t.Errorf("bits: %s decoded to %#o; want %#o", test.bits, got, test.want)
+		}
+	}
+}
@@ -13,7 +13,8 @@
 // limitations under the License.
 
 // Package catpogs provides Go struct equivalents of the Cap'n Proto
-// catalog.  These are primarily intended for constructing test inputs.
+// catalog.  These are primarily intended for constructing test inputs;
+// programs should use the catalogbuilder package instead.
 package catpogs
 
 import (
@@ -24,6 +25,8 @@ import (
 
 type Catalog struct {
 	Resources []*Resource
+	Exports   []ResourceName
+	Imports   []ResourceName
 }
 
 func (c *Catalog) ToCapnp() (catalog.Catalog, error) {
@@ -39,10 +42,16 @@ func (c *Catalog) ToCapnp() (catalog.Catalog, error) {
 	return root, err
 }
 
+type ResourceName struct {
+	Name string
+	ID   uint64 `capnp:"id"`
+}
+
 type Resource struct {
 	ID      uint64 `capnp:"id"`
 	Comment string
 	Deps    []uint64 `capnp:"dependencies"`
+	Locks   []string
 
 	Which catalog.Resource_Which
 	File  *File
@@ -54,8 +63,9 @@ type File struct {
 
 	Which catalog.File_Which
 	Plain struct {
-		Content []byte
-		Mode    *FileMode
+		Content       []byte
+		Mode          *FileMode
+		ContentSHA256 []byte `capnp:"contentSha256"`
 	}
 	Directory struct {
 		Mode *FileMode
@@ -63,6 +73,9 @@ type File struct {
 	Symlink struct {
 		Target string
 	}
+	Hardlink struct {
+		Target string
+	}
 }
 
 func PlainFile(path string, content []byte) *File {
@@ -92,6 +105,15 @@ func SymlinkFile(oldname, newname string) *File {
 	return f
 }
 
+func HardlinkFile(oldname, newname string) *File {
+	f := &File{
+		Path:  newname,
+		Which: catalog.File_Which_hardlink,
+	}
+	f.Hardlink.Target = oldname
+	return f
+}
+
 type FileMode struct {
 	Bits  uint16
 	User  *UserRef
@@ -0,0 +1,249 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package catsig signs and verifies catalogs with ed25519.
+//
+// A signature covers the exact bytes of a catalog file, in whatever
+// format it is written.  Signatures are stored in a SignedCatalog
+// message (see catalog.capnp), which either embeds the catalog bytes or
+// is kept alongside the catalog as a detached signature.
+//
+// Keys are written in base64.  A private key file holds a single
+// 64-byte ed25519 private key.  A keyring file lists trusted public
+// keys, one per line, each optionally followed by a comment:
+//
+//	# Release signing keys
+//	Ru3ChmgODsDsyAxbAuyqTL7CvVYYP9pcp55L2AzsVqA= release@example.com
+package catsig
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto"
+	"github.com/zombiezen/mcm/third_party/golang/ed25519"
+)
+
+// Sign returns a serialized SignedCatalog message with a signature of
+// data by key.  If embed is true, then the message also holds data;
+// otherwise, it is a detached signature.
+func Sign(data []byte, key ed25519.PrivateKey, embed bool) ([]byte, error) {
+	msg, seg, err := capnp.NewMessage(capnp.SingleSegment(nil))
+	if err != nil {
+		return nil, fmt.Errorf("sign catalog: %v", err)
+	}
+	sc, err := catalog.NewRootSignedCatalog(seg)
+	if err != nil {
+		return nil, fmt.Errorf("sign catalog: %v", err)
+	}
+	if embed {
+		if err := sc.SetCatalog(data); err != nil {
+			return nil, fmt.Errorf("sign catalog: %v", err)
+		}
+	}
+	sigs, err := sc.NewSignatures(1)
+	if err != nil {
+		return nil, fmt.Errorf("sign catalog: %v", err)
+	}
+	if err := sigs.At(0).SetPublicKey(key.Public().(ed25519.PublicKey)); err != nil {
+		return nil, fmt.Errorf("sign catalog: %v", err)
+	}
+	if err := sigs.At(0).SetSignature(ed25519.Sign(key, data)); err != nil {
+		return nil, fmt.Errorf("sign catalog: %v", err)
+	}
+	out, err := msg.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("sign catalog: %v", err)
+	}
+	return out, nil
+}
+
+// VerifyError is returned when a catalog does not have a valid
+// signature from a trusted key.
+type VerifyError struct {
+	Reason string
+}
+
+func (e *VerifyError) Error() string {
+	return "verify catalog signature: " + e.Reason
+}
+
+// IsVerifyError reports whether err is a *VerifyError.
+func IsVerifyError(err error) bool {
+	_, ok := err.(*VerifyError)
+	return ok
+}
+
+// A Keyring is a set of trusted public keys.
+type Keyring struct {
+	keys []ed25519.PublicKey
+}
+
+// ParseKeyring parses a keyring file.
+func ParseKeyring(data []byte) (*Keyring, error) {
+	kr := new(Keyring)
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; s.Scan(); lineno++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		field := strings.Fields(line)[0]
+		pub, err := ParsePublicKey(field)
+		if err != nil {
+			return nil, fmt.Errorf("parse keyring: line %d: %v", lineno, err)
+		}
+		kr.keys = append(kr.keys, pub)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("parse keyring: %v", err)
+	}
+	return kr, nil
+}
+
+// ReadKeyring reads and parses the keyring file at path.
+func ReadKeyring(path string) (*Keyring, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read keyring: %v", err)
+	}
+	kr, err := ParseKeyring(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return kr, nil
+}
+
+// Open verifies an embedded signed catalog and returns the catalog
+// bytes.  An error from verification is a *VerifyError.
+func (kr *Keyring) Open(signed []byte) ([]byte, error) {
+	sc, err := readSignedCatalog(signed)
+	if err != nil {
+		return nil, err
+	}
+	if !sc.HasCatalog() {
+		return nil, &VerifyError{Reason: "signed catalog has no catalog; is it a detached signature?"}
+	}
+	data, err := sc.Catalog()
+	if err != nil {
+		return nil, fmt.Errorf("read signed catalog: %v", err)
+	}
+	if err := kr.verify(data, sc); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// VerifyDetached verifies a detached signature of data.  An error from
+// verification is a *VerifyError.
+func (kr *Keyring) VerifyDetached(data, sig []byte) error {
+	sc, err := readSignedCatalog(sig)
+	if err != nil {
+		return err
+	}
+	return kr.verify(data, sc)
+}
+
+func readSignedCatalog(b []byte) (catalog.SignedCatalog, error) {
+	msg, err := capnp.Unmarshal(b)
+	if err != nil {
+		return catalog.SignedCatalog{}, &VerifyError{Reason: fmt.Sprintf("read signed catalog: %v", err)}
+	}
+	// The catalog is at most the size of the message.
+	msg.TraverseLimit = uint64(len(b)) * 2
+	sc, err := catalog.ReadRootSignedCatalog(msg)
+	if err != nil {
+		return catalog.SignedCatalog{}, &VerifyError{Reason: fmt.Sprintf("read signed catalog: %v", err)}
+	}
+	return sc, nil
+}
+
+// verify succeeds if any of the signatures in sc is a valid signature
+// of data by a key in the keyring.
+func (kr *Keyring) verify(data []byte, sc catalog.SignedCatalog) error {
+	sigs, err := sc.Signatures()
+	if err != nil {
+		return &VerifyError{Reason: fmt.Sprintf("read signatures: %v", err)}
+	}
+	if sigs.Len() == 0 {
+		return &VerifyError{Reason: "catalog is not signed"}
+	}
+	var invalid []string
+	for i := 0; i < sigs.Len(); i++ {
+		pub, err := sigs.At(i).PublicKey()
+		if err != nil || !kr.trusts(pub) {
+			continue
+		}
+		sig, err := sigs.At(i).Signature()
+		if err == nil && ed25519.Verify(pub, data, sig) {
+			return nil
+		}
+		invalid = append(invalid, FormatPublicKey(pub))
+	}
+	if len(invalid) > 0 {
+		return &VerifyError{Reason: "invalid signature by " + strings.Join(invalid, ", ")}
+	}
+	return &VerifyError{Reason: "no signature by a trusted key"}
+}
+
+func (kr *Keyring) trusts(pub []byte) bool {
+	if len(pub) != ed25519.PublicKeySize {
+		return false
+	}
+	for _, k := range kr.keys {
+		if bytes.Equal(k, pub) {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatPublicKey returns pub in base64, as it appears in a keyring.
+func FormatPublicKey(pub ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(pub)
+}
+
+// ParsePublicKey parses a base64 public key.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("public key: %v", err)
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key is %d bytes; want %d", len(b), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// MarshalPrivateKey returns the contents of a private key file for key.
+func MarshalPrivateKey(key ed25519.PrivateKey) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(key) + "\n")
+}
+
+// ParsePrivateKey parses the contents of a private key file.
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("private key: %v", err)
+	}
+	if len(b) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("private key is %d bytes; want %d", len(b), ed25519.PrivateKeySize)
+	}
+	return ed25519.PrivateKey(b), nil
+}
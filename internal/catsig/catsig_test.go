@@ -0,0 +1,114 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catsig
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/zombiezen/mcm/third_party/golang/ed25519"
+)
+
+func TestSignVerify(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr, err := ParseKeyring([]byte("# trusted\n\n" + FormatPublicKey(pub) + " alice@example.com\n"))
+	if err != nil {
+		t.Fatal("ParseKeyring:", err)
+	}
+	data := bytes.Repeat([]byte("catalog bytes\n"), 1000)
+
+	embedded, err := Sign(data, key, true)
+	if err != nil {
+		t.Fatal("Sign embedded:", err)
+	}
+	if got, err := kr.Open(embedded); err != nil {
+		t.Errorf("Open: %v", err)
+	} else if !bytes.Equal(got, data) {
+		t.Error("Open returned different bytes than were signed")
+	}
+	detached, err := Sign(data, key, false)
+	if err != nil {
+		t.Fatal("Sign detached:", err)
+	}
+	if len(detached) >= len(data) {
+		t.Errorf("detached signature is %d bytes; want smaller than catalog", len(detached))
+	}
+	if err := kr.VerifyDetached(data, detached); err != nil {
+		t.Errorf("VerifyDetached: %v", err)
+	}
+
+	untrusted, err := Sign(data, otherKey, true)
+	if err != nil {
+		t.Fatal("Sign with other key:", err)
+	}
+	tampered := append(append([]byte(nil), data...), '!')
+	failures := []struct {
+		name string
+		err  error
+	}{
+		{"untrusted key", openErr(kr, untrusted)},
+		{"tampered detached", kr.VerifyDetached(tampered, detached)},
+		{"open detached", openErr(kr, detached)},
+		{"unsigned", kr.VerifyDetached(data, []byte("not a message"))},
+		{"empty keyring", new(Keyring).VerifyDetached(data, detached)},
+	}
+	for _, f := range failures {
+		if !IsVerifyError(f.err) {
+			t.Errorf("%s: error = %v; want a VerifyError", f.name, f.err)
+		}
+	}
+}
+
+func openErr(kr *Keyring, signed []byte) error {
+	_, err := kr.Open(signed)
+	return err
+}
+
+func TestParseKeyringErrors(t *testing.T) {
+	tests := []string{
+		"not base64!\n",
+		"AAAA\n",
+	}
+	for _, test := range tests {
+		if _, err := ParseKeyring([]byte(test)); err == nil {
+			t.Errorf("ParseKeyring(%q) succeeded; want error", test)
+		}
+	}
+}
+
+func TestPrivateKey(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParsePrivateKey(MarshalPrivateKey(key))
+	if err != nil {
+		t.Fatal("ParsePrivateKey:", err)
+	}
+	if !bytes.Equal(got, key) {
+		t.Error("private key did not round-trip")
+	}
+	if _, err := ParsePrivateKey([]byte("AAAA\n")); err == nil {
+		t.Error("ParsePrivateKey of short key succeeded")
+	}
+}
@@ -0,0 +1,380 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package depgraph
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// An Analysis describes how much of a graph can be applied in parallel.
+// Costs are in whatever unit the cost function passed to Analyze uses.
+type Analysis struct {
+	// Levels groups resources by depth.  Levels[0] holds the resources
+	// with no dependencies, and Levels[i] holds the resources whose
+	// longest chain of dependencies is i resources long.  Each level is
+	// in catalog order.
+	Levels [][]uint64
+
+	// CriticalPath is the chain of dependencies with the greatest total
+	// cost, in the order that they must be applied.  No schedule can
+	// apply the graph in less than its cost, no matter how many workers
+	// it uses.
+	CriticalPath []uint64
+
+	// CriticalCost is the total cost of CriticalPath.
+	CriticalCost int64
+
+	// TotalCost is the sum of the costs of all resources: the cost of
+	// applying the graph with a single worker.
+	TotalCost int64
+
+	// Bottlenecks are the dependencies along the critical path that
+	// would shorten it if they were removed, most effective first.
+	Bottlenecks []Bottleneck
+}
+
+// A Bottleneck is a dependency that serializes a graph.
+type Bottleneck struct {
+	// Resource depends on Dep.
+	Resource, Dep uint64
+
+	// CriticalCost is the cost of the graph's critical path without
+	// this dependency.
+	CriticalCost int64
+}
+
+// Depth returns the number of levels in the graph.
+func (a *Analysis) Depth() int {
+	return len(a.Levels)
+}
+
+// Width returns the number of resources in the largest level.
+func (a *Analysis) Width() int {
+	w := 0
+	for _, l := range a.Levels {
+		if len(l) > w {
+			w = len(l)
+		}
+	}
+	return w
+}
+
+// Parallelism returns the average number of resources that can be
+// applied at once with unlimited workers: the ratio of the total cost
+// to the critical path's cost.  Using more workers than this will not
+// speed up applying the graph.
+func (a *Analysis) Parallelism() float64 {
+	if a.CriticalCost == 0 {
+		return 0
+	}
+	return float64(a.TotalCost) / float64(a.CriticalCost)
+}
+
+// Analyze computes the shape of the graph.  cost returns the cost of
+// applying a resource, like how long it took in a previous run.  If
+// cost is nil, then every resource costs 1.  Analyze does not depend on
+// which resources have been marked.
+func (g *Graph) Analyze(cost func(id uint64) int64) *Analysis {
+	if cost == nil {
+		cost = func(uint64) int64 { return 1 }
+	}
+	ids, depsOf, order := g.structure()
+	n := len(ids)
+	costs := make(map[uint64]int64, n)
+	a := new(Analysis)
+	for _, id := range ids {
+		costs[id] = cost(id)
+		a.TotalCost += costs[id]
+	}
+
+	level := make(map[uint64]int, n)
+	for _, id := range order {
+		for _, d := range depsOf[id] {
+			if level[d]+1 > level[id] {
+				level[id] = level[d] + 1
+			}
+		}
+	}
+	for _, id := range ids {
+		l := level[id]
+		for len(a.Levels) <= l {
+			a.Levels = append(a.Levels, nil)
+		}
+		a.Levels[l] = append(a.Levels[l], id)
+	}
+
+	finish, pred := longestPaths(order, depsOf, costs)
+	a.CriticalCost, a.CriticalPath = criticalPath(ids, finish, pred)
+	for _, b := range bottlenecks(order, depsOf, costs, finish, a.CriticalPath) {
+		if b.CriticalCost < a.CriticalCost {
+			a.Bottlenecks = append(a.Bottlenecks, b)
+		}
+	}
+	sort.SliceStable(a.Bottlenecks, func(i, j int) bool {
+		return a.Bottlenecks[i].CriticalCost < a.Bottlenecks[j].CriticalCost
+	})
+	return a
+}
+
+// bottlenecks returns the cost of the graph's critical path without
+// each dependency along path, in path order.
+//
+// Number the resources by their position in order.  Any chain that
+// does not use the dependency of path[i+1] on path[i] either stays at
+// or before path[i]'s position, stays after it, or crosses it with some
+// other dependency.  None of those chains can use the removed
+// dependency, so their costs come from finish and from the longest
+// chains of dependents (tail) in the whole graph.
+func bottlenecks(order []uint64, depsOf map[uint64][]uint64, costs map[uint64]int64, finish map[uint64]int64, path []uint64) []Bottleneck {
+	if len(path) < 2 {
+		return nil
+	}
+	n := len(order)
+	pos := make(map[uint64]int, n)
+	for i, id := range order {
+		pos[id] = i
+	}
+	// tail is the cost of the most expensive chain of dependents
+	// starting at each resource.  Dependents come later in order, so by
+	// the time a resource is reached, its tail holds the longest tail of
+	// its dependents.
+	tail := make(map[uint64]int64, n)
+	for i := n - 1; i >= 0; i-- {
+		id := order[i]
+		tail[id] += costs[id]
+		for _, d := range depsOf[id] {
+			if tail[id] > tail[d] {
+				tail[d] = tail[id]
+			}
+		}
+	}
+	before := make([]int64, n)  // before[i] is the max finish in order[:i+1]
+	after := make([]int64, n+1) // after[i] is the max tail in order[i:]
+	for i, id := range order {
+		before[i] = finish[id]
+		if i > 0 && before[i-1] > before[i] {
+			before[i] = before[i-1]
+		}
+	}
+	for i := n - 1; i >= 0; i-- {
+		after[i] = tail[order[i]]
+		if after[i+1] > after[i] {
+			after[i] = after[i+1]
+		}
+	}
+
+	// cuts[k] is the position of path[k].  Removing the dependency of
+	// path[k+1] on path[k] cuts the order just after cuts[k].
+	cuts := make([]int, len(path)-1)
+	onPath := make(map[uint64]uint64, len(path))
+	for k := range cuts {
+		cuts[k] = pos[path[k]]
+		onPath[path[k+1]] = path[k]
+	}
+	// A dependency of y on x crosses the cuts in [pos[x], pos[y]).
+	// Dependencies along path only cross their own cut, so they are
+	// left out.
+	start := make([][]crossing, len(cuts))
+	for _, y := range order {
+		for _, x := range depsOf[y] {
+			if d, ok := onPath[y]; ok && d == x {
+				continue
+			}
+			lo := sort.SearchInts(cuts, pos[x])
+			hi := sort.SearchInts(cuts, pos[y]) // first cut not crossed
+			if lo < hi {
+				start[lo] = append(start[lo], crossing{cost: finish[x] + tail[y], end: hi})
+			}
+		}
+	}
+	result := make([]Bottleneck, len(cuts))
+	h := new(crossingHeap)
+	for k, c := range cuts {
+		for _, cr := range start[k] {
+			heap.Push(h, cr)
+		}
+		for h.Len() > 0 && (*h)[0].end <= k {
+			heap.Pop(h)
+		}
+		cost := before[c]
+		if after[c+1] > cost {
+			cost = after[c+1]
+		}
+		if h.Len() > 0 && (*h)[0].cost > cost {
+			cost = (*h)[0].cost
+		}
+		result[k] = Bottleneck{Resource: path[k+1], Dep: path[k], CriticalCost: cost}
+	}
+	return result
+}
+
+// A crossing is the cost of the longest chain through a dependency and
+// the first cut that the dependency does not cross.
+type crossing struct {
+	cost int64
+	end  int
+}
+
+// crossingHeap is a max-heap of crossings by cost.
+type crossingHeap []crossing
+
+func (h crossingHeap) Len() int            { return len(h) }
+func (h crossingHeap) Less(i, j int) bool  { return h[i].cost > h[j].cost }
+func (h crossingHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *crossingHeap) Push(x interface{}) { *h = append(*h, x.(crossing)) }
+
+func (h *crossingHeap) Pop() interface{} {
+	x := (*h)[len(*h)-1]
+	*h = (*h)[:len(*h)-1]
+	return x
+}
+
+// RemainingCosts returns the total cost of the most expensive chain of
+// resources that starts with each resource and continues through
+// resources that depend on it.  This is the least cost of applying the
+// rest of the graph once the resource is started.  cost is as in
+// Analyze.
+func (g *Graph) RemainingCosts(cost func(id uint64) int64) map[uint64]int64 {
+	if cost == nil {
+		cost = func(uint64) int64 { return 1 }
+	}
+	_, _, order := g.structure()
+	remaining := make(map[uint64]int64, len(order))
+	for i := len(order) - 1; i >= 0; i-- {
+		id := order[i]
+		var max int64
+		for _, dep := range g.deps[id] {
+			if remaining[dep] > max {
+				max = remaining[dep]
+			}
+		}
+		remaining[id] = max + cost(id)
+	}
+	return remaining
+}
+
+// DescendantCounts returns an estimate of the number of resources that
+// depend on each resource, directly or indirectly.  Each resource's
+// count is the sum of one plus the count of each of its dependents, so
+// a resource that can be reached through more than one chain of
+// dependents is counted once per chain.  The counts are exact when no
+// resource has more than one such chain, as in a tree, and they are
+// capped at the number of other resources in the graph.  Unlike exact
+// counts, this needs memory linear in the size of the graph.
+func (g *Graph) DescendantCounts() map[uint64]int {
+	_, _, order := g.structure()
+	max := len(order) - 1
+	counts := make(map[uint64]int, len(order))
+	for i := len(order) - 1; i >= 0; i-- {
+		id := order[i]
+		n := 0
+		for j, dep := range g.deps[id] {
+			if j > 0 && dep == g.deps[id][j-1] {
+				// A resource that lists a dependency twice.
+				continue
+			}
+			n += 1 + counts[dep]
+			if n >= max {
+				n = max
+				break
+			}
+		}
+		counts[id] = n
+	}
+	return counts
+}
+
+// structure returns the resource IDs in catalog order, the dependencies
+// of each resource, and the IDs in an order where every resource comes
+// after its dependencies.
+func (g *Graph) structure() (ids []uint64, depsOf map[uint64][]uint64, order []uint64) {
+	n := g.res.Len()
+	ids = make([]uint64, n)
+	depsOf = make(map[uint64][]uint64, n)
+	for i := 0; i < n; i++ {
+		r := g.res.At(i)
+		id := r.ID()
+		ids[i] = id
+		deps, _ := r.Dependencies() // already read by New
+		for j := 0; j < deps.Len(); j++ {
+			depsOf[id] = append(depsOf[id], deps.At(j))
+		}
+	}
+	return ids, depsOf, g.topoOrder(ids, depsOf)
+}
+
+// topoOrder returns ids ordered so that every resource comes after its
+// dependencies.
+func (g *Graph) topoOrder(ids []uint64, depsOf map[uint64][]uint64) []uint64 {
+	queued := make(map[uint64]int, len(ids))
+	var order []uint64
+	for _, id := range ids {
+		if n := len(depsOf[id]); n > 0 {
+			queued[id] = n
+		} else {
+			order = append(order, id)
+		}
+	}
+	for i := 0; i < len(order); i++ {
+		for _, dep := range g.deps[order[i]] {
+			queued[dep]--
+			if queued[dep] == 0 {
+				order = append(order, dep)
+			}
+		}
+	}
+	return order
+}
+
+// longestPaths returns the total cost of the most expensive chain of
+// dependencies ending at each resource.  pred maps each resource to the
+// previous resource in its chain.
+func longestPaths(order []uint64, depsOf map[uint64][]uint64, costs map[uint64]int64) (finish map[uint64]int64, pred map[uint64]uint64) {
+	finish = make(map[uint64]int64, len(order))
+	pred = make(map[uint64]uint64, len(order))
+	for _, id := range order {
+		var start int64
+		for _, d := range depsOf[id] {
+			if f := finish[d]; f > start || pred[id] == 0 && f == start {
+				start = f
+				pred[id] = d
+			}
+		}
+		finish[id] = start + costs[id]
+	}
+	return finish, pred
+}
+
+// criticalPath returns the cost and resources of the most expensive
+// chain given the results of longestPaths.  Ties are broken by catalog
+// order.
+func criticalPath(ids []uint64, finish map[uint64]int64, pred map[uint64]uint64) (int64, []uint64) {
+	var end uint64
+	var max int64
+	for _, id := range ids {
+		if f := finish[id]; end == 0 || f > max {
+			end, max = id, f
+		}
+	}
+	var path []uint64
+	for id := end; id != 0; id = pred[id] {
+		path = append(path, id)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return max, path
+}
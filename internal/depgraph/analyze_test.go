@@ -0,0 +1,284 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package depgraph
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto/pogs"
+)
+
+type analyzeResource struct {
+	ID   uint64   `capnp:"id"`
+	Deps []uint64 `capnp:"dependencies"`
+}
+
+func newTestGraph(tb testing.TB, resources []analyzeResource) *Graph {
+	g, err := New(newTestResourceList(tb, resources))
+	if err != nil {
+		tb.Fatal("New:", err)
+	}
+	return g
+}
+
+func newTestResourceList(t testing.TB, resources []analyzeResource) catalog.Resource_List {
+	_, seg, err := capnp.NewMessage(capnp.SingleSegment(nil))
+	if err != nil {
+		t.Fatal("NewMessage:", err)
+	}
+	res, err := catalog.NewResource_List(seg, int32(len(resources)))
+	if err != nil {
+		t.Fatal("NewResource_List:", err)
+	}
+	for i := range resources {
+		if err := pogs.Insert(catalog.Resource_TypeID, res.At(i).Struct, &resources[i]); err != nil {
+			t.Fatalf("insert resources[%d]: %v", i, err)
+		}
+	}
+	return res
+}
+
+func TestAnalyze(t *testing.T) {
+	// 1 -> 2 -> 3 -> 5
+	//      4 ------> 5
+	//      6
+	g := newTestGraph(t, []analyzeResource{
+		{ID: 1},
+		{ID: 2, Deps: []uint64{1}},
+		{ID: 3, Deps: []uint64{2}},
+		{ID: 4},
+		{ID: 5, Deps: []uint64{3, 4}},
+		{ID: 6},
+	})
+	t.Run("Unweighted", func(t *testing.T) {
+		a := g.Analyze(nil)
+		wantLevels := [][]uint64{{1, 4, 6}, {2}, {3}, {5}}
+		if len(a.Levels) != len(wantLevels) {
+			t.Fatalf("Levels = %v; want %v", a.Levels, wantLevels)
+		}
+		for i := range wantLevels {
+			if !idListsEqual(a.Levels[i], wantLevels[i]) {
+				t.Errorf("Levels = %v; want %v", a.Levels, wantLevels)
+				break
+			}
+		}
+		if a.Depth() != 4 || a.Width() != 3 {
+			t.Errorf("Depth(), Width() = %d, %d; want 4, 3", a.Depth(), a.Width())
+		}
+		if want := []uint64{1, 2, 3, 5}; !idListsEqual(a.CriticalPath, want) || a.CriticalCost != 4 {
+			t.Errorf("CriticalPath, CriticalCost = %v, %d; want %v, 4", a.CriticalPath, a.CriticalCost, want)
+		}
+		if a.TotalCost != 6 || a.Parallelism() != 1.5 {
+			t.Errorf("TotalCost, Parallelism() = %d, %g; want 6, 1.5", a.TotalCost, a.Parallelism())
+		}
+		// Removing any edge of the chain leaves a chain of 2 or 3
+		// resources, and removing 2 -> 1 or 5 -> 3 leaves the longest.
+		wantBottlenecks := []Bottleneck{
+			{Resource: 3, Dep: 2, CriticalCost: 2},
+			{Resource: 2, Dep: 1, CriticalCost: 3},
+			{Resource: 5, Dep: 3, CriticalCost: 3},
+		}
+		if !bottlenecksEqual(a.Bottlenecks, wantBottlenecks) {
+			t.Errorf("Bottlenecks = %+v; want %+v", a.Bottlenecks, wantBottlenecks)
+		}
+	})
+	t.Run("Weighted", func(t *testing.T) {
+		costs := map[uint64]int64{1: 1, 2: 1, 3: 1, 4: 10, 5: 1, 6: 2}
+		a := g.Analyze(func(id uint64) int64 { return costs[id] })
+		if want := []uint64{4, 5}; !idListsEqual(a.CriticalPath, want) || a.CriticalCost != 11 {
+			t.Errorf("CriticalPath, CriticalCost = %v, %d; want %v, 11", a.CriticalPath, a.CriticalCost, want)
+		}
+		if a.TotalCost != 16 {
+			t.Errorf("TotalCost = %d; want 16", a.TotalCost)
+		}
+		wantBottlenecks := []Bottleneck{
+			{Resource: 5, Dep: 4, CriticalCost: 10},
+		}
+		if !bottlenecksEqual(a.Bottlenecks, wantBottlenecks) {
+			t.Errorf("Bottlenecks = %+v; want %+v", a.Bottlenecks, wantBottlenecks)
+		}
+	})
+}
+
+func TestAnalyzeBottlenecksRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for iter := 0; iter < 200; iter++ {
+		// Resources only depend on resources with smaller IDs, so the
+		// graph has no cycles.  They are listed in shuffled order.
+		n := 1 + rng.Intn(30)
+		resources := make([]analyzeResource, n)
+		costs := make(map[uint64]int64, n)
+		for i := range resources {
+			id := uint64(i + 1)
+			resources[i].ID = id
+			for d := uint64(1); d < id; d++ {
+				if rng.Intn(4) == 0 {
+					resources[i].Deps = append(resources[i].Deps, d)
+				}
+			}
+			costs[id] = int64(rng.Intn(5))
+		}
+		shuffled := make([]analyzeResource, n)
+		for i, j := range rng.Perm(n) {
+			shuffled[i] = resources[j]
+		}
+		a := newTestGraph(t, shuffled).Analyze(func(id uint64) int64 { return costs[id] })
+
+		var want []Bottleneck
+		for i := 1; i < len(a.CriticalPath); i++ {
+			from, to := a.CriticalPath[i], a.CriticalPath[i-1]
+			if c := longestWithout(resources, costs, from, to); c < a.CriticalCost {
+				want = append(want, Bottleneck{Resource: from, Dep: to, CriticalCost: c})
+			}
+		}
+		sort.SliceStable(want, func(i, j int) bool { return want[i].CriticalCost < want[j].CriticalCost })
+		if !bottlenecksEqual(a.Bottlenecks, want) {
+			t.Fatalf("for %+v with costs %v, Bottlenecks = %+v; want %+v", shuffled, costs, a.Bottlenecks, want)
+		}
+	}
+}
+
+// longestWithout returns the cost of the most expensive chain in
+// resources, whose dependencies all have smaller IDs, without the
+// dependency of from on to.
+func longestWithout(resources []analyzeResource, costs map[uint64]int64, from, to uint64) int64 {
+	depsOf := make(map[uint64][]uint64, len(resources))
+	for _, r := range resources {
+		depsOf[r.ID] = r.Deps
+	}
+	finish := make(map[uint64]int64, len(resources))
+	var max int64
+	for id := uint64(1); id <= uint64(len(resources)); id++ {
+		var start int64
+		for _, d := range depsOf[id] {
+			if id == from && d == to {
+				continue
+			}
+			if finish[d] > start {
+				start = finish[d]
+			}
+		}
+		finish[id] = start + costs[id]
+		if finish[id] > max {
+			max = finish[id]
+		}
+	}
+	return max
+}
+
+func TestRemainingCosts(t *testing.T) {
+	g := newTestGraph(t, []analyzeResource{
+		{ID: 1},
+		{ID: 2, Deps: []uint64{1}},
+		{ID: 3, Deps: []uint64{2}},
+		{ID: 4},
+		{ID: 5, Deps: []uint64{3, 4}},
+		{ID: 6},
+	})
+	got := g.RemainingCosts(nil)
+	want := map[uint64]int64{1: 4, 2: 3, 3: 2, 4: 2, 5: 1, 6: 1}
+	for id, w := range want {
+		if got[id] != w {
+			t.Errorf("RemainingCosts(nil)[%d] = %d; want %d", id, got[id], w)
+		}
+	}
+	costs := map[uint64]int64{4: 10}
+	got = g.RemainingCosts(func(id uint64) int64 { return costs[id] })
+	want = map[uint64]int64{1: 0, 2: 0, 3: 0, 4: 10, 5: 0, 6: 0}
+	for id, w := range want {
+		if got[id] != w {
+			t.Errorf("RemainingCosts(costs)[%d] = %d; want %d", id, got[id], w)
+		}
+	}
+}
+
+func TestDescendantCounts(t *testing.T) {
+	// 5 has a tree of dependents, so its count is exact.  4 depends on
+	// 1 through both 2 and 3, so 4 is counted twice for 1.
+	g := newTestGraph(t, []analyzeResource{
+		{ID: 4, Deps: []uint64{2, 3}},
+		{ID: 1},
+		{ID: 2, Deps: []uint64{1}},
+		{ID: 3, Deps: []uint64{1, 1}},
+		{ID: 5},
+		{ID: 6, Deps: []uint64{5}},
+		{ID: 7, Deps: []uint64{5}},
+		{ID: 8, Deps: []uint64{6}},
+	})
+	got := g.DescendantCounts()
+	want := map[uint64]int{1: 4, 2: 1, 3: 1, 4: 0, 5: 3, 6: 1, 7: 0, 8: 0}
+	for id, w := range want {
+		if got[id] != w {
+			t.Errorf("DescendantCounts()[%d] = %d; want %d", id, got[id], w)
+		}
+	}
+}
+
+func TestDescendantCountsCap(t *testing.T) {
+	// Layers of two resources that each depend on both resources of
+	// the layer before: the number of chains doubles with each layer.
+	const layers = 40
+	var resources []analyzeResource
+	for i := 0; i < layers; i++ {
+		a, b := uint64(2*i+1), uint64(2*i+2)
+		var deps []uint64
+		if i > 0 {
+			deps = []uint64{a - 2, b - 2}
+		}
+		resources = append(resources, analyzeResource{ID: a, Deps: deps}, analyzeResource{ID: b, Deps: deps})
+	}
+	got := newTestGraph(t, resources).DescendantCounts()
+	if got[1] != 2*layers-1 {
+		t.Errorf("DescendantCounts()[1] = %d; want %d", got[1], 2*layers-1)
+	}
+	if last := uint64(2 * layers); got[last] != 0 {
+		t.Errorf("DescendantCounts()[%d] = %d; want 0", last, got[last])
+	}
+}
+
+func TestAnalyzeEmpty(t *testing.T) {
+	a := newTestGraph(t, nil).Analyze(nil)
+	if a.Depth() != 0 || a.Width() != 0 || len(a.CriticalPath) != 0 || a.Parallelism() != 0 || len(a.Bottlenecks) != 0 {
+		t.Errorf("Analyze() = %+v; want empty analysis", a)
+	}
+}
+
+func idListsEqual(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func bottlenecksEqual(a, b []Bottleneck) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
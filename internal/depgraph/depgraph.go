@@ -29,8 +29,9 @@ type Graph struct {
 	index map[uint64]int
 
 	// Mutable state
-	ready  []uint64
-	queued map[uint64]int
+	ready    []uint64
+	readyPos map[uint64]int // resource ID -> index in ready
+	queued   map[uint64]int
 }
 
 // New builds a graph from a list of dependencies or returns an error
@@ -38,16 +39,20 @@ type Graph struct {
 func New(res catalog.Resource_List) (*Graph, error) {
 	n := res.Len()
 	g := &Graph{
-		res:    res,
-		deps:   make(map[uint64][]uint64, n),
-		index:  make(map[uint64]int, n),
-		queued: make(map[uint64]int, n),
+		res:      res,
+		deps:     make(map[uint64][]uint64, n),
+		index:    make(map[uint64]int, n),
+		queued:   make(map[uint64]int, n),
+		readyPos: make(map[uint64]int),
 	}
 	for i := 0; i < n; i++ {
 		id := res.At(i).ID()
 		if id == 0 {
 			return nil, errors.New("build dependency graph: encountered resource with ID=0")
 		}
+		if _, dup := g.index[id]; dup {
+			return nil, fmt.Errorf("build dependency graph: multiple resources with ID=%d", id)
+		}
 		g.index[id] = i
 		if _, ok := g.deps[id]; !ok {
 			g.deps[id] = nil
@@ -57,7 +62,7 @@ func New(res catalog.Resource_List) (*Graph, error) {
 			return nil, fmt.Errorf("build dependency graph: reading dependency list of resource ID=%d: %v", id, err)
 		}
 		if ndeps := deps.Len(); ndeps == 0 {
-			g.ready = append(g.ready, id)
+			g.push(id)
 		} else {
 			g.queued[id] = deps.Len()
 			for j := 0; j < ndeps; j++ {
@@ -71,13 +76,48 @@ func New(res catalog.Resource_List) (*Graph, error) {
 			return nil, fmt.Errorf("build dependency graph: unknown dependency ID %d requested by resource %d", id, out[0])
 		}
 	}
-	// TODO(soon): loop detection
+	if err := g.checkCycles(); err != nil {
+		return nil, err
+	}
 	return g, nil
 }
 
+// checkCycles returns an error if any resource can never become ready.
+func (g *Graph) checkCycles() error {
+	queued := make(map[uint64]int, len(g.queued))
+	for id, n := range g.queued {
+		queued[id] = n
+	}
+	stk := append([]uint64(nil), g.ready...)
+	for len(stk) > 0 {
+		end := len(stk) - 1
+		id := stk[end]
+		stk = stk[:end]
+		for _, dep := range g.deps[id] {
+			queued[dep]--
+			if queued[dep] == 0 {
+				delete(queued, dep)
+				stk = append(stk, dep)
+			}
+		}
+	}
+	if len(queued) == 0 {
+		return nil
+	}
+	// Report the smallest ID so that the message is deterministic.
+	var min uint64
+	for id := range queued {
+		if min == 0 || id < min {
+			min = id
+		}
+	}
+	return fmt.Errorf("build dependency graph: resource ID=%d is in or depends on a dependency cycle", min)
+}
+
 // Ready returns a list of resources that have not been marked and have
-// no unmarked dependencies.  This slice is only valid until the next
-// mark call.
+// no unmarked dependencies.  The list is in catalog order until the
+// first mark call and in no particular order after that.  This slice is
+// only valid until the next mark call.
 func (g *Graph) Ready() []uint64 {
 	return g.ready
 }
@@ -96,11 +136,14 @@ func (g *Graph) Resource(id uint64) catalog.Resource {
 	return g.res.At(i)
 }
 
-// Mark marks a resource as "completed".
-func (g *Graph) Mark(id uint64) {
+// Mark marks a resource as "completed" and returns the resources that
+// became ready because of it, in the order that they became ready.
+// This slice is only valid until the next mark call.
+func (g *Graph) Mark(id uint64) []uint64 {
 	if !g.pop(id) {
-		return
+		return nil
 	}
+	start := len(g.ready)
 	for _, dep := range g.deps[id] {
 		n := g.queued[dep]
 		n--
@@ -108,9 +151,10 @@ func (g *Graph) Mark(id uint64) {
 			g.queued[dep] = n
 		} else if n == 0 {
 			delete(g.queued, dep)
-			g.ready = append(g.ready, dep)
+			g.push(dep)
 		}
 	}
+	return g.ready[start:]
 }
 
 // Mark marks a resource as "completed with failure" and returns the
@@ -122,18 +166,12 @@ func (g *Graph) MarkFailure(id uint64) []uint64 {
 		return nil
 	}
 	var aborted []uint64
-	visited := func(id uint64) bool {
-		for _, x := range aborted {
-			if x == id {
-				return true
-			}
-		}
-		return false
-	}
+	visited := make(map[uint64]bool)
 	var stk []uint64
 	for {
 		for _, dep := range g.deps[id] {
-			if g.queued[dep] != 0 && !visited(dep) {
+			if g.queued[dep] != 0 && !visited[dep] {
+				visited[dep] = true
 				stk = append(stk, dep)
 			}
 		}
@@ -148,17 +186,25 @@ func (g *Graph) MarkFailure(id uint64) []uint64 {
 	return aborted
 }
 
+// push adds a resource to the end of the ready list.
+func (g *Graph) push(id uint64) {
+	g.readyPos[id] = len(g.ready)
+	g.ready = append(g.ready, id)
+}
+
+// pop removes a resource from the ready list by moving the last ready
+// resource into its place, and reports whether it was ready.
 func (g *Graph) pop(id uint64) bool {
-	i := -1
-	for ii, r := range g.ready {
-		if r == id {
-			i = ii
-			break
-		}
-	}
-	if i == -1 {
+	i, ok := g.readyPos[id]
+	if !ok {
 		return false
 	}
-	g.ready = append(g.ready[:i], g.ready[i+1:]...)
+	delete(g.readyPos, id)
+	last := len(g.ready) - 1
+	if i != last {
+		g.ready[i] = g.ready[last]
+		g.readyPos[g.ready[i]] = i
+	}
+	g.ready = g.ready[:last]
 	return true
 }
@@ -15,7 +15,9 @@
 package depgraph
 
 import (
+	"math"
 	"sort"
+	"strconv"
 	"testing"
 
 	"github.com/zombiezen/mcm/catalog"
@@ -218,11 +220,49 @@ func TestDepgraph(t *testing.T) {
 			},
 			done: true,
 		},
+		{
+			name: "A <- B, A <- C, B <- D, C <- D; fail A",
+			resources: []DummyResource{
+				{ID: 10},
+				{ID: 20, Deps: []uint64{10}},
+				{ID: 30, Deps: []uint64{10}},
+				{ID: 40, Deps: []uint64{20, 30}},
+			},
+			marks: []Mark{
+				{id: 10, fail: true, skipped: []uint64{20, 30, 40}},
+			},
+			done: true,
+		},
+		{
+			name: "A <- B, A <- B",
+			resources: []DummyResource{
+				{ID: 10},
+				{ID: 20, Deps: []uint64{10, 10}},
+			},
+			marks: []Mark{
+				{id: 10},
+			},
+			ready: []uint64{20},
+		},
+		{
+			name: "duplicate ID",
+			resources: []DummyResource{
+				{ID: 42},
+				{ID: 42},
+			},
+			failNew: true,
+		},
+		{
+			name: "unknown dependency",
+			resources: []DummyResource{
+				{ID: 42, Deps: []uint64{43}},
+			},
+			failNew: true,
+		},
 
 		// Cycle tests
 		{
 			name: "self cycle",
-			skip: true,
 			resources: []DummyResource{
 				{ID: 42, Deps: []uint64{42}},
 			},
@@ -230,7 +270,6 @@ func TestDepgraph(t *testing.T) {
 		},
 		{
 			name: "AB cycle",
-			skip: true,
 			resources: []DummyResource{
 				{ID: 10, Deps: []uint64{20}},
 				{ID: 20, Deps: []uint64{10}},
@@ -239,7 +278,6 @@ func TestDepgraph(t *testing.T) {
 		},
 		{
 			name: "ABC cycle",
-			skip: true,
 			resources: []DummyResource{
 				{ID: 10, Deps: []uint64{20}},
 				{ID: 20, Deps: []uint64{30}},
@@ -249,7 +287,6 @@ func TestDepgraph(t *testing.T) {
 		},
 		{
 			name: "ABC cycle with D",
-			skip: true,
 			resources: []DummyResource{
 				{ID: 10, Deps: []uint64{20}},
 				{ID: 20, Deps: []uint64{30}},
@@ -320,6 +357,47 @@ func TestDepgraph(t *testing.T) {
 	}
 }
 
+func TestReadyOrder(t *testing.T) {
+	g := newTestGraph(t, []analyzeResource{
+		{ID: 1},
+		{ID: 2},
+		{ID: 3},
+		{ID: 4},
+		{ID: 5},
+		{ID: 6, Deps: []uint64{2}},
+		{ID: 7, Deps: []uint64{4}},
+		{ID: 8, Deps: []uint64{4}},
+	})
+	steps := []struct {
+		mark     uint64
+		newReady []uint64
+		ready    []uint64
+	}{
+		{2, []uint64{6}, []uint64{1, 3, 4, 5, 6}},
+		{4, []uint64{7, 8}, []uint64{1, 3, 5, 6, 7, 8}},
+		{1, []uint64{}, []uint64{3, 5, 6, 7, 8}},
+		{7, []uint64{}, []uint64{3, 5, 6, 8}},
+		{5, []uint64{}, []uint64{3, 6, 8}},
+		{3, []uint64{}, []uint64{6, 8}},
+		{6, []uint64{}, []uint64{8}},
+		{8, []uint64{}, []uint64{}},
+	}
+	if ready, want := g.Ready(), []uint64{1, 2, 3, 4, 5}; !idListsEqual(ready, want) {
+		t.Errorf("initial Ready() = %v; want %v", ready, want)
+	}
+	for _, step := range steps {
+		if newReady := g.Mark(step.mark); !idListsEqual(newReady, step.newReady) {
+			t.Errorf("Mark(%d) = %v; want %v", step.mark, newReady, step.newReady)
+		}
+		if ready := g.Ready(); !idSetsEqual(ready, step.ready) {
+			t.Errorf("after Mark(%d), Ready() = %v; want %v", step.mark, ready, step.ready)
+		}
+	}
+	if !g.Done() {
+		t.Error("Done() = false after marking all resources")
+	}
+}
+
 func idSetsEqual(a, b []uint64) bool {
 	a, _ = sortSet(a)
 	b, _ = sortSet(b)
@@ -349,3 +427,150 @@ func sortSet(list []uint64) (set []uint64, ok bool) {
 	}
 	return
 }
+
+// benchmarkSizes are the catalog sizes used by benchmarks.
+var benchmarkSizes = []int{10000, 50000, 100000}
+
+// wideResources returns a synthetic catalog of n resources shaped like a
+// generated configuration: a few directories, each with many files, and
+// a service per directory that depends on all of its files.
+func wideResources(n int) []analyzeResource {
+	const dirs = 100
+	res := make([]analyzeResource, 0, n)
+	for i := 1; i <= dirs; i++ {
+		res = append(res, analyzeResource{ID: uint64(i)})
+	}
+	services := make([]analyzeResource, dirs)
+	for i := range services {
+		services[i].ID = uint64(n - dirs + 1 + i)
+	}
+	for id := dirs + 1; id <= n-dirs; id++ {
+		dir := uint64(id%dirs + 1)
+		res = append(res, analyzeResource{ID: uint64(id), Deps: []uint64{dir}})
+		services[id%dirs].Deps = append(services[id%dirs].Deps, uint64(id))
+	}
+	return append(res, services...)
+}
+
+// resetReadLimit lets a benchmark read g's catalog again.  Without it,
+// the message's traversal limit runs out after a few iterations and the
+// graph appears empty.
+func resetReadLimit(g *Graph) {
+	g.res.Segment().Message().ReadLimiter().Reset(math.MaxUint64)
+}
+
+func BenchmarkMark(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			resources := wideResources(n)
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				g := newTestGraph(b, resources)
+				b.StartTimer()
+				// Mark the second ready resource to simulate the out
+				// of order completion of concurrent workers.
+				for !g.Done() {
+					ready := g.Ready()
+					if len(ready) > 1 {
+						g.Mark(ready[1])
+					} else {
+						g.Mark(ready[0])
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkMarkFailure(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			// A binary tree rooted at the first resource.
+			resources := make([]analyzeResource, n)
+			resources[0].ID = 1
+			for i := 1; i < n; i++ {
+				id := uint64(i + 1)
+				resources[i] = analyzeResource{ID: id, Deps: []uint64{id / 2}}
+			}
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				g := newTestGraph(b, resources)
+				b.StartTimer()
+				if skipped := g.MarkFailure(1); len(skipped) != n-1 {
+					b.Fatalf("MarkFailure(1) skipped %d resources; want %d", len(skipped), n-1)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkNew(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			res := newTestResourceList(b, wideResources(n))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				res.Segment().Message().ReadLimiter().Reset(math.MaxUint64)
+				if _, err := New(res); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkAnalyze(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		// A chain has a critical path as long as the graph.
+		chain := make([]analyzeResource, n)
+		chain[0].ID = 1
+		for i := 1; i < n; i++ {
+			chain[i] = analyzeResource{ID: uint64(i + 1), Deps: []uint64{uint64(i)}}
+		}
+		shapes := []struct {
+			name      string
+			resources []analyzeResource
+		}{
+			{"Wide", wideResources(n)},
+			{"Chain", chain},
+		}
+		for _, shape := range shapes {
+			b.Run(shape.name+"/"+strconv.Itoa(n), func(b *testing.B) {
+				g := newTestGraph(b, shape.resources)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					resetReadLimit(g)
+					g.Analyze(nil)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkRemainingCosts and BenchmarkDescendantCounts measure
+// computing the priorities of execlib's schedules.
+func BenchmarkRemainingCosts(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			g := newTestGraph(b, wideResources(n))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resetReadLimit(g)
+				g.RemainingCosts(nil)
+			}
+		})
+	}
+}
+
+func BenchmarkDescendantCounts(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			g := newTestGraph(b, wideResources(n))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resetReadLimit(g)
+				g.DescendantCounts()
+			}
+		})
+	}
+}
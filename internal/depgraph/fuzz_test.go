@@ -0,0 +1,67 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.18
+// +build go1.18
+
+package depgraph
+
+import (
+	"testing"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/internal/fuzzcorpus"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto"
+)
+
+// FuzzNew builds graphs from arbitrary catalogs.  Any graph that New
+// accepts must be able to finish, whichever resources fail.
+func FuzzNew(f *testing.F) {
+	fuzzcorpus.Add(f, "../..")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := capnp.Unmarshal(data)
+		if err != nil {
+			return
+		}
+		c, err := catalog.ReadRootCatalog(msg)
+		if err != nil {
+			return
+		}
+		res, err := c.Resources()
+		if err != nil {
+			return
+		}
+		g, err := New(res)
+		if err != nil {
+			return
+		}
+		n := res.Len()
+		for !g.Done() {
+			ready := g.Ready()
+			if len(ready) == 0 {
+				t.Fatal("graph not done, but nothing is ready")
+			}
+			if n <= 0 {
+				t.Fatal("marked more resources than are in the catalog")
+			}
+			id := ready[0]
+			if id&1 == 0 {
+				g.Mark(id)
+				n--
+			} else {
+				n -= 1 + len(g.MarkFailure(id))
+			}
+		}
+	})
+}
@@ -0,0 +1,569 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package difftest provides a differential test harness that applies
+// the same catalog with execlib and with a script generated by shlib,
+// then compares the results.
+package difftest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/exec/execlib"
+	"github.com/zombiezen/mcm/exec/report"
+	"github.com/zombiezen/mcm/internal/catpogs"
+	"github.com/zombiezen/mcm/internal/system"
+	"github.com/zombiezen/mcm/shellify/shlib"
+)
+
+// RootPlaceholder is the directory that generated catalogs use for
+// all of their paths.  Before a catalog is applied, the placeholder is
+// replaced with a scratch directory.
+const RootPlaceholder = "/mcm-difftest-root"
+
+// Tools is the set of programs that generated catalogs may run.
+type Tools struct {
+	Bash  string
+	True  string
+	False string
+	Touch string
+}
+
+// FindTools looks up the tools in the PATH.
+func FindTools() (*Tools, error) {
+	t := new(Tools)
+	for _, x := range []struct {
+		name string
+		dst  *string
+	}{
+		{"bash", &t.Bash},
+		{"true", &t.True},
+		{"false", &t.False},
+		{"touch", &t.Touch},
+	} {
+		var err error
+		*x.dst, err = exec.LookPath(x.name)
+		if err != nil {
+			return nil, fmt.Errorf("can't find %s: %v", x.name, err)
+		}
+	}
+	return t, nil
+}
+
+// A Harness applies catalogs in scratch directories.
+type Harness struct {
+	Tools *Tools
+
+	// TempDir is the directory in which to create scratch directories.
+	// If empty, the default temporary directory is used.
+	TempDir string
+
+	// Runs is the number of times to apply each catalog.  Applying
+	// more than once compares whether the implementations agree on
+	// what is already up-to-date.  If non-positive, then it assumes 1.
+	Runs int
+}
+
+// A Divergence is a difference in outcome between execlib and shlib.
+type Divergence struct {
+	Catalog     *catpogs.Catalog
+	Differences []string
+}
+
+func (d *Divergence) Error() string {
+	return "execlib and shlib diverge:\n" + strings.Join(d.Differences, "\n")
+}
+
+// Compare applies c with both execlib and shlib and returns a
+// *Divergence if the outcomes differ.  Any other error indicates a
+// failure of the harness itself.
+func (h *Harness) Compare(ctx context.Context, c *catpogs.Catalog) error {
+	execRoot, err := ioutil.TempDir(h.TempDir, "difftest_exec")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(execRoot)
+	shRoot, err := ioutil.TempDir(h.TempDir, "difftest_sh")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(shRoot)
+
+	var diffs []string
+	runs := h.Runs
+	if runs < 1 {
+		runs = 1
+	}
+	for i := 1; i <= runs; i++ {
+		execResult, err := h.applyExec(ctx, relocate(c, execRoot))
+		if err != nil {
+			return err
+		}
+		shResult, err := h.applyShell(ctx, relocate(c, shRoot))
+		if err != nil {
+			return err
+		}
+		for _, d := range compareResults(execResult, shResult) {
+			diffs = append(diffs, fmt.Sprintf("run %d: %s", i, d))
+		}
+		if len(diffs) > 0 {
+			break
+		}
+	}
+	execTree, err := readTree(execRoot)
+	if err != nil {
+		return err
+	}
+	shTree, err := readTree(shRoot)
+	if err != nil {
+		return err
+	}
+	diffs = append(diffs, compareTrees(execTree, shTree, explicitModes(c))...)
+	if len(diffs) > 0 {
+		return &Divergence{Catalog: c, Differences: diffs}
+	}
+	return nil
+}
+
+// Minimize removes resources from div.Catalog for as long as the
+// catalog still diverges and returns the smallest divergence found.
+func (h *Harness) Minimize(ctx context.Context, div *Divergence) (*Divergence, error) {
+	for {
+		smaller, err := h.minimizeStep(ctx, div)
+		if err != nil {
+			return div, err
+		}
+		if smaller == nil {
+			return div, nil
+		}
+		div = smaller
+	}
+}
+
+func (h *Harness) minimizeStep(ctx context.Context, div *Divergence) (*Divergence, error) {
+	c := div.Catalog
+	for i := len(c.Resources) - 1; i >= 0; i-- {
+		if d, err := h.tryCandidate(ctx, removeResource(c, i)); d != nil || err != nil {
+			return d, err
+		}
+	}
+	return nil, nil
+}
+
+func (h *Harness) tryCandidate(ctx context.Context, c *catpogs.Catalog) (*Divergence, error) {
+	err := h.Compare(ctx, c)
+	if d, ok := err.(*Divergence); ok {
+		return d, nil
+	}
+	return nil, err
+}
+
+// result is the outcome of applying a catalog once.
+type result struct {
+	// err is the overall failure of the apply.  It is nil if all
+	// resources applied cleanly.
+	err error
+
+	// status maps resource IDs to one of "changed", "unchanged", or
+	// "failed".  Resources skipped due to a failed dependency are
+	// reported as failed, since the generated script does not
+	// distinguish between the two.
+	status map[uint64]string
+
+	// leaks is the list of temporary files left behind.
+	leaks []string
+}
+
+func (h *Harness) applyExec(ctx context.Context, c *catpogs.Catalog) (*result, error) {
+	cat, err := c.ToCapnp()
+	if err != nil {
+		return nil, err
+	}
+	rep := new(report.Report)
+	err = execlib.Apply(ctx, system.Local{}, cat, &execlib.Options{
+		Bash:   h.Tools.Bash,
+		Report: rep,
+	})
+	res := &result{err: err, status: make(map[uint64]string)}
+	for _, rr := range rep.Resources {
+		switch rr.Status {
+		case report.Changed, report.Unchanged:
+			res.status[rr.ID] = rr.Status.String()
+		default:
+			res.status[rr.ID] = "failed"
+		}
+	}
+	return res, nil
+}
+
+func (h *Harness) applyShell(ctx context.Context, c *catpogs.Catalog) (*result, error) {
+	cat, err := c.ToCapnp()
+	if err != nil {
+		return nil, err
+	}
+	sc := new(bytes.Buffer)
+	if err := shlib.WriteScript(sc, cat); err != nil {
+		return &result{err: fmt.Errorf("write script: %v", err)}, nil
+	}
+	statusFile, err := ioutil.TempFile(h.TempDir, "difftest_status")
+	if err != nil {
+		return nil, err
+	}
+	statusPath := statusFile.Name()
+	statusFile.Close()
+	defer os.Remove(statusPath)
+	// The generated script stores each resource's status in a global
+	// variable, so dump them after the script's main function returns.
+	fmt.Fprintf(sc, "_mcm_rc=$?\nfor _mcm_v in ${!status*}; do echo \"${_mcm_v#status} ${!_mcm_v}\"; done > '%s'\nexit $_mcm_rc\n", statusPath)
+
+	// Give the script its own temporary directory to check that it
+	// cleans up after itself.
+	tmpDir, err := ioutil.TempDir(h.TempDir, "difftest_shtmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	cmd := exec.CommandContext(ctx, h.Tools.Bash, "-s")
+	cmd.Stdin = sc
+	cmd.Env = append(os.Environ(), "TMPDIR="+tmpDir)
+	out, runErr := cmd.CombinedOutput()
+	if runErr != nil {
+		if _, ok := runErr.(*exec.ExitError); !ok {
+			return nil, runErr
+		}
+		runErr = fmt.Errorf("bash: %v; output:\n%s", runErr, out)
+	}
+	statusData, err := ioutil.ReadFile(statusPath)
+	if err != nil {
+		return nil, err
+	}
+	leftover, err := ioutil.ReadDir(tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	res := &result{err: runErr, status: make(map[uint64]string)}
+	for _, info := range leftover {
+		res.leaks = append(res.leaks, info.Name())
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(statusData)), "\n") {
+		if line == "" {
+			continue
+		}
+		var id uint64
+		var code int
+		if _, err := fmt.Sscanf(line, "%d %d", &id, &code); err != nil {
+			return nil, fmt.Errorf("parse status line %q: %v", line, err)
+		}
+		switch {
+		case code > 0:
+			res.status[id] = "changed"
+		case code == 0:
+			res.status[id] = "unchanged"
+		default:
+			res.status[id] = "failed"
+		}
+	}
+	return res, nil
+}
+
+func compareResults(execResult, shResult *result) []string {
+	var diffs []string
+	if (execResult.err == nil) != (shResult.err == nil) {
+		diffs = append(diffs, fmt.Sprintf("execlib error = %v; shlib error = %v", execResult.err, shResult.err))
+	}
+	if len(shResult.leaks) > 0 {
+		diffs = append(diffs, fmt.Sprintf("shlib left temporary files: %s", strings.Join(shResult.leaks, ", ")))
+	}
+	if shResult.status == nil {
+		// Script was never generated.
+		return diffs
+	}
+	ids := make(map[uint64]struct{})
+	for id := range execResult.status {
+		ids[id] = struct{}{}
+	}
+	for id := range shResult.status {
+		ids[id] = struct{}{}
+	}
+	for _, id := range sortedIDs(ids) {
+		e, s := execResult.status[id], shResult.status[id]
+		if e != s {
+			diffs = append(diffs, fmt.Sprintf("resource id=%d: execlib %s; shlib %s", id, orNone(e), orNone(s)))
+		}
+	}
+	return diffs
+}
+
+// node is a single filesystem entry in a tree snapshot.
+type node struct {
+	mode    os.FileMode
+	content string
+	target  string
+}
+
+func (n node) String() string {
+	switch {
+	case n.mode&os.ModeType == os.ModeSymlink:
+		return fmt.Sprintf("%v -> %q", n.mode, n.target)
+	case n.mode.IsRegular():
+		return fmt.Sprintf("%v %q", n.mode, n.content)
+	default:
+		return n.mode.String()
+	}
+}
+
+// readTree returns the entries under root keyed by their path relative to root.
+func readTree(root string) (map[string]node, error) {
+	const modeMask = os.ModeType | os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+	tree := make(map[string]node)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		n := node{mode: info.Mode() & modeMask}
+		switch {
+		case n.mode&os.ModeType == os.ModeSymlink:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			n.target = strings.Replace(target, root, RootPlaceholder, -1)
+		case n.mode.IsRegular():
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			n.content = string(data)
+		}
+		tree[filepath.ToSlash(rel)] = n
+		return nil
+	})
+	return tree, err
+}
+
+// compareTrees compares two tree snapshots.  Permission bits are only
+// compared for paths in modePaths, since execlib and shlib create new
+// files with different default permissions.
+func compareTrees(execTree, shTree map[string]node, modePaths map[string]bool) []string {
+	paths := make(map[string]struct{})
+	for p := range execTree {
+		paths[p] = struct{}{}
+	}
+	for p := range shTree {
+		paths[p] = struct{}{}
+	}
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	var diffs []string
+	for _, p := range sorted {
+		e, eok := execTree[p]
+		s, sok := shTree[p]
+		switch {
+		case !eok:
+			diffs = append(diffs, fmt.Sprintf("%s: only shlib created (%v)", p, s))
+		case !sok:
+			diffs = append(diffs, fmt.Sprintf("%s: only execlib created (%v)", p, e))
+		default:
+			if !modePaths[p] && e.mode&os.ModeType == s.mode&os.ModeType {
+				e.mode &= os.ModeType
+				s.mode &= os.ModeType
+			}
+			if e != s {
+				diffs = append(diffs, fmt.Sprintf("%s: execlib %v; shlib %v", p, e, s))
+			}
+		}
+	}
+	return diffs
+}
+
+// explicitModes returns the set of paths (relative to the root) for
+// which every file resource in c sets the mode bits.
+//
+// TODO(someday): shlib replaces a plain file's content with a new file,
+// which resets any permission bits that the resource does not set.
+func explicitModes(c *catpogs.Catalog) map[string]bool {
+	m := make(map[string]bool)
+	for _, r := range c.Resources {
+		if r.Which != catalog.Resource_Which_file {
+			continue
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(r.File.Path, RootPlaceholder), "/")
+		var mode *catpogs.FileMode
+		switch r.File.Which {
+		case catalog.File_Which_plain:
+			mode = r.File.Plain.Mode
+		case catalog.File_Which_directory:
+			mode = r.File.Directory.Mode
+		}
+		explicit := mode != nil && mode.Bits != catpogs.ModeUnset
+		if prev, ok := m[rel]; ok {
+			explicit = explicit && prev
+		}
+		m[rel] = explicit
+	}
+	return m
+}
+
+// relocate returns a deep copy of c with RootPlaceholder replaced by root.
+func relocate(c *catpogs.Catalog, root string) *catpogs.Catalog {
+	repl := func(s string) string {
+		return strings.Replace(s, RootPlaceholder, root, -1)
+	}
+	replCmd := func(cmd *catpogs.Command) *catpogs.Command {
+		if cmd == nil {
+			return nil
+		}
+		cc := new(catpogs.Command)
+		*cc = *cmd
+		cc.Argv = make([]string, len(cmd.Argv))
+		for i := range cmd.Argv {
+			cc.Argv[i] = repl(cmd.Argv[i])
+		}
+		cc.Bash = repl(cmd.Bash)
+		cc.Dir = repl(cmd.Dir)
+		return cc
+	}
+	cc := &catpogs.Catalog{Resources: make([]*catpogs.Resource, len(c.Resources))}
+	for i, r := range c.Resources {
+		rr := new(catpogs.Resource)
+		*rr = *r
+		if r.File != nil {
+			rr.File = new(catpogs.File)
+			*rr.File = *r.File
+			rr.File.Path = repl(r.File.Path)
+			rr.File.Symlink.Target = repl(r.File.Symlink.Target)
+			rr.File.Hardlink.Target = repl(r.File.Hardlink.Target)
+		}
+		if r.Exec != nil {
+			rr.Exec = new(catpogs.Exec)
+			*rr.Exec = *r.Exec
+			rr.Exec.Command = replCmd(r.Exec.Command)
+			rr.Exec.Condition.OnlyIf = replCmd(r.Exec.Condition.OnlyIf)
+			rr.Exec.Condition.Unless = replCmd(r.Exec.Condition.Unless)
+			rr.Exec.Condition.FileAbsent = repl(r.Exec.Condition.FileAbsent)
+		}
+		cc.Resources[i] = rr
+	}
+	return cc
+}
+
+// removeResource returns a copy of c without the i'th resource.  Any
+// references to the resource are removed, along with any resources
+// whose ifDepsChanged condition would become empty.  Dependents of a
+// removed resource inherit its dependencies so that the remaining
+// resources are applied in the same order.
+func removeResource(c *catpogs.Catalog, i int) *catpogs.Catalog {
+	removed := map[uint64]bool{c.Resources[i].ID: true}
+	for changed := true; changed; {
+		changed = false
+		for _, r := range c.Resources {
+			if removed[r.ID] || r.Which != catalog.Resource_Which_exec || r.Exec.Condition.Which != catalog.Exec_condition_Which_ifDepsChanged {
+				continue
+			}
+			if len(filterIDs(r.Exec.Condition.IfDepsChanged, removed)) == 0 {
+				removed[r.ID] = true
+				changed = true
+			}
+		}
+	}
+	// Resources only depend on earlier resources, so a single pass
+	// computes the inherited dependencies.
+	inherited := make(map[uint64][]uint64)
+	cc := &catpogs.Catalog{}
+	for _, r := range c.Resources {
+		var deps []uint64
+		seen := make(map[uint64]bool)
+		for _, id := range r.Deps {
+			ids := []uint64{id}
+			if removed[id] {
+				ids = inherited[id]
+			}
+			for _, id := range ids {
+				if !seen[id] {
+					seen[id] = true
+					deps = append(deps, id)
+				}
+			}
+		}
+		if removed[r.ID] {
+			inherited[r.ID] = deps
+			continue
+		}
+		rr := new(catpogs.Resource)
+		*rr = *r
+		rr.Deps = deps
+		if r.Which == catalog.Resource_Which_exec && r.Exec.Condition.Which == catalog.Exec_condition_Which_ifDepsChanged {
+			rr.Exec = new(catpogs.Exec)
+			*rr.Exec = *r.Exec
+			rr.Exec.Condition.IfDepsChanged = filterIDs(r.Exec.Condition.IfDepsChanged, removed)
+		}
+		cc.Resources = append(cc.Resources, rr)
+	}
+	return cc
+}
+
+func filterIDs(ids []uint64, removed map[uint64]bool) []uint64 {
+	var out []uint64
+	for _, id := range ids {
+		if !removed[id] {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+func sortedIDs(set map[uint64]struct{}) []uint64 {
+	ids := make([]uint64, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "not run"
+	}
+	return s
+}
+
+// FormatCatalog returns the Cap'n Proto text representation of c.
+func FormatCatalog(c *catpogs.Catalog) string {
+	cat, err := c.ToCapnp()
+	if err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	return cat.String()
+}
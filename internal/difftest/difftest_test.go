@@ -0,0 +1,81 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package difftest
+
+import (
+	"context"
+	"flag"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+var (
+	iterations = flag.Int("difftest.n", 50, "number of random catalogs to compare")
+	seed       = flag.Int64("difftest.seed", 1, "random seed for generating catalogs")
+)
+
+const tmpDirEnv = "TEST_TMPDIR"
+
+func TestDifferential(t *testing.T) {
+	tools, err := FindTools()
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Logf("seed = %d", *seed)
+	ctx := context.Background()
+	h := &Harness{
+		Tools:   tools,
+		TempDir: os.Getenv(tmpDirEnv),
+		Runs:    2,
+	}
+	g := &Generator{
+		Rand:  rand.New(rand.NewSource(*seed)),
+		Tools: tools,
+	}
+	for i := 0; i < *iterations; i++ {
+		c := g.Catalog()
+		err := h.Compare(ctx, c)
+		if err == nil {
+			continue
+		}
+		div, ok := err.(*Divergence)
+		if !ok {
+			t.Fatalf("iteration %d: %v", i, err)
+		}
+		div, err = h.Minimize(ctx, div)
+		if err != nil {
+			t.Errorf("iteration %d: minimize: %v", i, err)
+		}
+		t.Errorf("iteration %d: %v\nminimized catalog:\n%s", i, div, FormatCatalog(div.Catalog))
+	}
+}
+
+func TestRelocate(t *testing.T) {
+	tools := &Tools{Bash: "/bin/bash", True: "/bin/true", False: "/bin/false", Touch: "/bin/touch"}
+	g := &Generator{Rand: rand.New(rand.NewSource(1)), Tools: tools, MaxResources: 20}
+	for i := 0; i < 20; i++ {
+		c := g.Catalog()
+		before := FormatCatalog(c)
+		rc := relocate(c, "/foo")
+		if after := FormatCatalog(c); after != before {
+			t.Fatalf("relocate modified its argument:\nbefore: %s\nafter: %s", before, after)
+		}
+		if after := FormatCatalog(rc); strings.Contains(after, RootPlaceholder) {
+			t.Errorf("relocate(c, \"/foo\") = %s; still contains %s", after, RootPlaceholder)
+		}
+	}
+}
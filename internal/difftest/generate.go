@@ -0,0 +1,254 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package difftest
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/internal/catpogs"
+)
+
+// pathPool is the set of paths (relative to RootPlaceholder) that
+// generated file resources manage.  Keeping the pool small makes
+// resources likely to interact.
+var pathPool = []string{"a", "b", "c", "d", "d/x", "d/y"}
+
+// A Generator produces random valid catalogs.  Resources that touch
+// the path pool are ordered by dependencies, so the outcome of a
+// generated catalog does not depend on scheduling.
+//
+// A catalog either contains hard links or plain files with content,
+// but not both, since shlib replaces a file to change its content,
+// which breaks any hard links to it.  Similarly, either all plain files
+// in a catalog set their mode or none do, since execlib and shlib
+// create new files with different permissions.
+//
+// TODO(someday): Generate absent files and file owners once shlib
+// supports absent files and the harness can run as root.
+type Generator struct {
+	Rand  *rand.Rand
+	Tools *Tools
+
+	// MaxResources is the largest number of resources in a catalog.
+	// If non-positive, then it assumes 8.
+	MaxResources int
+}
+
+// Catalog returns a new random catalog.
+func (g *Generator) Catalog() *catpogs.Catalog {
+	max := g.MaxResources
+	if max <= 0 {
+		max = 8
+	}
+	gs := &genState{
+		g:          g,
+		ids:        make(map[uint64]bool),
+		hardlinks:  g.Rand.Intn(2) == 0,
+		plainModes: g.Rand.Intn(2) == 0,
+	}
+	n := 1 + g.Rand.Intn(max)
+	c := new(catpogs.Catalog)
+	for i := 0; i < n; i++ {
+		c.Resources = append(c.Resources, gs.resource(i))
+	}
+	return c
+}
+
+type genState struct {
+	g          *Generator
+	ids        map[uint64]bool
+	prev       []uint64
+	last       uint64 // last resource to touch the path pool
+	touches    int
+	hardlinks  bool
+	plainModes bool
+}
+
+func (gs *genState) resource(i int) *catpogs.Resource {
+	r := &catpogs.Resource{
+		ID:      gs.newID(),
+		Comment: fmt.Sprintf("resource %d", i),
+	}
+	deps := make(map[uint64]bool)
+	for _, id := range gs.prev {
+		if gs.g.Rand.Intn(5) == 0 {
+			deps[id] = true
+		}
+	}
+	touchesPool := false
+	switch gs.g.Rand.Intn(10) {
+	case 0:
+		r.Which = catalog.Resource_Which_noop
+	case 1, 2, 3, 4, 5, 6:
+		r.Which = catalog.Resource_Which_file
+		r.File = gs.file()
+		touchesPool = true
+	default:
+		r.Which = catalog.Resource_Which_exec
+		r.Exec = gs.exec()
+		touchesPool = r.Exec.Condition.Which == catalog.Exec_condition_Which_fileAbsent
+	}
+	if touchesPool {
+		// Symlinks can make any two pool paths refer to the same file,
+		// so order all resources that touch the pool.
+		if gs.last != 0 {
+			deps[gs.last] = true
+		}
+		gs.last = r.ID
+	}
+	for _, id := range gs.prev {
+		if deps[id] {
+			r.Deps = append(r.Deps, id)
+		}
+	}
+	if r.Which == catalog.Resource_Which_exec && r.Exec.Condition.Which == catalog.Exec_condition_Which_ifDepsChanged {
+		if len(r.Deps) == 0 {
+			r.Exec.Condition.Which = catalog.Exec_condition_Which_always
+		} else {
+			for _, id := range r.Deps {
+				if len(r.Exec.Condition.IfDepsChanged) == 0 || gs.g.Rand.Intn(2) == 0 {
+					r.Exec.Condition.IfDepsChanged = append(r.Exec.Condition.IfDepsChanged, id)
+				}
+			}
+		}
+	}
+	gs.prev = append(gs.prev, r.ID)
+	return r
+}
+
+func (gs *genState) newID() uint64 {
+	for {
+		id := uint64(gs.g.Rand.Int63())
+		if id != 0 && !gs.ids[id] {
+			gs.ids[id] = true
+			return id
+		}
+	}
+}
+
+func (gs *genState) file() *catpogs.File {
+	rng := gs.g.Rand
+	p := gs.poolPath()
+	kinds := 5
+	if gs.hardlinks {
+		kinds = 6
+	}
+	switch rng.Intn(kinds) {
+	case 0, 1:
+		var content []byte
+		choices := 4
+		if gs.hardlinks {
+			choices = 1
+		}
+		switch rng.Intn(choices) {
+		case 0:
+			// Leave content unset.
+		case 1:
+			content = []byte{}
+		case 2:
+			content = []byte("Hello, World!\n")
+		default:
+			content = []byte("Goodbye\n")
+		}
+		f := catpogs.PlainFile(p, content)
+		if gs.plainModes {
+			bits := []uint16{0644, 0600, 0755, 0640}
+			f.Plain.Mode = &catpogs.FileMode{Bits: bits[rng.Intn(len(bits))]}
+		}
+		return f
+	case 2, 3:
+		return catpogs.Directory(p, gs.mode([]uint16{0755, 0700, 0750}))
+	case 4:
+		target := gs.poolPath()
+		if rng.Intn(2) == 0 {
+			// Relative to the symlink's directory.
+			rel := strings.TrimPrefix(target, RootPlaceholder+"/")
+			for n := strings.Count(strings.TrimPrefix(p, RootPlaceholder+"/"), "/"); n > 0; n-- {
+				rel = "../" + rel
+			}
+			target = rel
+		}
+		return catpogs.SymlinkFile(target, p)
+	default:
+		return catpogs.HardlinkFile(gs.poolPath(), p)
+	}
+}
+
+func (gs *genState) mode(bits []uint16) *catpogs.FileMode {
+	if gs.g.Rand.Intn(2) == 0 {
+		return nil
+	}
+	return &catpogs.FileMode{Bits: bits[gs.g.Rand.Intn(len(bits))]}
+}
+
+func (gs *genState) exec() *catpogs.Exec {
+	rng := gs.g.Rand
+	e := &catpogs.Exec{Command: gs.command(true)}
+	switch rng.Intn(6) {
+	case 0, 1:
+		e.Condition.Which = catalog.Exec_condition_Which_always
+	case 2:
+		e.Condition.Which = catalog.Exec_condition_Which_onlyIf
+		e.Condition.OnlyIf = gs.command(false)
+	case 3:
+		e.Condition.Which = catalog.Exec_condition_Which_unless
+		e.Condition.Unless = gs.command(false)
+	case 4:
+		e.Condition.Which = catalog.Exec_condition_Which_fileAbsent
+		e.Condition.FileAbsent = gs.poolPath()
+	default:
+		// The caller fills in the dependency list.
+		e.Condition.Which = catalog.Exec_condition_Which_ifDepsChanged
+	}
+	return e
+}
+
+// command returns a random command.  If sideEffects is true, then the
+// command may create a file outside the path pool.
+func (gs *genState) command(sideEffects bool) *catpogs.Command {
+	rng := gs.g.Rand
+	tools := gs.g.Tools
+	choices := 2
+	if sideEffects {
+		choices = 3
+	}
+	var argv []string
+	switch rng.Intn(choices) {
+	case 0:
+		argv = []string{tools.True}
+	case 1:
+		argv = []string{tools.False}
+	default:
+		gs.touches++
+		argv = []string{tools.Touch, fmt.Sprintf("%s/touched%d", RootPlaceholder, gs.touches)}
+	}
+	if rng.Intn(3) == 0 {
+		return &catpogs.Command{
+			Which: catalog.Exec_Command_Which_bash,
+			Bash:  strings.Join(argv, " ") + "\n",
+		}
+	}
+	return &catpogs.Command{
+		Which: catalog.Exec_Command_Which_argv,
+		Argv:  argv,
+	}
+}
+
+func (gs *genState) poolPath() string {
+	return RootPlaceholder + "/" + pathPool[gs.g.Rand.Intn(len(pathPool))]
+}
@@ -0,0 +1,171 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package digestcache remembers the content digests of files, so that
+// a file that hasn't changed since it was last verified doesn't need
+// to be read again.
+//
+// An entry is keyed by the file's path, inode number, modification
+// time, and size.  Like make or rsync, the cache trusts that a file
+// whose key is unchanged has unchanged content, so it should not be
+// used where files may be rewritten without changing their mtime.
+package digestcache
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zombiezen/mcm/internal/blobstore"
+)
+
+// Key identifies a version of a file.
+type Key struct {
+	Path    string
+	Inode   uint64
+	ModTime time.Time
+	Size    int64
+}
+
+// A Cache maps file keys to content digests.  It is safe to call from
+// multiple goroutines.  The zero value is an empty cache.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[string]entry // by path
+}
+
+type entry struct {
+	inode   uint64
+	modTime int64 // in nanoseconds since the Unix epoch
+	size    int64
+	digest  blobstore.Digest
+}
+
+func (k Key) entry(d blobstore.Digest) entry {
+	return entry{
+		inode:   k.Inode,
+		modTime: k.ModTime.UnixNano(),
+		size:    k.Size,
+		digest:  d,
+	}
+}
+
+// Get returns the digest last recorded for the file with the given key.
+func (c *Cache) Get(k Key) (d blobstore.Digest, ok bool) {
+	c.mu.Lock()
+	ent, ok := c.entries[k.Path]
+	c.mu.Unlock()
+	if !ok || ent != k.entry(ent.digest) {
+		return blobstore.Digest{}, false
+	}
+	return ent.digest, true
+}
+
+// Put records that the file with the given key has content with the
+// digest d, replacing any previous entry for the path.
+func (c *Cache) Put(k Key, d blobstore.Digest) {
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = make(map[string]entry)
+	}
+	c.entries[k.Path] = k.entry(d)
+	c.mu.Unlock()
+}
+
+// jsonEntry is the on-disk form of an entry.
+type jsonEntry struct {
+	Path    string `json:"path"`
+	Inode   uint64 `json:"inode"`
+	ModTime int64  `json:"mtime"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+}
+
+// Load reads a cache written by Save.  A missing file is treated as an
+// empty cache.
+func Load(path string) (*Cache, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return new(Cache), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load digest cache: %v", err)
+	}
+	var list []jsonEntry
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("load digest cache %s: %v", path, err)
+	}
+	c := &Cache{entries: make(map[string]entry, len(list))}
+	for _, je := range list {
+		raw, err := hex.DecodeString(je.SHA256)
+		if err != nil {
+			return nil, fmt.Errorf("load digest cache %s: %s: %v", path, je.Path, err)
+		}
+		d, err := blobstore.DigestFromBytes(raw)
+		if err != nil {
+			return nil, fmt.Errorf("load digest cache %s: %s: %v", path, je.Path, err)
+		}
+		c.entries[je.Path] = entry{
+			inode:   je.Inode,
+			modTime: je.ModTime,
+			size:    je.Size,
+			digest:  d,
+		}
+	}
+	return c, nil
+}
+
+// Save writes the cache to path, replacing it atomically.
+func (c *Cache) Save(path string) error {
+	c.mu.Lock()
+	list := make([]jsonEntry, 0, len(c.entries))
+	for p, ent := range c.entries {
+		list = append(list, jsonEntry{
+			Path:    p,
+			Inode:   ent.inode,
+			ModTime: ent.modTime,
+			Size:    ent.size,
+			SHA256:  ent.digest.String(),
+		})
+	}
+	c.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	data, err := json.MarshalIndent(list, "", "\t")
+	if err != nil {
+		return fmt.Errorf("save digest cache: %v", err)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".digestcache")
+	if err != nil {
+		return fmt.Errorf("save digest cache: %v", err)
+	}
+	_, err = f.Write(append(data, '\n'))
+	cerr := f.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("save digest cache: %v", err)
+	}
+	return nil
+}
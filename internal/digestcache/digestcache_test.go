@@ -0,0 +1,71 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package digestcache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zombiezen/mcm/internal/blobstore"
+)
+
+func TestCache(t *testing.T) {
+	mtime := time.Date(2017, time.March, 1, 12, 0, 0, 5, time.UTC)
+	k := Key{Path: "/etc/motd", Inode: 42, ModTime: mtime, Size: 14}
+	d := blobstore.Sum([]byte("Hello, World!\n"))
+	misses := []Key{
+		{Path: "/etc/issue", Inode: 42, ModTime: mtime, Size: 14},
+		{Path: "/etc/motd", Inode: 43, ModTime: mtime, Size: 14},
+		{Path: "/etc/motd", Inode: 42, ModTime: mtime.Add(time.Nanosecond), Size: 14},
+		{Path: "/etc/motd", Inode: 42, ModTime: mtime, Size: 15},
+	}
+
+	dir, err := ioutil.TempDir("", "mcm_digestcache_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.json")
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal("Load of missing file:", err)
+	}
+	if _, ok := c.Get(k); ok {
+		t.Error("empty cache has entry")
+	}
+	c.Put(k, d)
+	if err := c.Save(path); err != nil {
+		t.Fatal("Save:", err)
+	}
+	c, err = Load(path)
+	if err != nil {
+		t.Fatal("Load:", err)
+	}
+	if got, ok := c.Get(k); !ok || got != d {
+		t.Errorf("Get(%+v) = %v, %t; want %v, true", k, got, ok, d)
+	}
+	for _, miss := range misses {
+		if _, ok := c.Get(miss); ok {
+			t.Errorf("Get(%+v) found an entry", miss)
+		}
+	}
+	c.Put(misses[1], d)
+	if _, ok := c.Get(k); ok {
+		t.Error("Put did not replace the entry for the path")
+	}
+}
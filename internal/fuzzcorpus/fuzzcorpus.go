@@ -0,0 +1,61 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fuzzcorpus provides the seed corpus for fuzz tests that read
+// catalogs.
+//
+// The corpus is the set of *.bin files in luacat/testdata, which are
+// the serialized catalogs that luacat's test suite expects from the
+// Lua scripts next to them.  To regenerate one after changing its
+// expectation in luacat/testsuite.capnp, run:
+//
+//	capnp encode catalog.capnp Catalog < expected.txt > luacat/testdata/NAME.bin
+package fuzzcorpus
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Dir is the directory containing the seed corpus, relative to the
+// root of the repository.
+const Dir = "luacat/testdata"
+
+// A Corpus receives seed inputs.  *testing.F implements Corpus; it is
+// an interface so that this package builds with Go releases that
+// predate native fuzzing.
+type Corpus interface {
+	testing.TB
+	Add(args ...interface{})
+}
+
+// Add adds each catalog in the seed corpus to f.  root is the path to
+// the root of the repository, relative to the test's working directory.
+func Add(f Corpus, root string) {
+	paths, err := filepath.Glob(filepath.Join(root, filepath.FromSlash(Dir), "*.bin"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	if len(paths) == 0 {
+		f.Fatalf("no seed catalogs found in %s", Dir)
+	}
+	for _, p := range paths {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+}
@@ -45,9 +45,10 @@ var epoch = time.Date(2016, time.November, 24, 0, 0, 0, 0, time.UTC)
 // It uses path/filepath for path manipulation.  It is safe to use from
 // multiple goroutines.  The zero value is an empty filesystem.
 type System struct {
-	mu   sync.Mutex
-	fs   map[string]*entry
-	time time.Time
+	mu      sync.Mutex
+	fs      map[string]*entry
+	time    time.Time
+	lastIno uint64
 }
 
 // Program is a function to call when an executable file is run.
@@ -62,6 +63,7 @@ type ProgramContext struct {
 }
 
 type entry struct {
+	ino     uint64
 	mode    os.FileMode
 	uid     system.UID
 	gid     system.GID
@@ -78,11 +80,17 @@ func (sys *System) init() {
 	sys.time = epoch
 	sys.fs = make(map[string]*entry)
 	sys.fs["/"] = &entry{
+		ino:     sys.nextIno(),
 		mode:    os.ModeDir | 0777,
 		modTime: sys.time,
 	}
 }
 
+func (sys *System) nextIno() uint64 {
+	sys.lastIno++
+	return sys.lastIno
+}
+
 func (sys *System) stepTime() {
 	sys.time = sys.time.Add(1 * time.Second)
 }
@@ -144,34 +152,45 @@ func (sys *System) Lstat(ctx context.Context, path string) (os.FileInfo, error)
 		size:    len(ent.content),
 		uid:     ent.uid,
 		gid:     ent.gid,
+		ent:     ent,
 	}, nil
 }
 
 func (sys *System) mkentry(path string, mode os.FileMode) (*entry, error) {
+	ent := &entry{
+		ino:     sys.nextIno(),
+		mode:    mode,
+		modTime: sys.time,
+		uid:     DefaultUID,
+		gid:     DefaultGID,
+	}
+	if err := sys.addentry(path, ent); err != nil {
+		return nil, err
+	}
+	return ent, nil
+}
+
+// addentry adds ent to the filesystem at path.  The same entry may be
+// added at multiple paths to form hard links.
+func (sys *System) addentry(path string, ent *entry) error {
 	dir, name := filepath.Split(path)
 	dir = sys.resolve(dir)
 	par := sys.fs[dir]
 	if par == nil {
-		return nil, os.ErrNotExist
+		return os.ErrNotExist
 	}
 	if !par.mode.IsDir() {
-		return nil, errors.New("fake OS: not a directory")
+		return errors.New("fake OS: not a directory")
 	}
 	if par.mode&0222 == 0 {
-		return nil, os.ErrPermission
+		return os.ErrPermission
 	}
 	path = filepath.Join(dir, name)
 	if sys.fs[path] != nil {
-		return nil, os.ErrExist
-	}
-	ent := &entry{
-		mode:    mode,
-		modTime: sys.time,
-		uid:     DefaultUID,
-		gid:     DefaultGID,
+		return os.ErrExist
 	}
 	sys.fs[path] = ent
-	return ent, nil
+	return nil
 }
 
 func (sys *System) CreateFile(ctx context.Context, path string, mode os.FileMode) (system.FileWriter, error) {
@@ -281,6 +300,60 @@ func (sys *System) Readlink(ctx context.Context, path string) (string, error) {
 	return ent.link, nil
 }
 
+func (sys *System) Link(ctx context.Context, oldname, newname string) error {
+	wrap := linkErrorFunc("link", oldname, newname)
+	oldname, err := cleanPath(oldname)
+	if err != nil {
+		return wrap(err)
+	}
+	newname, err = cleanPath(newname)
+	if err != nil {
+		return wrap(err)
+	}
+
+	defer sys.mu.Unlock()
+	defer sys.stepTime()
+	sys.mu.Lock()
+	sys.init()
+	dir, name := filepath.Split(oldname)
+	dir = sys.resolve(dir)
+	ent := sys.fs[filepath.Join(dir, name)]
+	if ent == nil {
+		return wrap(os.ErrNotExist)
+	}
+	if ent.mode.IsDir() {
+		return wrap(os.ErrPermission)
+	}
+	return wrap(sys.addentry(newname, ent))
+}
+
+// Mknod creates a special file, like a FIFO, socket, or device node.
+// The type bits of mode must be one of os.ModeNamedPipe, os.ModeSocket,
+// os.ModeDevice, or os.ModeDevice|os.ModeCharDevice.
+func (sys *System) Mknod(path string, mode os.FileMode) error {
+	wrap := pathErrorFunc("mknod", path)
+	switch mode & os.ModeType {
+	case os.ModeNamedPipe, os.ModeSocket, os.ModeDevice, os.ModeDevice | os.ModeCharDevice:
+		// Valid special file.
+	default:
+		return wrap(fmt.Errorf("fake OS: mode %v is not a special file", mode))
+	}
+	path, err := cleanPath(path)
+	if err != nil {
+		return wrap(err)
+	}
+
+	defer sys.mu.Unlock()
+	defer sys.stepTime()
+	sys.mu.Lock()
+	sys.init()
+	_, err = sys.mkentry(path, mode&(os.ModeType|os.ModePerm))
+	if err != nil {
+		return wrap(err)
+	}
+	return nil
+}
+
 func (sys *System) Chmod(ctx context.Context, path string, mode os.FileMode) error {
 	const mask = os.ModePerm | os.ModeSticky | os.ModeSetuid | os.ModeSetgid
 	wrap := pathErrorFunc("chmod", path)
@@ -337,6 +410,20 @@ func (sys *System) OwnerInfo(info os.FileInfo) (system.UID, system.GID, error) {
 	return s.uid, s.gid, nil
 }
 
+func (sys *System) SameFile(fi1, fi2 os.FileInfo) bool {
+	s1, ok1 := fi1.Sys().(*stat)
+	s2, ok2 := fi2.Sys().(*stat)
+	return ok1 && ok2 && s1.ent == s2.ent
+}
+
+func (sys *System) Inode(info os.FileInfo) (uint64, error) {
+	s, ok := info.Sys().(*stat)
+	if !ok {
+		return 0, errors.New("file info not from fakesystem")
+	}
+	return s.ent.ino, nil
+}
+
 func (sys *System) readdir(path string) []string {
 	var names []string
 	for p := range sys.fs {
@@ -622,6 +709,7 @@ type stat struct {
 	size    int
 	uid     system.UID
 	gid     system.GID
+	ent     *entry
 }
 
 func (s *stat) Name() string       { return s.name }
@@ -155,7 +155,7 @@ func TestMkdir(t *testing.T) {
 			sys := new(System)
 			dirpath := filepath.Join(Root, fmt.Sprintf("foo%#04o", uint32(i)))
 			if err := sys.Mkdir(ctx, dirpath, i); err != nil {
-				fmt.Errorf("sys.Mkdir(ctx, %q, %#04o): %v", dirpath, uint32(i), err)
+				t.Errorf("sys.Mkdir(ctx, %q, %#04o): %v", dirpath, uint32(i), err)
 				continue
 			}
 			info, err := sys.Lstat(ctx, dirpath)
@@ -326,6 +326,147 @@ func TestSymlink(t *testing.T) {
 	})
 }
 
+func TestLink(t *testing.T) {
+	dpath := filepath.Join(Root, "dir")
+	fpath := filepath.Join(dpath, "foo.txt")
+	const fileContent = "Hello"
+	newSystem := func(ctx context.Context, log logger) (*System, error) {
+		sys := new(System)
+		if err := mkdir(ctx, log, sys, dpath); err != nil {
+			return nil, err
+		}
+		if err := mkfile(ctx, log, sys, fpath, []byte(fileContent)); err != nil {
+			return nil, err
+		}
+		return sys, nil
+	}
+	t.Run("file", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		sys, err := newSystem(ctx, t)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lpath := filepath.Join(Root, "mylink")
+		t.Logf("sys.Link(ctx, %q, %q)", fpath, lpath)
+		if err := sys.Link(ctx, fpath, lpath); err != nil {
+			t.Fatalf("sys.Link(ctx, %q, %q): %v", fpath, lpath, err)
+		}
+		finfo, err := sys.Lstat(ctx, fpath)
+		if err != nil {
+			t.Fatalf("sys.Lstat(ctx, %q): %v", fpath, err)
+		}
+		linfo, err := sys.Lstat(ctx, lpath)
+		if err != nil {
+			t.Fatalf("sys.Lstat(ctx, %q): %v", lpath, err)
+		}
+		if !linfo.Mode().IsRegular() {
+			t.Errorf("sys.Lstat(ctx, %q).Mode() = %v; want regular", lpath, linfo.Mode())
+		}
+		if !sys.SameFile(finfo, linfo) {
+			t.Errorf("sys.SameFile(sys.Lstat(ctx, %q), sys.Lstat(ctx, %q)) = false; want true", fpath, lpath)
+		}
+
+		const newContent = "Goodbye"
+		if err := mkfile(ctx, t, sys, lpath, []byte(newContent)); err != nil {
+			t.Fatal(err)
+		}
+		content, err := system.ReadFile(ctx, sys, fpath)
+		if err != nil {
+			t.Fatalf("system.ReadFile(ctx, sys, %q): %v", fpath, err)
+		}
+		if !bytes.Equal(content, []byte(newContent)) {
+			t.Errorf("system.ReadFile(ctx, sys, %q) = %q; want %q", fpath, content, newContent)
+		}
+
+		t.Logf("sys.Remove(ctx, %q)", fpath)
+		if err := sys.Remove(ctx, fpath); err != nil {
+			t.Fatalf("sys.Remove(ctx, %q): %v", fpath, err)
+		}
+		content, err = system.ReadFile(ctx, sys, lpath)
+		if err != nil {
+			t.Fatalf("system.ReadFile(ctx, sys, %q): %v", lpath, err)
+		}
+		if !bytes.Equal(content, []byte(newContent)) {
+			t.Errorf("system.ReadFile(ctx, sys, %q) = %q; want %q", lpath, content, newContent)
+		}
+	})
+	t.Run("directory", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		sys, err := newSystem(ctx, t)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lpath := filepath.Join(Root, "mylink")
+		t.Logf("sys.Link(ctx, %q, %q)", dpath, lpath)
+		if err := sys.Link(ctx, dpath, lpath); err == nil {
+			t.Errorf("sys.Link(ctx, %q, %q) = nil; want error", dpath, lpath)
+		}
+		if _, err := sys.Lstat(ctx, lpath); !system.IsNotExist(err) {
+			t.Errorf("sys.Lstat(ctx, %q) = _, %v; want not exist", lpath, err)
+		}
+	})
+	t.Run("exists", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		sys, err := newSystem(ctx, t)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Logf("sys.Link(ctx, %q, %q)", fpath, dpath)
+		if err := sys.Link(ctx, fpath, dpath); !system.IsExist(err) {
+			t.Errorf("sys.Link(ctx, %q, %q) = %v; want exists", fpath, dpath, err)
+		}
+	})
+}
+
+func TestMknod(t *testing.T) {
+	tests := []struct {
+		mode  os.FileMode
+		fails bool
+	}{
+		{mode: os.ModeNamedPipe | 0644},
+		{mode: os.ModeSocket | 0755},
+		{mode: os.ModeDevice | 0600},
+		{mode: os.ModeDevice | os.ModeCharDevice | 0666},
+		{mode: 0644, fails: true},
+		{mode: os.ModeDir | 0755, fails: true},
+		{mode: os.ModeSymlink | 0777, fails: true},
+	}
+	for _, test := range tests {
+		ctx, cancel := context.WithCancel(context.Background())
+		sys := new(System)
+		path := filepath.Join(Root, "node")
+		err := sys.Mknod(path, test.mode)
+		if test.fails {
+			if err == nil {
+				t.Errorf("sys.Mknod(%q, %v) = nil; want error", path, test.mode)
+			}
+			cancel()
+			continue
+		}
+		if err != nil {
+			t.Errorf("sys.Mknod(%q, %v): %v", path, test.mode, err)
+			cancel()
+			continue
+		}
+		info, err := sys.Lstat(ctx, path)
+		if err != nil {
+			t.Errorf("sys.Lstat(ctx, %q): %v", path, err)
+		} else if info.Mode() != test.mode {
+			t.Errorf("sys.Lstat(ctx, %q).Mode() = %v; want %v", path, info.Mode(), test.mode)
+		}
+		if _, err := sys.OpenFile(ctx, path); err == nil {
+			t.Errorf("sys.OpenFile(ctx, %q) = _, nil; want error", path)
+		}
+		cancel()
+	}
+}
+
 func TestChmod(t *testing.T) {
 	const modeCount = 0777 * 8
 	modeAt := func(i int) os.FileMode {
@@ -588,6 +729,119 @@ func TestRun(t *testing.T) {
 	})
 }
 
+func TestDiff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sys := new(System)
+	dpath := filepath.Join(Root, "dir")
+	fpath := filepath.Join(dpath, "foo.txt")
+	gonePath := filepath.Join(Root, "gone")
+	lpath := filepath.Join(Root, "link")
+	if err := mkdir(ctx, t, sys, dpath); err != nil {
+		t.Fatal(err)
+	}
+	if err := mkfile(ctx, t, sys, fpath, []byte("Hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mkfile(ctx, t, sys, gonePath, nil); err != nil {
+		t.Fatal(err)
+	}
+	before := sys.Snapshot()
+	if changes := Diff(before, sys.Snapshot()); len(changes) != 0 {
+		t.Errorf("Diff of unchanged system = %v; want empty", changes)
+	}
+
+	if err := mkfile(ctx, t, sys, fpath, []byte("Goodbye")); err != nil {
+		t.Fatal(err)
+	}
+	if err := sys.Chmod(ctx, dpath, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := sys.Remove(ctx, gonePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := mklink(ctx, t, sys, fpath, lpath); err != nil {
+		t.Fatal(err)
+	}
+	changes := Diff(before, sys.Snapshot())
+	want := []struct {
+		path     string
+		old, new bool
+	}{
+		{dpath, true, true},
+		{fpath, true, true},
+		{gonePath, true, false},
+		{lpath, false, true},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("Diff(...) =\n%v\nwant %d changes", changes, len(want))
+	}
+	for i := range want {
+		c := changes[i]
+		if c.Path != want[i].path || (c.Old != nil) != want[i].old || (c.New != nil) != want[i].new {
+			t.Errorf("changes[%d] = %v; want change to %s (old=%t, new=%t)", i, c, want[i].path, want[i].old, want[i].new)
+		}
+	}
+	if s := changes[1].String(); !strings.Contains(s, `"Hello" -> "Goodbye"`) {
+		t.Errorf("changes[1].String() = %q; want to contain content change", s)
+	}
+}
+
+func TestTar(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sys := new(System)
+	dpath := filepath.Join(Root, "dir")
+	fpath := filepath.Join(dpath, "foo.txt")
+	hpath := filepath.Join(Root, "hardlink")
+	lpath := filepath.Join(Root, "symlink")
+	ppath := filepath.Join(Root, "fifo")
+	if err := mkdir(ctx, t, sys, dpath); err != nil {
+		t.Fatal(err)
+	}
+	if err := mkfile(ctx, t, sys, fpath, []byte("Hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := sys.Chmod(ctx, fpath, os.ModeSetuid|0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := sys.Chown(ctx, fpath, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := sys.Link(ctx, fpath, hpath); err != nil {
+		t.Fatal(err)
+	}
+	if err := mklink(ctx, t, sys, "dir/foo.txt", lpath); err != nil {
+		t.Fatal(err)
+	}
+	if err := sys.Mknod(ppath, os.ModeNamedPipe|0600); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := sys.WriteTar(buf); err != nil {
+		t.Fatal("WriteTar:", err)
+	}
+	sys2 := new(System)
+	if err := sys2.ReadTar(buf); err != nil {
+		t.Fatal("ReadTar:", err)
+	}
+	if changes := Diff(sys.Snapshot(), sys2.Snapshot()); len(changes) != 0 {
+		t.Errorf("Diff(original, round trip) =\n%v\nwant no changes", changes)
+	}
+	finfo, err := sys2.Lstat(ctx, fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hinfo, err := sys2.Lstat(ctx, hpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sys2.SameFile(finfo, hinfo) {
+		t.Errorf("after round trip, %s and %s are not the same file", fpath, hpath)
+	}
+}
+
 func TestPathParts(t *testing.T) {
 	type testCase struct {
 		path  string
@@ -0,0 +1,186 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakesystem
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/zombiezen/mcm/internal/system"
+)
+
+// A Snapshot is a copy of a System's filesystem at a point in time.
+// Modification times are not recorded, since every operation on a
+// System advances its clock.
+type Snapshot struct {
+	entries map[string]*EntryInfo
+}
+
+// EntryInfo describes a single entry in a Snapshot.
+type EntryInfo struct {
+	Mode    os.FileMode
+	UID     system.UID
+	GID     system.GID
+	Content []byte // regular files only
+	Target  string // symlinks only
+	Program bool   // whether the entry was created by Mkprogram
+}
+
+// Snapshot returns a copy of the filesystem's current state.
+func (sys *System) Snapshot() *Snapshot {
+	defer sys.mu.Unlock()
+	sys.mu.Lock()
+	sys.init()
+	snap := &Snapshot{entries: make(map[string]*EntryInfo, len(sys.fs))}
+	for path, ent := range sys.fs {
+		snap.entries[path] = &EntryInfo{
+			Mode:    ent.mode,
+			UID:     ent.uid,
+			GID:     ent.gid,
+			Content: append([]byte(nil), ent.content...),
+			Target:  ent.link,
+			Program: ent.program != nil,
+		}
+	}
+	return snap
+}
+
+// Paths returns the sorted list of paths in the snapshot.
+func (snap *Snapshot) Paths() []string {
+	paths := make([]string, 0, len(snap.entries))
+	for path := range snap.entries {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Entry returns the entry at path or nil if there is no such entry.
+// Symlinks are not followed.  The caller must not modify the returned
+// EntryInfo.
+func (snap *Snapshot) Entry(path string) *EntryInfo {
+	return snap.entries[path]
+}
+
+// A Change is a difference in a single path between two snapshots.
+// Old is nil if the entry was added and New is nil if the entry was
+// removed.
+type Change struct {
+	Path string
+	Old  *EntryInfo
+	New  *EntryInfo
+}
+
+// Changes is a list of changes, as returned by Diff.
+type Changes []Change
+
+// Diff returns the changes needed to go from snapshot a to snapshot b,
+// sorted by path.
+func Diff(a, b *Snapshot) Changes {
+	var changes Changes
+	for path, old := range a.entries {
+		new := b.entries[path]
+		if new == nil || !old.equal(new) {
+			changes = append(changes, Change{Path: path, Old: old, New: new})
+		}
+	}
+	for path, new := range b.entries {
+		if a.entries[path] == nil {
+			changes = append(changes, Change{Path: path, New: new})
+		}
+	}
+	sort.Sort(changes)
+	return changes
+}
+
+func (cs Changes) Len() int           { return len(cs) }
+func (cs Changes) Less(i, j int) bool { return cs[i].Path < cs[j].Path }
+func (cs Changes) Swap(i, j int)      { cs[i], cs[j] = cs[j], cs[i] }
+
+// String formats the changes as a listing with one line per change.
+// Added entries are prefixed with "+", removed entries with "-", and
+// modified entries with "~".
+func (cs Changes) String() string {
+	if len(cs) == 0 {
+		return "no changes"
+	}
+	lines := make([]string, len(cs))
+	for i := range cs {
+		lines[i] = cs[i].String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (c Change) String() string {
+	switch {
+	case c.Old == nil:
+		return fmt.Sprintf("+ %s (%v)", c.Path, c.New)
+	case c.New == nil:
+		return fmt.Sprintf("- %s (%v)", c.Path, c.Old)
+	}
+	var diffs []string
+	if c.Old.Mode != c.New.Mode {
+		diffs = append(diffs, fmt.Sprintf("mode %v -> %v", c.Old.Mode, c.New.Mode))
+	}
+	if c.Old.UID != c.New.UID || c.Old.GID != c.New.GID {
+		diffs = append(diffs, fmt.Sprintf("owner %d:%d -> %d:%d", c.Old.UID, c.Old.GID, c.New.UID, c.New.GID))
+	}
+	if !bytes.Equal(c.Old.Content, c.New.Content) {
+		diffs = append(diffs, "content "+formatContent(c.Old.Content)+" -> "+formatContent(c.New.Content))
+	}
+	if c.Old.Target != c.New.Target {
+		diffs = append(diffs, fmt.Sprintf("target %q -> %q", c.Old.Target, c.New.Target))
+	}
+	if c.Old.Program != c.New.Program {
+		diffs = append(diffs, fmt.Sprintf("program %t -> %t", c.Old.Program, c.New.Program))
+	}
+	return fmt.Sprintf("~ %s: %s", c.Path, strings.Join(diffs, "; "))
+}
+
+func (info *EntryInfo) String() string {
+	s := fmt.Sprintf("%v %d:%d", info.Mode, info.UID, info.GID)
+	switch {
+	case info.Mode&os.ModeType == os.ModeSymlink:
+		s += fmt.Sprintf(" -> %q", info.Target)
+	case info.Program:
+		s += ", program"
+	case info.Mode.IsRegular():
+		s += ", content " + formatContent(info.Content)
+	}
+	return s
+}
+
+func (info *EntryInfo) equal(other *EntryInfo) bool {
+	return info.Mode == other.Mode &&
+		info.UID == other.UID &&
+		info.GID == other.GID &&
+		bytes.Equal(info.Content, other.Content) &&
+		info.Target == other.Target &&
+		info.Program == other.Program
+}
+
+// maxInlineContent is the largest content that formatContent will
+// print verbatim.
+const maxInlineContent = 64
+
+func formatContent(b []byte) string {
+	if len(b) <= maxInlineContent {
+		return fmt.Sprintf("%q", b)
+	}
+	return fmt.Sprintf("%d bytes", len(b))
+}
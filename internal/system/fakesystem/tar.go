@@ -0,0 +1,245 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakesystem
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zombiezen/mcm/internal/system"
+)
+
+// WriteTar writes the filesystem to w as a tar archive.  Paths in the
+// archive are relative to Root.  Entries that share a hard link are
+// written as tar hard links.  Programs are written as empty regular
+// files and sockets are omitted, since neither can be represented in a
+// tar archive.
+func (sys *System) WriteTar(w io.Writer) error {
+	defer sys.mu.Unlock()
+	sys.mu.Lock()
+	sys.init()
+
+	paths := make([]string, 0, len(sys.fs))
+	for path := range sys.fs {
+		if path != Root {
+			paths = append(paths, path)
+		}
+	}
+	// Sorting ensures that directories are written before their contents.
+	sort.Strings(paths)
+	tw := tar.NewWriter(w)
+	linked := make(map[*entry]string)
+	for _, path := range paths {
+		ent := sys.fs[path]
+		if ent.mode&os.ModeType == os.ModeSocket {
+			continue
+		}
+		name := filepath.ToSlash(strings.TrimPrefix(path, Root))
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    tarMode(ent.mode),
+			Uid:     int(ent.uid),
+			Gid:     int(ent.gid),
+			ModTime: ent.modTime,
+		}
+		if first, ok := linked[ent]; ok {
+			hdr.Typeflag = tar.TypeLink
+			hdr.Linkname = first
+		} else {
+			switch ent.mode & os.ModeType {
+			case 0:
+				hdr.Typeflag = tar.TypeReg
+				hdr.Size = int64(len(ent.content))
+				linked[ent] = name
+			case os.ModeDir:
+				hdr.Typeflag = tar.TypeDir
+				hdr.Name += "/"
+			case os.ModeSymlink:
+				hdr.Typeflag = tar.TypeSymlink
+				hdr.Linkname = ent.link
+			case os.ModeNamedPipe:
+				hdr.Typeflag = tar.TypeFifo
+				linked[ent] = name
+			case os.ModeDevice:
+				hdr.Typeflag = tar.TypeBlock
+				linked[ent] = name
+			case os.ModeDevice | os.ModeCharDevice:
+				hdr.Typeflag = tar.TypeChar
+				linked[ent] = name
+			default:
+				return fmt.Errorf("write tar: %s has unsupported mode %v", path, ent.mode)
+			}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("write tar: %s: %v", path, err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write(ent.content); err != nil {
+				return fmt.Errorf("write tar: %s: %v", path, err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("write tar: %v", err)
+	}
+	return nil
+}
+
+// ReadTar extracts the tar archive in r into the filesystem.  Paths in
+// the archive are interpreted relative to Root.  Missing parent
+// directories are created and existing entries are replaced, except
+// that a directory in the archive only updates the mode and owner of an
+// existing directory.
+func (sys *System) ReadTar(r io.Reader) error {
+	defer sys.mu.Unlock()
+	defer sys.stepTime()
+	sys.mu.Lock()
+	sys.init()
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read tar: %v", err)
+		}
+		if err := sys.extract(tr, hdr); err != nil {
+			return fmt.Errorf("read tar: %s: %v", hdr.Name, err)
+		}
+	}
+}
+
+func (sys *System) extract(tr *tar.Reader, hdr *tar.Header) error {
+	path, err := tarPath(hdr.Name)
+	if err != nil {
+		return err
+	}
+	if path == Root {
+		return nil
+	}
+	if err := sys.mkparents(path); err != nil {
+		return err
+	}
+	mode := hdr.FileInfo().Mode()
+	if hdr.Typeflag == tar.TypeDir {
+		if ent := sys.fs[path]; ent != nil && ent.mode.IsDir() {
+			ent.mode = mode
+			ent.uid = system.UID(hdr.Uid)
+			ent.gid = system.GID(hdr.Gid)
+			ent.modTime = hdr.ModTime
+			return nil
+		}
+	}
+	if ent := sys.fs[path]; ent != nil {
+		if ent.mode.IsDir() && len(sys.readdir(path)) > 0 {
+			return errors.New("fake OS: directory not empty")
+		}
+		delete(sys.fs, path)
+	}
+	if hdr.Typeflag == tar.TypeLink {
+		oldpath, err := tarPath(hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		ent := sys.fs[oldpath]
+		if ent == nil {
+			return fmt.Errorf("hard link target %s: %v", hdr.Linkname, os.ErrNotExist)
+		}
+		if ent.mode.IsDir() {
+			return fmt.Errorf("hard link target %s: %v", hdr.Linkname, os.ErrPermission)
+		}
+		return sys.addentry(path, ent)
+	}
+	ent := &entry{
+		ino:     sys.nextIno(),
+		mode:    mode,
+		uid:     system.UID(hdr.Uid),
+		gid:     system.GID(hdr.Gid),
+		modTime: hdr.ModTime,
+	}
+	switch hdr.Typeflag {
+	case tar.TypeReg:
+		ent.content, err = ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+	case tar.TypeDir, tar.TypeFifo, tar.TypeChar, tar.TypeBlock:
+		// No extra data.
+	case tar.TypeSymlink:
+		ent.link = hdr.Linkname
+	default:
+		return fmt.Errorf("unsupported tar entry type %q", hdr.Typeflag)
+	}
+	return sys.addentry(path, ent)
+}
+
+// mkparents creates any missing parent directories of path.
+// The caller must be holding sys.mu.
+func (sys *System) mkparents(path string) error {
+	parts := pathParts(filepath.Dir(path))
+	if len(parts) == 0 {
+		return nil
+	}
+	curr := parts[0]
+	for _, p := range parts[1:] {
+		curr = sys.resolve(filepath.Join(curr, p))
+		ent := sys.fs[curr]
+		if ent == nil {
+			if _, err := sys.mkentry(curr, os.ModeDir|0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if !ent.mode.IsDir() {
+			return errors.New("fake OS: not a directory")
+		}
+	}
+	return nil
+}
+
+// tarPath converts a name in a tar archive to an absolute path.
+func tarPath(name string) (string, error) {
+	name = filepath.FromSlash(name)
+	if filepath.IsAbs(name) {
+		return "", errors.New("absolute path in tar archive")
+	}
+	// Join cleans the path, so ".." elements can't escape the root.
+	return filepath.Join(Root, name), nil
+}
+
+// tarMode converts a Go file mode to the Unix mode bits used in tar
+// headers.
+func tarMode(mode os.FileMode) int64 {
+	m := int64(mode & os.ModePerm)
+	if mode&os.ModeSetuid != 0 {
+		m |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= 01000
+	}
+	return m
+}
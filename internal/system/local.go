@@ -53,6 +53,16 @@ func (Local) Readlink(ctx context.Context, path string) (string, error) {
 	return os.Readlink(path)
 }
 
+// Link calls os.Link.
+func (Local) Link(ctx context.Context, oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+// SameFile calls os.SameFile.
+func (Local) SameFile(fi1, fi2 os.FileInfo) bool {
+	return os.SameFile(fi1, fi2)
+}
+
 // Chmod calls os.Chmod.
 func (Local) Chmod(ctx context.Context, path string, mode os.FileMode) error {
 	return os.Chmod(path, mode)
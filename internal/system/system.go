@@ -42,11 +42,20 @@ type FS interface {
 	Remove(ctx context.Context, path string) error
 	Symlink(ctx context.Context, oldname, newname string) error
 	Readlink(ctx context.Context, path string) (string, error)
+	Link(ctx context.Context, oldname, newname string) error
 
 	Chmod(ctx context.Context, path string, mode os.FileMode) error
 	Chown(ctx context.Context, path string, uid UID, gid GID) error
 	OwnerInfo(info os.FileInfo) (UID, GID, error)
 
+	// SameFile reports whether fi1 and fi2 describe the same file.
+	// The arguments must have been returned by this FS's Lstat method.
+	SameFile(fi1, fi2 os.FileInfo) bool
+
+	// Inode returns the inode number of the file that info describes.
+	// The argument must have been returned by this FS's Lstat method.
+	Inode(info os.FileInfo) (uint64, error)
+
 	// CreateFile creates the named file, returning an error if it already exists.
 	CreateFile(ctx context.Context, path string, mode os.FileMode) (FileWriter, error)
 
@@ -162,6 +171,10 @@ func (Stub) Readlink(ctx context.Context, path string) (string, error) {
 	return "", &os.PathError{Op: "readlink", Path: path, Err: errNotImplemented}
 }
 
+func (Stub) Link(ctx context.Context, oldname, newname string) error {
+	return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: errNotImplemented}
+}
+
 func (Stub) Chmod(ctx context.Context, path string, mode os.FileMode) error {
 	return &os.PathError{Op: "chmod", Path: path, Err: errNotImplemented}
 }
@@ -174,6 +187,14 @@ func (Stub) OwnerInfo(os.FileInfo) (UID, GID, error) {
 	return 0, 0, errNotImplemented
 }
 
+func (Stub) SameFile(fi1, fi2 os.FileInfo) bool {
+	return false
+}
+
+func (Stub) Inode(os.FileInfo) (uint64, error) {
+	return 0, errNotImplemented
+}
+
 func (Stub) CreateFile(ctx context.Context, path string, mode os.FileMode) (FileWriter, error) {
 	return nil, &os.PathError{Op: "open", Path: path, Err: errNotImplemented}
 }
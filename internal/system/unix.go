@@ -33,3 +33,12 @@ func (Local) OwnerInfo(info os.FileInfo) (UID, GID, error) {
 	}
 	return UID(st.Uid), GID(st.Gid), nil
 }
+
+// Inode attempts to retrieve a file's inode number from info.Sys().
+func (Local) Inode(info os.FileInfo) (uint64, error) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, errors.New("file info has no inode field")
+	}
+	return uint64(st.Ino), nil
+}
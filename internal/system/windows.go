@@ -28,3 +28,8 @@ const LocalRoot = "C:\\"
 func (Local) OwnerInfo(os.FileInfo) (UID, GID, error) {
 	return 0, 0, errors.New("uid/gid not supported on windows")
 }
+
+// Inode returns an error, since os.FileInfo has no file index on Windows.
+func (Local) Inode(os.FileInfo) (uint64, error) {
+	return 0, errors.New("inode not supported on windows")
+}
@@ -0,0 +1,117 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/zombiezen/mcm/internal/catalogio"
+	"github.com/zombiezen/mcm/internal/version"
+	"github.com/zombiezen/mcm/lint/lintlib"
+)
+
+func init() {
+	flag.Usage = usage
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [-W] [-json] [-imports] [CATALOG]\n", filepath.Base(os.Args[0]))
+	flag.PrintDefaults()
+}
+
+func main() {
+	opts := new(lintlib.Options)
+	flag.BoolVar(&opts.Warnings, "W", false, "also report warnings")
+	flag.BoolVar(&opts.Imports, "imports", false, "allow dependencies on imports, for catalogs that will be merged")
+	jsonMode := flag.Bool("json", false, "write problems as a JSON array")
+	readOpts := catalogio.Flags(flag.CommandLine)
+	versionMode := flag.Bool("version", false, "display version info")
+	flag.Parse()
+	if *versionMode {
+		version.Show()
+		return
+	}
+
+	var path string
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		path = flag.Arg(0)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	cat, err := catalogio.ReadFile(path, readOpts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "mcm-lint:", err)
+		os.Exit(1)
+	}
+	problems := lintlib.Check(cat, opts)
+	if *jsonMode {
+		err = writeJSON(os.Stdout, problems)
+	} else {
+		err = writeText(os.Stdout, problems)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "mcm-lint:", err)
+		os.Exit(1)
+	}
+	if lintlib.HasErrors(problems) {
+		os.Exit(1)
+	}
+}
+
+func writeText(w io.Writer, problems []*lintlib.Problem) error {
+	for _, p := range problems {
+		if _, err := fmt.Fprintln(w, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonProblem is the JSON form of a lintlib.Problem.
+type jsonProblem struct {
+	ID       uint64 `json:"id,string,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+	Severity string `json:"severity"`
+	Check    string `json:"check"`
+	Message  string `json:"message"`
+}
+
+func writeJSON(w io.Writer, problems []*lintlib.Problem) error {
+	out := make([]jsonProblem, len(problems))
+	for i, p := range problems {
+		out[i] = jsonProblem{
+			ID:       p.ResourceID,
+			Comment:  p.ResourceComment,
+			Severity: p.Severity.String(),
+			Check:    p.Check,
+			Message:  p.Message,
+		}
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = w.Write(data)
+	return err
+}
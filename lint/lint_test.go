@@ -0,0 +1,59 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/zombiezen/mcm/lint/lintlib"
+)
+
+func TestWriteJSON(t *testing.T) {
+	problems := []*lintlib.Problem{
+		{
+			ResourceID:      18446744073709551615,
+			ResourceComment: "big",
+			Severity:        lintlib.Error,
+			Check:           "deps",
+			Message:         "oops",
+		},
+		{
+			Severity: lintlib.Warning,
+			Check:    "catalog",
+			Message:  "whole catalog",
+		},
+	}
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, problems); err != nil {
+		t.Fatal("writeJSON:", err)
+	}
+	var got []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output %q is not JSON: %v", buf.Bytes(), err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("output has %d problems; want 2", len(got))
+	}
+	// IDs are strings so that readers that store numbers as floats
+	// don't round them.
+	if id, ok := got[0]["id"].(string); !ok || id != "18446744073709551615" {
+		t.Errorf("problem 0 id = %#v; want \"18446744073709551615\"", got[0]["id"])
+	}
+	if id, ok := got[1]["id"]; ok {
+		t.Errorf("problem 1 id = %#v; want no id", id)
+	}
+}
@@ -0,0 +1,591 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lintlib checks catalogs for problems that would otherwise
+// only be found when the catalog is applied.
+package lintlib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zombiezen/mcm/catalog"
+)
+
+// Severity indicates how serious a problem is.
+type Severity int
+
+// Severities.
+const (
+	// Error is a problem that will cause applying the catalog to fail,
+	// or will make mcm-exec and mcm-shellify disagree.
+	Error Severity = iota
+	// Warning is a suspicious construct that applies successfully.
+	Warning
+)
+
+// String returns "error" or "warning".
+func (sev Severity) String() string {
+	switch sev {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(sev))
+	}
+}
+
+// Check names.  These are stable, so they can be used to filter the
+// output of mcm-lint.
+const (
+	CheckMalformed        = "malformed"
+	CheckZeroID           = "zero-id"
+	CheckDuplicateID      = "duplicate-id"
+	CheckUnknownDep       = "unknown-dependency"
+	CheckCycle            = "dependency-cycle"
+	CheckPath             = "relative-path"
+	CheckEmpty            = "empty-field"
+	CheckMode             = "invalid-mode"
+	CheckEnv              = "invalid-environment"
+	CheckDepsChanged      = "deps-changed-not-dependency"
+	CheckPathConflict     = "path-conflict"
+	CheckExport           = "invalid-export"
+	CheckImport           = "invalid-import"
+	CheckDigest           = "invalid-digest"
+	CheckUnconditionalRun = "unconditional-exec"
+)
+
+// A Problem is a single issue found in a catalog.
+type Problem struct {
+	// ResourceID and ResourceComment identify the resource that has
+	// the problem.  ResourceID is zero if the problem is with the
+	// catalog as a whole.
+	ResourceID      uint64
+	ResourceComment string
+
+	Severity Severity
+	Check    string
+	Message  string
+
+	index int // of resource in catalog, for sorting
+}
+
+// String formats the problem on a single line.
+func (p *Problem) String() string {
+	switch {
+	case p.ResourceID == 0:
+		return fmt.Sprintf("%v: %s [%s]", p.Severity, p.Message, p.Check)
+	case p.ResourceComment == "":
+		return fmt.Sprintf("%v: id=%d: %s [%s]", p.Severity, p.ResourceID, p.Message, p.Check)
+	default:
+		return fmt.Sprintf("%v: %s (id=%d): %s [%s]", p.Severity, p.ResourceComment, p.ResourceID, p.Message, p.Check)
+	}
+}
+
+// Options controls which checks are run.
+type Options struct {
+	// Warnings enables checks for problems with Warning severity.
+	Warnings bool
+
+	// Imports allows resources to depend on the catalog's imports, for
+	// checking a catalog before it is merged with other catalogs.
+	Imports bool
+}
+
+// HasErrors reports whether any of the problems has Error severity.
+func HasErrors(problems []*Problem) bool {
+	for _, p := range problems {
+		if p.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
+
+// Check runs all the checks on c and returns the problems found,
+// ordered by the position of the resource in the catalog.  opts may be
+// nil, which is the same as a zero Options.
+func Check(c catalog.Catalog, opts *Options) []*Problem {
+	if opts == nil {
+		opts = new(Options)
+	}
+	l := &linter{
+		opts:    opts,
+		index:   make(map[uint64]int),
+		imports: make(map[uint64]string),
+	}
+	res, err := c.Resources()
+	if err != nil {
+		l.catalogf(CheckMalformed, "read resources: %v", err)
+		return l.problems
+	}
+	l.res = res
+	for i := 0; i < res.Len(); i++ {
+		r := res.At(i)
+		id := r.ID()
+		if id == 0 {
+			l.errorf(i, CheckZeroID, "resource ID is zero")
+			continue
+		}
+		if prev, dup := l.index[id]; dup {
+			l.errorf(i, CheckDuplicateID, "ID is also used by %s", l.describe(prev))
+			continue
+		}
+		l.index[id] = i
+	}
+	l.names(c)
+	for i := 0; i < res.Len(); i++ {
+		l.resource(i)
+	}
+	l.cycles()
+	l.pathConflicts()
+	sort.SliceStable(l.problems, func(i, j int) bool {
+		return l.problems[i].index < l.problems[j].index
+	})
+	return l.problems
+}
+
+type linter struct {
+	opts     *Options
+	res      catalog.Resource_List
+	index    map[uint64]int
+	imports  map[uint64]string // placeholder ID -> name
+	deps     [][]uint64
+	problems []*Problem
+}
+
+func (l *linter) add(i int, sev Severity, check, format string, args ...interface{}) {
+	p := &Problem{
+		Severity: sev,
+		Check:    check,
+		Message:  fmt.Sprintf(format, args...),
+		index:    i,
+	}
+	if i >= 0 {
+		r := l.res.At(i)
+		p.ResourceID = r.ID()
+		p.ResourceComment, _ = r.Comment()
+	}
+	l.problems = append(l.problems, p)
+}
+
+func (l *linter) errorf(i int, check, format string, args ...interface{}) {
+	l.add(i, Error, check, format, args...)
+}
+
+func (l *linter) warnf(i int, check, format string, args ...interface{}) {
+	if l.opts.Warnings {
+		l.add(i, Warning, check, format, args...)
+	}
+}
+
+func (l *linter) catalogf(check, format string, args ...interface{}) {
+	l.add(-1, Error, check, format, args...)
+}
+
+// describe returns a short description of the i'th resource for use
+// in messages about other resources.
+func (l *linter) describe(i int) string {
+	r := l.res.At(i)
+	if c, _ := r.Comment(); c != "" {
+		return fmt.Sprintf("%s (id=%d)", c, r.ID())
+	}
+	return fmt.Sprintf("id=%d", r.ID())
+}
+
+// names checks the catalog's exports and imports.
+func (l *linter) names(c catalog.Catalog) {
+	exports, err := c.Exports()
+	if err != nil {
+		l.catalogf(CheckMalformed, "read exports: %v", err)
+		return
+	}
+	seen := make(map[string]bool, exports.Len())
+	for i := 0; i < exports.Len(); i++ {
+		e := exports.At(i)
+		name, err := e.Name()
+		if err != nil {
+			l.catalogf(CheckMalformed, "read export name: %v", err)
+			continue
+		}
+		switch {
+		case name == "":
+			l.catalogf(CheckExport, "export of ID %d has an empty name", e.ID())
+		case seen[name]:
+			l.catalogf(CheckExport, "export %q is listed more than once", name)
+		}
+		seen[name] = true
+		if _, ok := l.index[e.ID()]; !ok {
+			l.catalogf(CheckExport, "export %q names unknown resource ID %d", name, e.ID())
+		}
+	}
+
+	imports, err := c.Imports()
+	if err != nil {
+		l.catalogf(CheckMalformed, "read imports: %v", err)
+		return
+	}
+	seen = make(map[string]bool, imports.Len())
+	for i := 0; i < imports.Len(); i++ {
+		imp := imports.At(i)
+		name, err := imp.Name()
+		if err != nil {
+			l.catalogf(CheckMalformed, "read import name: %v", err)
+			continue
+		}
+		id := imp.ID()
+		switch {
+		case id == 0:
+			l.catalogf(CheckImport, "import %q has ID zero", name)
+		case name == "":
+			l.catalogf(CheckImport, "import with ID %d has an empty name", id)
+		case seen[name]:
+			l.catalogf(CheckImport, "import %q is listed more than once", name)
+		}
+		seen[name] = true
+		if ri, ok := l.index[id]; ok {
+			l.catalogf(CheckImport, "import %q has the same ID as %s", name, l.describe(ri))
+			continue
+		}
+		if prev, dup := l.imports[id]; dup {
+			l.catalogf(CheckImport, "imports %q and %q have the same ID", prev, name)
+			continue
+		}
+		l.imports[id] = name
+	}
+}
+
+func (l *linter) resource(i int) {
+	r := l.res.At(i)
+	depList, err := r.Dependencies()
+	if err != nil {
+		l.errorf(i, CheckMalformed, "read dependencies: %v", err)
+	}
+	deps := make([]uint64, depList.Len())
+	for j := range deps {
+		deps[j] = depList.At(j)
+		if _, ok := l.index[deps[j]]; ok {
+			continue
+		}
+		if name, ok := l.imports[deps[j]]; !ok {
+			l.errorf(i, CheckUnknownDep, "depends on unknown resource ID %d", deps[j])
+		} else if !l.opts.Imports {
+			l.errorf(i, CheckUnknownDep, "depends on import %q, which must be merged with a catalog that exports it", name)
+		}
+	}
+	if l.deps == nil {
+		l.deps = make([][]uint64, l.res.Len())
+	}
+	l.deps[i] = deps
+	l.locks(i, r)
+
+	switch r.Which() {
+	case catalog.Resource_Which_noop:
+	case catalog.Resource_Which_file:
+		f, err := r.File()
+		if err != nil {
+			l.errorf(i, CheckMalformed, "read file: %v", err)
+			return
+		}
+		l.file(i, f)
+	case catalog.Resource_Which_exec:
+		e, err := r.Exec()
+		if err != nil {
+			l.errorf(i, CheckMalformed, "read exec: %v", err)
+			return
+		}
+		l.exec(i, e, deps)
+	default:
+		l.errorf(i, CheckMalformed, "unknown resource type %v", r.Which())
+	}
+}
+
+func (l *linter) locks(i int, r catalog.Resource) {
+	locks, err := r.Locks()
+	if err != nil {
+		l.errorf(i, CheckMalformed, "read locks: %v", err)
+		return
+	}
+	for j := 0; j < locks.Len(); j++ {
+		name, err := locks.At(j)
+		if err != nil {
+			l.errorf(i, CheckMalformed, "read lock name: %v", err)
+			continue
+		}
+		if name == "" {
+			l.errorf(i, CheckEmpty, "lock name is empty")
+		}
+	}
+}
+
+func (l *linter) file(i int, f catalog.File) {
+	path, err := f.Path()
+	if err != nil {
+		l.errorf(i, CheckMalformed, "read file path: %v", err)
+		return
+	}
+	l.absPath(i, "file path", path)
+	switch f.Which() {
+	case catalog.File_Which_plain:
+		if mode, err := f.Plain().Mode(); err != nil {
+			l.errorf(i, CheckMalformed, "read mode: %v", err)
+		} else {
+			l.mode(i, mode)
+		}
+		l.contentDigest(i, f.Plain())
+	case catalog.File_Which_directory:
+		if mode, err := f.Directory().Mode(); err != nil {
+			l.errorf(i, CheckMalformed, "read mode: %v", err)
+		} else {
+			l.mode(i, mode)
+		}
+	case catalog.File_Which_symlink:
+		target, err := f.Symlink().Target()
+		if err != nil {
+			l.errorf(i, CheckMalformed, "read symlink target: %v", err)
+		} else if target == "" {
+			l.errorf(i, CheckEmpty, "symlink target is empty")
+		}
+	case catalog.File_Which_hardlink:
+		target, err := f.Hardlink().Target()
+		if err != nil {
+			l.errorf(i, CheckMalformed, "read hard link target: %v", err)
+		} else {
+			l.absPath(i, "hard link target", target)
+		}
+	case catalog.File_Which_absent:
+	default:
+		l.errorf(i, CheckMalformed, "unknown file type %v", f.Which())
+	}
+}
+
+// contentDigest checks that a plain file's content digest is the right
+// size and, if the content is also given, that it matches.
+func (l *linter) contentDigest(i int, p catalog.File_plain) {
+	if !p.HasContentSHA256() {
+		return
+	}
+	digest, err := p.ContentSHA256()
+	if err != nil {
+		l.errorf(i, CheckMalformed, "read content digest: %v", err)
+		return
+	}
+	if len(digest) != sha256.Size {
+		l.errorf(i, CheckDigest, "content digest is %d bytes; SHA-256 digests are %d bytes", len(digest), sha256.Size)
+		return
+	}
+	if !p.HasContent() {
+		return
+	}
+	content, err := p.Content()
+	if err != nil {
+		l.errorf(i, CheckMalformed, "read content: %v", err)
+		return
+	}
+	if sum := sha256.Sum256(content); !bytes.Equal(sum[:], digest) {
+		l.errorf(i, CheckDigest, "content does not match content digest")
+	}
+}
+
+func (l *linter) mode(i int, mode catalog.File_Mode) {
+	const validBits = catalog.File_Mode_permMask | catalog.File_Mode_sticky | catalog.File_Mode_setuid | catalog.File_Mode_setgid
+	if bits := mode.Bits(); bits != catalog.File_Mode_unset && bits&^validBits != 0 {
+		l.errorf(i, CheckMode, "mode bits %#o has unknown bits set", bits)
+	}
+	if user, err := mode.User(); err != nil {
+		l.errorf(i, CheckMalformed, "read mode user: %v", err)
+	} else if user.Which() == catalog.UserRef_Which_ID && user.ID() < -1 {
+		l.errorf(i, CheckMode, "invalid uid %d", user.ID())
+	} else if name, _ := user.Name(); user.Which() == catalog.UserRef_Which_name && name == "" {
+		l.errorf(i, CheckEmpty, "user name is empty")
+	}
+	if group, err := mode.Group(); err != nil {
+		l.errorf(i, CheckMalformed, "read mode group: %v", err)
+	} else if group.Which() == catalog.GroupRef_Which_ID && group.ID() < -1 {
+		l.errorf(i, CheckMode, "invalid gid %d", group.ID())
+	} else if name, _ := group.Name(); group.Which() == catalog.GroupRef_Which_name && name == "" {
+		l.errorf(i, CheckEmpty, "group name is empty")
+	}
+}
+
+func (l *linter) exec(i int, e catalog.Exec, deps []uint64) {
+	if cmd, err := e.Command(); err != nil {
+		l.errorf(i, CheckMalformed, "read command: %v", err)
+	} else {
+		l.command(i, "command", cmd)
+	}
+	cond := e.Condition()
+	switch cond.Which() {
+	case catalog.Exec_condition_Which_always:
+		l.warnf(i, CheckUnconditionalRun, "exec runs every time the catalog is applied; consider adding a condition")
+	case catalog.Exec_condition_Which_onlyIf:
+		if cmd, err := cond.OnlyIf(); err != nil {
+			l.errorf(i, CheckMalformed, "read onlyIf: %v", err)
+		} else {
+			l.command(i, "onlyIf", cmd)
+		}
+	case catalog.Exec_condition_Which_unless:
+		if cmd, err := cond.Unless(); err != nil {
+			l.errorf(i, CheckMalformed, "read unless: %v", err)
+		} else {
+			l.command(i, "unless", cmd)
+		}
+	case catalog.Exec_condition_Which_fileAbsent:
+		path, err := cond.FileAbsent()
+		if err != nil {
+			l.errorf(i, CheckMalformed, "read fileAbsent: %v", err)
+		} else {
+			l.absPath(i, "fileAbsent path", path)
+		}
+	case catalog.Exec_condition_Which_ifDepsChanged:
+		ids, err := cond.IfDepsChanged()
+		if err != nil {
+			l.errorf(i, CheckMalformed, "read ifDepsChanged: %v", err)
+			break
+		}
+		if ids.Len() == 0 {
+			l.errorf(i, CheckEmpty, "ifDepsChanged is empty")
+		}
+	ids:
+		for j := 0; j < ids.Len(); j++ {
+			id := ids.At(j)
+			for _, d := range deps {
+				if d == id {
+					continue ids
+				}
+			}
+			l.errorf(i, CheckDepsChanged, "ifDepsChanged lists ID %d, which is not in dependencies", id)
+		}
+	default:
+		l.errorf(i, CheckMalformed, "unknown exec condition %v", cond.Which())
+	}
+}
+
+func (l *linter) command(i int, name string, cmd catalog.Exec_Command) {
+	switch cmd.Which() {
+	case catalog.Exec_Command_Which_argv:
+		argv, err := cmd.Argv()
+		if err != nil {
+			l.errorf(i, CheckMalformed, "read %s argv: %v", name, err)
+			break
+		}
+		if argv.Len() == 0 {
+			l.errorf(i, CheckEmpty, "%s argv is empty", name)
+			break
+		}
+		if arg0, err := argv.At(0); err != nil {
+			l.errorf(i, CheckMalformed, "read %s argv[0]: %v", name, err)
+		} else {
+			l.absPath(i, name+" argv[0]", arg0)
+		}
+	case catalog.Exec_Command_Which_bash:
+	default:
+		l.errorf(i, CheckMalformed, "unknown %s type %v", name, cmd.Which())
+	}
+	env, err := cmd.Environment()
+	if err != nil {
+		l.errorf(i, CheckMalformed, "read %s environment: %v", name, err)
+	}
+	seen := make(map[string]bool, env.Len())
+	for j := 0; j < env.Len(); j++ {
+		k, err := env.At(j).Name()
+		switch {
+		case err != nil:
+			l.errorf(i, CheckMalformed, "read %s environment[%d]: %v", name, j, err)
+		case k == "":
+			l.errorf(i, CheckEnv, "%s environment[%d] has an empty name", name, j)
+		case strings.ContainsAny(k, "=\x00"):
+			l.errorf(i, CheckEnv, "%s environment variable %q has an invalid name", name, k)
+		case seen[k]:
+			l.warnf(i, CheckEnv, "%s environment variable %s is set more than once", name, k)
+		}
+		seen[k] = true
+	}
+	if dir, err := cmd.WorkingDirectory(); err != nil {
+		l.errorf(i, CheckMalformed, "read %s working directory: %v", name, err)
+	} else if dir != "" && !filepath.IsAbs(dir) {
+		l.errorf(i, CheckPath, "%s working directory %q is not an absolute path", name, dir)
+	}
+}
+
+// absPath checks that path is a non-empty absolute path.  what names
+// the field in messages.
+func (l *linter) absPath(i int, what, path string) {
+	if path == "" {
+		l.errorf(i, CheckEmpty, "%s is empty", what)
+	} else if !filepath.IsAbs(path) {
+		l.errorf(i, CheckPath, "%s %q is not an absolute path", what, path)
+	}
+}
+
+// cycles reports every resource that is part of a dependency cycle.
+// Resources that merely depend on a cycle are not reported.
+func (l *linter) cycles() {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	n := l.res.Len()
+	state := make([]int, n)
+	onCycle := make([]bool, n)
+	// Iterative depth-first search, marking every resource on the
+	// stack between a back edge's endpoints.
+	type frame struct {
+		i, next int
+	}
+	var stk []frame
+	for root := 0; root < n; root++ {
+		if state[root] != unvisited || l.index[l.res.At(root).ID()] != root {
+			continue
+		}
+		state[root] = visiting
+		stk = append(stk[:0], frame{i: root})
+		for len(stk) > 0 {
+			top := &stk[len(stk)-1]
+			if top.next >= len(l.deps[top.i]) {
+				state[top.i] = done
+				stk = stk[:len(stk)-1]
+				continue
+			}
+			d, ok := l.index[l.deps[top.i][top.next]]
+			top.next++
+			if !ok {
+				continue
+			}
+			switch state[d] {
+			case unvisited:
+				state[d] = visiting
+				stk = append(stk, frame{i: d})
+			case visiting:
+				for k := len(stk) - 1; k >= 0; k-- {
+					onCycle[stk[k].i] = true
+					if stk[k].i == d {
+						break
+					}
+				}
+			}
+		}
+	}
+	for i, c := range onCycle {
+		if c {
+			l.errorf(i, CheckCycle, "resource is part of a dependency cycle")
+		}
+	}
+}
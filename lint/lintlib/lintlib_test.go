@@ -0,0 +1,325 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lintlib
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/internal/catpogs"
+)
+
+type problemKey struct {
+	id    uint64
+	check string
+}
+
+func TestCheck(t *testing.T) {
+	argv := func(args ...string) *catpogs.Command {
+		return &catpogs.Command{Which: catalog.Exec_Command_Which_argv, Argv: args}
+	}
+	digestFile := func(path string, content, digest []byte) *catpogs.File {
+		f := catpogs.PlainFile(path, content)
+		f.Plain.ContentSHA256 = digest
+		return f
+	}
+	fooDigest := sha256.Sum256([]byte("foo"))
+	tests := []struct {
+		name      string
+		resources []*catpogs.Resource
+		warnings  bool
+		want      []problemKey
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "valid",
+			resources: []*catpogs.Resource{
+				{ID: 1, Which: catalog.Resource_Which_file, File: catpogs.PlainFile("/etc/foo", []byte("foo"))},
+				{ID: 2, Which: catalog.Resource_Which_file, File: catpogs.SymlinkFile("foo", "/etc/bar")},
+				{
+					ID:    3,
+					Deps:  []uint64{1, 2},
+					Which: catalog.Resource_Which_exec,
+					Exec: &catpogs.Exec{
+						Command: argv("/bin/true"),
+						Condition: catpogs.ExecCondition{
+							Which:         catalog.Exec_condition_Which_ifDepsChanged,
+							IfDepsChanged: []uint64{1},
+						},
+					},
+				},
+			},
+			warnings: true,
+		},
+		{
+			name: "ids",
+			resources: []*catpogs.Resource{
+				{ID: 0, Which: catalog.Resource_Which_noop},
+				{ID: 1, Which: catalog.Resource_Which_noop},
+				{ID: 1, Which: catalog.Resource_Which_noop},
+				{ID: 2, Deps: []uint64{3}, Which: catalog.Resource_Which_noop},
+			},
+			want: []problemKey{
+				{0, CheckZeroID},
+				{1, CheckDuplicateID},
+				{2, CheckUnknownDep},
+			},
+		},
+		{
+			name: "cycle",
+			resources: []*catpogs.Resource{
+				{ID: 1, Deps: []uint64{2}, Which: catalog.Resource_Which_noop},
+				{ID: 2, Deps: []uint64{3}, Which: catalog.Resource_Which_noop},
+				{ID: 3, Deps: []uint64{2}, Which: catalog.Resource_Which_noop},
+				{ID: 4, Deps: []uint64{4}, Which: catalog.Resource_Which_noop},
+			},
+			want: []problemKey{
+				{2, CheckCycle},
+				{3, CheckCycle},
+				{4, CheckCycle},
+			},
+		},
+		{
+			name: "files",
+			resources: []*catpogs.Resource{
+				{ID: 1, Which: catalog.Resource_Which_file, File: catpogs.PlainFile("etc/foo", nil)},
+				{ID: 2, Which: catalog.Resource_Which_file, File: catpogs.PlainFile("", nil)},
+				{ID: 3, Which: catalog.Resource_Which_file, File: catpogs.SymlinkFile("", "/foo")},
+				{ID: 4, Which: catalog.Resource_Which_file, File: catpogs.HardlinkFile("bar", "/bar")},
+				{ID: 5, Which: catalog.Resource_Which_file, File: catpogs.Directory("/dir", &catpogs.FileMode{Bits: 010755})},
+				{ID: 6, Which: catalog.Resource_Which_file, File: catpogs.Directory("/dir2", &catpogs.FileMode{Bits: catpogs.ModeUnset, User: catpogs.UserIDRef(-2), Group: catpogs.GroupNameRef("")})},
+				{ID: 7, Which: catalog.Resource_Which_file, File: catpogs.PlainFile("/dir/", nil)},
+			},
+			want: []problemKey{
+				{1, CheckPath},
+				{2, CheckEmpty},
+				{3, CheckEmpty},
+				{4, CheckPath},
+				{5, CheckMode},
+				{6, CheckMode},
+				{6, CheckEmpty},
+				{7, CheckPathConflict},
+			},
+		},
+		{
+			name: "locks",
+			resources: []*catpogs.Resource{
+				{ID: 1, Locks: []string{"apt"}, Which: catalog.Resource_Which_noop},
+				{ID: 2, Locks: []string{"apt", ""}, Which: catalog.Resource_Which_noop},
+			},
+			want: []problemKey{
+				{2, CheckEmpty},
+			},
+		},
+		{
+			name: "digests",
+			resources: []*catpogs.Resource{
+				{ID: 1, Which: catalog.Resource_Which_file, File: digestFile("/foo1", nil, fooDigest[:])},
+				{ID: 2, Which: catalog.Resource_Which_file, File: digestFile("/foo2", []byte("foo"), fooDigest[:])},
+				{ID: 3, Which: catalog.Resource_Which_file, File: digestFile("/foo3", []byte("bar"), fooDigest[:])},
+				{ID: 4, Which: catalog.Resource_Which_file, File: digestFile("/foo4", nil, fooDigest[:4])},
+			},
+			want: []problemKey{
+				{3, CheckDigest},
+				{4, CheckDigest},
+			},
+		},
+		{
+			name: "exec",
+			resources: []*catpogs.Resource{
+				{ID: 1, Which: catalog.Resource_Which_exec, Exec: &catpogs.Exec{Command: argv()}},
+				{ID: 2, Which: catalog.Resource_Which_exec, Exec: &catpogs.Exec{Command: argv("true")}},
+				{
+					ID:    3,
+					Which: catalog.Resource_Which_exec,
+					Exec: &catpogs.Exec{
+						Command: &catpogs.Command{
+							Which: catalog.Exec_Command_Which_bash,
+							Bash:  "true",
+							Env:   []catpogs.EnvVar{{Name: ""}, {Name: "A=B"}, {Name: "X"}, {Name: "X"}},
+							Dir:   "tmp",
+						},
+						Condition: catpogs.ExecCondition{
+							Which:  catalog.Exec_condition_Which_unless,
+							Unless: argv("test"),
+						},
+					},
+				},
+				{
+					ID:    4,
+					Deps:  []uint64{1},
+					Which: catalog.Resource_Which_exec,
+					Exec: &catpogs.Exec{
+						Command: argv("/bin/true"),
+						Condition: catpogs.ExecCondition{
+							Which:         catalog.Exec_condition_Which_ifDepsChanged,
+							IfDepsChanged: []uint64{1, 2},
+						},
+					},
+				},
+				{
+					ID:    5,
+					Which: catalog.Resource_Which_exec,
+					Exec: &catpogs.Exec{
+						Command: argv("/bin/true"),
+						Condition: catpogs.ExecCondition{
+							Which:      catalog.Exec_condition_Which_fileAbsent,
+							FileAbsent: "foo",
+						},
+					},
+				},
+				{
+					ID:    6,
+					Which: catalog.Resource_Which_exec,
+					Exec: &catpogs.Exec{
+						Command: argv("/bin/true"),
+						Condition: catpogs.ExecCondition{
+							Which:         catalog.Exec_condition_Which_ifDepsChanged,
+							IfDepsChanged: []uint64{},
+						},
+					},
+				},
+			},
+			warnings: true,
+			want: []problemKey{
+				{1, CheckEmpty},
+				{1, CheckUnconditionalRun},
+				{2, CheckPath},
+				{2, CheckUnconditionalRun},
+				{3, CheckEnv},
+				{3, CheckEnv},
+				{3, CheckEnv},
+				{3, CheckPath},
+				{3, CheckPath},
+				{4, CheckDepsChanged},
+				{5, CheckPath},
+				{6, CheckEmpty},
+			},
+		},
+		{
+			name: "no warnings",
+			resources: []*catpogs.Resource{
+				{ID: 1, Which: catalog.Resource_Which_exec, Exec: &catpogs.Exec{Command: argv("/bin/true")}},
+			},
+		},
+	}
+	for _, test := range tests {
+		c, err := (&catpogs.Catalog{Resources: test.resources}).ToCapnp()
+		if err != nil {
+			t.Errorf("%s: build catalog: %v", test.name, err)
+			continue
+		}
+		problems := Check(c, &Options{Warnings: test.warnings})
+		got := make([]problemKey, len(problems))
+		for i, p := range problems {
+			got[i] = problemKey{p.ResourceID, p.Check}
+		}
+		if !equalKeys(got, test.want) {
+			t.Errorf("%s: Check(...) = %v; want %v", test.name, problems, test.want)
+		}
+		if hasErrs := HasErrors(problems); hasErrs != (len(test.want) > 0) {
+			t.Errorf("%s: HasErrors(...) = %t", test.name, hasErrs)
+		}
+	}
+}
+
+func TestCheckNames(t *testing.T) {
+	c, err := (&catpogs.Catalog{
+		Resources: []*catpogs.Resource{
+			{ID: 1, Which: catalog.Resource_Which_noop},
+			{ID: 2, Deps: []uint64{100}, Which: catalog.Resource_Which_noop},
+		},
+		Exports: []catpogs.ResourceName{
+			{Name: "one", ID: 1},
+			{Name: "one", ID: 1},
+			{Name: "", ID: 2},
+			{Name: "three", ID: 3},
+		},
+		Imports: []catpogs.ResourceName{
+			{Name: "base", ID: 100},
+			{Name: "self", ID: 1},
+			{Name: "again", ID: 100},
+			{Name: "zero", ID: 0},
+		},
+	}).ToCapnp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalogProblems := []problemKey{
+		{0, CheckExport},
+		{0, CheckExport},
+		{0, CheckExport},
+		{0, CheckImport},
+		{0, CheckImport},
+		{0, CheckImport},
+	}
+	tests := []struct {
+		imports bool
+		want    []problemKey
+	}{
+		{false, append(catalogProblems[:len(catalogProblems):len(catalogProblems)], problemKey{2, CheckUnknownDep})},
+		{true, catalogProblems},
+	}
+	for _, test := range tests {
+		problems := Check(c, &Options{Imports: test.imports})
+		got := make([]problemKey, len(problems))
+		for i, p := range problems {
+			got[i] = problemKey{p.ResourceID, p.Check}
+		}
+		if !equalKeys(got, test.want) {
+			t.Errorf("Check(..., Imports: %t) = %v; want %v", test.imports, problems, test.want)
+		}
+	}
+}
+
+func equalKeys(a, b []problemKey) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestProblemString(t *testing.T) {
+	tests := []struct {
+		p    *Problem
+		want string
+	}{
+		{
+			&Problem{Severity: Error, Check: CheckMalformed, Message: "bad"},
+			"error: bad [malformed]",
+		},
+		{
+			&Problem{ResourceID: 42, Severity: Warning, Check: CheckUnconditionalRun, Message: "always runs"},
+			"warning: id=42: always runs [unconditional-exec]",
+		},
+		{
+			&Problem{ResourceID: 42, ResourceComment: "foo", Severity: Error, Check: CheckPath, Message: "relative"},
+			"error: foo (id=42): relative [relative-path]",
+		},
+	}
+	for _, test := range tests {
+		if got := test.p.String(); got != test.want {
+			t.Errorf("%+v.String() = %q; want %q", test.p, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lintlib
+
+import (
+	"path/filepath"
+
+	"github.com/zombiezen/mcm/catalog"
+)
+
+// A pathClaim is a resource's use of a path: either a file resource
+// that manages it or an exec whose fileAbsent condition tests it.
+type pathClaim struct {
+	i    int
+	path string
+	// kind is the file type for a file resource.  For an exec
+	// condition, cond is true and kind is meaningless.
+	kind catalog.File_Which
+	cond bool
+}
+
+func (c pathClaim) String() string {
+	if c.cond {
+		return "tests for"
+	}
+	switch c.kind {
+	case catalog.File_Which_plain:
+		return "manages the file"
+	case catalog.File_Which_directory:
+		return "manages the directory"
+	case catalog.File_Which_symlink:
+		return "manages the symlink"
+	case catalog.File_Which_hardlink:
+		return "manages the hard link"
+	case catalog.File_Which_absent:
+		return "removes"
+	default:
+		return "manages"
+	}
+}
+
+// canContain reports whether the path claimed by c can have other
+// managed paths under it.  Symlinks can, since they may point to a
+// directory.
+func (c pathClaim) canContain() bool {
+	return c.cond || c.kind == catalog.File_Which_directory || c.kind == catalog.File_Which_symlink
+}
+
+// pathConflicts reports pairs of resources that use the same path, or
+// where one manages a path under a path that the other removes or
+// makes into a non-directory, unless one resource depends on the other
+// (directly or transitively).  Without an ordering between them, which
+// resource wins depends on scheduling.  Two exec conditions that test
+// the same path do not conflict.
+func (l *linter) pathConflicts() {
+	var claims []pathClaim
+	byPath := make(map[string][]int) // path -> indices into claims
+	for i := 0; i < l.res.Len(); i++ {
+		c, ok := l.pathClaim(i)
+		if !ok {
+			continue
+		}
+		byPath[c.path] = append(byPath[c.path], len(claims))
+		claims = append(claims, c)
+	}
+	reported := make(map[[2]int]bool)
+	report := func(c, other pathClaim, format string, args ...interface{}) {
+		key := [2]int{other.i, c.i}
+		if c.i < other.i {
+			key = [2]int{c.i, other.i}
+		}
+		if c.i == other.i || reported[key] || l.ordered(c.i, other.i) {
+			return
+		}
+		reported[key] = true
+		l.errorf(c.i, CheckPathConflict, format, args...)
+	}
+	for _, c := range claims {
+		for _, j := range byPath[c.path] {
+			other := claims[j]
+			if other.i >= c.i || c.cond && other.cond {
+				continue
+			}
+			report(c, other, "%s %s, and %s %s it; neither depends on the other", c, c.path, l.describe(other.i), other)
+		}
+		for dir := filepath.Dir(c.path); dir != c.path; dir = filepath.Dir(dir) {
+			for _, j := range byPath[dir] {
+				parent := claims[j]
+				if parent.canContain() {
+					continue
+				}
+				report(c, parent, "%s %s, but %s %s %s; neither depends on the other", c, c.path, l.describe(parent.i), parent, parent.path)
+			}
+			if dir == "/" {
+				break
+			}
+		}
+	}
+}
+
+// pathClaim returns the path claimed by the i'th resource, if any.
+// Resources with missing or relative paths are reported elsewhere, so
+// they are skipped here.
+func (l *linter) pathClaim(i int) (pathClaim, bool) {
+	r := l.res.At(i)
+	var c pathClaim
+	switch r.Which() {
+	case catalog.Resource_Which_file:
+		f, err := r.File()
+		if err != nil {
+			return pathClaim{}, false
+		}
+		c.path, err = f.Path()
+		if err != nil {
+			return pathClaim{}, false
+		}
+		c.kind = f.Which()
+	case catalog.Resource_Which_exec:
+		e, err := r.Exec()
+		if err != nil {
+			return pathClaim{}, false
+		}
+		cond := e.Condition()
+		if cond.Which() != catalog.Exec_condition_Which_fileAbsent {
+			return pathClaim{}, false
+		}
+		c.path, err = cond.FileAbsent()
+		if err != nil {
+			return pathClaim{}, false
+		}
+		c.cond = true
+	default:
+		return pathClaim{}, false
+	}
+	if !filepath.IsAbs(c.path) {
+		return pathClaim{}, false
+	}
+	c.i = i
+	c.path = filepath.Clean(c.path)
+	return c, true
+}
+
+// ordered reports whether either of the i'th or j'th resources
+// transitively depends on the other.
+func (l *linter) ordered(i, j int) bool {
+	return l.dependsOn(i, j) || l.dependsOn(j, i)
+}
+
+// dependsOn reports whether the i'th resource transitively depends on
+// the j'th resource.
+func (l *linter) dependsOn(i, j int) bool {
+	seen := make(map[int]bool)
+	stk := []int{i}
+	for len(stk) > 0 {
+		k := stk[len(stk)-1]
+		stk = stk[:len(stk)-1]
+		for _, id := range l.deps[k] {
+			d, ok := l.index[id]
+			if !ok || seen[d] {
+				continue
+			}
+			if d == j {
+				return true
+			}
+			seen[d] = true
+			stk = append(stk, d)
+		}
+	}
+	return false
+}
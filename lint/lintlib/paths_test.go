@@ -0,0 +1,173 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lintlib
+
+import (
+	"testing"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/internal/catpogs"
+)
+
+func TestPathConflicts(t *testing.T) {
+	file := func(id uint64, f *catpogs.File, deps ...uint64) *catpogs.Resource {
+		return &catpogs.Resource{ID: id, Deps: deps, Which: catalog.Resource_Which_file, File: f}
+	}
+	absent := func(path string) *catpogs.File {
+		return &catpogs.File{Path: path, Which: catalog.File_Which_absent}
+	}
+	testAbsent := func(id uint64, path string, deps ...uint64) *catpogs.Resource {
+		return &catpogs.Resource{
+			ID:    id,
+			Deps:  deps,
+			Which: catalog.Resource_Which_exec,
+			Exec: &catpogs.Exec{
+				Command: &catpogs.Command{Which: catalog.Exec_Command_Which_argv, Argv: []string{"/bin/true"}},
+				Condition: catpogs.ExecCondition{
+					Which:      catalog.Exec_condition_Which_fileAbsent,
+					FileAbsent: path,
+				},
+			},
+		}
+	}
+	tests := []struct {
+		name      string
+		resources []*catpogs.Resource
+		want      []uint64 // IDs with path conflicts
+	}{
+		{
+			name: "plain and absent",
+			resources: []*catpogs.Resource{
+				file(1, catpogs.PlainFile("/etc/hosts", nil)),
+				file(2, absent("/etc/hosts")),
+			},
+			want: []uint64{2},
+		},
+		{
+			name: "unclean path",
+			resources: []*catpogs.Resource{
+				file(1, catpogs.PlainFile("/etc/hosts", nil)),
+				file(2, catpogs.PlainFile("/etc/../etc//hosts", nil)),
+			},
+			want: []uint64{2},
+		},
+		{
+			name: "direct dependency",
+			resources: []*catpogs.Resource{
+				file(1, catpogs.PlainFile("/etc/hosts", nil), 2),
+				file(2, absent("/etc/hosts")),
+			},
+		},
+		{
+			name: "transitive dependency",
+			resources: []*catpogs.Resource{
+				file(1, catpogs.PlainFile("/etc/hosts", nil)),
+				{ID: 2, Deps: []uint64{1}, Which: catalog.Resource_Which_noop},
+				file(3, absent("/etc/hosts"), 2),
+			},
+		},
+		{
+			name: "three way",
+			resources: []*catpogs.Resource{
+				file(1, catpogs.PlainFile("/etc/hosts", nil)),
+				file(2, absent("/etc/hosts"), 1),
+				file(3, catpogs.SymlinkFile("/etc/hosts.real", "/etc/hosts")),
+			},
+			want: []uint64{3, 3},
+		},
+		{
+			name: "nested under absent",
+			resources: []*catpogs.Resource{
+				file(1, absent("/opt/foo")),
+				file(2, catpogs.PlainFile("/opt/foo/bar/baz.conf", nil)),
+			},
+			want: []uint64{2},
+		},
+		{
+			name: "nested under plain file",
+			resources: []*catpogs.Resource{
+				file(1, catpogs.PlainFile("/opt/foo/bar", nil)),
+				file(2, catpogs.PlainFile("/opt/foo", nil)),
+			},
+			want: []uint64{1},
+		},
+		{
+			name: "nested under directory",
+			resources: []*catpogs.Resource{
+				file(1, catpogs.Directory("/opt/foo", nil)),
+				file(2, catpogs.PlainFile("/opt/foo/bar", nil)),
+				file(3, catpogs.SymlinkFile("/srv", "/opt/srv")),
+				file(4, catpogs.PlainFile("/opt/srv/index.html", nil)),
+			},
+		},
+		{
+			name: "nested under ordered absent",
+			resources: []*catpogs.Resource{
+				file(1, absent("/opt/foo")),
+				file(2, catpogs.PlainFile("/opt/foo/bar", nil), 1),
+			},
+		},
+		{
+			name: "fileAbsent condition",
+			resources: []*catpogs.Resource{
+				testAbsent(1, "/opt/foo"),
+				file(2, catpogs.Directory("/opt/foo", nil)),
+				testAbsent(3, "/opt/foo/"),
+				file(4, catpogs.PlainFile("/opt/foo", nil), 1, 3),
+			},
+			want: []uint64{2, 3, 4},
+		},
+	}
+	for _, test := range tests {
+		c, err := (&catpogs.Catalog{Resources: test.resources}).ToCapnp()
+		if err != nil {
+			t.Errorf("%s: build catalog: %v", test.name, err)
+			continue
+		}
+		var got []uint64
+		for _, p := range Check(c, nil) {
+			if p.Check != CheckPathConflict {
+				t.Errorf("%s: unexpected problem: %v", test.name, p)
+				continue
+			}
+			got = append(got, p.ResourceID)
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%s: path conflicts on %v; want %v", test.name, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: path conflicts on %v; want %v", test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestPathConflictMessage(t *testing.T) {
+	c, err := (&catpogs.Catalog{Resources: []*catpogs.Resource{
+		{ID: 1, Comment: "hosts", Which: catalog.Resource_Which_file, File: catpogs.PlainFile("/etc/hosts", nil)},
+		{ID: 2, Which: catalog.Resource_Which_file, File: &catpogs.File{Path: "/etc", Which: catalog.File_Which_absent}},
+	}}).ToCapnp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems := Check(c, nil)
+	const want = "error: hosts (id=1): manages the file /etc/hosts, but id=2 removes /etc; neither depends on the other [path-conflict]"
+	if len(problems) != 1 || problems[0].String() != want {
+		t.Errorf("Check(...) = %v; want [%s]", problems, want)
+	}
+}
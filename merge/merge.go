@@ -0,0 +1,95 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/zombiezen/mcm/internal/catalogio"
+	"github.com/zombiezen/mcm/internal/version"
+	"github.com/zombiezen/mcm/merge/mergelib"
+)
+
+func init() {
+	flag.Usage = usage
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [-o OUTPUT] [-packed] [-gzip] [-keep-identical] [-partial] CATALOG [...]\n", filepath.Base(os.Args[0]))
+	flag.PrintDefaults()
+}
+
+func main() {
+	opts := new(mergelib.Options)
+	output := flag.String("o", "", "write the merged catalog to this file instead of stdout")
+	flag.BoolVar(&opts.KeepIdentical, "keep-identical", false, "allow the same resource in more than one catalog if the copies are identical")
+	flag.BoolVar(&opts.Partial, "partial", false, "keep imports that no catalog exports, so the result can be merged again")
+	readOpts := catalogio.Flags(flag.CommandLine)
+	writeOpts := catalogio.WriteFlags(flag.CommandLine)
+	versionMode := flag.Bool("version", false, "display version info")
+	flag.Parse()
+	if *versionMode {
+		version.Show()
+		return
+	}
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	inputs := make([]mergelib.Input, flag.NArg())
+	readStdin := false
+	for i, path := range flag.Args() {
+		inputs[i].Name = path
+		if path == "-" {
+			if readStdin {
+				fmt.Fprintln(os.Stderr, "mcm-merge: stdin given more than once")
+				os.Exit(2)
+			}
+			readStdin = true
+			inputs[i].Name = "<stdin>"
+			path = ""
+		}
+		var err error
+		inputs[i].Catalog, err = catalogio.ReadFile(path, readOpts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "mcm-merge: %s: %v\n", inputs[i].Name, err)
+			os.Exit(1)
+		}
+	}
+	c, err := mergelib.Merge(inputs, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "mcm-merge:", err)
+		os.Exit(1)
+	}
+	data, err := catalogio.Marshal(c, writeOpts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "mcm-merge:", err)
+		os.Exit(1)
+	}
+	if *output == "" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = ioutil.WriteFile(*output, data, 0666)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "mcm-merge:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mergelib combines several catalogs into one.
+//
+// Resources keep their IDs, so catalogs generated separately must use
+// distinct IDs, like the name hashes that mcm-luacat and catalogbuilder
+// produce.  A resource in one catalog can depend on a resource in
+// another through the catalogs' exports and imports: each import's
+// placeholder ID is replaced with the ID of the resource exported under
+// the same name.
+package mergelib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/internal/catdiff"
+	"github.com/zombiezen/mcm/internal/catpogs"
+	"github.com/zombiezen/mcm/internal/depgraph"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto/pogs"
+)
+
+// An Input is a catalog to merge.
+type Input struct {
+	// Name identifies the catalog in error messages, like its file name.
+	Name    string
+	Catalog catalog.Catalog
+}
+
+// Options controls how catalogs are merged.
+type Options struct {
+	// KeepIdentical allows more than one catalog to have a resource
+	// with the same ID, as long as the resources are semantically
+	// identical.  Only the first is kept.  Without KeepIdentical, any
+	// ID collision is an error.
+	KeepIdentical bool
+
+	// Partial allows imports that none of the inputs export.  They are
+	// kept as imports in the merged catalog, so that it can be merged
+	// again later.  Without Partial, an unresolved import is an error.
+	Partial bool
+}
+
+// Merge combines the inputs into a single catalog in a new message.
+// Resources appear in the order of the inputs.  The merged catalog
+// exports all the names that the inputs export.  opts may be nil,
+// which is the same as a zero Options.
+//
+// Unless the merged catalog still has imports, Merge checks that its
+// dependency graph is valid, so the result can be applied as is.
+func Merge(inputs []Input, opts *Options) (catalog.Catalog, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	m := &merger{
+		opts:       opts,
+		exports:    make(map[string]export),
+		unresolved: make(map[string]uint64),
+		importer:   make(map[string]string),
+		byID:       make(map[uint64]int),
+	}
+	cats := make([]*catpogs.Catalog, len(inputs))
+	for i, in := range inputs {
+		cats[i] = new(catpogs.Catalog)
+		if err := pogs.Extract(cats[i], catalog.Catalog_TypeID, in.Catalog.Struct); err != nil {
+			return catalog.Catalog{}, fmt.Errorf("merge: read %s: %v", in.Name, err)
+		}
+		if err := m.addExports(in.Name, cats[i]); err != nil {
+			return catalog.Catalog{}, fmt.Errorf("merge: %v", err)
+		}
+	}
+	for i, in := range inputs {
+		if err := m.addResources(in.Name, cats[i]); err != nil {
+			return catalog.Catalog{}, fmt.Errorf("merge: %v", err)
+		}
+	}
+	if err := m.checkImports(); err != nil {
+		return catalog.Catalog{}, fmt.Errorf("merge: %v", err)
+	}
+	c, err := m.out.ToCapnp()
+	if err != nil {
+		return catalog.Catalog{}, fmt.Errorf("merge: %v", err)
+	}
+	if len(m.out.Imports) == 0 {
+		res, err := c.Resources()
+		if err != nil {
+			return catalog.Catalog{}, fmt.Errorf("merge: %v", err)
+		}
+		if _, err := depgraph.New(res); err != nil {
+			return catalog.Catalog{}, fmt.Errorf("merge: %v", err)
+		}
+	}
+	return c, nil
+}
+
+type merger struct {
+	opts *Options
+	out  catpogs.Catalog
+
+	exports    map[string]export
+	unresolved map[string]uint64 // import name -> placeholder ID
+	importer   map[string]string // import name -> first input that imports it
+
+	// byID maps resource IDs to their index in out.Resources, and
+	// inputOf holds the name of the input each resource came from.
+	byID    map[uint64]int
+	inputOf []string
+}
+
+type export struct {
+	id    uint64
+	input string
+}
+
+// addExports records the exports of an input.  Exports are gathered
+// before any resources are added so that an import can be resolved
+// by a later input.
+func (m *merger) addExports(input string, c *catpogs.Catalog) error {
+	ids := make(map[uint64]bool, len(c.Resources))
+	for _, r := range c.Resources {
+		ids[r.ID] = true
+	}
+	for _, e := range c.Exports {
+		if !ids[e.ID] {
+			return fmt.Errorf("%s: export %q names unknown resource ID %d", input, e.Name, e.ID)
+		}
+		prev, dup := m.exports[e.Name]
+		switch {
+		case !dup:
+			m.exports[e.Name] = export{id: e.ID, input: input}
+			m.out.Exports = append(m.out.Exports, e)
+		case prev.id != e.ID:
+			return fmt.Errorf("%s and %s both export %q", prev.input, input, e.Name)
+		}
+	}
+	return nil
+}
+
+// addResources adds an input's resources to the merged catalog,
+// resolving its imports.
+func (m *merger) addResources(input string, c *catpogs.Catalog) error {
+	rewrite := make(map[uint64]uint64, len(c.Imports))
+	for _, imp := range c.Imports {
+		if e, ok := m.exports[imp.Name]; ok {
+			rewrite[imp.ID] = e.id
+			continue
+		}
+		// Unresolved imports of the same name from different inputs
+		// share the first input's placeholder.
+		id, ok := m.unresolved[imp.Name]
+		if !ok {
+			id = imp.ID
+			m.unresolved[imp.Name] = id
+			m.importer[imp.Name] = input
+			m.out.Imports = append(m.out.Imports, catpogs.ResourceName{Name: imp.Name, ID: id})
+		}
+		rewrite[imp.ID] = id
+	}
+	for _, r := range c.Resources {
+		if _, collides := rewrite[r.ID]; collides {
+			return fmt.Errorf("%s: resource %s has the same ID as an import", input, describe(r))
+		}
+	}
+	for _, r := range c.Resources {
+		rewriteIDs(r.Deps, rewrite)
+		if r.Which == catalog.Resource_Which_exec && r.Exec != nil {
+			rewriteIDs(r.Exec.Condition.IfDepsChanged, rewrite)
+		}
+		if i, dup := m.byID[r.ID]; dup {
+			prev := m.out.Resources[i]
+			if !m.opts.KeepIdentical {
+				return fmt.Errorf("resource %s in %s has the same ID as %s in %s", describe(r), input, describe(prev), m.inputOf[i])
+			}
+			same, err := identical(prev, r)
+			if err != nil {
+				return fmt.Errorf("%s: compare resource %s: %v", input, describe(r), err)
+			}
+			if !same {
+				return fmt.Errorf("resource %s in %s has the same ID as %s in %s, but they differ", describe(r), input, describe(prev), m.inputOf[i])
+			}
+			continue
+		}
+		m.byID[r.ID] = len(m.out.Resources)
+		m.out.Resources = append(m.out.Resources, r)
+		m.inputOf = append(m.inputOf, input)
+	}
+	return nil
+}
+
+// checkImports verifies that the imports left after merging are
+// allowed and don't collide with resources from other inputs.
+func (m *merger) checkImports() error {
+	if len(m.out.Imports) == 0 {
+		return nil
+	}
+	if !m.opts.Partial {
+		names := make([]string, len(m.out.Imports))
+		for i, imp := range m.out.Imports {
+			names[i] = fmt.Sprintf("%q (imported by %s)", imp.Name, m.importer[imp.Name])
+		}
+		sort.Strings(names)
+		return fmt.Errorf("nothing exports %s", strings.Join(names, ", "))
+	}
+	for _, imp := range m.out.Imports {
+		if i, ok := m.byID[imp.ID]; ok {
+			return fmt.Errorf("import %q from %s has the same ID as resource %s in %s", imp.Name, m.importer[imp.Name], describe(m.out.Resources[i]), m.inputOf[i])
+		}
+	}
+	return nil
+}
+
+func rewriteIDs(ids []uint64, rewrite map[uint64]uint64) {
+	for i, id := range ids {
+		if newID, ok := rewrite[id]; ok {
+			ids[i] = newID
+		}
+	}
+}
+
+// identical reports whether two resources are semantically equal.
+func identical(r1, r2 *catpogs.Resource) (bool, error) {
+	c, err := (&catpogs.Catalog{Resources: []*catpogs.Resource{r1, r2}}).ToCapnp()
+	if err != nil {
+		return false, err
+	}
+	res, err := c.Resources()
+	if err != nil {
+		return false, err
+	}
+	d, err := catdiff.CompareResources(res.At(0), res.At(1))
+	if err != nil {
+		return false, err
+	}
+	return d == nil, nil
+}
+
+func describe(r *catpogs.Resource) string {
+	if r.Comment == "" {
+		return fmt.Sprintf("id=%d", r.ID)
+	}
+	return fmt.Sprintf("%s (id=%d)", r.Comment, r.ID)
+}
@@ -0,0 +1,269 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mergelib
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/internal/catpogs"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto/encoding/text"
+)
+
+func TestMerge(t *testing.T) {
+	noop := func(id uint64, comment string, deps ...uint64) *catpogs.Resource {
+		return &catpogs.Resource{ID: id, Comment: comment, Deps: deps, Which: catalog.Resource_Which_noop}
+	}
+	tests := []struct {
+		name   string
+		inputs []*catpogs.Catalog
+		opts   Options
+		want   string
+	}{
+		{
+			name: "empty",
+			want: `(resources = [], exports = [], imports = [])`,
+		},
+		{
+			name: "disjoint",
+			inputs: []*catpogs.Catalog{
+				{Resources: []*catpogs.Resource{noop(1, "a")}},
+				{Resources: []*catpogs.Resource{noop(2, "b", 1)}},
+			},
+			want: `(resources = [` +
+				`(id = 1, comment = "a", dependencies = [], locks = [], noop = void), ` +
+				`(id = 2, comment = "b", dependencies = [1], locks = [], noop = void)], ` +
+				`exports = [], imports = [])`,
+		},
+		{
+			name: "import resolved by later catalog",
+			inputs: []*catpogs.Catalog{
+				{
+					Resources: []*catpogs.Resource{
+						noop(10, "site", 99),
+						{
+							ID:    11,
+							Deps:  []uint64{99, 10},
+							Which: catalog.Resource_Which_exec,
+							Exec: &catpogs.Exec{
+								Command: &catpogs.Command{Which: catalog.Exec_Command_Which_argv, Argv: []string{"/bin/true"}},
+								Condition: catpogs.ExecCondition{
+									Which:         catalog.Exec_condition_Which_ifDepsChanged,
+									IfDepsChanged: []uint64{99},
+								},
+							},
+						},
+					},
+					Imports: []catpogs.ResourceName{{Name: "nginx", ID: 99}},
+				},
+				{
+					Resources: []*catpogs.Resource{noop(5, "install nginx")},
+					Exports:   []catpogs.ResourceName{{Name: "nginx", ID: 5}},
+				},
+			},
+			want: `(resources = [` +
+				`(id = 10, comment = "site", dependencies = [5], locks = [], noop = void), ` +
+				`(id = 11, comment = "", dependencies = [5, 10], locks = [], exec = (command = (argv = ["/bin/true"], environment = [], workingDirectory = ""), condition = (ifDepsChanged = [5]))), ` +
+				`(id = 5, comment = "install nginx", dependencies = [], locks = [], noop = void)], ` +
+				`exports = [(name = "nginx", id = 5)], imports = [])`,
+		},
+		{
+			name: "identical duplicates",
+			inputs: []*catpogs.Catalog{
+				{
+					Resources: []*catpogs.Resource{noop(1, "common"), noop(2, "a", 1)},
+					Exports:   []catpogs.ResourceName{{Name: "common", ID: 1}},
+				},
+				{
+					Resources: []*catpogs.Resource{noop(1, "common"), noop(3, "b", 1)},
+					Exports:   []catpogs.ResourceName{{Name: "common", ID: 1}},
+				},
+			},
+			opts: Options{KeepIdentical: true},
+			want: `(resources = [` +
+				`(id = 1, comment = "common", dependencies = [], locks = [], noop = void), ` +
+				`(id = 2, comment = "a", dependencies = [1], locks = [], noop = void), ` +
+				`(id = 3, comment = "b", dependencies = [1], locks = [], noop = void)], ` +
+				`exports = [(name = "common", id = 1)], imports = [])`,
+		},
+		{
+			name: "partial",
+			inputs: []*catpogs.Catalog{
+				{
+					Resources: []*catpogs.Resource{noop(1, "a", 100)},
+					Imports:   []catpogs.ResourceName{{Name: "base", ID: 100}},
+				},
+				{
+					Resources: []*catpogs.Resource{noop(2, "b", 200)},
+					Imports:   []catpogs.ResourceName{{Name: "base", ID: 200}},
+				},
+			},
+			opts: Options{Partial: true},
+			want: `(resources = [` +
+				`(id = 1, comment = "a", dependencies = [100], locks = [], noop = void), ` +
+				`(id = 2, comment = "b", dependencies = [100], locks = [], noop = void)], ` +
+				`exports = [], imports = [(name = "base", id = 100)])`,
+		},
+	}
+	for _, test := range tests {
+		inputs, err := toInputs(test.inputs)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		c, err := Merge(inputs, &test.opts)
+		if err != nil {
+			t.Errorf("%s: Merge: %v", test.name, err)
+			continue
+		}
+		got, err := text.Marshal(catalog.Catalog_TypeID, c.Struct)
+		if err != nil {
+			t.Errorf("%s: marshal: %v", test.name, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: Merge(...) = %s; want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMergeErrors(t *testing.T) {
+	noop := func(id uint64, comment string, deps ...uint64) *catpogs.Resource {
+		return &catpogs.Resource{ID: id, Comment: comment, Deps: deps, Which: catalog.Resource_Which_noop}
+	}
+	tests := []struct {
+		name   string
+		inputs []*catpogs.Catalog
+		opts   Options
+		msg    string
+	}{
+		{
+			name: "collision",
+			inputs: []*catpogs.Catalog{
+				{Resources: []*catpogs.Resource{noop(1, "a")}},
+				{Resources: []*catpogs.Resource{noop(1, "a")}},
+			},
+			msg: "resource a (id=1) in catalog1 has the same ID as a (id=1) in catalog0",
+		},
+		{
+			name: "different duplicates",
+			inputs: []*catpogs.Catalog{
+				{Resources: []*catpogs.Resource{noop(1, "a")}},
+				{Resources: []*catpogs.Resource{noop(1, "b")}},
+			},
+			opts: Options{KeepIdentical: true},
+			msg:  "but they differ",
+		},
+		{
+			name: "unresolved import",
+			inputs: []*catpogs.Catalog{
+				{
+					Resources: []*catpogs.Resource{noop(1, "a", 100)},
+					Imports:   []catpogs.ResourceName{{Name: "base", ID: 100}},
+				},
+			},
+			msg: `nothing exports "base" (imported by catalog0)`,
+		},
+		{
+			name: "conflicting exports",
+			inputs: []*catpogs.Catalog{
+				{
+					Resources: []*catpogs.Resource{noop(1, "a")},
+					Exports:   []catpogs.ResourceName{{Name: "x", ID: 1}},
+				},
+				{
+					Resources: []*catpogs.Resource{noop(2, "b")},
+					Exports:   []catpogs.ResourceName{{Name: "x", ID: 2}},
+				},
+			},
+			msg: `catalog0 and catalog1 both export "x"`,
+		},
+		{
+			name: "unknown export",
+			inputs: []*catpogs.Catalog{
+				{Exports: []catpogs.ResourceName{{Name: "x", ID: 1}}},
+			},
+			msg: `export "x" names unknown resource ID 1`,
+		},
+		{
+			name: "import collides with resource",
+			inputs: []*catpogs.Catalog{
+				{
+					Resources: []*catpogs.Resource{noop(1, "a")},
+					Imports:   []catpogs.ResourceName{{Name: "x", ID: 1}},
+				},
+			},
+			opts: Options{Partial: true},
+			msg:  "same ID as an import",
+		},
+		{
+			name: "unresolved import collides with other catalog",
+			inputs: []*catpogs.Catalog{
+				{
+					Resources: []*catpogs.Resource{noop(1, "a", 100)},
+					Imports:   []catpogs.ResourceName{{Name: "x", ID: 100}},
+				},
+				{Resources: []*catpogs.Resource{noop(100, "b")}},
+			},
+			opts: Options{Partial: true},
+			msg:  `import "x" from catalog0 has the same ID as resource b (id=100) in catalog1`,
+		},
+		{
+			name: "cycle across catalogs",
+			inputs: []*catpogs.Catalog{
+				{
+					Resources: []*catpogs.Resource{noop(1, "a", 100)},
+					Imports:   []catpogs.ResourceName{{Name: "b", ID: 100}},
+					Exports:   []catpogs.ResourceName{{Name: "a", ID: 1}},
+				},
+				{
+					Resources: []*catpogs.Resource{noop(2, "b", 200)},
+					Imports:   []catpogs.ResourceName{{Name: "a", ID: 200}},
+					Exports:   []catpogs.ResourceName{{Name: "b", ID: 2}},
+				},
+			},
+			msg: "cycle",
+		},
+	}
+	for _, test := range tests {
+		inputs, err := toInputs(test.inputs)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		_, err = Merge(inputs, &test.opts)
+		if err == nil {
+			t.Errorf("%s: Merge succeeded", test.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.msg) {
+			t.Errorf("%s: Merge error = %v; want to contain %q", test.name, err, test.msg)
+		}
+	}
+}
+
+func toInputs(cats []*catpogs.Catalog) ([]Input, error) {
+	inputs := make([]Input, len(cats))
+	for i, pc := range cats {
+		c, err := pc.ToCapnp()
+		if err != nil {
+			return nil, err
+		}
+		inputs[i] = Input{Name: "catalog" + strconv.Itoa(i), Catalog: c}
+	}
+	return inputs, nil
+}
@@ -17,13 +17,12 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io"
 	"os"
 
 	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/internal/catalogio"
 	"github.com/zombiezen/mcm/internal/version"
 	"github.com/zombiezen/mcm/shellify/shlib"
-	"github.com/zombiezen/mcm/third_party/golang/capnproto"
 )
 
 func init() {
@@ -36,6 +35,7 @@ func usage() {
 }
 
 func main() {
+	readOpts := catalogio.Flags(flag.CommandLine)
 	versionMode := flag.Bool("version", false, "display version info")
 	flag.Parse()
 	if *versionMode {
@@ -43,9 +43,9 @@ func main() {
 		return
 	}
 
-	c, err := readCatalogArg()
+	c, err := readCatalogArg(readOpts)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "mcm-shellify: read catalog:", err)
+		fmt.Fprintln(os.Stderr, "mcm-shellify:", err)
 		os.Exit(1)
 	}
 	if err = shlib.WriteScript(os.Stdout, c); err != nil {
@@ -54,29 +54,15 @@ func main() {
 	}
 }
 
-func readCatalogArg() (catalog.Catalog, error) {
+func readCatalogArg(opts *catalogio.Options) (catalog.Catalog, error) {
 	switch flag.NArg() {
 	case 0:
-		return readCatalog(os.Stdin)
+		return catalogio.ReadFile("", opts)
 	case 1:
-		// TODO(someday): read segments lazily
-		f, err := os.Open(flag.Arg(0))
-		if err != nil {
-			return catalog.Catalog{}, err
-		}
-		defer f.Close()
-		return readCatalog(f)
+		return catalogio.ReadFile(flag.Arg(0), opts)
 	default:
 		usage()
 		os.Exit(2)
 		panic("unreachable")
 	}
 }
-
-func readCatalog(r io.Reader) (catalog.Catalog, error) {
-	msg, err := capnp.NewDecoder(r).Decode()
-	if err != nil {
-		return catalog.Catalog{}, err
-	}
-	return catalog.ReadRootCatalog(msg)
-}
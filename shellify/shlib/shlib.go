@@ -26,6 +26,7 @@ import (
 	"strconv"
 
 	"github.com/zombiezen/mcm/catalog"
+	"github.com/zombiezen/mcm/internal/blobstore"
 	"github.com/zombiezen/mcm/internal/depgraph"
 	"github.com/zombiezen/mcm/third_party/golang/capnproto"
 )
@@ -58,13 +59,18 @@ func WriteScript(w io.Writer, c catalog.Catalog) error {
 		v := resourceStatusVar(res.At(i).ID())
 		g.p(assignment{v, -2})
 	}
+	// The script runs one resource at a time, so resource locks are
+	// always satisfied and can be ignored.
+	// Each pass writes the resources that became ready during the one
+	// before, in the order that they became ready.
+	ready := append([]uint64(nil), graph.Ready()...)
 	for g.ew.err == nil && !graph.Done() {
-		ready := append([]uint64(nil), graph.Ready()...)
 		if len(ready) == 0 {
 			return errors.New("graph not done, but has nothing to do")
 		}
+		var next []uint64
 		for _, id := range ready {
-			graph.Mark(id)
+			next = append(next, graph.Mark(id)...)
 			deps, _ := graph.Resource(id).Dependencies()
 			if deps.Len() == 0 {
 				g.p(resourceFuncName(id))
@@ -80,6 +86,7 @@ func WriteScript(w io.Writer, c catalog.Catalog) error {
 			g.out()
 			g.p(script("fi"))
 		}
+		ready = next
 	}
 	g.exitStatusCheck(res)
 	g.out()
@@ -258,6 +265,27 @@ func resourceFuncReturn(id uint64) script {
 	return script(buf)
 }
 
+// checkContentDigest returns an error if a plain file's content does
+// not match its contentSha256, as mcm-exec does.
+func checkContentDigest(p catalog.File_plain) error {
+	content, err := p.Content()
+	if err != nil {
+		return fmt.Errorf("read content from catalog: %v", err)
+	}
+	raw, err := p.ContentSHA256()
+	if err != nil {
+		return fmt.Errorf("read content digest from catalog: %v", err)
+	}
+	want, err := blobstore.DigestFromBytes(raw)
+	if err != nil {
+		return fmt.Errorf("content digest: %v", err)
+	}
+	if blobstore.Sum(content) != want {
+		return fmt.Errorf("content does not match digest %v", want)
+	}
+	return nil
+}
+
 func (g *gen) file(id uint64, f catalog.File) error {
 	path, err := f.Path()
 	if err != nil {
@@ -273,18 +301,31 @@ func (g *gen) file(id uint64, f catalog.File) error {
 
 	switch f.Which() {
 	case catalog.File_Which_plain:
-		g.p(script(`if [[ -h "$respath" ]]; then`))
-		g.in()
-		g.p(script(`echo "$respath is not a regular file" 1>&2`))
-		g.returnStatus(id, -1)
-		g.out()
-		g.p(script("fi"))
-
+		if !f.Plain().HasContent() && f.Plain().HasContentSHA256() {
+			return errors.New("content given only by digest is not supported")
+		}
+		if f.Plain().HasContent() && f.Plain().HasContentSHA256() {
+			if err := checkContentDigest(f.Plain()); err != nil {
+				return err
+			}
+		}
 		m, _ := f.Plain().Mode()
 		margs, err := modeToArgs(m)
 		if err != nil {
 			return err
 		}
+		if f.Plain().HasContent() {
+			// Like a redirect, replace the content of the file that an
+			// existing symlink points to instead of the symlink itself.
+			g.followSymlinks(id)
+		} else {
+			g.p(script(`if [[ -h "$respath" ]]; then`))
+			g.in()
+			g.p(script(`echo "$respath is not a regular file" 1>&2`))
+			g.returnStatus(id, -1)
+			g.out()
+			g.p(script("fi"))
+		}
 		switch {
 		case f.Plain().HasContent() && !margs.isEmpty():
 			content, err := f.Plain().Content()
@@ -315,6 +356,7 @@ func (g *gen) file(id uint64, f catalog.File) error {
 			// and non-fileness.
 			g.p(script(`elif [[ -e "$respath" ]]; then`))
 			g.in()
+			g.p(script(`rm "$tmploc"`))
 			g.p(script(`echo "$respath is not a regular file" 1>&2`))
 			g.returnStatus(id, -1)
 			g.out()
@@ -363,6 +405,7 @@ func (g *gen) file(id uint64, f catalog.File) error {
 			// and non-fileness.
 			g.p(script(`elif [[ ! -f "$respath" ]]; then`))
 			g.in()
+			g.p(script(`rm "$tmploc"`))
 			g.p(script(`echo "$respath is not a regular file" 1>&2`))
 			g.returnStatus(id, -1)
 			g.out()
@@ -424,6 +467,13 @@ func (g *gen) file(id uint64, f catalog.File) error {
 			return err
 		}
 
+		g.p(script(`if [[ -h "$respath" ]]; then`))
+		g.in()
+		g.p(script(`echo "$respath is not a directory" 1>&2`))
+		g.returnStatus(id, -1)
+		g.out()
+		g.p(script("fi"))
+
 		if margs.isEmpty() {
 			g.p(script(`if [[ -d "$respath" ]]; then`))
 			g.in()
@@ -449,12 +499,16 @@ func (g *gen) file(id uint64, f catalog.File) error {
 			g.returnStatus(id, -1)
 			g.out()
 			g.p(script("fi"))
+			g.p(script("if [[ $needmkdir -eq 1 ]]; then"))
+			g.in()
 			g.p(script(`mkdir "$respath"`))
 			g.p(script("if [[ $? -ne 0 ]]; then"))
 			g.in()
 			g.returnStatus(id, -1)
 			g.out()
 			g.p(script("fi"))
+			g.out()
+			g.p(script("fi"))
 
 			g.needsSetmode = true
 			g.p(script("local modeout"))
@@ -478,7 +532,7 @@ func (g *gen) file(id uint64, f catalog.File) error {
 		g.in()
 		g.p(script(`if [[ "$(readlink "$respath")" != "$tgt" ]]; then`))
 		g.in()
-		g.p(script(`ln -f -s "$tgt" "$respath"`), updateStatus(id))
+		g.p(script(`ln -f -n -s "$tgt" "$respath"`), updateStatus(id))
 		g.p(resourceFuncReturn(id))
 		g.out()
 		g.p(script("else"))
@@ -498,6 +552,56 @@ func (g *gen) file(id uint64, f catalog.File) error {
 
 		g.p(script(`ln -s "$tgt" "$respath"`), updateStatus(id))
 		g.p(resourceFuncReturn(id))
+	case catalog.File_Which_hardlink:
+		target, _ := f.Hardlink().Target()
+		if target == "" {
+			return errors.New("hard link target is empty")
+		}
+		if !slashpath.IsAbs(target) {
+			return fmt.Errorf("hard link target %s is not an absolute path", target)
+		}
+		g.p(script("local"), assignment{"tgt", target})
+		g.p(script(`if [[ -h "$tgt" ]]; then`))
+		g.in()
+		g.p(script(`echo "$tgt is a symlink" 1>&2`))
+		g.returnStatus(id, -1)
+		g.out()
+		g.p(script(`elif [[ ! -e "$tgt" ]]; then`))
+		g.in()
+		g.p(script(`echo "$tgt does not exist" 1>&2`))
+		g.returnStatus(id, -1)
+		g.out()
+		g.p(script(`elif [[ -d "$tgt" ]]; then`))
+		g.in()
+		g.p(script(`echo "$tgt is a directory" 1>&2`))
+		g.returnStatus(id, -1)
+		g.out()
+		g.p(script("fi"))
+
+		g.p(script(`if [[ -h "$respath" ]]; then`))
+		g.in()
+		g.p(script(`echo "$respath is not a regular file" 1>&2`))
+		g.returnStatus(id, -1)
+		g.out()
+		g.p(script(`elif [[ -e "$respath" ]]; then`))
+		g.in()
+		g.p(script(`if [[ "$respath" -ef "$tgt" ]]; then`))
+		g.in()
+		g.returnStatus(id, 0)
+		g.out()
+		g.p(script(`elif [[ ! -f "$respath" ]]; then`))
+		g.in()
+		g.p(script(`echo "$respath is not a regular file" 1>&2`))
+		g.returnStatus(id, -1)
+		g.out()
+		g.p(script("fi"))
+		g.p(script(`ln -f "$tgt" "$respath"`), updateStatus(id))
+		g.p(resourceFuncReturn(id))
+		g.out()
+		g.p(script("fi"))
+
+		g.p(script(`ln "$tgt" "$respath"`), updateStatus(id))
+		g.p(resourceFuncReturn(id))
 	default:
 		return fmt.Errorf("unsupported file directive %v", f.Which())
 	}
@@ -507,6 +611,33 @@ func (g *gen) file(id uint64, f catalog.File) error {
 // fileContent is a macro for writing data to a temporary file.
 // This creates a local variable called "tmploc" that has the path of
 // the new file.
+// followSymlinks emits a script that sets $respath to the path that a
+// symlink at $respath ultimately points to.  It follows at most as many
+// symlinks as execlib does.
+func (g *gen) followSymlinks(id uint64) {
+	g.p(script("local linkhops=0 linktgt"))
+	g.p(script(`while [[ -h "$respath" ]]; do`))
+	g.in()
+	g.p(script("if [[ $linkhops -ge 40 ]]; then"))
+	g.in()
+	g.p(script(`echo "$respath: too many levels of symbolic links" 1>&2`))
+	g.returnStatus(id, -1)
+	g.out()
+	g.p(script("fi"))
+	g.p(script(`linktgt="$(readlink "$respath")"`))
+	g.p(script("if [[ $? -ne 0 ]]; then"))
+	g.in()
+	g.returnStatus(id, -1)
+	g.out()
+	g.p(script("fi"))
+	// Don't clean the joined path, matching execlib.
+	g.p(script(`[[ "$linktgt" == /* ]] || linktgt="${respath%/*}/$linktgt"`))
+	g.p(script(`respath="$linktgt"`))
+	g.p(script("linkhops=$((linkhops + 1))"))
+	g.out()
+	g.p(script("done"))
+}
+
 func (g *gen) fileContent(id uint64, content []byte) {
 	enc := make([]byte, base64.StdEncoding.EncodedLen(len(content)))
 	base64.StdEncoding.Encode(enc, content)
@@ -665,7 +796,7 @@ func (g *gen) execCondition(id uint64, cond catalog.Exec_condition) error {
 		if !slashpath.IsAbs(path) {
 			return fmt.Errorf("%s is not an absolute path", path)
 		}
-		g.p(script("if [[ -e"), path, script("]]; then"))
+		g.p(script("if [[ -e"), path, script("|| -h"), path, script("]]; then"))
 		g.in()
 		g.returnStatus(id, 0)
 		g.out()
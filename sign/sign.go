@@ -0,0 +1,152 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/zombiezen/mcm/internal/catalogio"
+	"github.com/zombiezen/mcm/internal/catsig"
+	"github.com/zombiezen/mcm/internal/version"
+	"github.com/zombiezen/mcm/third_party/golang/ed25519"
+)
+
+func init() {
+	flag.Usage = usage
+}
+
+func usage() {
+	name := filepath.Base(os.Args[0])
+	fmt.Fprintf(os.Stderr, "usage: %s -key=KEYFILE [-d] [-o OUTPUT] [CATALOG]\n", name)
+	fmt.Fprintf(os.Stderr, "       %s -key=KEYFILE -generate\n", name)
+	fmt.Fprintf(os.Stderr, "       %s -key=KEYFILE -public\n", name)
+	flag.PrintDefaults()
+}
+
+func main() {
+	keyPath := flag.String("key", "", "private key file")
+	generate := flag.Bool("generate", false, "create a new private key file and print its public key")
+	public := flag.Bool("public", false, "print the public key for the private key file")
+	detached := flag.Bool("d", false, "write a detached signature instead of embedding the catalog")
+	output := flag.String("o", "", "write to this file instead of stdout")
+	readOpts := new(catalogio.Options)
+	catalogio.ReadLimitFlag(flag.CommandLine, &readOpts.ReadLimit)
+	versionMode := flag.Bool("version", false, "display version info")
+	flag.Parse()
+	if *versionMode {
+		version.Show()
+		return
+	}
+	if *keyPath == "" || *generate && *public {
+		usage()
+		os.Exit(2)
+	}
+
+	switch {
+	case *generate:
+		if flag.NArg() != 0 {
+			usage()
+			os.Exit(2)
+		}
+		pub, err := generateKey(*keyPath)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Println(catsig.FormatPublicKey(pub))
+	case *public:
+		if flag.NArg() != 0 {
+			usage()
+			os.Exit(2)
+		}
+		key, err := readKey(*keyPath)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Println(catsig.FormatPublicKey(key.Public().(ed25519.PublicKey)))
+	default:
+		var path string
+		switch flag.NArg() {
+		case 0:
+		case 1:
+			path = flag.Arg(0)
+		default:
+			usage()
+			os.Exit(2)
+		}
+		key, err := readKey(*keyPath)
+		if err != nil {
+			fatal(err)
+		}
+		data, err := catalogio.ReadBytes(path, readOpts)
+		if err != nil {
+			fatal(err)
+		}
+		signed, err := catsig.Sign(data, key, !*detached)
+		if err != nil {
+			fatal(err)
+		}
+		if *output == "" {
+			_, err = os.Stdout.Write(signed)
+		} else {
+			err = ioutil.WriteFile(*output, signed, 0666)
+		}
+		if err != nil {
+			fatal(err)
+		}
+	}
+}
+
+// generateKey writes a new private key to path, which must not exist.
+func generateKey(path string) (ed25519.PublicKey, error) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.Write(catsig.MarshalPrivateKey(key))
+	cerr := f.Close()
+	if err != nil {
+		return nil, err
+	}
+	if cerr != nil {
+		return nil, cerr
+	}
+	return pub, nil
+}
+
+func readKey(path string) (ed25519.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := catsig.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return key, nil
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "mcm-sign:", err)
+	os.Exit(1)
+}
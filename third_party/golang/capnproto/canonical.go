@@ -0,0 +1,415 @@
+package capnp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Canonicalize encodes a struct into its canonical form: a single-
+// segment blob without a segment table.  The result will be identical
+// for equivalent structs, even as the schema evolves.  The blob is
+// suitable for hashing or signing.
+//
+// Reading s counts against its message's read limit.
+func Canonicalize(s Struct) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := WriteCanonical(&buf, s); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteCanonical writes the canonical form of s, as returned by
+// Canonicalize, to w.  The canonical form is written while s is
+// traversed, so it is never held in memory: write to a hash.Hash to
+// get a digest of a large message cheaply.
+//
+// Reading s counts against its message's read limit.  Each object is
+// counted once, even though laying out an object requires finding the
+// canonical sizes of its children first.
+func WriteCanonical(w io.Writer, s Struct) error {
+	cw := &canonicalWriter{w: bufio.NewWriter(w)}
+	if err := cw.writeRoot(s); err != nil {
+		return fmt.Errorf("canonicalize: %v", err)
+	}
+	if err := cw.w.Flush(); err != nil {
+		return fmt.Errorf("canonicalize: %v", err)
+	}
+	return nil
+}
+
+// A canonicalWriter writes the canonical form of a message.  Objects
+// are laid out depth-first in pointer order, so a pointer's target is
+// known once the canonical sizes of the objects before it are.
+type canonicalWriter struct {
+	w   *bufio.Writer
+	n   int64 // bytes written
+	buf [8]byte
+}
+
+// pos returns the number of words written.
+func (cw *canonicalWriter) pos() int64 {
+	return cw.n / int64(wordSize)
+}
+
+func (cw *canonicalWriter) write(b []byte) error {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return err
+}
+
+func (cw *canonicalWriter) writeWord(v uint64) error {
+	binary.LittleEndian.PutUint64(cw.buf[:], v)
+	return cw.write(cw.buf[:])
+}
+
+// pad writes zeroes up to the next word boundary.
+func (cw *canonicalWriter) pad() error {
+	for cw.n%int64(wordSize) != 0 {
+		if err := cw.w.WriteByte(0); err != nil {
+			return err
+		}
+		cw.n++
+	}
+	return nil
+}
+
+func (cw *canonicalWriter) writeRoot(s Struct) error {
+	if !s.IsValid() {
+		return cw.writeWord(0)
+	}
+	if err := cw.writePtr(s.ToPtr(), 1); err != nil {
+		return err
+	}
+	return cw.writeStruct(s)
+}
+
+// writePtr writes a pointer to p, whose canonical form starts at word
+// target.
+func (cw *canonicalWriter) writePtr(p Ptr, target int64) error {
+	if !p.IsValid() {
+		return cw.writeWord(0)
+	}
+	off := pointerOffset(target - cw.pos() - 1)
+	switch p.flags.ptrType() {
+	case structPtrType:
+		sz := canonicalStructSize(p.Struct())
+		if sz.isZero() {
+			// Empty structs are encoded with an offset of -1 like the
+			// reference implementation does, so that they aren't
+			// confused with null.
+			return cw.writeWord(uint64(rawStructPointer(-1, ObjectSize{})))
+		}
+		return cw.writeWord(uint64(rawStructPointer(off, sz)))
+	case listPtrType:
+		l := p.List()
+		if l.flags&isBitList != 0 {
+			return cw.writeWord(uint64(rawListPointer(off, bit1List, l.length)))
+		}
+		if l.flags&isCompositeList != 0 {
+			words := l.length * canonicalElemSize(l).totalWordCount()
+			return cw.writeWord(uint64(rawListPointer(off, compositeList, words)))
+		}
+		if l.size.PointerCount != 0 {
+			return cw.writeWord(uint64(rawListPointer(off, pointerList, l.length)))
+		}
+		var lt int
+		switch l.size.DataSize {
+		case 0:
+			lt = voidList
+		case 1:
+			lt = byte1List
+		case 2:
+			lt = byte2List
+		case 4:
+			lt = byte4List
+		case 8:
+			lt = byte8List
+		default:
+			return errListSize
+		}
+		return cw.writeWord(uint64(rawListPointer(off, lt, l.length)))
+	case interfacePtrType:
+		return errors.New("cannot canonicalize interface")
+	default:
+		panic("unreachable")
+	}
+}
+
+// writeObject writes the object that p points to, then its children.
+func (cw *canonicalWriter) writeObject(p Ptr) error {
+	if !p.IsValid() {
+		return nil
+	}
+	switch p.flags.ptrType() {
+	case structPtrType:
+		return cw.writeStruct(p.Struct())
+	case listPtrType:
+		return cw.writeList(p.List())
+	case interfacePtrType:
+		return errors.New("cannot canonicalize interface")
+	default:
+		panic("unreachable")
+	}
+}
+
+func (cw *canonicalWriter) writeStruct(s Struct) error {
+	sz := canonicalStructSize(s)
+	next := cw.pos() + int64(sz.totalWordCount())
+	if err := cw.writeSections(s, sz, &next); err != nil {
+		return err
+	}
+	return cw.writeChildren(s, sz)
+}
+
+// writeSections writes the data and pointer sections of s, resized to
+// sz.  *next is the word at which s's first child will be written, and
+// it is advanced past each of s's children.
+func (cw *canonicalWriter) writeSections(s Struct, sz ObjectSize, next *int64) error {
+	n := sz.DataSize
+	if s.size.DataSize < n {
+		n = s.size.DataSize
+	}
+	if err := cw.write(s.seg.slice(s.off, n)); err != nil {
+		return err
+	}
+	for ; n < sz.DataSize; n++ {
+		if err := cw.w.WriteByte(0); err != nil {
+			return err
+		}
+		cw.n++
+	}
+	for i := uint16(0); i < sz.PointerCount; i++ {
+		p, err := peekPtr(s.Ptr(i))
+		if err != nil {
+			return fmt.Errorf("pointer %d: %v", i, err)
+		}
+		if err := cw.writePtr(p, *next); err != nil {
+			return fmt.Errorf("pointer %d: %v", i, err)
+		}
+		size, err := canonicalTreeSize(p)
+		if err != nil {
+			return fmt.Errorf("pointer %d: %v", i, err)
+		}
+		*next += size
+	}
+	return nil
+}
+
+// writeChildren writes the objects that the first sz.PointerCount
+// pointers of s point to.
+func (cw *canonicalWriter) writeChildren(s Struct, sz ObjectSize) error {
+	for i := uint16(0); i < sz.PointerCount; i++ {
+		p, err := s.Ptr(i)
+		if err != nil {
+			return fmt.Errorf("pointer %d: %v", i, err)
+		}
+		if err := cw.writeObject(p); err != nil {
+			return fmt.Errorf("pointer %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func (cw *canonicalWriter) writeList(l List) error {
+	if l.flags&isBitList != 0 {
+		b := l.seg.slice(l.off, Size(int64(l.length+7)/8))
+		if r := l.length % 8; r != 0 {
+			// Unused bits in the last byte must be zero.
+			if err := cw.write(b[:len(b)-1]); err != nil {
+				return err
+			}
+			b = []byte{b[len(b)-1] & (byte(1<<uint(r)) - 1)}
+		}
+		if err := cw.write(b); err != nil {
+			return err
+		}
+		return cw.pad()
+	}
+	if l.flags&isCompositeList == 0 && l.size.PointerCount == 0 {
+		// Data only, just copy over.
+		sz, _ := l.size.DataSize.times(l.length) // list was already validated
+		if err := cw.write(l.seg.slice(l.off, sz)); err != nil {
+			return err
+		}
+		return cw.pad()
+	}
+	if l.flags&isCompositeList == 0 {
+		pl := PointerList{l}
+		next := cw.pos() + int64(l.length)
+		for i := 0; i < l.Len(); i++ {
+			p, err := peekPtr(pl.PtrAt(i))
+			if err != nil {
+				return fmt.Errorf("element %d: %v", i, err)
+			}
+			if err := cw.writePtr(p, next); err != nil {
+				return fmt.Errorf("element %d: %v", i, err)
+			}
+			size, err := canonicalTreeSize(p)
+			if err != nil {
+				return fmt.Errorf("element %d: %v", i, err)
+			}
+			next += size
+		}
+		for i := 0; i < l.Len(); i++ {
+			p, err := pl.PtrAt(i)
+			if err != nil {
+				return fmt.Errorf("element %d: %v", i, err)
+			}
+			if err := cw.writeObject(p); err != nil {
+				return fmt.Errorf("element %d: %v", i, err)
+			}
+		}
+		return nil
+	}
+
+	// Composite lists use the largest canonical element size.
+	elemSize := canonicalElemSize(l)
+	if err := cw.writeWord(uint64(rawStructPointer(pointerOffset(l.length), elemSize))); err != nil {
+		return err
+	}
+	next := cw.pos() + int64(l.length)*int64(elemSize.totalWordCount())
+	for i := 0; i < l.Len(); i++ {
+		if err := cw.writeSections(l.Struct(i), elemSize, &next); err != nil {
+			return fmt.Errorf("element %d: %v", i, err)
+		}
+	}
+	for i := 0; i < l.Len(); i++ {
+		if err := cw.writeChildren(l.Struct(i), elemSize); err != nil {
+			return fmt.Errorf("element %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// canonicalTreeSize returns the number of words that the object p
+// points to and all of its descendants occupy in canonical form.
+func canonicalTreeSize(p Ptr) (int64, error) {
+	if !p.IsValid() {
+		return 0, nil
+	}
+	switch p.flags.ptrType() {
+	case structPtrType:
+		s := p.Struct()
+		sz := canonicalStructSize(s)
+		n := int64(sz.totalWordCount())
+		m, err := canonicalChildrenSize(s, sz)
+		return n + m, err
+	case listPtrType:
+		l := p.List()
+		if l.flags&isBitList != 0 {
+			return int64(Size(int64(l.length+7)/8).padToWord() / wordSize), nil
+		}
+		if l.flags&isCompositeList == 0 && l.size.PointerCount == 0 {
+			sz, _ := l.size.DataSize.times(l.length)
+			return int64(sz.padToWord() / wordSize), nil
+		}
+		if l.flags&isCompositeList == 0 {
+			n := int64(l.length)
+			for i := 0; i < l.Len(); i++ {
+				c, err := peekPtr(PointerList{l}.PtrAt(i))
+				if err != nil {
+					return 0, fmt.Errorf("element %d: %v", i, err)
+				}
+				m, err := canonicalTreeSize(c)
+				if err != nil {
+					return 0, fmt.Errorf("element %d: %v", i, err)
+				}
+				n += m
+			}
+			return n, nil
+		}
+		elemSize := canonicalElemSize(l)
+		n := 1 + int64(l.length)*int64(elemSize.totalWordCount())
+		for i := 0; i < l.Len(); i++ {
+			m, err := canonicalChildrenSize(l.Struct(i), elemSize)
+			if err != nil {
+				return 0, fmt.Errorf("element %d: %v", i, err)
+			}
+			n += m
+		}
+		return n, nil
+	case interfacePtrType:
+		return 0, errors.New("cannot canonicalize interface")
+	default:
+		panic("unreachable")
+	}
+}
+
+// canonicalChildrenSize returns the sum of canonicalTreeSize for the
+// first sz.PointerCount pointers of s.
+func canonicalChildrenSize(s Struct, sz ObjectSize) (int64, error) {
+	var n int64
+	for i := uint16(0); i < sz.PointerCount; i++ {
+		c, err := peekPtr(s.Ptr(i))
+		if err != nil {
+			return 0, fmt.Errorf("pointer %d: %v", i, err)
+		}
+		m, err := canonicalTreeSize(c)
+		if err != nil {
+			return 0, fmt.Errorf("pointer %d: %v", i, err)
+		}
+		n += m
+	}
+	return n, nil
+}
+
+// peekPtr returns the result of reading a pointer, giving back what the
+// read counted against the read limit.  Sizing an object's children
+// reads objects that are read again when they are written, and only
+// the second read should count.
+func peekPtr(p Ptr, err error) (Ptr, error) {
+	if err != nil || !p.IsValid() {
+		return p, err
+	}
+	switch p.flags.ptrType() {
+	case structPtrType:
+		p.seg.msg.ReadLimiter().Unread(p.Struct().readSize())
+	case listPtrType:
+		p.seg.msg.ReadLimiter().Unread(p.List().readSize())
+	}
+	return p, nil
+}
+
+// canonicalStructSize returns the size of s with trailing zero data
+// words and trailing null pointers removed.
+func canonicalStructSize(s Struct) ObjectSize {
+	if !s.IsValid() {
+		return ObjectSize{}
+	}
+	var sz ObjectSize
+	// int32 will not overflow because max struct data size is 2^16 words.
+	for off := int32(s.size.DataSize&^(wordSize-1)) - int32(wordSize); off >= 0; off -= int32(wordSize) {
+		if s.Uint64(DataOffset(off)) != 0 {
+			sz.DataSize = Size(off) + wordSize
+			break
+		}
+	}
+	for i := int32(s.size.PointerCount) - 1; i >= 0; i-- {
+		if s.seg.readRawPointer(s.pointerAddress(uint16(i))) != 0 {
+			sz.PointerCount = uint16(i + 1)
+			break
+		}
+	}
+	return sz
+}
+
+// canonicalElemSize returns the largest canonical size of the elements
+// of the composite list l.
+func canonicalElemSize(l List) ObjectSize {
+	var elemSize ObjectSize
+	for i := 0; i < l.Len(); i++ {
+		sz := canonicalStructSize(l.Struct(i))
+		if sz.DataSize > elemSize.DataSize {
+			elemSize.DataSize = sz.DataSize
+		}
+		if sz.PointerCount > elemSize.PointerCount {
+			elemSize.PointerCount = sz.PointerCount
+		}
+	}
+	return elemSize
+}
@@ -0,0 +1,549 @@
+package capnp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	type testCase struct {
+		name string
+		f    func(t *testing.T) Struct
+		want []byte
+	}
+	tests := []testCase{
+		{
+			name: "zero struct",
+			f: func(t *testing.T) Struct {
+				_, seg, _ := NewMessage(SingleSegment(nil))
+				s, err := NewRootStruct(seg, ObjectSize{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return s
+			},
+			want: []byte{0xfc, 0xff, 0xff, 0xff, 0, 0, 0, 0},
+		},
+		{
+			name: "trailing zero data and null pointers are trimmed",
+			f: func(t *testing.T) Struct {
+				_, seg, _ := NewMessage(SingleSegment(nil))
+				s, err := NewRootStruct(seg, ObjectSize{DataSize: 16, PointerCount: 2})
+				if err != nil {
+					t.Fatal(err)
+				}
+				s.SetUint32(0, 0xdeadbeef)
+				return s
+			},
+			want: []byte{
+				0, 0, 0, 0, 1, 0, 0, 0,
+				0xef, 0xbe, 0xad, 0xde, 0, 0, 0, 0,
+			},
+		},
+		{
+			name: "struct pointer",
+			f: func(t *testing.T) Struct {
+				_, seg, _ := NewMessage(SingleSegment(nil))
+				s, err := NewRootStruct(seg, ObjectSize{DataSize: 8, PointerCount: 2})
+				if err != nil {
+					t.Fatal(err)
+				}
+				sub, err := NewStruct(seg, ObjectSize{DataSize: 16})
+				if err != nil {
+					t.Fatal(err)
+				}
+				sub.SetUint64(0, 0x1122334455667788)
+				if err := s.SetPtr(1, sub.ToPtr()); err != nil {
+					t.Fatal(err)
+				}
+				return s
+			},
+			want: []byte{
+				0, 0, 0, 0, 0, 0, 2, 0,
+				0, 0, 0, 0, 0, 0, 0, 0,
+				0, 0, 0, 0, 1, 0, 0, 0,
+				0x88, 0x77, 0x66, 0x55, 0x44, 0x33, 0x22, 0x11,
+			},
+		},
+		{
+			name: "data",
+			f: func(t *testing.T) Struct {
+				_, seg, _ := NewMessage(SingleSegment(nil))
+				s, err := NewRootStruct(seg, ObjectSize{PointerCount: 1})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := s.SetData(0, []byte("abc")); err != nil {
+					t.Fatal(err)
+				}
+				return s
+			},
+			want: []byte{
+				0, 0, 0, 0, 0, 0, 1, 0,
+				1, 0, 0, 0, 0x1a, 0, 0, 0,
+				'a', 'b', 'c', 0, 0, 0, 0, 0,
+			},
+		},
+		{
+			name: "bit list with garbage",
+			f: func(t *testing.T) Struct {
+				_, seg, _ := NewMessage(SingleSegment(nil))
+				s, err := NewRootStruct(seg, ObjectSize{PointerCount: 1})
+				if err != nil {
+					t.Fatal(err)
+				}
+				l, err := NewBitList(seg, 3)
+				if err != nil {
+					t.Fatal(err)
+				}
+				l.Set(0, true)
+				l.seg.data[l.off] |= 0xf0
+				if err := s.SetPtr(0, l.ToPtr()); err != nil {
+					t.Fatal(err)
+				}
+				return s
+			},
+			want: []byte{
+				0, 0, 0, 0, 0, 0, 1, 0,
+				1, 0, 0, 0, 0x19, 0, 0, 0,
+				0x01, 0, 0, 0, 0, 0, 0, 0,
+			},
+		},
+		{
+			name: "composite list elements are shrunk to largest element",
+			f: func(t *testing.T) Struct {
+				_, seg, _ := NewMessage(SingleSegment(nil))
+				s, err := NewRootStruct(seg, ObjectSize{PointerCount: 1})
+				if err != nil {
+					t.Fatal(err)
+				}
+				l, err := NewCompositeList(seg, ObjectSize{DataSize: 16, PointerCount: 1}, 2)
+				if err != nil {
+					t.Fatal(err)
+				}
+				l.Struct(0).SetUint16(0, 1)
+				l.Struct(1).SetUint16(0, 2)
+				if err := s.SetPtr(0, l.ToPtr()); err != nil {
+					t.Fatal(err)
+				}
+				return s
+			},
+			want: []byte{
+				0, 0, 0, 0, 0, 0, 1, 0,
+				1, 0, 0, 0, 0x17, 0, 0, 0,
+				0x08, 0, 0, 0, 1, 0, 0, 0,
+				1, 0, 0, 0, 0, 0, 0, 0,
+				2, 0, 0, 0, 0, 0, 0, 0,
+			},
+		},
+		{
+			name: "pointer list with empty struct",
+			f: func(t *testing.T) Struct {
+				_, seg, _ := NewMessage(SingleSegment(nil))
+				s, err := NewRootStruct(seg, ObjectSize{PointerCount: 1})
+				if err != nil {
+					t.Fatal(err)
+				}
+				l, err := NewPointerList(seg, 2)
+				if err != nil {
+					t.Fatal(err)
+				}
+				empty, err := NewStruct(seg, ObjectSize{DataSize: 8})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := l.SetPtr(1, empty.ToPtr()); err != nil {
+					t.Fatal(err)
+				}
+				if err := s.SetPtr(0, l.ToPtr()); err != nil {
+					t.Fatal(err)
+				}
+				return s
+			},
+			want: []byte{
+				0, 0, 0, 0, 0, 0, 1, 0,
+				1, 0, 0, 0, 0x16, 0, 0, 0,
+				0, 0, 0, 0, 0, 0, 0, 0,
+				0xfc, 0xff, 0xff, 0xff, 0, 0, 0, 0,
+			},
+		},
+		{
+			name: "multiple segments",
+			f: func(t *testing.T) Struct {
+				_, seg, _ := NewMessage(MultiSegment(nil))
+				s, err := NewRootStruct(seg, ObjectSize{PointerCount: 1})
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Fill up the first segment so that the text lands in another.
+				if _, err := NewData(seg, make([]byte, len(seg.data[len(seg.data):cap(seg.data)]))); err != nil {
+					t.Fatal(err)
+				}
+				if err := s.SetText(0, "hi"); err != nil {
+					t.Fatal(err)
+				}
+				return s
+			},
+			want: []byte{
+				0, 0, 0, 0, 0, 0, 1, 0,
+				1, 0, 0, 0, 0x1a, 0, 0, 0,
+				'h', 'i', 0, 0, 0, 0, 0, 0,
+			},
+		},
+	}
+	for _, test := range tests {
+		s := test.f(t)
+		got, err := Canonicalize(s)
+		if err != nil {
+			t.Errorf("%s: Canonicalize(...) error: %v", test.name, err)
+			continue
+		}
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("%s: Canonicalize(...) =\n%s\nwant:\n%s", test.name, hex.Dump(got), hex.Dump(test.want))
+		}
+	}
+}
+
+func TestCanonicalizeNull(t *testing.T) {
+	got, err := Canonicalize(Struct{})
+	if err != nil {
+		t.Fatal("Canonicalize(Struct{}) error:", err)
+	}
+	if want := make([]byte, 8); !bytes.Equal(got, want) {
+		t.Errorf("Canonicalize(Struct{}) = % x; want % x", got, want)
+	}
+}
+
+func TestWriteCanonicalMatchesCopy(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		arena := SingleSegment(nil)
+		if i%2 == 1 {
+			arena = MultiSegment(nil)
+		}
+		_, seg, err := NewMessage(arena)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := randomStruct(t, rng, seg, 4)
+		want, err := copyCanonicalize(s)
+		if err != nil {
+			t.Fatalf("#%d: copyCanonicalize(...) error: %v", i, err)
+		}
+		h := sha256.New()
+		if err := WriteCanonical(h, s); err != nil {
+			t.Errorf("#%d: WriteCanonical(...) error: %v", i, err)
+			continue
+		}
+		if got := h.Sum(nil); !bytes.Equal(got, sha256Sum(want)) {
+			got, _ := Canonicalize(s)
+			t.Errorf("#%d: WriteCanonical(...) wrote\n%s\nwant:\n%s", i, hex.Dump(got), hex.Dump(want))
+		}
+	}
+}
+
+func TestWriteCanonicalReadLimit(t *testing.T) {
+	_, seg, err := NewMessage(SingleSegment(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := randomStruct(t, rand.New(rand.NewSource(2)), seg, 5)
+	if err := seg.msg.SetRootPtr(root.ToPtr()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := seg.msg.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Find how much a single traversal reads.
+	msg, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := msg.RootPtr()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := copyCanonicalize(p.Struct()); err != nil {
+		t.Fatal("copyCanonicalize:", err)
+	}
+	used := defaultTraverseLimit - msg.rlimit.limit
+
+	// WriteCanonical must fit in the same limit.
+	for _, limit := range []uint64{used, used - 1} {
+		msg, err := Unmarshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.TraverseLimit = limit
+		p, err := msg.RootPtr()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = WriteCanonical(new(bytes.Buffer), p.Struct())
+		if limit == used && err != nil {
+			t.Errorf("WriteCanonical with limit %d: %v", limit, err)
+		}
+		if limit < used && err == nil {
+			t.Errorf("WriteCanonical with limit %d succeeded; want error", limit)
+		}
+	}
+}
+
+func sha256Sum(b []byte) []byte {
+	h := sha256.Sum256(b)
+	return h[:]
+}
+
+// randomStruct builds a random tree of objects in seg, up to depth
+// levels deep, for comparing WriteCanonical against copyCanonicalize.
+func randomStruct(t *testing.T, rng *rand.Rand, seg *Segment, depth int) Struct {
+	sz := ObjectSize{
+		DataSize:     Size(rng.Intn(3)) * wordSize,
+		PointerCount: uint16(rng.Intn(4)),
+	}
+	s, err := NewStruct(seg, sz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fillRandomStruct(t, rng, s, depth)
+	return s
+}
+
+func fillRandomStruct(t *testing.T, rng *rand.Rand, s Struct, depth int) {
+	for off := Size(0); off < s.size.DataSize; off += wordSize {
+		if rng.Intn(2) == 0 {
+			s.SetUint64(DataOffset(off), uint64(rng.Int63()))
+		}
+	}
+	for i := uint16(0); i < s.size.PointerCount; i++ {
+		if depth == 0 || rng.Intn(4) == 0 {
+			continue
+		}
+		if err := s.SetPtr(i, randomPtr(t, rng, s.seg, depth-1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func randomPtr(t *testing.T, rng *rand.Rand, seg *Segment, depth int) Ptr {
+	n := int32(rng.Intn(12))
+	switch rng.Intn(6) {
+	case 0:
+		return randomStruct(t, rng, seg, depth).ToPtr()
+	case 1:
+		b := make([]byte, n)
+		rng.Read(b)
+		l, err := NewData(seg, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l.ToPtr()
+	case 2:
+		l, err := NewBitList(seg, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Garbage in the unused bits must not show up.
+		rng.Read(l.seg.slice(l.off, Size(n+7)/8))
+		return l.ToPtr()
+	case 3:
+		l, err := newPrimitiveList(seg, []Size{0, 2, 4, 8}[rng.Intn(4)], n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sz, _ := l.size.DataSize.times(n)
+		rng.Read(l.seg.slice(l.off, sz))
+		return l.ToPtr()
+	case 4:
+		l, err := NewPointerList(seg, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < l.Len(); i++ {
+			if depth == 0 || rng.Intn(3) == 0 {
+				continue
+			}
+			if err := l.SetPtr(i, randomPtr(t, rng, seg, depth-1)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return l.ToPtr()
+	default:
+		sz := ObjectSize{
+			DataSize:     Size(rng.Intn(3)) * wordSize,
+			PointerCount: uint16(rng.Intn(3)),
+		}
+		l, err := NewCompositeList(seg, sz, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < l.Len(); i++ {
+			fillRandomStruct(t, rng, l.Struct(i), depth)
+		}
+		return l.ToPtr()
+	}
+}
+
+// copyCanonicalize is a reference implementation of Canonicalize that
+// builds the canonical form by copying s into a new message.
+func copyCanonicalize(s Struct) ([]byte, error) {
+	_, seg, _ := NewMessage(SingleSegment(nil))
+	if !s.IsValid() {
+		return seg.Data(), nil
+	}
+	root, err := NewStruct(seg, canonicalStructSize(s))
+	if err != nil {
+		return nil, fmt.Errorf("canonicalize: %v", err)
+	}
+	if err := copySetCanonicalPtr(seg, 0, root.ToPtr()); err != nil {
+		return nil, fmt.Errorf("canonicalize: %v", err)
+	}
+	if err := copyFillCanonicalStruct(root, s); err != nil {
+		return nil, fmt.Errorf("canonicalize: %v", err)
+	}
+	return seg.Data(), nil
+}
+
+// copyCanonicalPtr copies p into dst in canonical form.
+func copyCanonicalPtr(dst *Segment, p Ptr) (Ptr, error) {
+	if !p.IsValid() {
+		return Ptr{}, nil
+	}
+	switch p.flags.ptrType() {
+	case structPtrType:
+		ss, err := NewStruct(dst, canonicalStructSize(p.Struct()))
+		if err != nil {
+			return Ptr{}, err
+		}
+		if err := copyFillCanonicalStruct(ss, p.Struct()); err != nil {
+			return Ptr{}, err
+		}
+		return ss.ToPtr(), nil
+	case listPtrType:
+		ll, err := copyCanonicalList(dst, p.List())
+		if err != nil {
+			return Ptr{}, err
+		}
+		return ll.ToPtr(), nil
+	case interfacePtrType:
+		return Ptr{}, errors.New("cannot canonicalize interface")
+	default:
+		panic("unreachable")
+	}
+}
+
+// copySetCanonicalPtr writes p, which must be in seg, to the pointer at
+// addr.  Empty structs are encoded with an offset of -1 like the
+// reference implementation does, so that they aren't confused with null.
+func copySetCanonicalPtr(seg *Segment, addr Address, p Ptr) error {
+	if p.IsValid() && p.flags.ptrType() == structPtrType && p.size.isZero() {
+		seg.writeRawPointer(addr, rawStructPointer(-1, ObjectSize{}))
+		return nil
+	}
+	return seg.writePtr(copyContext{}, addr, p)
+}
+
+// copyFillCanonicalStruct copies the sections of s into dst, which must
+// have been allocated with at most s's canonical size.  Pointers are
+// copied depth-first in pointer section order.
+func copyFillCanonicalStruct(dst, s Struct) error {
+	n := dst.size.DataSize
+	if s.size.DataSize < n {
+		n = s.size.DataSize
+	}
+	copy(dst.seg.slice(dst.off, n), s.seg.slice(s.off, n))
+	for i := uint16(0); i < dst.size.PointerCount; i++ {
+		p, err := s.Ptr(i)
+		if err != nil {
+			return fmt.Errorf("pointer %d: %v", i, err)
+		}
+		cp, err := copyCanonicalPtr(dst.seg, p)
+		if err != nil {
+			return fmt.Errorf("pointer %d: %v", i, err)
+		}
+		if err := copySetCanonicalPtr(dst.seg, dst.pointerAddress(i), cp); err != nil {
+			return fmt.Errorf("pointer %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// copyCanonicalList copies l into dst in canonical form.
+func copyCanonicalList(dst *Segment, l List) (List, error) {
+	if !l.IsValid() {
+		return List{}, nil
+	}
+	if l.flags&isBitList != 0 {
+		bl, err := NewBitList(dst, l.length)
+		if err != nil {
+			return List{}, err
+		}
+		n := Size(int64(l.length+7) / 8)
+		copy(bl.seg.slice(bl.off, n), l.seg.slice(l.off, n))
+		if r := l.length % 8; r != 0 {
+			// Unused bits in the last byte must be zero.
+			bl.seg.data[bl.off+Address(n)-1] &= byte(1<<uint(r)) - 1
+		}
+		return bl.List, nil
+	}
+	if l.flags&isCompositeList == 0 && l.size.PointerCount == 0 {
+		// Data only, just copy over.
+		sz, _ := l.size.DataSize.times(l.length) // list was already validated
+		cl, err := newPrimitiveList(dst, l.size.DataSize, l.length)
+		if err != nil {
+			return List{}, err
+		}
+		copy(cl.seg.slice(cl.off, sz), l.seg.slice(l.off, sz))
+		return cl, nil
+	}
+	if l.flags&isCompositeList == 0 {
+		cl, err := NewPointerList(dst, l.length)
+		if err != nil {
+			return List{}, err
+		}
+		for i := 0; i < l.Len(); i++ {
+			p, err := (PointerList{l}).PtrAt(i)
+			if err != nil {
+				return List{}, fmt.Errorf("element %d: %v", i, err)
+			}
+			cp, err := copyCanonicalPtr(dst, p)
+			if err != nil {
+				return List{}, fmt.Errorf("element %d: %v", i, err)
+			}
+			addr, _ := cl.elem(i)
+			if err := copySetCanonicalPtr(dst, addr, cp); err != nil {
+				return List{}, fmt.Errorf("element %d: %v", i, err)
+			}
+		}
+		return cl.List, nil
+	}
+
+	// Composite lists use the largest canonical element size.
+	var elemSize ObjectSize
+	for i := 0; i < l.Len(); i++ {
+		sz := canonicalStructSize(l.Struct(i))
+		if sz.DataSize > elemSize.DataSize {
+			elemSize.DataSize = sz.DataSize
+		}
+		if sz.PointerCount > elemSize.PointerCount {
+			elemSize.PointerCount = sz.PointerCount
+		}
+	}
+	cl, err := NewCompositeList(dst, elemSize, l.length)
+	if err != nil {
+		return List{}, err
+	}
+	for i := 0; i < cl.Len(); i++ {
+		if err := copyFillCanonicalStruct(cl.Struct(i), l.Struct(i)); err != nil {
+			return List{}, fmt.Errorf("element %d: %v", i, err)
+		}
+	}
+	return cl, nil
+}
@@ -518,6 +518,7 @@ var (
 var (
 	errOverflow    = errors.New("capnp: address or size overflow")
 	errOutOfBounds = errors.New("capnp: address out of bounds")
+	errElemSize    = errors.New("capnp: list element is smaller than expected")
 	errCopyDepth   = errors.New("capnp: copy depth too large")
 	errOverlap     = errors.New("capnp: overlapping data on copy")
 	errListSize    = errors.New("capnp: invalid list size")
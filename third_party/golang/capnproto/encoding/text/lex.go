@@ -0,0 +1,260 @@
+package text
+
+import (
+	"fmt"
+	"strconv"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokIdent
+	tokNumber
+	tokString
+	tokHexData
+)
+
+type token struct {
+	kind      tokenKind
+	val       []byte // decoded value for strings and data
+	line, col int
+}
+
+func (tok token) String() string {
+	switch tok.kind {
+	case tokEOF:
+		return "end of input"
+	case tokPunct:
+		return fmt.Sprintf("'%s'", tok.val)
+	case tokString:
+		return "string " + strconv.Quote(string(tok.val))
+	case tokHexData:
+		return "data literal"
+	default:
+		return string(tok.val)
+	}
+}
+
+// A scanner splits text format input into tokens.  It is a value type
+// so that the parser can save and restore its position.
+type scanner struct {
+	src       []byte
+	pos       int
+	line, col int
+}
+
+func (sc *scanner) scan() (token, error) {
+	sc.skipSpace()
+	tok := token{line: sc.line, col: sc.col}
+	if sc.pos >= len(sc.src) {
+		tok.kind = tokEOF
+		return tok, nil
+	}
+	c := sc.src[sc.pos]
+	switch {
+	case c == '(' || c == ')' || c == '[' || c == ']' || c == ',' || c == '=':
+		tok.kind = tokPunct
+		tok.val = sc.src[sc.pos : sc.pos+1]
+		sc.advance(1)
+	case c == '"':
+		val, err := sc.scanString()
+		if err != nil {
+			return token{}, err
+		}
+		tok.kind = tokString
+		tok.val = val
+	case c == '0' && sc.pos+2 < len(sc.src) && sc.src[sc.pos+1] == 'x' && sc.src[sc.pos+2] == '"':
+		sc.advance(2)
+		val, err := sc.scanHexData(tok)
+		if err != nil {
+			return token{}, err
+		}
+		tok.kind = tokHexData
+		tok.val = val
+	case isDigit(c) || c == '-':
+		start := sc.pos
+		sc.advance(1)
+		for sc.pos < len(sc.src) {
+			c := sc.src[sc.pos]
+			prev := sc.src[sc.pos-1]
+			if !isIdentChar(c) && c != '.' && !((c == '+' || c == '-') && (prev == 'e' || prev == 'E')) {
+				break
+			}
+			sc.advance(1)
+		}
+		tok.kind = tokNumber
+		tok.val = sc.src[start:sc.pos]
+	case isIdentChar(c):
+		start := sc.pos
+		for sc.pos < len(sc.src) && isIdentChar(sc.src[sc.pos]) {
+			sc.advance(1)
+		}
+		tok.kind = tokIdent
+		tok.val = sc.src[start:sc.pos]
+	default:
+		return token{}, &SyntaxError{Line: tok.line, Col: tok.col, Msg: fmt.Sprintf("unexpected character %q", c)}
+	}
+	return tok, nil
+}
+
+// skipSpace skips whitespace and comments.
+func (sc *scanner) skipSpace() {
+	for sc.pos < len(sc.src) {
+		switch sc.src[sc.pos] {
+		case ' ', '\t', '\r', '\n':
+			sc.advance(1)
+		case '#':
+			for sc.pos < len(sc.src) && sc.src[sc.pos] != '\n' {
+				sc.advance(1)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (sc *scanner) advance(n int) {
+	for ; n > 0; n-- {
+		if sc.src[sc.pos] == '\n' {
+			sc.line++
+			sc.col = 1
+		} else {
+			sc.col++
+		}
+		sc.pos++
+	}
+}
+
+func (sc *scanner) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Line: sc.line, Col: sc.col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// scanString scans a double-quoted string literal and returns its
+// decoded bytes.
+func (sc *scanner) scanString() ([]byte, error) {
+	sc.advance(1)
+	val := []byte{}
+	for {
+		if sc.pos >= len(sc.src) {
+			return nil, sc.errorf("unterminated string")
+		}
+		c := sc.src[sc.pos]
+		switch c {
+		case '"':
+			sc.advance(1)
+			return val, nil
+		case '\n':
+			return nil, sc.errorf("newline in string")
+		case '\\':
+			sc.advance(1)
+			if sc.pos >= len(sc.src) {
+				return nil, sc.errorf("unterminated string")
+			}
+			b, err := sc.scanEscape()
+			if err != nil {
+				return nil, err
+			}
+			val = append(val, b)
+		default:
+			val = append(val, c)
+			sc.advance(1)
+		}
+	}
+}
+
+// scanEscape decodes the escape sequence after a backslash.
+func (sc *scanner) scanEscape() (byte, error) {
+	c := sc.src[sc.pos]
+	simple := map[byte]byte{
+		'a': '\a', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t', 'v': '\v',
+		'\'': '\'', '"': '"', '\\': '\\', '?': '?',
+	}
+	if b, ok := simple[c]; ok {
+		sc.advance(1)
+		return b, nil
+	}
+	switch {
+	case c == 'x':
+		sc.advance(1)
+		var v byte
+		n := 0
+		for ; n < 2 && sc.pos < len(sc.src) && isHexDigit(sc.src[sc.pos]); n++ {
+			v = v<<4 | hexValue(sc.src[sc.pos])
+			sc.advance(1)
+		}
+		if n == 0 {
+			return 0, sc.errorf("\\x not followed by hex digits")
+		}
+		return v, nil
+	case c >= '0' && c <= '7':
+		var v int
+		for n := 0; n < 3 && sc.pos < len(sc.src) && sc.src[sc.pos] >= '0' && sc.src[sc.pos] <= '7'; n++ {
+			v = v<<3 | int(sc.src[sc.pos]-'0')
+			sc.advance(1)
+		}
+		if v > 0xff {
+			return 0, sc.errorf("octal escape out of range")
+		}
+		return byte(v), nil
+	default:
+		return 0, sc.errorf("unknown escape sequence \\%c", c)
+	}
+}
+
+// scanHexData scans the quoted part of a 0x"..." data literal.
+// Whitespace between hex digits is ignored.
+func (sc *scanner) scanHexData(start token) ([]byte, error) {
+	sc.advance(1)
+	var val []byte
+	var digits []byte
+	for {
+		if sc.pos >= len(sc.src) {
+			return nil, &SyntaxError{Line: start.line, Col: start.col, Msg: "unterminated data literal"}
+		}
+		c := sc.src[sc.pos]
+		switch {
+		case c == '"':
+			sc.advance(1)
+			if len(digits)%2 != 0 {
+				return nil, &SyntaxError{Line: start.line, Col: start.col, Msg: "odd number of hex digits in data literal"}
+			}
+			for i := 0; i < len(digits); i += 2 {
+				val = append(val, hexValue(digits[i])<<4|hexValue(digits[i+1]))
+			}
+			if val == nil {
+				val = []byte{}
+			}
+			return val, nil
+		case isHexDigit(c):
+			digits = append(digits, c)
+		case c != ' ' && c != '\t' && c != '\r' && c != '\n':
+			return nil, sc.errorf("invalid character %q in data literal", c)
+		}
+		sc.advance(1)
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentChar(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func hexValue(c byte) byte {
+	switch {
+	case isDigit(c):
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
@@ -32,9 +32,13 @@ func Marshal(typeID uint64, s capnp.Struct) (string, error) {
 
 // An Encoder writes the text format of Cap'n Proto messages to an output stream.
 type Encoder struct {
-	w     errWriter
-	tmp   []byte
-	nodes nodemap.Map
+	w      errWriter
+	tmp    []byte
+	nodes  nodemap.Map
+	indent string
+	depth  int
+
+	singleLiteral bool
 }
 
 // NewEncoder returns a new encoder that writes to w.
@@ -48,6 +52,23 @@ func (enc *Encoder) UseRegistry(reg *schemas.Registry) {
 	enc.nodes.UseRegistry(reg)
 }
 
+// SetIndent makes the encoder write each struct field and each element
+// of a struct list on its own line, indented by indent per level of
+// nesting, with a trailing comma.  Text and data fields that span
+// multiple lines are written as adjacent string literals, one per line.
+// The capnp tool does not accept adjacent string literals, but Decoder
+// does; see SetSingleLiteral.  An empty indent (the default) writes everything on one line.
+func (enc *Encoder) SetIndent(indent string) {
+	enc.indent = indent
+}
+
+// SetSingleLiteral makes the encoder write every text and data field as
+// a single string literal, with newlines escaped, even when indenting.
+// Use it when the output must be read by the capnp tool.
+func (enc *Encoder) SetSingleLiteral(single bool) {
+	enc.singleLiteral = single
+}
+
 // Encode writes the text representation of s to the stream.
 func (enc *Encoder) Encode(typeID uint64, s capnp.Struct) error {
 	if enc.w.err != nil {
@@ -79,13 +100,57 @@ func (enc *Encoder) marshalUint(i uint64) {
 }
 
 func (enc *Encoder) marshalFloat32(f float32) {
-	enc.tmp = strconv.AppendFloat(enc.tmp[:0], float64(f), 'g', -1, 32)
-	enc.w.Write(enc.tmp)
+	enc.marshalFloat(float64(f), 32)
 }
 
 func (enc *Encoder) marshalFloat64(f float64) {
-	enc.tmp = strconv.AppendFloat(enc.tmp[:0], f, 'g', -1, 64)
-	enc.w.Write(enc.tmp)
+	enc.marshalFloat(f, 64)
+}
+
+func (enc *Encoder) marshalFloat(f float64, bitSize int) {
+	switch {
+	case math.IsInf(f, 1):
+		enc.w.WriteString("inf")
+	case math.IsInf(f, -1):
+		enc.w.WriteString("-inf")
+	case math.IsNaN(f):
+		enc.w.WriteString("nan")
+	default:
+		enc.tmp = strconv.AppendFloat(enc.tmp[:0], f, 'g', -1, bitSize)
+		enc.w.Write(enc.tmp)
+	}
+}
+
+// marshalMultilineText writes t as a sequence of adjacent string
+// literals, breaking after each newline, if the encoder is indenting
+// and not limited to single literals.
+func (enc *Encoder) marshalMultilineText(t []byte) {
+	i := bytes.IndexByte(t, '\n')
+	if enc.indent == "" || enc.singleLiteral || i == -1 || i == len(t)-1 {
+		enc.marshalText(t)
+		return
+	}
+	enc.depth++
+	for first := true; len(t) > 0; first = false {
+		if !first {
+			enc.newline()
+		}
+		n := bytes.IndexByte(t, '\n') + 1
+		if n == 0 {
+			n = len(t)
+		}
+		enc.marshalText(t[:n])
+		t = t[n:]
+	}
+	enc.depth--
+}
+
+// newline starts a new line at the current indentation.
+func (enc *Encoder) newline() {
+	enc.w.WriteByte('\n')
+	for i := 0; i < enc.depth; i++ {
+		enc.w.WriteString(enc.indent)
+	}
 }
 
 func (enc *Encoder) marshalText(t []byte) {
@@ -129,7 +194,7 @@ func (enc *Encoder) marshalText(t []byte) {
 }
 
 func needsEscape(b byte) bool {
-	return b < 0x20 || b >= 0x7f
+	return b < 0x20 || b >= 0x7f || b == '"' || b == '\\'
 }
 
 func hexDigit(b byte) byte {
@@ -150,6 +215,7 @@ func (enc *Encoder) marshalStruct(typeID uint64, s capnp.Struct) error {
 		discriminant = s.Uint16(capnp.DataOffset(n.StructNode().DiscriminantOffset() * 2))
 	}
 	enc.w.WriteByte('(')
+	enc.depth++
 	fields := codeOrderFields(n.StructNode())
 	first := true
 	for _, f := range fields {
@@ -159,7 +225,12 @@ func (enc *Encoder) marshalStruct(typeID uint64, s capnp.Struct) error {
 		if dv := f.DiscriminantValue(); !(dv == schema.Field_noDiscriminant || dv == discriminant) {
 			continue
 		}
-		if !first {
+		if enc.indent != "" {
+			if !first {
+				enc.w.WriteByte(',')
+			}
+			enc.newline()
+		} else if !first {
 			enc.w.WriteString(", ")
 		}
 		first = false
@@ -180,6 +251,11 @@ func (enc *Encoder) marshalStruct(typeID uint64, s capnp.Struct) error {
 			}
 		}
 	}
+	enc.depth--
+	if enc.indent != "" && !first {
+		enc.w.WriteByte(',')
+		enc.newline()
+	}
 	enc.w.WriteByte(')')
 	return nil
 }
@@ -260,10 +336,10 @@ func (enc *Encoder) marshalFieldValue(s capnp.Struct, f schema.Field) error {
 		}
 		if !p.IsValid() {
 			b, _ := dv.Data()
-			enc.marshalText(b)
+			enc.marshalMultilineText(b)
 			return nil
 		}
-		enc.marshalText(p.Data())
+		enc.marshalMultilineText(p.Data())
 	case schema.Type_Which_text:
 		p, err := s.Ptr(uint16(f.Slot().Offset()))
 		if err != nil {
@@ -271,10 +347,10 @@ func (enc *Encoder) marshalFieldValue(s capnp.Struct, f schema.Field) error {
 		}
 		if !p.IsValid() {
 			b, _ := dv.TextBytes()
-			enc.marshalText(b)
+			enc.marshalMultilineText(b)
 			return nil
 		}
-		enc.marshalText(p.TextBytes())
+		enc.marshalMultilineText(p.TextBytes())
 	case schema.Type_Which_list:
 		elem, err := typ.List().ElementType()
 		if err != nil {
@@ -436,14 +512,24 @@ func (enc *Encoder) marshalList(elem schema.Type, l capnp.List) error {
 			enc.marshalText(t)
 		}
 	case schema.Type_Which_structType:
+		enc.depth++
 		for i := 0; i < l.Len(); i++ {
-			if i > 0 {
+			if enc.indent != "" {
+				enc.newline()
+			} else if i > 0 {
 				enc.w.WriteString(", ")
 			}
 			err := enc.marshalStruct(elem.StructType().TypeId(), l.Struct(i))
 			if err != nil {
 				return err
 			}
+			if enc.indent != "" {
+				enc.w.WriteByte(',')
+			}
+		}
+		enc.depth--
+		if enc.indent != "" && l.Len() > 0 {
+			enc.newline()
 		}
 	case schema.Type_Which_list:
 		ee, err := elem.List().ElementType()
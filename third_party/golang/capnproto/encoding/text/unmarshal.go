@@ -0,0 +1,760 @@
+package text
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+
+	"github.com/zombiezen/mcm/third_party/golang/capnproto"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto/internal/nodemap"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto/schemas"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto/std/capnp/schema_bootstrap"
+)
+
+// Unmarshal parses the text representation of a struct into s.
+// s must be at least as large as the struct's schema requires.
+func Unmarshal(typeID uint64, s capnp.Struct, text []byte) error {
+	return NewDecoder(bytes.NewReader(text)).Decode(typeID, s)
+}
+
+// A Decoder reads the text format of Cap'n Proto messages from an input stream.
+//
+// The accepted syntax is the value syntax of the Cap'n Proto schema
+// language: parenthesized structs, bracketed lists, string literals,
+// numbers, and enumerant names.  Comments start with '#' and run to the
+// end of the line.  As an extension, adjacent string literals are
+// concatenated, so the multi-line output of an indented Encoder can be
+// read back.
+type Decoder struct {
+	r     io.Reader
+	nodes nodemap.Map
+}
+
+// NewDecoder returns a new decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// UseRegistry changes the registry that the decoder consults for
+// schemas from the default registry.
+func (dec *Decoder) UseRegistry(reg *schemas.Registry) {
+	dec.nodes.UseRegistry(reg)
+}
+
+// Decode reads the rest of the stream as the text representation of a
+// single struct and stores it in s.
+func (dec *Decoder) Decode(typeID uint64, s capnp.Struct) error {
+	data, err := ioutil.ReadAll(dec.r)
+	if err != nil {
+		return err
+	}
+	p := &parser{nodes: &dec.nodes, sc: scanner{src: data, line: 1, col: 1}}
+	if err := p.next(); err != nil {
+		return err
+	}
+	if err := p.parseStruct(typeID, s); err != nil {
+		return err
+	}
+	if p.tok.kind != tokEOF {
+		return p.errorf("unexpected %v after value", p.tok)
+	}
+	return nil
+}
+
+type parser struct {
+	nodes *nodemap.Map
+	sc    scanner
+	tok   token
+}
+
+// next advances to the next token.
+func (p *parser) next() error {
+	tok, err := p.sc.scan()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+// expect consumes a punctuation token.
+func (p *parser) expect(punct byte) error {
+	if p.tok.kind != tokPunct || p.tok.val[0] != punct {
+		return p.errorf("expected '%c', found %v", punct, p.tok)
+	}
+	return p.next()
+}
+
+func (p *parser) isPunct(punct byte) bool {
+	return p.tok.kind == tokPunct && p.tok.val[0] == punct
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Line: p.tok.line, Col: p.tok.col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// parseStruct parses a parenthesized field list into s.
+func (p *parser) parseStruct(typeID uint64, s capnp.Struct) error {
+	n, err := p.findStruct(typeID)
+	if err != nil {
+		return err
+	}
+	if sz := structSize(n); s.Size().DataSize < sz.DataSize || s.Size().PointerCount < sz.PointerCount {
+		name, _ := n.DisplayName()
+		return fmt.Errorf("decode %s: struct is smaller than schema", name)
+	}
+	if err := p.expect('('); err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	var unionField string
+	for !p.isPunct(')') {
+		if p.tok.kind != tokIdent {
+			return p.errorf("expected field name, found %v", p.tok)
+		}
+		name := string(p.tok.val)
+		if seen[name] {
+			return p.errorf("field %s set more than once", name)
+		}
+		seen[name] = true
+		f, ok := findField(n.StructNode(), name)
+		if !ok {
+			dn, _ := n.DisplayName()
+			return p.errorf("%s has no field %s", dn, name)
+		}
+		if dv := f.DiscriminantValue(); dv != schema.Field_noDiscriminant {
+			if unionField != "" {
+				return p.errorf("fields %s and %s are members of the same union", unionField, name)
+			}
+			unionField = name
+			s.SetUint16(capnp.DataOffset(n.StructNode().DiscriminantOffset()*2), dv)
+		}
+		if err := p.next(); err != nil {
+			return err
+		}
+		if err := p.expect('='); err != nil {
+			return err
+		}
+		switch f.Which() {
+		case schema.Field_Which_slot:
+			err = p.parseFieldValue(s, f)
+		case schema.Field_Which_group:
+			err = p.parseStruct(f.Group().TypeId(), s)
+		default:
+			err = p.errorf("field %s has unknown kind %v", name, f.Which())
+		}
+		if err != nil {
+			return err
+		}
+		if p.isPunct(')') {
+			break
+		}
+		if err := p.expect(','); err != nil {
+			return err
+		}
+	}
+	return p.next()
+}
+
+func (p *parser) findStruct(typeID uint64) (schema.Node, error) {
+	n, err := p.nodes.Find(typeID)
+	if err != nil {
+		return schema.Node{}, err
+	}
+	if !n.IsValid() || n.Which() != schema.Node_Which_structNode {
+		return schema.Node{}, fmt.Errorf("cannot find struct type %#x", typeID)
+	}
+	return n, nil
+}
+
+func structSize(n schema.Node) capnp.ObjectSize {
+	return capnp.ObjectSize{
+		DataSize:     capnp.Size(n.StructNode().DataWordCount()) * 8,
+		PointerCount: n.StructNode().PointerCount(),
+	}
+}
+
+func findField(s schema.Node_structNode, name string) (schema.Field, bool) {
+	list, _ := s.Fields()
+	for i := 0; i < list.Len(); i++ {
+		f := list.At(i)
+		if fn, _ := f.Name(); fn == name {
+			return f, true
+		}
+	}
+	return schema.Field{}, false
+}
+
+func (p *parser) parseFieldValue(s capnp.Struct, f schema.Field) error {
+	typ, err := f.Slot().Type()
+	if err != nil {
+		return err
+	}
+	dv, err := f.Slot().DefaultValue()
+	if err != nil {
+		return err
+	}
+	if dv.IsValid() && int(typ.Which()) != int(dv.Which()) {
+		name, _ := f.Name()
+		return fmt.Errorf("unmarshal field %s: default value is a %v, want %v", name, dv.Which(), typ.Which())
+	}
+	off := f.Slot().Offset()
+	switch typ.Which() {
+	case schema.Type_Which_void:
+		return p.parseVoid()
+	case schema.Type_Which_bool:
+		v, err := p.parseBool()
+		if err != nil {
+			return err
+		}
+		s.SetBit(capnp.BitOffset(off), v != dv.Bool())
+	case schema.Type_Which_int8:
+		v, err := p.parseInt(8)
+		if err != nil {
+			return err
+		}
+		s.SetUint8(capnp.DataOffset(off), uint8(v)^uint8(dv.Int8()))
+	case schema.Type_Which_int16:
+		v, err := p.parseInt(16)
+		if err != nil {
+			return err
+		}
+		s.SetUint16(capnp.DataOffset(off*2), uint16(v)^uint16(dv.Int16()))
+	case schema.Type_Which_int32:
+		v, err := p.parseInt(32)
+		if err != nil {
+			return err
+		}
+		s.SetUint32(capnp.DataOffset(off*4), uint32(v)^uint32(dv.Int32()))
+	case schema.Type_Which_int64:
+		v, err := p.parseInt(64)
+		if err != nil {
+			return err
+		}
+		s.SetUint64(capnp.DataOffset(off*8), uint64(v)^uint64(dv.Int64()))
+	case schema.Type_Which_uint8:
+		v, err := p.parseUint(8)
+		if err != nil {
+			return err
+		}
+		s.SetUint8(capnp.DataOffset(off), uint8(v)^dv.Uint8())
+	case schema.Type_Which_uint16:
+		v, err := p.parseUint(16)
+		if err != nil {
+			return err
+		}
+		s.SetUint16(capnp.DataOffset(off*2), uint16(v)^dv.Uint16())
+	case schema.Type_Which_uint32:
+		v, err := p.parseUint(32)
+		if err != nil {
+			return err
+		}
+		s.SetUint32(capnp.DataOffset(off*4), uint32(v)^dv.Uint32())
+	case schema.Type_Which_uint64:
+		v, err := p.parseUint(64)
+		if err != nil {
+			return err
+		}
+		s.SetUint64(capnp.DataOffset(off*8), v^dv.Uint64())
+	case schema.Type_Which_float32:
+		v, err := p.parseFloat(32)
+		if err != nil {
+			return err
+		}
+		s.SetUint32(capnp.DataOffset(off*4), math.Float32bits(float32(v))^math.Float32bits(dv.Float32()))
+	case schema.Type_Which_float64:
+		v, err := p.parseFloat(64)
+		if err != nil {
+			return err
+		}
+		s.SetUint64(capnp.DataOffset(off*8), math.Float64bits(v)^math.Float64bits(dv.Float64()))
+	case schema.Type_Which_enum:
+		v, err := p.parseEnum(typ.Enum().TypeId())
+		if err != nil {
+			return err
+		}
+		s.SetUint16(capnp.DataOffset(off*2), v^dv.Uint16())
+	case schema.Type_Which_text, schema.Type_Which_data, schema.Type_Which_structType, schema.Type_Which_list:
+		ptr, err := p.parsePointer(s.Segment(), typ)
+		if err != nil {
+			return err
+		}
+		return s.SetPtr(uint16(off), ptr)
+	case schema.Type_Which_interface, schema.Type_Which_anyPointer:
+		name, _ := f.Name()
+		return p.errorf("cannot decode field %s of type %v", name, typ.Which())
+	default:
+		return fmt.Errorf("unknown field type %v", typ.Which())
+	}
+	return nil
+}
+
+// parsePointer parses a text, data, struct, or list value into a new
+// object in seg.
+func (p *parser) parsePointer(seg *capnp.Segment, typ schema.Type) (capnp.Ptr, error) {
+	switch typ.Which() {
+	case schema.Type_Which_text:
+		b, err := p.parseBytes(false)
+		if err != nil {
+			return capnp.Ptr{}, err
+		}
+		t, err := capnp.NewTextFromBytes(seg, b)
+		if err != nil {
+			return capnp.Ptr{}, err
+		}
+		return t.ToPtr(), nil
+	case schema.Type_Which_data:
+		b, err := p.parseBytes(true)
+		if err != nil {
+			return capnp.Ptr{}, err
+		}
+		d, err := capnp.NewData(seg, b)
+		if err != nil {
+			return capnp.Ptr{}, err
+		}
+		return d.ToPtr(), nil
+	case schema.Type_Which_structType:
+		n, err := p.findStruct(typ.StructType().TypeId())
+		if err != nil {
+			return capnp.Ptr{}, err
+		}
+		st, err := capnp.NewStruct(seg, structSize(n))
+		if err != nil {
+			return capnp.Ptr{}, err
+		}
+		if err := p.parseStruct(n.Id(), st); err != nil {
+			return capnp.Ptr{}, err
+		}
+		return st.ToPtr(), nil
+	case schema.Type_Which_list:
+		elem, err := typ.List().ElementType()
+		if err != nil {
+			return capnp.Ptr{}, err
+		}
+		l, err := p.parseList(seg, elem)
+		if err != nil {
+			return capnp.Ptr{}, err
+		}
+		return l.ToPtr(), nil
+	default:
+		return capnp.Ptr{}, fmt.Errorf("%v is not a pointer type", typ.Which())
+	}
+}
+
+// parseList parses a bracketed list.  Elements are parsed before the
+// list is allocated, since the list's length must be known up front.
+// Struct lists are the exception: they are counted first and their
+// elements are parsed in place, so no scratch objects are left behind
+// in the message.
+func (p *parser) parseList(seg *capnp.Segment, elem schema.Type) (capnp.List, error) {
+	if elem.Which() == schema.Type_Which_structType {
+		return p.parseStructList(seg, elem.StructType().TypeId())
+	}
+	if err := p.expect('['); err != nil {
+		return capnp.List{}, err
+	}
+	var vals []interface{}
+	for !p.isPunct(']') {
+		v, err := p.parseElem(seg, elem)
+		if err != nil {
+			return capnp.List{}, err
+		}
+		vals = append(vals, v)
+		if p.isPunct(']') {
+			break
+		}
+		if err := p.expect(','); err != nil {
+			return capnp.List{}, err
+		}
+	}
+	if err := p.next(); err != nil {
+		return capnp.List{}, err
+	}
+	return newList(seg, elem, vals)
+}
+
+func (p *parser) parseStructList(seg *capnp.Segment, typeID uint64) (capnp.List, error) {
+	n, err := p.findStruct(typeID)
+	if err != nil {
+		return capnp.List{}, err
+	}
+	if !p.isPunct('[') {
+		return capnp.List{}, p.errorf("expected '[', found %v", p.tok)
+	}
+	count, err := p.countElems()
+	if err != nil {
+		return capnp.List{}, err
+	}
+	l, err := capnp.NewCompositeList(seg, structSize(n), int32(count))
+	if err != nil {
+		return capnp.List{}, err
+	}
+	if err := p.next(); err != nil {
+		return capnp.List{}, err
+	}
+	for i := 0; i < count; i++ {
+		if err := p.parseStruct(typeID, l.Struct(i)); err != nil {
+			return capnp.List{}, err
+		}
+		if i < count-1 || p.isPunct(',') {
+			if err := p.expect(','); err != nil {
+				return capnp.List{}, err
+			}
+		}
+	}
+	if err := p.expect(']'); err != nil {
+		return capnp.List{}, err
+	}
+	return l, nil
+}
+
+// countElems counts the elements in the list starting at the current
+// '[' token without consuming any input.
+func (p *parser) countElems() (int, error) {
+	sc, tok := p.sc, p.tok
+	defer func() { p.sc, p.tok = sc, tok }()
+	depth, count, empty := 0, 0, true
+	for {
+		if err := p.next(); err != nil {
+			return 0, err
+		}
+		switch {
+		case p.tok.kind == tokEOF:
+			return 0, &SyntaxError{Line: tok.line, Col: tok.col, Msg: "unterminated list"}
+		case p.isPunct('(') || p.isPunct('['):
+			depth++
+		case p.isPunct(')') || p.isPunct(']'):
+			if depth == 0 {
+				if !empty {
+					count++
+				}
+				return count, nil
+			}
+			depth--
+		case p.isPunct(',') && depth == 0:
+			count++
+			empty = true
+			continue
+		}
+		empty = false
+	}
+}
+
+func (p *parser) parseElem(seg *capnp.Segment, elem schema.Type) (interface{}, error) {
+	switch elem.Which() {
+	case schema.Type_Which_void:
+		return nil, p.parseVoid()
+	case schema.Type_Which_bool:
+		return p.parseBool()
+	case schema.Type_Which_int8:
+		return p.parseInt(8)
+	case schema.Type_Which_int16:
+		return p.parseInt(16)
+	case schema.Type_Which_int32:
+		return p.parseInt(32)
+	case schema.Type_Which_int64:
+		return p.parseInt(64)
+	case schema.Type_Which_uint8:
+		return p.parseUint(8)
+	case schema.Type_Which_uint16:
+		return p.parseUint(16)
+	case schema.Type_Which_uint32:
+		return p.parseUint(32)
+	case schema.Type_Which_uint64:
+		return p.parseUint(64)
+	case schema.Type_Which_float32:
+		return p.parseFloat(32)
+	case schema.Type_Which_float64:
+		return p.parseFloat(64)
+	case schema.Type_Which_enum:
+		return p.parseEnum(elem.Enum().TypeId())
+	case schema.Type_Which_text:
+		return p.parseBytes(false)
+	case schema.Type_Which_data:
+		return p.parseBytes(true)
+	case schema.Type_Which_list:
+		ee, err := elem.List().ElementType()
+		if err != nil {
+			return nil, err
+		}
+		return p.parseList(seg, ee)
+	case schema.Type_Which_interface, schema.Type_Which_anyPointer:
+		return nil, p.errorf("cannot decode list element of type %v", elem.Which())
+	default:
+		return nil, fmt.Errorf("unknown list type %v", elem.Which())
+	}
+}
+
+func newList(seg *capnp.Segment, elem schema.Type, vals []interface{}) (capnp.List, error) {
+	n := int32(len(vals))
+	switch elem.Which() {
+	case schema.Type_Which_void:
+		return capnp.NewVoidList(seg, n).List, nil
+	case schema.Type_Which_bool:
+		l, err := capnp.NewBitList(seg, n)
+		for i, v := range vals {
+			if err == nil {
+				l.Set(i, v.(bool))
+			}
+		}
+		return l.List, err
+	case schema.Type_Which_int8:
+		l, err := capnp.NewInt8List(seg, n)
+		for i, v := range vals {
+			if err == nil {
+				l.Set(i, int8(v.(int64)))
+			}
+		}
+		return l.List, err
+	case schema.Type_Which_int16:
+		l, err := capnp.NewInt16List(seg, n)
+		for i, v := range vals {
+			if err == nil {
+				l.Set(i, int16(v.(int64)))
+			}
+		}
+		return l.List, err
+	case schema.Type_Which_int32:
+		l, err := capnp.NewInt32List(seg, n)
+		for i, v := range vals {
+			if err == nil {
+				l.Set(i, int32(v.(int64)))
+			}
+		}
+		return l.List, err
+	case schema.Type_Which_int64:
+		l, err := capnp.NewInt64List(seg, n)
+		for i, v := range vals {
+			if err == nil {
+				l.Set(i, v.(int64))
+			}
+		}
+		return l.List, err
+	case schema.Type_Which_uint8:
+		l, err := capnp.NewUInt8List(seg, n)
+		for i, v := range vals {
+			if err == nil {
+				l.Set(i, uint8(v.(uint64)))
+			}
+		}
+		return l.List, err
+	case schema.Type_Which_uint16:
+		l, err := capnp.NewUInt16List(seg, n)
+		for i, v := range vals {
+			if err == nil {
+				l.Set(i, uint16(v.(uint64)))
+			}
+		}
+		return l.List, err
+	case schema.Type_Which_uint32:
+		l, err := capnp.NewUInt32List(seg, n)
+		for i, v := range vals {
+			if err == nil {
+				l.Set(i, uint32(v.(uint64)))
+			}
+		}
+		return l.List, err
+	case schema.Type_Which_uint64:
+		l, err := capnp.NewUInt64List(seg, n)
+		for i, v := range vals {
+			if err == nil {
+				l.Set(i, v.(uint64))
+			}
+		}
+		return l.List, err
+	case schema.Type_Which_float32:
+		l, err := capnp.NewFloat32List(seg, n)
+		for i, v := range vals {
+			if err == nil {
+				l.Set(i, float32(v.(float64)))
+			}
+		}
+		return l.List, err
+	case schema.Type_Which_float64:
+		l, err := capnp.NewFloat64List(seg, n)
+		for i, v := range vals {
+			if err == nil {
+				l.Set(i, v.(float64))
+			}
+		}
+		return l.List, err
+	case schema.Type_Which_enum:
+		l, err := capnp.NewUInt16List(seg, n)
+		for i, v := range vals {
+			if err == nil {
+				l.Set(i, v.(uint16))
+			}
+		}
+		return l.List, err
+	case schema.Type_Which_text:
+		l, err := capnp.NewPointerList(seg, n)
+		if err != nil {
+			return capnp.List{}, err
+		}
+		for i, v := range vals {
+			t, err := capnp.NewTextFromBytes(seg, v.([]byte))
+			if err != nil {
+				return capnp.List{}, err
+			}
+			if err := l.SetPtr(i, t.ToPtr()); err != nil {
+				return capnp.List{}, err
+			}
+		}
+		return l.List, nil
+	case schema.Type_Which_data:
+		l, err := capnp.NewPointerList(seg, n)
+		if err != nil {
+			return capnp.List{}, err
+		}
+		for i, v := range vals {
+			d, err := capnp.NewData(seg, v.([]byte))
+			if err != nil {
+				return capnp.List{}, err
+			}
+			if err := l.SetPtr(i, d.ToPtr()); err != nil {
+				return capnp.List{}, err
+			}
+		}
+		return l.List, nil
+	case schema.Type_Which_list:
+		l, err := capnp.NewPointerList(seg, n)
+		if err != nil {
+			return capnp.List{}, err
+		}
+		for i, v := range vals {
+			if err := l.SetPtr(i, v.(capnp.List).ToPtr()); err != nil {
+				return capnp.List{}, err
+			}
+		}
+		return l.List, nil
+	default:
+		return capnp.List{}, fmt.Errorf("unknown list type %v", elem.Which())
+	}
+}
+
+func (p *parser) parseVoid() error {
+	if p.tok.kind != tokIdent || string(p.tok.val) != voidMarker {
+		return p.errorf("expected void, found %v", p.tok)
+	}
+	return p.next()
+}
+
+func (p *parser) parseBool() (bool, error) {
+	if p.tok.kind == tokIdent {
+		switch string(p.tok.val) {
+		case "true":
+			return true, p.next()
+		case "false":
+			return false, p.next()
+		}
+	}
+	return false, p.errorf("expected bool, found %v", p.tok)
+}
+
+func (p *parser) parseInt(bits int) (int64, error) {
+	if p.tok.kind != tokNumber {
+		return 0, p.errorf("expected integer, found %v", p.tok)
+	}
+	i, err := strconv.ParseInt(string(p.tok.val), 0, bits)
+	if err != nil {
+		return 0, p.errorf("invalid int%d %s", bits, p.tok.val)
+	}
+	return i, p.next()
+}
+
+func (p *parser) parseUint(bits int) (uint64, error) {
+	if p.tok.kind != tokNumber {
+		return 0, p.errorf("expected integer, found %v", p.tok)
+	}
+	i, err := strconv.ParseUint(string(p.tok.val), 0, bits)
+	if err != nil {
+		return 0, p.errorf("invalid uint%d %s", bits, p.tok.val)
+	}
+	return i, p.next()
+}
+
+func (p *parser) parseFloat(bits int) (float64, error) {
+	var f float64
+	switch {
+	case p.tok.kind == tokIdent && string(p.tok.val) == "inf":
+		f = math.Inf(1)
+	case p.tok.kind == tokIdent && string(p.tok.val) == "nan":
+		f = math.NaN()
+	case p.tok.kind == tokNumber && string(p.tok.val) == "-inf":
+		f = math.Inf(-1)
+	case p.tok.kind == tokNumber:
+		var err error
+		f, err = strconv.ParseFloat(string(p.tok.val), bits)
+		if err != nil {
+			return 0, p.errorf("invalid float%d %s", bits, p.tok.val)
+		}
+	default:
+		return 0, p.errorf("expected number, found %v", p.tok)
+	}
+	return f, p.next()
+}
+
+func (p *parser) parseEnum(typeID uint64) (uint16, error) {
+	if p.tok.kind == tokNumber {
+		v, err := p.parseUint(16)
+		return uint16(v), err
+	}
+	if p.tok.kind != tokIdent {
+		return 0, p.errorf("expected enumerant, found %v", p.tok)
+	}
+	n, err := p.nodes.Find(typeID)
+	if err != nil {
+		return 0, err
+	}
+	if n.Which() != schema.Node_Which_enum {
+		return 0, fmt.Errorf("unmarshaling enum of type @%#x: type is not an enum", typeID)
+	}
+	enums, err := n.Enum().Enumerants()
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < enums.Len(); i++ {
+		name, err := enums.At(i).NameBytes()
+		if err != nil {
+			return 0, err
+		}
+		if bytes.Equal(name, p.tok.val) {
+			return uint16(i), p.next()
+		}
+	}
+	dn, _ := n.DisplayName()
+	return 0, p.errorf("%s has no enumerant %s", dn, p.tok.val)
+}
+
+// parseBytes parses one or more adjacent string literals.  If allowHex
+// is true, then hexadecimal data literals are also accepted.
+func (p *parser) parseBytes(allowHex bool) ([]byte, error) {
+	if p.tok.kind != tokString && !(allowHex && p.tok.kind == tokHexData) {
+		return nil, p.errorf("expected string, found %v", p.tok)
+	}
+	var b []byte
+	for p.tok.kind == tokString || allowHex && p.tok.kind == tokHexData {
+		b = append(b, p.tok.val...)
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if b == nil {
+		b = []byte{}
+	}
+	return b, nil
+}
+
+// A SyntaxError is returned by Decoder when the input is not a valid
+// value of the requested type.
+type SyntaxError struct {
+	Line, Col int
+	Msg       string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Col, e.Msg)
+}
@@ -0,0 +1,227 @@
+package text
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/zombiezen/mcm/third_party/golang/capnproto"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto/schemas"
+)
+
+const (
+	keyValueType = 0x8df8bc5abdc060a6
+	valueType    = 0xd3602730c572a43b
+)
+
+func testRegistry(t *testing.T) *schemas.Registry {
+	data, err := readTestFile("txt.capnp.out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := new(schemas.Registry)
+	err = reg.Register(&schemas.Schema{
+		Bytes: data,
+		Nodes: []uint64{keyValueType, valueType},
+	})
+	if err != nil {
+		t.Fatalf("Adding to registry: %v", err)
+	}
+	return reg
+}
+
+// decode decodes text into the root struct of a new message.
+func decode(reg *schemas.Registry, typeID uint64, text string) (capnp.Struct, error) {
+	_, seg, err := capnp.NewMessage(capnp.SingleSegment(nil))
+	if err != nil {
+		return capnp.Struct{}, err
+	}
+	// Large enough for both test structs.
+	s, err := capnp.NewRootStruct(seg, capnp.ObjectSize{DataSize: 16, PointerCount: 2})
+	if err != nil {
+		return capnp.Struct{}, err
+	}
+	dec := NewDecoder(strings.NewReader(text))
+	dec.UseRegistry(reg)
+	return s, dec.Decode(typeID, s)
+}
+
+func encode(reg *schemas.Registry, typeID uint64, s capnp.Struct, indent string) (string, error) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	enc.UseRegistry(reg)
+	enc.SetIndent(indent)
+	if err := enc.Encode(typeID, s); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		typeID uint64
+		text   string
+		want   string
+	}{
+		{keyValueType, `(key = "42", value = (int32 = -123))`, `(key = "42", value = (int32 = -123))`},
+		{keyValueType, `(value = (int32 = -123), key = "42")`, `(key = "42", value = (int32 = -123))`},
+		{keyValueType, `()`, `(key = "", value = (void = void))`},
+		{keyValueType, "# comment\n( key = \"float\" , value=(float64=3.14) , )\n", `(key = "float", value = (float64 = 3.14))`},
+		{valueType, `(bool = true)`, `(bool = true)`},
+		{valueType, `(int8 = -128)`, `(int8 = -128)`},
+		{valueType, `(uint16 = 0xffff)`, `(uint16 = 65535)`},
+		{valueType, `(uint64 = 18446744073709551615)`, `(uint64 = 18446744073709551615)`},
+		{valueType, `(float32 = -inf)`, `(float32 = -inf)`},
+		{valueType, `(float64 = nan)`, `(float64 = nan)`},
+		{valueType, `(float64 = 1e-3)`, `(float64 = 0.001)`},
+		{valueType, `(text = "a\tb\x00\"\101")`, `(text = "a\tb\x00\"A")`},
+		{valueType, `(text = "foo\n" "bar\n")`, `(text = "foo\nbar\n")`},
+		{valueType, `(data = "Hi\xde\xad\xbe\xef\xca\xfe")`, `(data = "Hi\xde\xad\xbe\xef\xca\xfe")`},
+		{valueType, `(data = 0x"4869 dead beef cafe")`, `(data = "Hi\xde\xad\xbe\xef\xca\xfe")`},
+		{valueType, `(cheese = gouda)`, `(cheese = gouda)`},
+		{valueType, `(map = [(key = "foo", value = (void = void)), (key = "bar"),])`, `(map = [(key = "foo", value = (void = void)), (key = "bar", value = (void = void))])`},
+		{valueType, `(map = [])`, `(map = [])`},
+		{valueType, `(map = [(key = "a", value = (map = [(key = "b")]))])`, `(map = [(key = "a", value = (map = [(key = "b", value = (void = void))]))])`},
+		{valueType, `(voidList = [void, void])`, `(voidList = [void, void])`},
+		{valueType, `(boolList = [true, false, true, false])`, `(boolList = [true, false, true, false])`},
+		{valueType, `(int8List = [1, -2, 3])`, `(int8List = [1, -2, 3])`},
+		{valueType, `(int64List = [1, -2, 3])`, `(int64List = [1, -2, 3])`},
+		{valueType, `(uint8List = [255, 0, 1])`, `(uint8List = [255, 0, 1])`},
+		{valueType, `(float32List = [0.5, 3.14, -2.0])`, `(float32List = [0.5, 3.14, -2])`},
+		{valueType, `(textList = ["foo", "bar", "baz"])`, `(textList = ["foo", "bar", "baz"])`},
+		{valueType, `(dataList = [0x"deadbeef", "\xca\xfe"])`, `(dataList = ["\xde\xad\xbe\xef", "\xca\xfe"])`},
+		{valueType, `(cheeseList = [gouda, cheddar, 1])`, `(cheeseList = [gouda, cheddar, gouda])`},
+		{valueType, `(matrix = [[1, 2, 3], [4, 5, 6]])`, `(matrix = [[1, 2, 3], [4, 5, 6]])`},
+	}
+	reg := testRegistry(t)
+	for _, test := range tests {
+		s, err := decode(reg, test.typeID, test.text)
+		if err != nil {
+			t.Errorf("Decode(%#x, %q): %v", test.typeID, test.text, err)
+			continue
+		}
+		got, err := encode(reg, test.typeID, s, "")
+		if err != nil {
+			t.Errorf("Encode(Decode(%#x, %q)): %v", test.typeID, test.text, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Encode(Decode(%#x, %q)) = %q; want %q", test.typeID, test.text, got, test.want)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		err  string
+	}{
+		{``, `1:1: expected '(', found end of input`},
+		{`(key = "a") extra`, `1:13: unexpected extra after value`},
+		{`(key = "a"`, `1:11: expected ',', found end of input`},
+		{`(nope = 1)`, `1:2: txt.capnp:KeyValue has no field nope`},
+		{`(key = "a", key = "b")`, `1:13: field key set more than once`},
+		{"(key = \"a\nb\")", `1:10: newline in string`},
+		{`(key = "\q")`, `1:10: unknown escape sequence \q`},
+		{`(key = 1)`, `1:8: expected string, found 1`},
+		{`(value = (int8 = 128))`, `1:18: invalid int8 128`},
+		{`(value = (bool = true, int8 = 1))`, `1:24: fields bool and int8 are members of the same union`},
+		{`(value = (cheese = brie))`, `1:20: txt.capnp:Cheese has no enumerant brie`},
+		{`(value = (data = 0x"abc"))`, `1:18: odd number of hex digits in data literal`},
+		{`(value = (map = [(key = "a"`, `1:17: unterminated list`},
+		{`(value = (void = void)) $`, `1:25: unexpected character '$'`},
+	}
+	reg := testRegistry(t)
+	for _, test := range tests {
+		_, err := decode(reg, keyValueType, test.text)
+		if err == nil {
+			t.Errorf("Decode(%q) succeeded; want error %q", test.text, test.err)
+			continue
+		}
+		if err.Error() != test.err {
+			t.Errorf("Decode(%q) = %q; want %q", test.text, err.Error(), test.err)
+		}
+	}
+}
+
+func TestEncodeIndent(t *testing.T) {
+	tests := []struct {
+		typeID uint64
+		text   string
+		want   string
+	}{
+		{keyValueType, `()`, "(\n\tkey = \"\",\n\tvalue = (\n\t\tvoid = void,\n\t),\n)"},
+		{valueType, `(map = [])`, "(\n\tmap = [],\n)"},
+		{
+			valueType,
+			`(map = [(key = "foo"), (key = "bar", value = (text = "one\ntwo\nthree"))])`,
+			"(\n" +
+				"\tmap = [\n" +
+				"\t\t(\n" +
+				"\t\t\tkey = \"foo\",\n" +
+				"\t\t\tvalue = (\n" +
+				"\t\t\t\tvoid = void,\n" +
+				"\t\t\t),\n" +
+				"\t\t),\n" +
+				"\t\t(\n" +
+				"\t\t\tkey = \"bar\",\n" +
+				"\t\t\tvalue = (\n" +
+				"\t\t\t\ttext = \"one\\n\"\n" +
+				"\t\t\t\t\t\"two\\n\"\n" +
+				"\t\t\t\t\t\"three\",\n" +
+				"\t\t\t),\n" +
+				"\t\t),\n" +
+				"\t],\n" +
+				")",
+		},
+		{valueType, `(text = "one line\n")`, "(\n\ttext = \"one line\\n\",\n)"},
+		{valueType, `(textList = ["a\nb", "c"])`, "(\n\ttextList = [\"a\\nb\", \"c\"],\n)"},
+	}
+	reg := testRegistry(t)
+	for _, test := range tests {
+		s, err := decode(reg, test.typeID, test.text)
+		if err != nil {
+			t.Errorf("Decode(%#x, %q): %v", test.typeID, test.text, err)
+			continue
+		}
+		got, err := encode(reg, test.typeID, s, "\t")
+		if err != nil {
+			t.Errorf("Encode(%#x, %q): %v", test.typeID, test.text, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("indented Encode(%#x, %q) = %q; want %q", test.typeID, test.text, got, test.want)
+			continue
+		}
+		// Indented output must decode to the same value.
+		s2, err := decode(reg, test.typeID, got)
+		if err != nil {
+			t.Errorf("Decode(%#x, %q): %v", test.typeID, got, err)
+			continue
+		}
+		want, _ := encode(reg, test.typeID, s, "")
+		if round, _ := encode(reg, test.typeID, s2, ""); round != want {
+			t.Errorf("Decode(%#x, %q) = %s; want %s", test.typeID, got, round, want)
+		}
+	}
+}
+
+func TestEncodeSingleLiteral(t *testing.T) {
+	reg := testRegistry(t)
+	s, err := decode(reg, valueType, `(text = "one\ntwo\nthree")`)
+	if err != nil {
+		t.Fatal("Decode:", err)
+	}
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	enc.UseRegistry(reg)
+	enc.SetIndent("\t")
+	enc.SetSingleLiteral(true)
+	if err := enc.Encode(valueType, s); err != nil {
+		t.Fatal("Encode:", err)
+	}
+	const want = "(\n\ttext = \"one\\ntwo\\nthree\",\n)"
+	if got := buf.String(); got != want {
+		t.Errorf("Encode with SetSingleLiteral(true) = %q; want %q", got, want)
+	}
+}
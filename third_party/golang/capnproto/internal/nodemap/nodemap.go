@@ -26,9 +26,16 @@ func (m *Map) UseRegistry(reg *schemas.Registry) {
 	m.nodes = make(map[uint64]schema.Node)
 }
 
-// Find returns the node for the given ID.
+// traverseLimit is the read limit given to each call to Find.
+const traverseLimit = 64 << 20
+
+// Find returns the node for the given ID.  The nodes are cached and
+// read again on every lookup, so each call resets the read limit of
+// the node's message: otherwise a long-lived Map would eventually
+// exhaust it.
 func (m *Map) Find(id uint64) (schema.Node, error) {
 	if n := m.nodes[id]; n.IsValid() {
+		n.Segment().Message().ReadLimiter().Reset(traverseLimit)
 		return n, nil
 	}
 	data, err := m.registry().Find(id)
@@ -39,6 +46,7 @@ func (m *Map) Find(id uint64) (schema.Node, error) {
 	if err != nil {
 		return schema.Node{}, err
 	}
+	msg.TraverseLimit = traverseLimit
 	req, err := schema.ReadRootCodeGeneratorRequest(msg)
 	if err != nil {
 		return schema.Node{}, err
@@ -54,5 +62,6 @@ func (m *Map) Find(id uint64) (schema.Node, error) {
 		n := nodes.At(i)
 		m.nodes[n.Id()] = n
 	}
+	msg.ReadLimiter().Reset(traverseLimit)
 	return m.nodes[id], nil
 }
@@ -0,0 +1,58 @@
+package nodemap
+
+import (
+	"testing"
+
+	"github.com/zombiezen/mcm/third_party/golang/capnproto"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto/schemas"
+	"github.com/zombiezen/mcm/third_party/golang/capnproto/std/capnp/schema_bootstrap"
+)
+
+func TestFindResetsReadLimit(t *testing.T) {
+	const id = 0xdeadbeef
+	msg, seg, err := capnp.NewMessage(capnp.SingleSegment(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := schema.NewRootCodeGeneratorRequest(seg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := req.NewNodes(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes.At(0).SetId(id)
+	if err := nodes.At(0).SetDisplayName("foo.capnp:Foo"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := msg.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := new(schemas.Registry)
+	if err := reg.Register(&schemas.Schema{Bytes: data, Nodes: []uint64{id}}); err != nil {
+		t.Fatal(err)
+	}
+	m := new(Map)
+	m.UseRegistry(reg)
+
+	n, err := m.Find(id)
+	if err != nil {
+		t.Fatal("first Find:", err)
+	}
+	// Simulate a long-lived program that has read the node many times.
+	n.Segment().Message().ReadLimiter().Reset(0)
+	if _, err := n.DisplayName(); err == nil {
+		t.Fatal("DisplayName with an exhausted read limit succeeded")
+	}
+	n, err = m.Find(id)
+	if err != nil {
+		t.Fatal("second Find:", err)
+	}
+	if name, err := n.DisplayName(); err != nil {
+		t.Errorf("DisplayName after second Find: %v", err)
+	} else if name != "foo.capnp:Foo" {
+		t.Errorf("DisplayName after second Find = %q; want \"foo.capnp:Foo\"", name)
+	}
+}
@@ -185,6 +185,31 @@ func (p List) elem(i int) (addr Address, sz Size) {
 	return addr, sz
 }
 
+// primitiveElem returns the address of the i'th element, reading it
+// as an element of the expected size.  It reports false if the list's
+// elements are smaller than expected, which only happens for malformed
+// messages.
+func (p List) primitiveElem(i int, expected ObjectSize) (Address, bool) {
+	if p.seg == nil || i < 0 || i >= int(p.length) {
+		panic(errOutOfBounds)
+	}
+	if p.flags&isBitList != 0 || p.size.DataSize < expected.DataSize || p.size.PointerCount < expected.PointerCount {
+		return 0, false
+	}
+	addr, ok := p.off.element(int32(i), p.size.totalSize())
+	if !ok {
+		return 0, false
+	}
+	if expected.PointerCount > 0 {
+		// The pointer section follows the data section.
+		addr, ok = addr.addSize(p.size.DataSize)
+		if !ok {
+			return 0, false
+		}
+	}
+	return addr, true
+}
+
 func (p List) slice(i int) []byte {
 	addr, sz := p.elem(i)
 	return p.seg.slice(addr, sz)
@@ -285,7 +310,10 @@ func (p PointerList) At(i int) (Pointer, error) {
 
 // PtrAt returns the i'th pointer in the list.
 func (p PointerList) PtrAt(i int) (Ptr, error) {
-	addr, _ := p.elem(i)
+	addr, ok := p.primitiveElem(i, ObjectSize{PointerCount: 1})
+	if !ok {
+		return Ptr{}, errElemSize
+	}
 	return p.seg.readPtr(addr, p.depthLimit)
 }
 
@@ -314,7 +342,10 @@ func NewTextList(s *Segment, n int32) (TextList, error) {
 
 // At returns the i'th string in the list.
 func (l TextList) At(i int) (string, error) {
-	addr, _ := l.elem(i)
+	addr, ok := l.primitiveElem(i, ObjectSize{PointerCount: 1})
+	if !ok {
+		return "", errElemSize
+	}
 	p, err := l.seg.readPtr(addr, l.depthLimit)
 	if err != nil {
 		return "", err
@@ -325,7 +356,10 @@ func (l TextList) At(i int) (string, error) {
 // BytesAt returns the i'th element in the list as a byte slice.
 // The underlying array of the slice is the segment data.
 func (l TextList) BytesAt(i int) ([]byte, error) {
-	addr, _ := l.elem(i)
+	addr, ok := l.primitiveElem(i, ObjectSize{PointerCount: 1})
+	if !ok {
+		return nil, errElemSize
+	}
 	p, err := l.seg.readPtr(addr, l.depthLimit)
 	if err != nil {
 		return nil, err
@@ -360,7 +394,10 @@ func NewDataList(s *Segment, n int32) (DataList, error) {
 
 // At returns the i'th data in the list.
 func (l DataList) At(i int) ([]byte, error) {
-	addr, _ := l.elem(i)
+	addr, ok := l.primitiveElem(i, ObjectSize{PointerCount: 1})
+	if !ok {
+		return nil, errElemSize
+	}
 	p, err := l.seg.readPtr(addr, l.depthLimit)
 	if err != nil {
 		return nil, err
@@ -465,11 +502,11 @@ func isOneByteList(p Ptr) bool {
 
 // At returns the i'th element.
 func (l UInt8List) At(i int) uint8 {
-	b := l.slice(i)
-	if b == nil {
-		panic(errOutOfBounds)
+	addr, ok := l.primitiveElem(i, ObjectSize{DataSize: 1})
+	if !ok {
+		return 0
 	}
-	return b[0]
+	return l.seg.readUint8(addr)
 }
 
 // Set sets the i'th element to v.
@@ -495,11 +532,11 @@ func NewInt8List(s *Segment, n int32) (Int8List, error) {
 
 // At returns the i'th element.
 func (l Int8List) At(i int) int8 {
-	b := l.slice(i)
-	if b == nil {
-		panic(errOutOfBounds)
+	addr, ok := l.primitiveElem(i, ObjectSize{DataSize: 1})
+	if !ok {
+		return 0
 	}
-	return int8(b[0])
+	return int8(l.seg.readUint8(addr))
 }
 
 // Set sets the i'th element to v.
@@ -525,7 +562,10 @@ func NewUInt16List(s *Segment, n int32) (UInt16List, error) {
 
 // At returns the i'th element.
 func (l UInt16List) At(i int) uint16 {
-	addr, _ := l.elem(i)
+	addr, ok := l.primitiveElem(i, ObjectSize{DataSize: 2})
+	if !ok {
+		return 0
+	}
 	return l.seg.readUint16(addr)
 }
 
@@ -549,7 +589,10 @@ func NewInt16List(s *Segment, n int32) (Int16List, error) {
 
 // At returns the i'th element.
 func (l Int16List) At(i int) int16 {
-	addr, _ := l.elem(i)
+	addr, ok := l.primitiveElem(i, ObjectSize{DataSize: 2})
+	if !ok {
+		return 0
+	}
 	return int16(l.seg.readUint16(addr))
 }
 
@@ -573,7 +616,10 @@ func NewUInt32List(s *Segment, n int32) (UInt32List, error) {
 
 // At returns the i'th element.
 func (l UInt32List) At(i int) uint32 {
-	addr, _ := l.elem(i)
+	addr, ok := l.primitiveElem(i, ObjectSize{DataSize: 4})
+	if !ok {
+		return 0
+	}
 	return l.seg.readUint32(addr)
 }
 
@@ -597,7 +643,10 @@ func NewInt32List(s *Segment, n int32) (Int32List, error) {
 
 // At returns the i'th element.
 func (l Int32List) At(i int) int32 {
-	addr, _ := l.elem(i)
+	addr, ok := l.primitiveElem(i, ObjectSize{DataSize: 4})
+	if !ok {
+		return 0
+	}
 	return int32(l.seg.readUint32(addr))
 }
 
@@ -621,7 +670,10 @@ func NewUInt64List(s *Segment, n int32) (UInt64List, error) {
 
 // At returns the i'th element.
 func (l UInt64List) At(i int) uint64 {
-	addr, _ := l.elem(i)
+	addr, ok := l.primitiveElem(i, ObjectSize{DataSize: 8})
+	if !ok {
+		return 0
+	}
 	return l.seg.readUint64(addr)
 }
 
@@ -645,7 +697,10 @@ func NewInt64List(s *Segment, n int32) (Int64List, error) {
 
 // At returns the i'th element.
 func (l Int64List) At(i int) int64 {
-	addr, _ := l.elem(i)
+	addr, ok := l.primitiveElem(i, ObjectSize{DataSize: 8})
+	if !ok {
+		return 0
+	}
 	return int64(l.seg.readUint64(addr))
 }
 
@@ -669,7 +724,10 @@ func NewFloat32List(s *Segment, n int32) (Float32List, error) {
 
 // At returns the i'th element.
 func (l Float32List) At(i int) float32 {
-	addr, _ := l.elem(i)
+	addr, ok := l.primitiveElem(i, ObjectSize{DataSize: 4})
+	if !ok {
+		return 0
+	}
 	return math.Float32frombits(l.seg.readUint32(addr))
 }
 
@@ -693,7 +751,10 @@ func NewFloat64List(s *Segment, n int32) (Float64List, error) {
 
 // At returns the i'th element.
 func (l Float64List) At(i int) float64 {
-	addr, _ := l.elem(i)
+	addr, ok := l.primitiveElem(i, ObjectSize{DataSize: 8})
+	if !ok {
+		return 0
+	}
 	return math.Float64frombits(l.seg.readUint64(addr))
 }
 
@@ -116,6 +116,9 @@ func (m *Message) RootPtr() (Ptr, error) {
 	if err != nil {
 		return Ptr{}, err
 	}
+	if !s.regionInBounds(0, wordSize) {
+		return Ptr{}, errOutOfBounds
+	}
 	return s.root().PtrAt(0)
 }
 
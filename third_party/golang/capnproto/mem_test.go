@@ -568,6 +568,16 @@ func TestUnmarshal(t *testing.T) {
 	}
 }
 
+func TestRootPtrEmptySegment(t *testing.T) {
+	msg, err := Unmarshal([]byte{0, 0, 0, 0, 0, 0, 0, 0})
+	if err != nil {
+		t.Fatal("Unmarshal:", err)
+	}
+	if _, err := msg.RootPtr(); err == nil {
+		t.Error("RootPtr did not return an error")
+	}
+}
+
 func TestEncoder(t *testing.T) {
 	for i, test := range serializeTests {
 		if test.decodeFails {
@@ -0,0 +1,51 @@
+// Copyright 2017 The Minimal Configuration Manager Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zstd
+
+// The functions in this file stand in for math/bits, which was added
+// in Go 1.9.
+
+func leadingZeros8(x uint8) int {
+	n := 8
+	for x != 0 {
+		x >>= 1
+		n--
+	}
+	return n
+}
+
+func leadingZeros16(x uint16) int {
+	n := 16
+	for x != 0 {
+		x >>= 1
+		n--
+	}
+	return n
+}
+
+func leadingZeros32(x uint32) int {
+	n := 32
+	for x != 0 {
+		x >>= 1
+		n--
+	}
+	return n
+}
+
+func rotateLeft64(x uint64, k int) uint64 {
+	const n = 64
+	s := uint(k) & (n - 1)
+	return x<<s | x>>(n-s)
+}
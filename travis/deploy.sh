@@ -59,12 +59,18 @@ echostep "$gcloud_root/bin/gcloud" auth activate-service-account --key-file=trav
 
 # Build and deploy
 echostep ./bazel --bazelrc=travis/bazelrc build -c opt --stamp --embed_label="$build_label" \
-  //dot:mcm-dot //exec:mcm-exec //luacat:mcm-luacat //shellify:mcm-shellify || exit 1
+  //analyze:mcm-analyze //cat:mcm-cat //diff:mcm-diff //dot:mcm-dot //exec:mcm-exec //lint:mcm-lint //luacat:mcm-luacat //merge:mcm-merge //shellify:mcm-shellify //sign:mcm-sign || exit 1
 echostep zip -j travis/build.zip \
+  bazel-bin/analyze/mcm-analyze \
+  bazel-bin/cat/mcm-cat \
+  bazel-bin/diff/mcm-diff \
   bazel-bin/dot/mcm-dot \
   bazel-bin/exec/mcm-exec \
+  bazel-bin/lint/mcm-lint \
   bazel-bin/luacat/mcm-luacat \
-  bazel-bin/shellify/mcm-shellify || exit 1
+  bazel-bin/merge/mcm-merge \
+  bazel-bin/shellify/mcm-shellify \
+  bazel-bin/sign/mcm-sign || exit 1
 echostep "$gcloud_root/bin/gsutil" cp -n travis/build.zip "$gcs_out"
 gsutil_result=$?
 echostep rm -f travis/build.zip || exit 1